@@ -8,16 +8,20 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,6 +41,7 @@ import (
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -142,6 +147,12 @@ func main() {
 		authCmd()
 	case "cron":
 		cronCmd()
+	case "whatsapp":
+		whatsappCmd()
+	case "outbox":
+		outboxCmd()
+	case "pairing":
+		pairingCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -211,6 +222,9 @@ func printHelp() {
 	fmt.Println("  gateway     Start picoclaw gateway")
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  whatsapp    Link picoclaw as a WhatsApp device (native mode)")
+	fmt.Println("  outbox      Inspect and retry undelivered messages")
+	fmt.Println("  pairing     Approve or revoke senders who asked for access")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -581,12 +595,16 @@ func gatewayCmd() {
 		if err != nil {
 			return tools.ErrorResult(fmt.Sprintf("Heartbeat error: %v", err))
 		}
-		if response == "HEARTBEAT_OK" {
+		decision, err := heartbeat.ParseDecision(response)
+		if err != nil {
+			return tools.SilentResult(fmt.Sprintf("Unparsed heartbeat reply: %s", response))
+		}
+		if !decision.Notify {
+			// Spawned subagents report to the user themselves via
+			// processSystemMessage when the async task completes
 			return tools.SilentResult("Heartbeat OK")
 		}
-		// For heartbeat, always return silent - the subagent result will be
-		// sent to user via processSystemMessage when the async task completes
-		return tools.SilentResult(response)
+		return tools.UserResult(decision.Message)
 	})
 
 	channelManager, err := channels.NewManager(cfg, msgBus)
@@ -597,6 +615,8 @@ func gatewayCmd() {
 
 	// Inject channel manager into agent loop for command handling
 	agentLoop.SetChannelManager(channelManager)
+	// Telegram, Discord and Slack show the agent's commands natively
+	channelManager.SetCommands(agentLoop.Commands())
 
 	var transcriber *voice.GroqTranscriber
 	if cfg.Providers.Groq.APIKey != "" {
@@ -623,6 +643,18 @@ func gatewayCmd() {
 				logger.InfoC("voice", "Groq transcription attached to Slack channel")
 			}
 		}
+		if whatsappChannel, ok := channelManager.GetChannel("whatsapp"); ok {
+			if wc, ok := whatsappChannel.(*channels.WhatsAppNativeChannel); ok {
+				wc.SetTranscriber(transcriber)
+				logger.InfoC("voice", "Groq transcription attached to WhatsApp channel")
+			}
+		}
+		if signalChannel, ok := channelManager.GetChannel("signal"); ok {
+			if sc, ok := signalChannel.(*channels.SignalChannel); ok {
+				sc.SetTranscriber(transcriber)
+				logger.InfoC("voice", "Groq transcription attached to Signal channel")
+			}
+		}
 	}
 
 	enabledChannels := channelManager.GetEnabledChannels()
@@ -665,6 +697,44 @@ func gatewayCmd() {
 	}
 
 	healthServer := health.NewServer(cfg.Gateway.Host, cfg.Gateway.Port)
+	if ch, ok := channelManager.GetChannel("http"); ok {
+		if httpChannel, ok := ch.(*channels.HTTPChannel); ok {
+			httpChannel.SetAgent(agentLoop)
+			handler := httpChannel.Handler()
+			healthServer.Handle("/api/", handler)
+			healthServer.Handle("/v1/", handler)
+			fmt.Printf("✓ HTTP API available at http://%s:%d/api/v1 and /v1/chat/completions\n", cfg.Gateway.Host, cfg.Gateway.Port)
+		}
+	}
+	if ch, ok := channelManager.GetChannel("webchat"); ok {
+		if webchatChannel, ok := ch.(*channels.WebChatChannel); ok {
+			healthServer.Handle("/chat/", webchatChannel.Handler())
+			fmt.Printf("✓ Web chat available at http://%s:%d/chat/\n", cfg.Gateway.Host, cfg.Gateway.Port)
+		}
+	}
+
+	if ch, ok := channelManager.GetChannel("telegram"); ok {
+		if telegramChannel, ok := ch.(*channels.TelegramChannel); ok && telegramChannel.WebhookPath() != "" {
+			healthServer.Handle(telegramChannel.WebhookPath(), telegramChannel.WebhookHandler())
+			fmt.Printf("✓ Telegram webhook served at %s\n", telegramChannel.WebhookPath())
+		}
+	}
+
+	if ch, ok := channelManager.GetChannel("onebot"); ok {
+		if oneBotChannel, ok := ch.(*channels.OneBotChannel); ok && oneBotChannel.EventPath() != "" {
+			healthServer.Handle(oneBotChannel.EventPath(), oneBotChannel.Handler())
+			fmt.Printf("✓ OneBot events received at %s\n", oneBotChannel.EventPath())
+		}
+	}
+
+	for _, name := range channelManager.GetEnabledChannels() {
+		healthServer.RegisterCheck("channel:"+name, func() (bool, string) {
+			return channelManager.CheckChannel(name)
+		})
+	}
+	healthServer.Handle("/status/channels", channelManager.StatusHandler())
+	healthServer.Handle("/outbox/retry", channelManager.OutboxRetryHandler())
+
 	go func() {
 		if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
 			logger.ErrorCF("health", "Health server error", map[string]interface{}{"error": err.Error()})
@@ -722,30 +792,16 @@ func statusCmd() {
 	if _, err := os.Stat(configPath); err == nil {
 		fmt.Printf("Model: %s\n", cfg.Agents.Defaults.Model)
 
-		hasOpenRouter := cfg.Providers.OpenRouter.APIKey != ""
-		hasAnthropic := cfg.Providers.Anthropic.APIKey != ""
-		hasOpenAI := cfg.Providers.OpenAI.APIKey != ""
-		hasGemini := cfg.Providers.Gemini.APIKey != ""
-		hasZhipu := cfg.Providers.Zhipu.APIKey != ""
-		hasGroq := cfg.Providers.Groq.APIKey != ""
-		hasVLLM := cfg.Providers.VLLM.APIBase != ""
-
-		status := func(enabled bool) string {
-			if enabled {
-				return "✓"
+		fmt.Println("Providers:")
+		for _, entry := range cfg.ProviderEntries() {
+			if entry.Protocol == config.ProtocolCLI || entry.Protocol == config.ProtocolGitHubCopilot {
+				continue
 			}
-			return "not set"
-		}
-		fmt.Println("OpenRouter API:", status(hasOpenRouter))
-		fmt.Println("Anthropic API:", status(hasAnthropic))
-		fmt.Println("OpenAI API:", status(hasOpenAI))
-		fmt.Println("Gemini API:", status(hasGemini))
-		fmt.Println("Zhipu API:", status(hasZhipu))
-		fmt.Println("Groq API:", status(hasGroq))
-		if hasVLLM {
-			fmt.Printf("vLLM/Local: ✓ %s\n", cfg.Providers.VLLM.APIBase)
-		} else {
-			fmt.Println("vLLM/Local: not set")
+			status := "✓"
+			if entry.APIKey == "" && entry.AuthMethod == "" {
+				status = "no key"
+			}
+			fmt.Printf("  %s (%s): %s %s\n", entry.Name, entry.Protocol, status, entry.APIBase)
 		}
 
 		store, _ := auth.LoadStore()
@@ -761,6 +817,57 @@ func statusCmd() {
 				fmt.Printf("  %s (%s): %s\n", provider, cred.AuthMethod, status)
 			}
 		}
+
+		printGatewayChannels(cfg)
+	}
+}
+
+// printGatewayChannels shows each channel's state and counters as reported
+// by a running gateway.
+func printGatewayChannels(cfg *config.Config) {
+	host := cfg.Gateway.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	url := fmt.Sprintf("http://%s/status/channels", net.JoinHostPort(host, strconv.Itoa(cfg.Gateway.Port)))
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Println("\nGateway: not running")
+		return
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Channels []channels.ChannelStatus `json:"channels"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&body) != nil {
+		fmt.Printf("\nGateway: unexpected response from %s (%s)\n", url, resp.Status)
+		return
+	}
+
+	fmt.Println("\nGateway channels:")
+	if len(body.Channels) == 0 {
+		fmt.Println("  none enabled")
+	}
+	for _, ch := range body.Channels {
+		mark := "✓"
+		if ch.State != channels.ChannelRunning {
+			mark = "✗"
+		}
+		fmt.Printf("  %s %s: %s for %s, %d received, %d sent",
+			mark, ch.Name, ch.State, time.Since(ch.Since).Round(time.Second), ch.Received, ch.Sent)
+		if ch.SendErrors > 0 {
+			fmt.Printf(", %d failed sends", ch.SendErrors)
+		}
+		if ch.Restarts > 0 {
+			fmt.Printf(", %d restarts", ch.Restarts)
+		}
+		fmt.Println()
+		if ch.LastError != "" && ch.LastErrorAt != nil {
+			fmt.Printf("      last error %s ago: %s\n", time.Since(*ch.LastErrorAt).Round(time.Second), ch.LastError)
+		}
 	}
 }
 
@@ -1011,6 +1118,241 @@ func loadConfig() (*config.Config, error) {
 	return config.LoadConfig(getConfigPath())
 }
 
+func whatsappCmd() {
+	if len(os.Args) < 3 || os.Args[2] != "login" {
+		whatsappHelp()
+		return
+	}
+
+	phone := ""
+	args := os.Args[3:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--phone", "-p":
+			if i+1 < len(args) {
+				phone = args[i+1]
+				i++
+			}
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := channels.WhatsAppLogin(ctx, cfg.Channels.WhatsApp, phone, os.Stdout); err != nil {
+		fmt.Printf("WhatsApp login failed: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.Channels.WhatsApp.Mode != "native" {
+		fmt.Println("Set channels.whatsapp.mode to \"native\" and enable the channel to use this device.")
+	}
+}
+
+func whatsappHelp() {
+	fmt.Println("\nWhatsApp commands:")
+	fmt.Println("  login       Link picoclaw as a WhatsApp device")
+	fmt.Println()
+	fmt.Println("Login options:")
+	fmt.Println("  --phone <number>     Get a pairing code for this number instead of a QR code")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  picoclaw whatsapp login")
+	fmt.Println("  picoclaw whatsapp login --phone +15551234567")
+}
+
+func outboxCmd() {
+	if len(os.Args) < 3 {
+		outboxHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	outbox := channels.NewOutbox(filepath.Join(cfg.WorkspacePath(), "state"), cfg.Outbox)
+
+	switch os.Args[2] {
+	case "list":
+		outboxListCmd(outbox)
+	case "retry":
+		outboxRetryCmd(cfg, outbox, os.Args[3:])
+	default:
+		fmt.Printf("Unknown outbox command: %s\n", os.Args[2])
+		outboxHelp()
+	}
+}
+
+func outboxHelp() {
+	fmt.Println("\nOutbox commands:")
+	fmt.Println("  list              Show queued and undelivered messages")
+	fmt.Println("  retry <id>...     Queue undelivered messages again")
+	fmt.Println("  retry --all       Queue every undelivered message again")
+}
+
+func outboxListCmd(outbox *channels.Outbox) {
+	pending := outbox.Pending()
+	dead, err := outbox.DeadLetters()
+	if err != nil {
+		fmt.Printf("Error reading dead letters: %v\n", err)
+		return
+	}
+
+	if len(pending) > 0 {
+		fmt.Printf("\nQueued (%d):\n", len(pending))
+		for _, entry := range pending {
+			fmt.Printf("  %s  %s:%s  %d attempts\n", entry.ID, entry.Message.Channel, entry.Message.ChatID, entry.Attempts)
+			if entry.LastError != "" {
+				fmt.Printf("    Last error: %s\n", entry.LastError)
+			}
+		}
+	}
+
+	if len(dead) == 0 {
+		fmt.Println("\nNo undelivered messages.")
+		return
+	}
+	fmt.Printf("\nUndelivered (%d):\n", len(dead))
+	for _, entry := range dead {
+		failed := ""
+		if entry.FailedAt != nil {
+			failed = entry.FailedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf("  %s  %s:%s  %s\n", entry.ID, entry.Message.Channel, entry.Message.ChatID, failed)
+		fmt.Printf("    Error: %s\n", entry.LastError)
+		fmt.Printf("    Message: %s\n", utils.Truncate(strings.ReplaceAll(entry.Message.Content, "\n", " "), 80))
+	}
+	fmt.Println("\nRun 'picoclaw outbox retry <id>' to send one again.")
+}
+
+func outboxRetryCmd(cfg *config.Config, outbox *channels.Outbox, args []string) {
+	var ids []string
+	all := false
+	for _, arg := range args {
+		if arg == "--all" {
+			all = true
+		} else {
+			ids = append(ids, arg)
+		}
+	}
+	if !all && len(ids) == 0 {
+		fmt.Println("Usage: picoclaw outbox retry <id>... | --all")
+		return
+	}
+	if all {
+		ids = nil
+	}
+
+	// A running gateway owns the queue, so ask it to do the retry
+	host := cfg.Gateway.Host
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	url := fmt.Sprintf("http://%s/outbox/retry", net.JoinHostPort(host, strconv.Itoa(cfg.Gateway.Port)))
+	body, _ := json.Marshal(map[string]interface{}{"ids": ids})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		defer resp.Body.Close()
+		var result struct {
+			Requeued int `json:"requeued"`
+			Error    struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("Gateway refused the retry: %s\n", result.Error.Message)
+			return
+		}
+		fmt.Printf("✓ Requeued %d message(s)\n", result.Requeued)
+		return
+	}
+
+	n, err := outbox.Retry(ids)
+	if err != nil {
+		fmt.Printf("Error requeueing messages: %v\n", err)
+		return
+	}
+	fmt.Printf("✓ Requeued %d message(s); they will be sent when the gateway starts\n", n)
+}
+
+func pairingCmd() {
+	if len(os.Args) < 3 {
+		pairingHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	pairing := channels.NewPairing(filepath.Join(cfg.WorkspacePath(), "state"))
+
+	switch os.Args[2] {
+	case "list":
+		pairingListCmd(pairing)
+	case "approve":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw pairing approve <code>")
+			return
+		}
+		req, err := pairing.Approve(os.Args[3])
+		if err != nil {
+			fmt.Printf("Error approving: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Approved %s:%s\n", req.Channel, req.SenderID)
+	case "revoke":
+		if len(os.Args) < 5 {
+			fmt.Println("Usage: picoclaw pairing revoke <channel> <sender-id>")
+			return
+		}
+		if err := pairing.Revoke(os.Args[3], os.Args[4]); err != nil {
+			fmt.Printf("Error revoking: %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Revoked %s:%s\n", os.Args[3], os.Args[4])
+	default:
+		fmt.Printf("Unknown pairing command: %s\n", os.Args[2])
+		pairingHelp()
+	}
+}
+
+func pairingHelp() {
+	fmt.Println("\nPairing commands:")
+	fmt.Println("  list                          Show pending requests and approved senders")
+	fmt.Println("  approve <code>                Let the sender who was given code chat with the agent")
+	fmt.Println("  revoke <channel> <sender-id>  Remove an approved sender")
+}
+
+func pairingListCmd(pairing *channels.Pairing) {
+	approved, pending := pairing.List()
+	if len(pending) > 0 {
+		fmt.Printf("\nPending (%d):\n", len(pending))
+		for _, req := range pending {
+			fmt.Printf("  %s  %s:%s  %s\n", req.Code, req.Channel, req.SenderID, req.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	}
+	if len(approved) == 0 {
+		fmt.Println("\nNo approved senders.")
+		return
+	}
+	fmt.Printf("\nApproved (%d):\n", len(approved))
+	for _, s := range approved {
+		fmt.Printf("  %s:%s  %s\n", s.Channel, s.SenderID, s.ApprovedAt.Format("2006-01-02 15:04"))
+	}
+}
+
 func cronCmd() {
 	if len(os.Args) < 3 {
 		cronHelp()
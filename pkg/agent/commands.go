@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Commands returns the command registry, for channels to advertise and for
+// extensions to register their own commands.
+func (al *AgentLoop) Commands() *commands.Registry {
+	return al.commands
+}
+
+// handleCommand runs msg as a command if it is one. A command that expands
+// into a prompt returns it in prompt for the agent to process instead.
+func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage) (response, prompt string, handled bool) {
+	req := commands.Request{
+		Channel:    msg.Channel,
+		ChatID:     msg.ChatID,
+		SenderID:   msg.SenderID,
+		SessionKey: msg.SessionKey,
+		Admin:      al.isAdmin(msg.Channel, msg.SenderID),
+	}
+	if perms := al.permissionsFor(msg.Channel, msg.SenderID); perms != nil {
+		req.Permitted = perms.allowsCommand
+	}
+	result, ok := al.commands.Execute(ctx, req, msg.Content)
+	if !ok {
+		return "", "", false
+	}
+	return result.Reply, result.Prompt, true
+}
+
+// isAdmin reports whether a sender may run admin commands. With no admins
+// configured everyone may, as before commands had permission levels.
+func (al *AgentLoop) isAdmin(channel, senderID string) bool {
+	if len(al.admins) == 0 || channel == "cli" {
+		return true
+	}
+	return senderMatches(al.admins, channel, senderID)
+}
+
+// clientNamedChannels take the sender ID from whoever holds the API token,
+// so a bare entry, which names a sender on any channel, must not match there.
+var clientNamedChannels = map[string]bool{
+	"http": true,
+}
+
+// senderMatches reports whether a sender is one of entries, which are
+// sender IDs as in allow_from, optionally prefixed with "channel:".
+func senderMatches(entries []string, channel, senderID string) bool {
+	idPart, userPart, _ := strings.Cut(senderID, "|")
+	for _, entry := range entries {
+		if prefix, rest, ok := strings.Cut(entry, ":"); ok {
+			if prefix != channel {
+				continue
+			}
+			entry = rest
+		} else if clientNamedChannels[channel] {
+			continue
+		}
+		entry = strings.TrimPrefix(entry, "@")
+		if entry == senderID || entry == idPart || (userPart != "" && entry == userPart) {
+			return true
+		}
+	}
+	return false
+}
+
+func (al *AgentLoop) registerBuiltinCommands() {
+	builtins := []commands.Command{
+		{
+			Name:        "help",
+			Description: "Show available commands",
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				return commands.Reply("Available commands:\n%s", al.commands.Help(req)), nil
+			},
+		},
+		{
+			Name:        "start",
+			Description: "Start a conversation",
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				return commands.Reply("Hello! I am PicoClaw 🦞\nSend /help to see what I can do."), nil
+			},
+		},
+		{
+			Name:        "show",
+			Description: "Show current configuration",
+			Args: []commands.Arg{
+				{Name: "target", Description: "What to show", Choices: []string{"model", "channel"}, Required: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				if req.Args.String("target") == "model" {
+					return commands.Reply("Current model: %s", al.currentModel()), nil
+				}
+				return commands.Reply("Current channel: %s", req.Channel), nil
+			},
+		},
+		{
+			Name:        "list",
+			Description: "List available options",
+			Args: []commands.Arg{
+				{Name: "target", Description: "What to list", Choices: []string{"models", "channels"}, Required: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				if req.Args.String("target") == "models" {
+					// TODO: Fetch available models dynamically if possible
+					return commands.Reply("Available models: glm-4.7, claude-3-5-sonnet, gpt-4o (configured in config.json/env)"), nil
+				}
+				if al.channelManager == nil {
+					return commands.Reply("Channel manager not initialized"), nil
+				}
+				channels := al.channelManager.GetEnabledChannels()
+				if len(channels) == 0 {
+					return commands.Reply("No channels enabled"), nil
+				}
+				return commands.Reply("Enabled channels: %s", strings.Join(channels, ", ")), nil
+			},
+		},
+		{
+			Name:        "think",
+			Description: "Show or hide the model's reasoning",
+			Args: []commands.Arg{
+				{Name: "state", Description: "on or off", Type: commands.ArgBool, Required: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				show := req.Args.Bool("state")
+				al.sessions.SetShowThinking(req.SessionKey, show)
+				if err := al.sessions.Save(req.SessionKey); err != nil {
+					return commands.Reply("Could not save the setting: %v", err), nil
+				}
+				if show {
+					return commands.Reply("Reasoning will be shown before replies"), nil
+				}
+				return commands.Reply("Reasoning hidden"), nil
+			},
+		},
+		{
+			Name:        "switch",
+			Description: "Switch model or target channel",
+			Level:       commands.LevelAdmin,
+			Args: []commands.Arg{
+				{Name: "target", Description: "What to switch", Choices: []string{"model", "channel"}, Required: true},
+				{Name: "value", Description: "New model or channel name", Required: true, Rest: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				// "/switch model to gpt-4o" reads better, so accept it
+				value := strings.TrimSpace(strings.TrimPrefix(req.Args.String("value"), "to "))
+				if req.Args.String("target") == "model" {
+					oldModel, newModel := al.switchModel(value)
+					return commands.Reply("Switched model from %s to %s", oldModel, newModel), nil
+				}
+				if al.channelManager == nil {
+					return commands.Reply("Channel manager not initialized"), nil
+				}
+				if _, exists := al.channelManager.GetChannel(value); !exists && value != "cli" {
+					return commands.Reply("Channel '%s' not found or not enabled", value), nil
+				}
+				return commands.Reply("Switched target channel to %s (Note: this currently only validates existence)", value), nil
+			},
+		},
+		{
+			Name:        "pairing",
+			Description: "List, approve or revoke paired senders",
+			Level:       commands.LevelAdmin,
+			Args: []commands.Arg{
+				{Name: "action", Description: "What to do", Choices: []string{"list", "approve", "revoke"}, Required: true},
+				{Name: "value", Description: "Code to approve, or channel and sender ID to revoke", Rest: true},
+			},
+			Handler: al.handlePairing,
+		},
+	}
+
+	for _, cmd := range builtins {
+		if err := al.commands.Register(cmd); err != nil {
+			logger.ErrorCF("agent", "Failed to register command", map[string]interface{}{
+				"command": cmd.Name,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
+// registerSkillCommands turns skills that declare a command into shortcuts:
+// "/weather Paris" asks the agent to use the weather skill for "Paris".
+func (al *AgentLoop) registerSkillCommands() {
+	for _, skill := range al.contextBuilder.ListSkills() {
+		if skill.Command == "" {
+			continue
+		}
+		err := al.commands.Register(commands.Command{
+			Name:        skill.Command,
+			Description: utils.Truncate(skill.Description, 100),
+			Source:      "skill:" + skill.Name,
+			Args: []commands.Arg{
+				{Name: "request", Description: "What to do", Rest: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				request := req.Args.String("request")
+				if request == "" {
+					request = "Run it with sensible defaults."
+				}
+				return commands.Result{
+					Prompt: fmt.Sprintf("Use the %s skill (%s) for this request: %s", skill.Name, skill.Path, request),
+				}, nil
+			},
+		})
+		if err != nil {
+			logger.WarnCF("agent", "Skill command not registered", map[string]interface{}{
+				"skill": skill.Name,
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+// handlePairing runs /pairing, the chat equivalent of "picoclaw pairing".
+// Without configured admins every sender counts as one, so any approved
+// sender could approve strangers or revoke the owner; only the CLI may then.
+func (al *AgentLoop) handlePairing(ctx context.Context, req *commands.Request) (commands.Result, error) {
+	if al.pairingEnabled && len(al.admins) == 0 && req.Channel != "cli" {
+		return commands.Reply("Set commands.admins to manage pairing from chat, or use \"picoclaw pairing\""), nil
+	}
+	if al.channelManager == nil {
+		return commands.Reply("Channel manager not initialized"), nil
+	}
+	pairing := al.channelManager.Pairing()
+	value := req.Args.String("value")
+
+	switch req.Args.String("action") {
+	case "approve":
+		if value == "" {
+			return commands.Reply("Usage: /pairing approve <code>"), nil
+		}
+		approved, err := pairing.Approve(value)
+		if err != nil {
+			return commands.Reply("Could not approve: %v", err), nil
+		}
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel: approved.Channel,
+			ChatID:  approved.ChatID,
+			Content: "You're approved. Send me a message to get started.",
+		})
+		return commands.Reply("Approved %s:%s", approved.Channel, approved.SenderID), nil
+	case "revoke":
+		channel, senderID, ok := strings.Cut(value, " ")
+		if !ok {
+			channel, senderID, ok = strings.Cut(value, ":")
+		}
+		if !ok {
+			return commands.Reply("Usage: /pairing revoke <channel> <sender id>"), nil
+		}
+		if err := pairing.Revoke(channel, strings.TrimSpace(senderID)); err != nil {
+			return commands.Reply("Could not revoke: %v", err), nil
+		}
+		return commands.Reply("Revoked %s:%s", channel, strings.TrimSpace(senderID)), nil
+	}
+
+	approved, pending := pairing.List()
+	if len(approved) == 0 && len(pending) == 0 {
+		return commands.Reply("No paired senders or pending requests"), nil
+	}
+	var sb strings.Builder
+	for _, r := range pending {
+		fmt.Fprintf(&sb, "Pending %s  %s:%s\n", r.Code, r.Channel, r.SenderID)
+	}
+	for _, s := range approved {
+		fmt.Fprintf(&sb, "Approved %s:%s\n", s.Channel, s.SenderID)
+	}
+	return commands.Reply("%s", strings.TrimSuffix(sb.String(), "\n")), nil
+}
@@ -0,0 +1,218 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newCommandTestLoop(t *testing.T, admins ...string) *AgentLoop {
+	t.Helper()
+	tmpDir := t.TempDir()
+	skillDir := filepath.Join(tmpDir, "skills", "weather")
+	os.MkdirAll(skillDir, 0755)
+	os.WriteFile(filepath.Join(skillDir, "SKILL.md"), []byte("---\nname: weather\ndescription: Get the weather forecast\ncommand: weather\n---\n# Weather\n"), 0644)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Commands: config.CommandsConfig{Admins: admins},
+	}
+	return NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "forecast: sunny"})
+}
+
+func TestCommands_BuiltinsAndHelp(t *testing.T) {
+	al := newCommandTestLoop(t)
+
+	response, _, handled := al.handleCommand(context.Background(), bus.InboundMessage{Channel: "telegram", Content: "/show model"})
+	if !handled || response != "Current model: test-model" {
+		t.Errorf("/show model = %q, handled %v", response, handled)
+	}
+
+	response, _, _ = al.handleCommand(context.Background(), bus.InboundMessage{Channel: "telegram", Content: "/help"})
+	for _, want := range []string{"/show <model|channel>", "/switch <model|channel> <value...>", "/weather [request...]"} {
+		if !strings.Contains(response, want) {
+			t.Errorf("help missing %q:\n%s", want, response)
+		}
+	}
+
+	if _, _, handled := al.handleCommand(context.Background(), bus.InboundMessage{Content: "/etc/hosts is missing"}); handled {
+		t.Error("unknown command was handled")
+	}
+}
+
+func TestCommands_AdminOnlySwitch(t *testing.T) {
+	al := newCommandTestLoop(t, "telegram:123")
+
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "456|bob", Content: "/switch model to other-model"}
+	if response, _, _ := al.handleCommand(context.Background(), msg); response != "/switch is only available to admins" {
+		t.Errorf("non-admin /switch = %q", response)
+	}
+
+	msg.SenderID = "123|alice"
+	if response, _, _ := al.handleCommand(context.Background(), msg); response != "Switched model from test-model to other-model" {
+		t.Errorf("admin /switch = %q", response)
+	}
+
+	// The same ID on another channel is someone else
+	if al.isAdmin("discord", "123") {
+		t.Error("admin entry matched on the wrong channel")
+	}
+}
+
+func TestCommands_SkillCommandPromptsAgent(t *testing.T) {
+	al := newCommandTestLoop(t)
+
+	_, prompt, handled := al.handleCommand(context.Background(), bus.InboundMessage{Content: "/weather Paris"})
+	if !handled || !strings.HasPrefix(prompt, "Use the weather skill") || !strings.HasSuffix(prompt, "for this request: Paris") {
+		t.Fatalf("prompt = %q, handled %v", prompt, handled)
+	}
+
+	response, err := al.ProcessDirectWithChannel(context.Background(), "/weather Paris", "test:weather", "test", "chat")
+	if err != nil || response != "forecast: sunny" {
+		t.Errorf("response = %q, %v", response, err)
+	}
+}
+
+func TestCommands_RolePermissions(t *testing.T) {
+	al := newCommandTestLoop(t)
+	al.permissions = config.PermissionsConfig{
+		DefaultRole: "guest",
+		Roles: map[string]config.RoleConfig{
+			"owner": {Members: []string{"telegram:1"}, Tools: []string{"*"}, Commands: []string{"*"}},
+			"guest": {Commands: []string{"help", "weather"}},
+		},
+	}
+
+	guest := bus.InboundMessage{Channel: "telegram", SenderID: "2", Content: "/show model"}
+	if response, _, _ := al.handleCommand(context.Background(), guest); response != "/show is not available to you" {
+		t.Errorf("guest /show = %q", response)
+	}
+	guest.Content = "/help"
+	if response, _, _ := al.handleCommand(context.Background(), guest); strings.Contains(response, "/show") || !strings.Contains(response, "/weather") {
+		t.Errorf("guest /help = %q", response)
+	}
+
+	owner := bus.InboundMessage{Channel: "telegram", SenderID: "1", Content: "/show model"}
+	if response, _, _ := al.handleCommand(context.Background(), owner); response != "Current model: test-model" {
+		t.Errorf("owner /show = %q", response)
+	}
+}
+
+func TestCommands_HTTPSenderIsNotAdmin(t *testing.T) {
+	al := newCommandTestLoop(t, "http:boss", "owner")
+	ch, err := channels.NewHTTPChannel(config.HTTPConfig{Token: "secret"}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.SetAgent(al)
+	ch.Start(context.Background())
+	server := httptest.NewServer(ch.Handler())
+	defer server.Close()
+
+	complete := func(user string) string {
+		body := `{"model":"picoclaw","user":"` + user + `","messages":[{"role":"user","content":"/switch model to other-model"}]}`
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var completion struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil || len(completion.Choices) != 1 {
+			t.Fatalf("completion = %+v, %v", completion, err)
+		}
+		return completion.Choices[0].Message.Content
+	}
+
+	if reply := complete("mallory"); reply != "/switch is only available to admins" {
+		t.Errorf("non-admin /switch = %q", reply)
+	}
+	if reply := complete("boss"); reply != "Switched model from test-model to other-model" {
+		t.Errorf("admin /switch = %q", reply)
+	}
+	// Bare entries name senders on other channels; API clients pick their own ID
+	if reply := complete("owner"); reply != "/switch is only available to admins" {
+		t.Errorf("API client claiming a bare admin ID /switch = %q", reply)
+	}
+	if !al.isAdmin("telegram", "owner") {
+		t.Error("bare admin entry no longer matches on other channels")
+	}
+}
+
+func TestProcessDirectFrom_WaitsForRunningTurn(t *testing.T) {
+	al := newCommandTestLoop(t)
+
+	endTurn, err := al.beginTurn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := al.ProcessDirectFrom(ctx, "hi", "http:s1", "http", "s1", "api"); err != context.DeadlineExceeded {
+		t.Errorf("API turn ran alongside another turn: %v", err)
+	}
+
+	endTurn()
+	if reply, err := al.ProcessDirectFrom(context.Background(), "hi", "http:s1", "http", "s1", "api"); err != nil || reply == "" {
+		t.Errorf("reply = %q, %v", reply, err)
+	}
+}
+
+func TestCommands_PairingNeedsConfiguredAdmins(t *testing.T) {
+	refused := `Set commands.admins to manage pairing from chat, or use "picoclaw pairing"`
+
+	al := newCommandTestLoop(t)
+	al.pairingEnabled = true
+	msg := bus.InboundMessage{Channel: "telegram", SenderID: "456", Content: "/pairing revoke telegram 123"}
+	if response, _, _ := al.handleCommand(context.Background(), msg); response != refused {
+		t.Errorf("/pairing with no admins = %q", response)
+	}
+	msg.Channel = "cli"
+	if response, _, _ := al.handleCommand(context.Background(), msg); response == refused {
+		t.Error("/pairing refused on the CLI")
+	}
+
+	al = newCommandTestLoop(t, "telegram:123")
+	al.pairingEnabled = true
+	msg = bus.InboundMessage{Channel: "telegram", SenderID: "123", Content: "/pairing list"}
+	if response, _, _ := al.handleCommand(context.Background(), msg); response == refused {
+		t.Error("/pairing refused for a configured admin")
+	}
+}
+
+func TestCommands_SwitchModelResolvesPrefix(t *testing.T) {
+	al := newCommandTestLoop(t)
+	al.cfg.Providers.Groq.APIKey = "gq"
+
+	msg := bus.InboundMessage{Channel: "cli", Content: "/switch model groq/llama-3.3-70b-versatile"}
+	if response, _, _ := al.handleCommand(context.Background(), msg); response != "Switched model from test-model to llama-3.3-70b-versatile" {
+		t.Errorf("/switch model = %q", response)
+	}
+	if model := al.currentModel(); model != "llama-3.3-70b-versatile" {
+		t.Errorf("model sent upstream = %q", model)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sipeed/picoclaw/pkg/channels"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
@@ -49,7 +50,6 @@ func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 }
 
 func (cb *ContextBuilder) getIdentity() string {
-	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
@@ -60,9 +60,6 @@ func (cb *ContextBuilder) getIdentity() string {
 
 You are picoclaw, a helpful AI assistant.
 
-## Current Time
-%s
-
 ## Runtime
 %s
 
@@ -81,7 +78,7 @@ Your workspace is at: %s
 2. **Be helpful and accurate** - When using tools, briefly explain what you're doing.
 
 3. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
-		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
+		runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
 }
 
 func (cb *ContextBuilder) buildToolsSection() string {
@@ -106,6 +103,9 @@ func (cb *ContextBuilder) buildToolsSection() string {
 	return sb.String()
 }
 
+// BuildSystemPrompt returns the part of the system prompt that stays the same
+// from turn to turn. Per-turn details are added by buildTurnContext so that
+// providers can cache this prefix.
 func (cb *ContextBuilder) BuildSystemPrompt() string {
 	parts := []string{}
 
@@ -162,11 +162,6 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 
 	systemPrompt := cb.BuildSystemPrompt()
 
-	// Add Current Session info if provided
-	if channel != "" && chatID != "" {
-		systemPrompt += fmt.Sprintf("\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
-	}
-
 	// Log system prompt summary for debugging (debug mode only)
 	logger.DebugCF("agent", "System prompt built",
 		map[string]interface{}{
@@ -185,9 +180,10 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 			"preview": preview,
 		})
 
-	if summary != "" {
-		systemPrompt += "\n\n## Summary of Previous Conversation\n\n" + summary
-	}
+	// Volatile details go after the stable prompt so the prefix stays
+	// byte-identical across turns and prompt caches keep hitting.
+	stableLen := len(systemPrompt)
+	systemPrompt += "\n\n---\n\n" + buildTurnContext(summary, channel, chatID)
 
 	//This fix prevents the session memory from LLM failure due to elimination of toolu_IDs required from LLM
 	// --- INICIO DEL FIX ---
@@ -201,8 +197,9 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	// --- FIN DEL FIX ---
 
 	messages = append(messages, providers.Message{
-		Role:    "system",
-		Content: systemPrompt,
+		Role:           "system",
+		Content:        systemPrompt,
+		CachePrefixLen: stableLen,
 	})
 
 	messages = append(messages, history...)
@@ -210,11 +207,59 @@ func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary str
 	messages = append(messages, providers.Message{
 		Role:    "user",
 		Content: currentMessage,
+		Media:   media,
 	})
 
 	return messages
 }
 
+// buildTurnContext renders the parts of the system prompt that change
+// between turns: the clock, the current session and the running summary.
+func buildTurnContext(summary, channel, chatID string) string {
+	var sb strings.Builder
+	sb.WriteString("## Current Time\n")
+	sb.WriteString(time.Now().Format("2006-01-02 15:04 (Monday)"))
+
+	if channel != "" && chatID != "" {
+		fmt.Fprintf(&sb, "\n\n## Current Session\nChannel: %s\nChat ID: %s", channel, chatID)
+	}
+
+	if summary != "" {
+		sb.WriteString("\n\n## Summary of Previous Conversation\n\n")
+		sb.WriteString(summary)
+	}
+
+	return sb.String()
+}
+
+// withReplyContext puts the message a user replied to or quoted above
+// their own text, so a request like "summarize this" has its subject.
+func withReplyContext(content string, metadata map[string]string) string {
+	quoted, ok := metadata[channels.MetaReplyToText]
+	if !ok {
+		return content
+	}
+
+	var sb strings.Builder
+	switch author := metadata[channels.MetaReplyToAuthor]; {
+	case metadata[channels.MetaIsReplyToBot] == "true":
+		sb.WriteString("[Replying to your earlier message]")
+	case author != "":
+		fmt.Fprintf(&sb, "[Replying to a message from %s]", author)
+	default:
+		sb.WriteString("[Replying to an earlier message]")
+	}
+	if quoted != "" {
+		for _, line := range strings.Split(quoted, "\n") {
+			sb.WriteString("\n> ")
+			sb.WriteString(line)
+		}
+	}
+	sb.WriteString("\n\n")
+	sb.WriteString(content)
+	return sb.String()
+}
+
 func (cb *ContextBuilder) AddToolResult(messages []providers.Message, toolCallID, toolName, result string) []providers.Message {
 	messages = append(messages, providers.Message{
 		Role:       "tool",
@@ -253,6 +298,11 @@ func (cb *ContextBuilder) loadSkills() string {
 	return "# Skill Definitions\n\n" + content
 }
 
+// ListSkills returns the skills available to the agent.
+func (cb *ContextBuilder) ListSkills() []skills.SkillInfo {
+	return cb.skillsLoader.ListSkills()
+}
+
 // GetSkillsInfo returns information about loaded skills.
 func (cb *ContextBuilder) GetSkillsInfo() map[string]interface{} {
 	allSkills := cb.skillsLoader.ListSkills()
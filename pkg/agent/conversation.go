@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// activeRun is the message the agent is working on, so /stop can cancel
+// it from the chat it came from.
+type activeRun struct {
+	channel string
+	chatID  string
+	cancel  context.CancelFunc
+}
+
+func (al *AgentLoop) registerConversationCommands() {
+	cmds := []commands.Command{
+		{
+			Name:        "ask",
+			Description: "Ask the assistant something",
+			Args: []commands.Arg{
+				{Name: "question", Description: "What to ask", Required: true, Rest: true},
+			},
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				return commands.Result{Prompt: req.Args.String("question")}, nil
+			},
+		},
+		{
+			Name:        "new",
+			Description: "Start a new conversation",
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				al.sessions.SetHistory(req.SessionKey, nil)
+				al.sessions.SetSummary(req.SessionKey, "")
+				if err := al.sessions.Save(req.SessionKey); err != nil {
+					return commands.Reply("Could not reset the conversation: %v", err), nil
+				}
+				return commands.Reply("Started a new conversation."), nil
+			},
+		},
+		{
+			Name:        "stop",
+			Description: "Stop the reply being worked on",
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				if !al.stopRun(req.Channel, req.ChatID) {
+					return commands.Reply("Nothing to stop."), nil
+				}
+				return commands.Reply("Stopped."), nil
+			},
+		},
+	}
+	for _, cmd := range cmds {
+		if err := al.commands.Register(cmd); err != nil {
+			logger.ErrorCF("agent", "Failed to register command", map[string]interface{}{
+				"command": cmd.Name,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
+// isStopCommand reports whether msg is a /stop to handle right away
+// rather than after the message being worked on.
+func isStopCommand(msg bus.InboundMessage) bool {
+	if msg.Channel == "system" || msg.Metadata[channels.MetaListenOnly] == "true" {
+		return false
+	}
+	name, _, ok := commands.Parse(msg.Content)
+	return ok && name == "stop"
+}
+
+func (al *AgentLoop) startRun(msg bus.InboundMessage, cancel context.CancelFunc) {
+	al.activeMu.Lock()
+	al.active = &activeRun{channel: msg.Channel, chatID: msg.ChatID, cancel: cancel}
+	al.activeMu.Unlock()
+}
+
+func (al *AgentLoop) finishRun() {
+	al.activeMu.Lock()
+	al.active = nil
+	al.activeMu.Unlock()
+}
+
+// stopRun cancels the message being worked on if it came from the chat.
+func (al *AgentLoop) stopRun(channel, chatID string) bool {
+	al.activeMu.Lock()
+	defer al.activeMu.Unlock()
+	if al.active == nil || al.active.channel != channel || al.active.chatID != chatID {
+		return false
+	}
+	al.active.cancel()
+	al.active = nil
+	return true
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// blockingProvider answers only once its context is cancelled.
+type blockingProvider struct {
+	called chan struct{}
+}
+
+func (p *blockingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	p.called <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p *blockingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func TestStopCancelsRunningReply(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	msgBus := bus.NewMessageBus()
+	provider := &blockingProvider{called: make(chan struct{}, 1)}
+	al := NewAgentLoop(cfg, msgBus, provider)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go al.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "discord", SenderID: "1", ChatID: "c1", Content: "write a novel", SessionKey: "discord:c1"})
+	select {
+	case <-provider.called:
+	case <-ctx.Done():
+		t.Fatal("provider was never called")
+	}
+
+	// A /stop from another chat leaves the reply running
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "discord", SenderID: "1", ChatID: "c2", Content: "/stop", SessionKey: "discord:c2"})
+	if out, _ := msgBus.SubscribeOutbound(ctx); out.ChatID != "c2" || out.Content != "Nothing to stop." {
+		t.Fatalf("other chat /stop = %+v", out)
+	}
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "discord", SenderID: "1", ChatID: "c1", Content: "/stop", SessionKey: "discord:c1"})
+	if out, _ := msgBus.SubscribeOutbound(ctx); out.ChatID != "c1" || out.Content != "Stopped." {
+		t.Fatalf("/stop = %+v", out)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer waitCancel()
+	if out, ok := msgBus.SubscribeOutbound(waitCtx); ok {
+		t.Errorf("stopped reply still sent: %+v", out)
+	}
+}
+
+func TestNewStartsFreshSession(t *testing.T) {
+	al := newCommandTestLoop(t)
+	al.sessions.AddMessage("discord:c1", "user", "remember the milk")
+	al.sessions.SetSummary("discord:c1", "talked about groceries")
+
+	response, _, _ := al.handleCommand(context.Background(), bus.InboundMessage{Channel: "discord", ChatID: "c1", SessionKey: "discord:c1", Content: "/new"})
+	if response != "Started a new conversation." {
+		t.Errorf("/new = %q", response)
+	}
+	if history := al.sessions.GetHistory("discord:c1"); len(history) != 0 {
+		t.Errorf("history after /new = %+v", history)
+	}
+	if summary := al.sessions.GetSummary("discord:c1"); summary != "" {
+		t.Errorf("summary after /new = %q", summary)
+	}
+
+	_, prompt, handled := al.handleCommand(context.Background(), bus.InboundMessage{Channel: "discord", Content: "/ask what's for dinner?"})
+	if !handled || prompt != "what's for dinner?" {
+		t.Errorf("/ask prompt = %q, handled %v", prompt, handled)
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/identity"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// isDirectMessage reports whether msg came from a one-to-one chat with a
+// user of a chat platform.
+func isDirectMessage(msg bus.InboundMessage) bool {
+	switch msg.Channel {
+	case "cli", "system":
+		return false
+	}
+	return msg.SenderID != "" && msg.Metadata[channels.MetaIsGroup] != "true"
+}
+
+// resolvePerson records where a sender can be reached for /link and
+// returns the person they are linked to. With shared sessions on, a linked
+// person's direct chats all use one session.
+func (al *AgentLoop) resolvePerson(msg *bus.InboundMessage) string {
+	if al.identities == nil || !isDirectMessage(*msg) {
+		return ""
+	}
+	al.identities.SeenDirect(msg.Channel, msg.SenderID, msg.ChatID)
+
+	person, ok := al.identities.Lookup(msg.Channel, msg.SenderID)
+	if !ok {
+		return ""
+	}
+	if al.sharedSessions {
+		msg.SessionKey = "person:" + person.ID
+	}
+	return person.ID
+}
+
+// personContext returns the notes kept about a linked person, for the
+// system prompt. The agent keeps them up to date with the file tools.
+func (al *AgentLoop) personContext(personID string) string {
+	if personID == "" {
+		return ""
+	}
+	rel := filepath.Join("memory", "people", personID+".md")
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## Current User\nThis user has linked accounts on several channels. Keep notes about them in %s.", rel)
+	if data, err := os.ReadFile(filepath.Join(al.workspace, rel)); err == nil && len(data) > 0 {
+		sb.WriteString("\n\n")
+		sb.Write(data)
+	}
+	return sb.String()
+}
+
+// buildMessages builds the prompt for a turn, adding the current user's
+// notes after the cached part of the system prompt.
+func (al *AgentLoop) buildMessages(opts processOptions, history []providers.Message, summary, currentMessage string, media []string) []providers.Message {
+	messages := al.contextBuilder.BuildMessages(history, summary, currentMessage, media, opts.Channel, opts.ChatID)
+	if notes := al.personContext(opts.PersonID); notes != "" {
+		messages[0].Content += "\n\n" + notes
+	}
+	return messages
+}
+
+func (al *AgentLoop) registerIdentityCommands() {
+	cmds := []commands.Command{
+		{
+			Name:        "link",
+			Description: "Link your account on another channel",
+			Args: []commands.Arg{
+				{Name: "target", Description: "Channel to link, or the code you were sent", Required: true},
+				{Name: "id", Description: "Your user ID on that channel"},
+			},
+			Handler: al.handleLink,
+		},
+		{
+			Name:        "unlink",
+			Description: "Unlink this account from your other channels",
+			Handler: func(ctx context.Context, req *commands.Request) (commands.Result, error) {
+				if err := al.identities.Unlink(req.Channel, req.SenderID); err != nil {
+					return commands.Reply("Could not unlink: %v", err), nil
+				}
+				return commands.Reply("This account is no longer linked."), nil
+			},
+		},
+	}
+	for _, cmd := range cmds {
+		if err := al.commands.Register(cmd); err != nil {
+			logger.ErrorCF("agent", "Failed to register command", map[string]interface{}{
+				"command": cmd.Name,
+				"error":   err.Error(),
+			})
+		}
+	}
+}
+
+// handleLink runs "/link <channel> <id>", which sends a code to that
+// account, and "/link <code>", which links the two accounts.
+func (al *AgentLoop) handleLink(ctx context.Context, req *commands.Request) (commands.Result, error) {
+	target := req.Args.String("target")
+	targetID := strings.TrimPrefix(req.Args.String("id"), "@")
+
+	if targetID == "" {
+		person, err := al.identities.CompleteLink(req.Channel, req.SenderID, target)
+		if err != nil {
+			return commands.Reply("Could not link: %v", err), nil
+		}
+		return commands.Reply("Linked: %s", strings.Join(person.Accounts, ", ")), nil
+	}
+
+	if al.channelManager != nil {
+		if _, ok := al.channelManager.GetChannel(target); !ok {
+			return commands.Reply("Channel '%s' not found or not enabled", target), nil
+		}
+	}
+	code, chatID, err := al.identities.StartLink(req.Channel, req.SenderID, target, targetID)
+	if errors.Is(err, identity.ErrNoDirectChat) {
+		return commands.Reply("Send the bot a direct message from that account first, then try again."), nil
+	}
+	if err != nil {
+		return commands.Reply("Could not link: %v", err), nil
+	}
+
+	al.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: target,
+		ChatID:  chatID,
+		Content: fmt.Sprintf("Your link code is %s. Send /link %s on %s within %d minutes to link the accounts. Ignore this if you did not ask for it.",
+			code, code, req.Channel, int(identity.CodeTTL.Minutes())),
+	})
+	return commands.Reply("I sent a code to your %s account. Send /link <code> here to finish.", target), nil
+}
@@ -20,8 +20,11 @@ import (
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/channels"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/heartbeat"
+	"github.com/sipeed/picoclaw/pkg/identity"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/session"
@@ -34,8 +37,10 @@ type AgentLoop struct {
 	bus            *bus.MessageBus
 	provider       providers.LLMProvider
 	workspace      string
-	model          string
-	contextWindow  int // Maximum context window size in tokens
+	cfg            *config.Config
+	modelMu        sync.RWMutex
+	model          string // Changed by /switch model, read with currentModel
+	contextWindow  int    // Maximum context window size in tokens
 	maxIterations  int
 	sessions       *session.SessionManager
 	state          *state.Manager
@@ -44,18 +49,35 @@ type AgentLoop struct {
 	running        atomic.Bool
 	summarizing    sync.Map // Tracks which sessions are currently being summarized
 	channelManager *channels.Manager
+	// Reasoning controls passed to the provider on every call
+	reasoningEffort string
+	thinkingBudget  int
+	commands        *commands.Registry
+	admins          []string // Senders allowed to run admin commands
+	pairingEnabled  bool
+	permissions     config.PermissionsConfig
+	identities      *identity.Registry
+	sharedSessions  bool          // Linked people share one session across channels
+	turn            chan struct{} // Held while a turn runs, see beginTurn
+	activeMu        sync.Mutex
+	active          *activeRun // Message being worked on, for /stop
 }
 
 // processOptions configures how a message is processed
 type processOptions struct {
-	SessionKey      string // Session identifier for history/context
-	Channel         string // Target channel for tool execution
-	ChatID          string // Target chat ID for tool execution
-	UserMessage     string // User message content (may include prefix)
-	DefaultResponse string // Response when LLM returns empty
-	EnableSummary   bool   // Whether to trigger summarization
-	SendResponse    bool   // Whether to send response via bus
-	NoHistory       bool   // If true, don't load session history (for heartbeat)
+	SessionKey      string       // Session identifier for history/context
+	Channel         string       // Target channel for tool execution
+	ChatID          string       // Target chat ID for tool execution
+	UserMessage     string       // User message content (may include prefix)
+	Media           []string     // Local media files attached to the user message
+	DefaultResponse string       // Response when LLM returns empty
+	EnableSummary   bool         // Whether to trigger summarization
+	SendResponse    bool         // Whether to send response via bus
+	NoHistory       bool         // If true, don't load session history (for heartbeat)
+	PersonID        string       // Linked person the message is from, if any
+	Permissions     *permissions // Sender's role limits; nil allows everything
+	// ResponseSchema constrains the final reply to a JSON object
+	ResponseSchema *providers.JSONSchema
 }
 
 // createToolRegistry creates a tool registry with common tools.
@@ -102,6 +124,24 @@ func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msg
 		})
 		return nil
 	})
+	messageTool.SetMediaCallback(func(channel, chatID, content string, media []string) error {
+		msgBus.PublishOutbound(bus.OutboundMessage{
+			Channel: channel,
+			ChatID:  chatID,
+			Content: content,
+			Media:   media,
+		})
+		return nil
+	}, workspace, restrict)
+	messageTool.SetButtonsCallback(func(channel, chatID, content string, buttons []bus.Button) error {
+		msgBus.PublishOutbound(bus.OutboundMessage{
+			Channel: channel,
+			ChatID:  chatID,
+			Content: content,
+			Buttons: buttons,
+		})
+		return nil
+	})
 	registry.Register(messageTool)
 
 	return registry
@@ -116,11 +156,16 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	// Create tool registry for main agent
 	toolsRegistry := createToolRegistry(workspace, restrict, cfg, msgBus)
 
+	// Resolve "provider/model" addressing and aliases to the upstream model id
+	model := providers.ResolveModel(cfg, cfg.Agents.Defaults.Model)
+
 	// Create subagent manager with its own tool registry
-	subagentManager := tools.NewSubagentManager(provider, cfg.Agents.Defaults.Model, workspace, msgBus)
+	subagentManager := tools.NewSubagentManager(provider, model, workspace, msgBus)
 	subagentTools := createToolRegistry(workspace, restrict, cfg, msgBus)
 	// Subagent doesn't need spawn/subagent tools to avoid recursion
 	subagentManager.SetTools(subagentTools)
+	subagentCfg := cfg.Agents.Defaults.Merge(cfg.Agents.Subagent)
+	subagentManager.SetReasoning(subagentCfg.ReasoningEffort, subagentCfg.ThinkingBudget)
 
 	// Register spawn tool (for main agent)
 	spawnTool := tools.NewSpawnTool(subagentManager)
@@ -139,11 +184,12 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	contextBuilder := NewContextBuilder(workspace)
 	contextBuilder.SetToolsRegistry(toolsRegistry)
 
-	return &AgentLoop{
+	al := &AgentLoop{
 		bus:            msgBus,
 		provider:       provider,
 		workspace:      workspace,
-		model:          cfg.Agents.Defaults.Model,
+		cfg:            cfg,
+		model:          model,
 		contextWindow:  cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
 		maxIterations:  cfg.Agents.Defaults.MaxToolIterations,
 		sessions:       sessionsManager,
@@ -151,12 +197,41 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 		contextBuilder: contextBuilder,
 		tools:          toolsRegistry,
 		summarizing:    sync.Map{},
+		turn:           make(chan struct{}, 1),
+
+		reasoningEffort: cfg.Agents.Defaults.ReasoningEffort,
+		thinkingBudget:  cfg.Agents.Defaults.ThinkingBudget,
+		commands:        commands.NewRegistry(),
+		admins:          cfg.Commands.Admins,
+		pairingEnabled:  cfg.Pairing.Enabled,
+		permissions:     cfg.Permissions,
+		identities:      identity.NewRegistry(filepath.Join(workspace, "state")),
+		sharedSessions:  cfg.Identity.SharedSessions,
 	}
+	checkPermissions(cfg.Permissions)
+	al.registerBuiltinCommands()
+	al.registerIdentityCommands()
+	al.registerConversationCommands()
+	al.registerSkillCommands()
+	return al
 }
 
 func (al *AgentLoop) Run(ctx context.Context) error {
 	al.running.Store(true)
 
+	// Messages are worked on one at a time in the background, so a /stop
+	// can be handled here while a reply is still being worked on
+	queue := make(chan bus.InboundMessage, 100)
+	defer close(queue)
+	go func() {
+		for msg := range queue {
+			if ctx.Err() != nil {
+				continue
+			}
+			al.processInbound(ctx, msg)
+		}
+	}()
+
 	for al.running.Load() {
 		select {
 		case <-ctx.Done():
@@ -167,28 +242,21 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
-			}
-
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				// If so, skip publishing to avoid duplicate messages to the user.
-				alreadySent := false
-				if tool, ok := al.tools.Get("message"); ok {
-					if mt, ok := tool.(*tools.MessageTool); ok {
-						alreadySent = mt.HasSentInRound()
-					}
-				}
-
-				if !alreadySent {
+			if isStopCommand(msg) {
+				if response, _, _ := al.handleCommand(ctx, msg); response != "" {
 					al.bus.PublishOutbound(bus.OutboundMessage{
 						Channel: msg.Channel,
 						ChatID:  msg.ChatID,
 						Content: response,
 					})
 				}
+				continue
+			}
+
+			select {
+			case queue <- msg:
+			case <-ctx.Done():
+				return nil
 			}
 		}
 	}
@@ -196,6 +264,63 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 	return nil
 }
 
+// beginTurn waits until no other turn is running and returns the function
+// that ends this one. Bus messages, API requests, cron jobs and heartbeats
+// share the tools' per-turn state (the message tool's target chat and sent
+// flag), so they must not overlap.
+func (al *AgentLoop) beginTurn(ctx context.Context) (func(), error) {
+	select {
+	case al.turn <- struct{}{}:
+		return func() { <-al.turn }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// processInbound processes a message from the bus and publishes the reply.
+func (al *AgentLoop) processInbound(ctx context.Context, msg bus.InboundMessage) {
+	endTurn, err := al.beginTurn(ctx)
+	if err != nil {
+		return
+	}
+	defer endTurn()
+
+	// /stop ends the turn, but not subagents it spawned
+	runCtx, cancel := context.WithCancel(tools.WithBackground(ctx, ctx))
+	al.startRun(msg, cancel)
+	response, err := al.processMessage(runCtx, msg)
+	al.finishRun()
+	stopped := runCtx.Err() != nil && ctx.Err() == nil
+	cancel()
+
+	if err != nil {
+		if stopped {
+			// /stop has already answered
+			return
+		}
+		response = fmt.Sprintf("Error processing message: %v", err)
+	}
+
+	if response != "" {
+		// Check if the message tool already sent a response during this round.
+		// If so, skip publishing to avoid duplicate messages to the user.
+		alreadySent := false
+		if tool, ok := al.tools.Get("message"); ok {
+			if mt, ok := tool.(*tools.MessageTool); ok {
+				alreadySent = mt.HasSentInRound()
+			}
+		}
+
+		if !alreadySent {
+			al.bus.PublishOutbound(bus.OutboundMessage{
+				Channel: msg.Channel,
+				ChatID:  msg.ChatID,
+				Content: response,
+			})
+		}
+	}
+}
+
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
 }
@@ -225,20 +350,36 @@ func (al *AgentLoop) ProcessDirect(ctx context.Context, content, sessionKey stri
 }
 
 func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error) {
+	return al.ProcessDirectFrom(ctx, content, sessionKey, channel, chatID, "cron")
+}
+
+// ProcessDirectFrom runs a message from senderID through the agent and
+// returns the reply, so roles and admin checks apply to the real sender.
+func (al *AgentLoop) ProcessDirectFrom(ctx context.Context, content, sessionKey, channel, chatID, senderID string) (string, error) {
 	msg := bus.InboundMessage{
 		Channel:    channel,
-		SenderID:   "cron",
+		SenderID:   senderID,
 		ChatID:     chatID,
 		Content:    content,
 		SessionKey: sessionKey,
 	}
 
+	endTurn, err := al.beginTurn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer endTurn()
 	return al.processMessage(ctx, msg)
 }
 
 // ProcessHeartbeat processes a heartbeat request without session history.
 // Each heartbeat is independent and doesn't accumulate context.
 func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, chatID string) (string, error) {
+	endTurn, err := al.beginTurn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer endTurn()
 	return al.runAgentLoop(ctx, processOptions{
 		SessionKey:      "heartbeat",
 		Channel:         channel,
@@ -248,6 +389,7 @@ func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, cha
 		EnableSummary:   false,
 		SendResponse:    false,
 		NoHistory:       true, // Don't load session history for heartbeat
+		ResponseSchema:  heartbeat.DecisionSchema,
 	})
 }
 
@@ -272,9 +414,20 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		return al.processSystemMessage(ctx, msg)
 	}
 
+	// Group messages the agent only listens to become context for later
+	if msg.Metadata[channels.MetaListenOnly] == "true" {
+		al.recordGroupContext(msg)
+		return "", nil
+	}
+
+	personID := al.resolvePerson(&msg)
+
 	// Check for commands
-	if response, handled := al.handleCommand(ctx, msg); handled {
-		return response, nil
+	if response, prompt, handled := al.handleCommand(ctx, msg); handled {
+		if prompt == "" {
+			return response, nil
+		}
+		msg.Content = prompt
 	}
 
 	// Process as user message
@@ -282,13 +435,30 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		SessionKey:      msg.SessionKey,
 		Channel:         msg.Channel,
 		ChatID:          msg.ChatID,
-		UserMessage:     msg.Content,
+		UserMessage:     withReplyContext(msg.Content, msg.Metadata),
+		Media:           msg.Media,
+		PersonID:        personID,
+		Permissions:     al.permissionsFor(msg.Channel, msg.SenderID),
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   true,
 		SendResponse:    false,
 	})
 }
 
+// recordGroupContext adds a group message the agent does not answer to the
+// session history, attributed to its sender.
+func (al *AgentLoop) recordGroupContext(msg bus.InboundMessage) {
+	sender := msg.SenderID
+	for _, key := range []string{"username", "sender_name", "nickname", "first_name"} {
+		if name := msg.Metadata[key]; name != "" {
+			sender = name
+			break
+		}
+	}
+	al.sessions.AddMessage(msg.SessionKey, "user", fmt.Sprintf("[%s]: %s", sender, msg.Content))
+	al.saveSession(msg.SessionKey)
+}
+
 func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
 	// Verify this is a system message
 	if msg.Channel != "system" {
@@ -365,20 +535,13 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		history = al.sessions.GetHistory(opts.SessionKey)
 		summary = al.sessions.GetSummary(opts.SessionKey)
 	}
-	messages := al.contextBuilder.BuildMessages(
-		history,
-		summary,
-		opts.UserMessage,
-		nil,
-		opts.Channel,
-		opts.ChatID,
-	)
+	messages := al.buildMessages(opts, history, summary, opts.UserMessage, opts.Media)
 
 	// 3. Save user message to session
 	al.sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
 
 	// 4. Run LLM iteration loop
-	finalContent, iteration, err := al.runLLMIteration(ctx, messages, opts)
+	finalContent, reasoning, iteration, err := al.runLLMIteration(ctx, messages, opts)
 	if err != nil {
 		return "", err
 	}
@@ -393,7 +556,13 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 
 	// 6. Save final assistant message to session
 	al.sessions.AddMessage(opts.SessionKey, "assistant", finalContent)
-	al.sessions.Save(opts.SessionKey)
+	al.saveSession(opts.SessionKey)
+
+	// Reasoning is only shown on request and never stored as reply content
+	reply := finalContent
+	if reasoning != "" && al.sessions.GetShowThinking(opts.SessionKey) {
+		reply = formatThinking(reasoning) + "\n\n" + finalContent
+	}
 
 	// 7. Optional: summarization
 	if opts.EnableSummary {
@@ -405,7 +574,7 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		al.bus.PublishOutbound(bus.OutboundMessage{
 			Channel: opts.Channel,
 			ChatID:  opts.ChatID,
-			Content: finalContent,
+			Content: reply,
 		})
 	}
 
@@ -418,32 +587,71 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 			"final_length": len(finalContent),
 		})
 
-	return finalContent, nil
+	return reply, nil
+}
+
+// formatThinking renders reasoning as a quoted block shown before a reply.
+func formatThinking(reasoning string) string {
+	lines := strings.Split(strings.TrimSpace(reasoning), "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return "💭 Thinking\n" + strings.Join(lines, "\n")
+}
+
+// chatOptions returns the options passed to the provider on each call.
+func (al *AgentLoop) chatOptions(schema *providers.JSONSchema) map[string]interface{} {
+	options := map[string]interface{}{
+		"max_tokens":  8192,
+		"temperature": 0.7,
+	}
+	if al.reasoningEffort != "" {
+		options["reasoning_effort"] = al.reasoningEffort
+	}
+	if al.thinkingBudget > 0 {
+		options["thinking_budget"] = al.thinkingBudget
+	}
+	if schema != nil {
+		options["response_schema"] = schema
+	}
+	return options
 }
 
 // runLLMIteration executes the LLM call loop with tool handling.
-// Returns the final content, iteration count, and any error.
-func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, int, error) {
+// Returns the final content, the reasoning produced along the way,
+// iteration count, and any error.
+func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, string, int, error) {
 	iteration := 0
 	var finalContent string
+	var reasoning []string
+	repairs := 0
+	// Sent natively until the provider rejects it, as providers.ChatJSON does
+	schema := opts.ResponseSchema
+
+	maxIterations := al.maxIterations
+	if opts.Permissions != nil {
+		maxIterations = opts.Permissions.maxIterations(maxIterations)
+		ctx = tools.WithToolFilter(ctx, opts.Permissions.allowsTool)
+	}
 
-	for iteration < al.maxIterations {
+	for iteration < maxIterations {
 		iteration++
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
 				"iteration": iteration,
-				"max":       al.maxIterations,
+				"max":       maxIterations,
 			})
 
 		// Build tool definitions
-		providerToolDefs := al.tools.ToProviderDefs()
+		providerToolDefs := al.tools.ToProviderDefsFor(ctx)
+		model := al.currentModel()
 
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"iteration":         iteration,
-				"model":             al.model,
+				"model":             model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
 				"max_tokens":        8192,
@@ -465,15 +673,24 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		// Retry loop for context/token errors
 		maxRetries := 2
 		for retry := 0; retry <= maxRetries; retry++ {
-			response, err = al.provider.Chat(ctx, messages, providerToolDefs, al.model, map[string]interface{}{
-				"max_tokens":  8192,
-				"temperature": 0.7,
-			})
+			response, err = al.provider.Chat(ctx, messages, providerToolDefs, model, al.chatOptions(schema))
 
 			if err == nil {
 				break // Success
 			}
 
+			// Vendors without structured output reject the schema; the
+			// prompt still asks for JSON and replies are checked below
+			if schema != nil && providers.SchemaRejected(err) {
+				logger.WarnCF("agent", "Structured output rejected, retrying with prompt only", map[string]interface{}{
+					"schema": schema.Name,
+					"error":  err.Error(),
+				})
+				schema = nil
+				retry--
+				continue
+			}
+
 			errMsg := strings.ToLower(err.Error())
 			// Check for context window errors (provider specific, but usually contain "token" or "invalid")
 			isContextError := strings.Contains(errMsg, "token") ||
@@ -506,14 +723,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 
 				// Re-create messages for the next attempt
 				// We keep the current user message (opts.UserMessage) effectively
-				messages = al.contextBuilder.BuildMessages(
-					newHistory,
-					newSummary,
-					opts.UserMessage,
-					nil,
-					opts.Channel,
-					opts.ChatID,
-				)
+				messages = al.buildMessages(opts, newHistory, newSummary, opts.UserMessage, opts.Media)
 
 				// Important: If we are in the middle of a tool loop (iteration > 1),
 				// rebuilding messages from session history might duplicate the flow or miss context
@@ -569,14 +779,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				// We pass empty string as "currentMessage" to BuildMessages
 				// because the "current message" is already saved in history (step 3).
 
-				messages = al.contextBuilder.BuildMessages(
-					newHistory,
-					newSummary,
-					"", // Empty because history already contains the relevant messages
-					nil,
-					opts.Channel,
-					opts.ChatID,
-				)
+				messages = al.buildMessages(opts, newHistory, newSummary, "", nil) // Empty because history already contains the relevant messages
 
 				continue
 			}
@@ -591,7 +794,29 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					"iteration": iteration,
 					"error":     err.Error(),
 				})
-			return "", iteration, fmt.Errorf("LLM call failed after retries: %w", err)
+			return "", "", iteration, fmt.Errorf("LLM call failed after retries: %w", err)
+		}
+
+		if response.Reasoning != "" {
+			reasoning = append(reasoning, response.Reasoning)
+		}
+
+		// A final reply that misses the requested schema is sent back once or
+		// twice for repair; the exchange is not saved to the session.
+		if len(response.ToolCalls) == 0 && opts.ResponseSchema != nil && repairs < 2 {
+			if err := opts.ResponseSchema.Parse(response.Content, nil); err != nil {
+				repairs++
+				logger.WarnCF("agent", "Reply does not match response schema, asking for repair",
+					map[string]interface{}{
+						"schema": opts.ResponseSchema.Name,
+						"error":  err.Error(),
+					})
+				messages = append(messages,
+					providers.Message{Role: "assistant", Content: response.Content},
+					providers.Message{Role: "user", Content: opts.ResponseSchema.RepairPrompt(err)},
+				)
+				continue
+			}
 		}
 
 		// Check if no tool calls - we're done
@@ -618,9 +843,13 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 			})
 
 		// Build assistant message with tool calls
+		// Reasoning rides along so providers can replay it while the tool
+		// loop continues
 		assistantMsg := providers.Message{
-			Role:    "assistant",
-			Content: response.Content,
+			Role:             "assistant",
+			Content:          response.Content,
+			ReasoningContent: response.Reasoning,
+			ThinkingBlocks:   response.ThinkingBlocks,
 		}
 		for _, tc := range response.ToolCalls {
 			argumentsJSON, _ := json.Marshal(tc.Arguments)
@@ -631,6 +860,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 					Name:      tc.Name,
 					Arguments: string(argumentsJSON),
 				},
+				ThoughtSignature: tc.ThoughtSignature,
 			})
 		}
 		messages = append(messages, assistantMsg)
@@ -665,6 +895,11 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 
+			observer := al.toolObserver(opts.Channel)
+			if observer != nil {
+				observer.ToolStarted(opts.ChatID, tc.Name, tc.Arguments)
+			}
+
 			toolResult := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
 
 			// Send ForUser content to user immediately if not Silent
@@ -687,6 +922,10 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				contentForLLM = toolResult.Err.Error()
 			}
 
+			if observer != nil {
+				observer.ToolFinished(opts.ChatID, tc.Name, contentForLLM, toolResult.IsError)
+			}
+
 			toolResultMsg := providers.Message{
 				Role:       "tool",
 				Content:    contentForLLM,
@@ -699,7 +938,20 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		}
 	}
 
-	return finalContent, iteration, nil
+	return finalContent, strings.Join(reasoning, "\n\n"), iteration, nil
+}
+
+// toolObserver returns the channel if it wants to see tool calls as they run.
+func (al *AgentLoop) toolObserver(channel string) channels.ToolObserver {
+	if al.channelManager == nil {
+		return nil
+	}
+	ch, ok := al.channelManager.GetChannel(channel)
+	if !ok {
+		return nil
+	}
+	observer, _ := ch.(channels.ToolObserver)
+	return observer
 }
 
 // updateToolContexts updates the context for tools that need channel/chatID info.
@@ -746,6 +998,36 @@ func (al *AgentLoop) maybeSummarize(sessionKey, channel, chatID string) {
 	}
 }
 
+// currentModel returns the model turns are sent to.
+func (al *AgentLoop) currentModel() string {
+	al.modelMu.RLock()
+	defer al.modelMu.RUnlock()
+	return al.model
+}
+
+// switchModel makes later turns use model, resolved like the configured
+// one so "provider/model" addressing and aliases work, and returns the
+// previous model.
+func (al *AgentLoop) switchModel(model string) (string, string) {
+	resolved := providers.ResolveModel(al.cfg, model)
+	al.modelMu.Lock()
+	defer al.modelMu.Unlock()
+	old := al.model
+	al.model = resolved
+	return old, resolved
+}
+
+// saveSession writes a session to disk. A failed write only loses history
+// across restarts, so it is logged rather than failing the turn.
+func (al *AgentLoop) saveSession(sessionKey string) {
+	if err := al.sessions.Save(sessionKey); err != nil {
+		logger.ErrorCF("agent", "Failed to save session", map[string]interface{}{
+			"session_key": sessionKey,
+			"error":       err.Error(),
+		})
+	}
+}
+
 // forceCompression aggressively reduces context when the limit is hit.
 // It drops the oldest 50% of messages (keeping system prompt and last user message).
 func (al *AgentLoop) forceCompression(sessionKey string) {
@@ -797,7 +1079,7 @@ func (al *AgentLoop) forceCompression(sessionKey string) {
 
 	// Update session
 	al.sessions.SetHistory(sessionKey, newHistory)
-	al.sessions.Save(sessionKey)
+	al.saveSession(sessionKey)
 
 	logger.WarnCF("agent", "Forced compression executed", map[string]interface{}{
 		"session_key":  sessionKey,
@@ -925,12 +1207,9 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 
 		// Merge them
 		mergePrompt := fmt.Sprintf("Merge these two conversation summaries into one cohesive summary:\n\n1: %s\n\n2: %s", s1, s2)
-		resp, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: mergePrompt}}, nil, al.model, map[string]interface{}{
-			"max_tokens":  1024,
-			"temperature": 0.3,
-		})
+		merged, err := al.summarize(ctx, mergePrompt)
 		if err == nil {
-			finalSummary = resp.Content
+			finalSummary = merged
 		} else {
 			finalSummary = s1 + " " + s2
 		}
@@ -945,7 +1224,7 @@ func (al *AgentLoop) summarizeSession(sessionKey string) {
 	if finalSummary != "" {
 		al.sessions.SetSummary(sessionKey, finalSummary)
 		al.sessions.TruncateHistory(sessionKey, 4)
-		al.sessions.Save(sessionKey)
+		al.saveSession(sessionKey)
 	}
 }
 
@@ -960,14 +1239,39 @@ func (al *AgentLoop) summarizeBatch(ctx context.Context, batch []providers.Messa
 		prompt += fmt.Sprintf("%s: %s\n", m.Role, m.Content)
 	}
 
-	response, err := al.provider.Chat(ctx, []providers.Message{{Role: "user", Content: prompt}}, nil, al.model, map[string]interface{}{
+	return al.summarize(ctx, prompt)
+}
+
+// summarySchema constrains summarization replies, so preambles such as
+// "Here is a summary:" never end up in the stored summary.
+var summarySchema = &providers.JSONSchema{
+	Name:        "conversation_summary",
+	Description: "A concise summary of the conversation.",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"summary": map[string]interface{}{
+				"type":        "string",
+				"description": "The summary text",
+			},
+		},
+		"required": []interface{}{"summary"},
+	},
+}
+
+// summarize runs a summarization prompt as a structured request.
+func (al *AgentLoop) summarize(ctx context.Context, prompt string) (string, error) {
+	var out struct {
+		Summary string `json:"summary"`
+	}
+	err := providers.ChatJSON(ctx, al.provider, []providers.Message{{Role: "user", Content: prompt}}, al.currentModel(), map[string]interface{}{
 		"max_tokens":  1024,
 		"temperature": 0.3,
-	})
+	}, summarySchema, &out)
 	if err != nil {
 		return "", err
 	}
-	return response.Content, nil
+	return out.Summary, nil
 }
 
 // estimateTokens estimates the number of tokens in a message list.
@@ -981,86 +1285,3 @@ func (al *AgentLoop) estimateTokens(messages []providers.Message) int {
 	// 2.5 chars per token = totalChars * 2 / 5
 	return totalChars * 2 / 5
 }
-
-func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage) (string, bool) {
-	content := strings.TrimSpace(msg.Content)
-	if !strings.HasPrefix(content, "/") {
-		return "", false
-	}
-
-	parts := strings.Fields(content)
-	if len(parts) == 0 {
-		return "", false
-	}
-
-	cmd := parts[0]
-	args := parts[1:]
-
-	switch cmd {
-	case "/show":
-		if len(args) < 1 {
-			return "Usage: /show [model|channel]", true
-		}
-		switch args[0] {
-		case "model":
-			return fmt.Sprintf("Current model: %s", al.model), true
-		case "channel":
-			return fmt.Sprintf("Current channel: %s", msg.Channel), true
-		default:
-			return fmt.Sprintf("Unknown show target: %s", args[0]), true
-		}
-
-	case "/list":
-		if len(args) < 1 {
-			return "Usage: /list [models|channels]", true
-		}
-		switch args[0] {
-		case "models":
-			// TODO: Fetch available models dynamically if possible
-			return "Available models: glm-4.7, claude-3-5-sonnet, gpt-4o (configured in config.json/env)", true
-		case "channels":
-			if al.channelManager == nil {
-				return "Channel manager not initialized", true
-			}
-			channels := al.channelManager.GetEnabledChannels()
-			if len(channels) == 0 {
-				return "No channels enabled", true
-			}
-			return fmt.Sprintf("Enabled channels: %s", strings.Join(channels, ", ")), true
-		default:
-			return fmt.Sprintf("Unknown list target: %s", args[0]), true
-		}
-
-	case "/switch":
-		if len(args) < 3 || args[1] != "to" {
-			return "Usage: /switch [model|channel] to <name>", true
-		}
-		target := args[0]
-		value := args[2]
-
-		switch target {
-		case "model":
-			oldModel := al.model
-			al.model = value
-			return fmt.Sprintf("Switched model from %s to %s", oldModel, value), true
-		case "channel":
-			// This changes the 'default' channel for some operations, or effectively redirects output?
-			// For now, let's just validate if the channel exists
-			if al.channelManager == nil {
-				return "Channel manager not initialized", true
-			}
-			if _, exists := al.channelManager.GetChannel(value); !exists && value != "cli" {
-				return fmt.Sprintf("Channel '%s' not found or not enabled", value), true
-			}
-
-			// If message came from CLI, maybe we want to redirect CLI output to this channel?
-			// That would require state persistence about "redirected channel"
-			// For now, just acknowledged.
-			return fmt.Sprintf("Switched target channel to %s (Note: this currently only validates existence)", value), true
-		default:
-			return fmt.Sprintf("Unknown switch target: %s", target), true
-		}
-	}
-
-	return "", false
-}
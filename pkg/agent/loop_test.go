@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -624,3 +625,266 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 		t.Errorf("Expected history to be compressed (len < 8), got %d", len(finalHistory))
 	}
 }
+
+func TestBuildMessages_StableSystemPrefix(t *testing.T) {
+	workspace := t.TempDir()
+	cb := NewContextBuilder(workspace)
+
+	first := cb.BuildMessages(nil, "", "hi", nil, "telegram", "1")
+	second := cb.BuildMessages(nil, "earlier summary", "hi again", nil, "discord", "2")
+
+	sys1, sys2 := first[0], second[0]
+	if sys1.CachePrefixLen == 0 || sys1.CachePrefixLen >= len(sys1.Content) {
+		t.Fatalf("CachePrefixLen = %d, want a proper prefix of %d chars", sys1.CachePrefixLen, len(sys1.Content))
+	}
+	if sys1.Content[:sys1.CachePrefixLen] != sys2.Content[:sys2.CachePrefixLen] {
+		t.Error("stable system prefix should not depend on session, summary or time")
+	}
+	if strings.Contains(sys1.Content[:sys1.CachePrefixLen], "Current Time") {
+		t.Error("current time must stay out of the cacheable prefix")
+	}
+	if !strings.Contains(sys2.Content[sys2.CachePrefixLen:], "earlier summary") {
+		t.Error("summary should be in the volatile part")
+	}
+}
+
+func TestProcessMessage_ListenOnlyRecordsContext(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "unused"})
+
+	response, err := al.processMessage(context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "42",
+		ChatID:     "-100",
+		Content:    "anyone for lunch?",
+		SessionKey: "telegram:-100",
+		Metadata:   map[string]string{"listen_only": "true", "username": "alice"},
+	})
+	if err != nil || response != "" {
+		t.Fatalf("response = %q, %v; want no reply", response, err)
+	}
+
+	history := al.sessions.GetHistory("telegram:-100")
+	if len(history) != 1 || history[0].Content != "[alice]: anyone for lunch?" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestProcessMessage_SharedSessionForLinkedPerson(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Identity: config.IdentityConfig{SharedSessions: true},
+	}
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &simpleMockProvider{response: "hi"})
+	ctx := context.Background()
+
+	send := func(channel, senderID, chatID, content string) string {
+		response, err := al.processMessage(ctx, bus.InboundMessage{
+			Channel:    channel,
+			SenderID:   senderID,
+			ChatID:     chatID,
+			Content:    content,
+			SessionKey: channel + ":" + chatID,
+		})
+		if err != nil {
+			t.Fatalf("processMessage(%q): %v", content, err)
+		}
+		return response
+	}
+
+	send("discord", "777", "dm-777", "hello from discord")
+	send("telegram", "42", "42", "/link discord 777")
+
+	codeCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(codeCtx)
+	if !ok || out.Channel != "discord" || out.ChatID != "dm-777" {
+		t.Fatalf("code message = %+v, received %v", out, ok)
+	}
+	code := strings.Fields(strings.TrimPrefix(out.Content, "Your link code is "))[0]
+	code = strings.TrimSuffix(code, ".")
+
+	if reply := send("telegram", "42", "42", "/link "+code); !strings.Contains(reply, "discord:777") {
+		t.Fatalf("link reply = %q", reply)
+	}
+
+	send("telegram", "42", "42", "remember me")
+	person, _ := al.identities.Lookup("telegram", "42")
+	if history := al.sessions.GetHistory("person:" + person.ID); len(history) != 2 {
+		t.Errorf("shared history = %+v", history)
+	}
+}
+
+// toolRecordingProvider records the tools offered on each call.
+type toolRecordingProvider struct {
+	offered [][]string
+}
+
+func (p *toolRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+	p.offered = append(p.offered, names)
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (p *toolRecordingProvider) GetDefaultModel() string {
+	return "mock"
+}
+
+func TestProcessMessage_RoleLimitsTools(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Permissions: config.PermissionsConfig{
+			Roles: map[string]config.RoleConfig{
+				"guest": {Members: []string{"telegram:2"}, Tools: []string{"web_fetch"}},
+			},
+		},
+	}
+	provider := &toolRecordingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	for _, sender := range []string{"2", "3"} {
+		_, err := al.processMessage(context.Background(), bus.InboundMessage{
+			Channel:    "telegram",
+			SenderID:   sender,
+			ChatID:     sender,
+			Content:    "hi",
+			SessionKey: "telegram:" + sender,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(provider.offered) != 2 {
+		t.Fatalf("calls = %d", len(provider.offered))
+	}
+	if guest := provider.offered[0]; len(guest) != 1 || guest[0] != "web_fetch" {
+		t.Errorf("guest offered %v", guest)
+	}
+	if other := provider.offered[1]; len(other) < 5 {
+		t.Errorf("sender without a role offered only %v", other)
+	}
+
+	ctx := tools.WithToolFilter(context.Background(), func(name string) bool { return name == "web_fetch" })
+	if result := al.tools.ExecuteWithContext(ctx, "exec", map[string]interface{}{"command": "echo hi"}, "telegram", "2", nil); !result.IsError {
+		t.Errorf("exec ran for a guest: %+v", result)
+	}
+}
+
+func TestProcessMessage_IncludesReplyContext(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "ok"})
+
+	_, err := al.processMessage(context.Background(), bus.InboundMessage{
+		Channel:  "telegram",
+		SenderID: "42",
+		ChatID:   "42",
+		Content:  "summarize this",
+		Metadata: map[string]string{
+			"reply_to_author": "Alice (forwarded from Daily News)",
+			"reply_to_text":   "Markets rose.\nRates held.",
+		},
+		SessionKey: "telegram:42",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := al.sessions.GetHistory("telegram:42")
+	if len(history) == 0 {
+		t.Fatal("no history")
+	}
+	want := "[Replying to a message from Alice (forwarded from Daily News)]\n> Markets rose.\n> Rates held.\n\nsummarize this"
+	if history[0].Content != want {
+		t.Errorf("user message = %q, want %q", history[0].Content, want)
+	}
+
+	if got := withReplyContext("thanks", map[string]string{"reply_to_text": "", "is_reply_to_bot": "true"}); got != "[Replying to your earlier message]\n\nthanks" {
+		t.Errorf("reply to bot = %q", got)
+	}
+	if got := withReplyContext("plain", nil); got != "plain" {
+		t.Errorf("no reply = %q", got)
+	}
+}
+
+// schemaRejectingProvider fails any call carrying a response schema, as
+// vendors without structured output do.
+type schemaRejectingProvider struct {
+	calls []bool // whether each call carried the schema
+}
+
+func (p *schemaRejectingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	_, native := opts["response_schema"]
+	p.calls = append(p.calls, native)
+	if native {
+		return nil, fmt.Errorf("API request failed:\n  Status: 400\n  Body:   response_format json_schema is not supported in this context")
+	}
+	return &providers.LLMResponse{Content: `{"notify": false, "message": ""}`}, nil
+}
+
+func (p *schemaRejectingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func TestProcessHeartbeat_SchemaRejectedFallsBackToPrompt(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	provider := &schemaRejectingProvider{}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), provider)
+
+	response, err := al.ProcessHeartbeat(context.Background(), "check tasks", "cli", "direct")
+	if err != nil {
+		t.Fatalf("ProcessHeartbeat() error = %v", err)
+	}
+	if response != `{"notify": false, "message": ""}` {
+		t.Errorf("response = %q", response)
+	}
+	// One rejected native call, then the prompt alone; no compression retries
+	if len(provider.calls) != 2 || !provider.calls[0] || provider.calls[1] {
+		t.Errorf("calls with schema = %v, want [true false]", provider.calls)
+	}
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"sort"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// permissions is what a sender's role lets them do in a turn. A nil
+// *permissions allows everything.
+type permissions struct {
+	role string
+	cfg  config.RoleConfig
+}
+
+// permissionsFor returns the limits of the sender's role, or nil when the
+// sender is unrestricted: no roles are configured, the sender has no role
+// and there is no default, or the message comes from the CLI.
+func (al *AgentLoop) permissionsFor(channel, senderID string) *permissions {
+	if len(al.permissions.Roles) == 0 {
+		return nil
+	}
+	switch channel {
+	case "cli", "system":
+		return nil
+	}
+
+	// Roles are checked by name so a sender listed twice always gets the same one
+	names := make([]string, 0, len(al.permissions.Roles))
+	for name := range al.permissions.Roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		role := al.permissions.Roles[name]
+		if senderMatches(role.Members, channel, senderID) {
+			return &permissions{role: name, cfg: role}
+		}
+	}
+	if al.permissions.DefaultRole == "" {
+		return nil
+	}
+	// An undefined default role allows nothing rather than everything
+	role := al.permissions.Roles[al.permissions.DefaultRole]
+	return &permissions{role: al.permissions.DefaultRole, cfg: role}
+}
+
+func (p *permissions) allowsTool(name string) bool {
+	return roleAllows(p.cfg.Tools, name)
+}
+
+func (p *permissions) allowsCommand(name string) bool {
+	return roleAllows(p.cfg.Commands, name)
+}
+
+// maxIterations returns the role's tool iteration cap, if lower than def.
+func (p *permissions) maxIterations(def int) int {
+	if p.cfg.MaxToolIterations > 0 && p.cfg.MaxToolIterations < def {
+		return p.cfg.MaxToolIterations
+	}
+	return def
+}
+
+func roleAllows(allowed []string, name string) bool {
+	for _, a := range allowed {
+		if a == "*" || a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPermissions warns about role settings that are likely mistakes.
+func checkPermissions(cfg config.PermissionsConfig) {
+	if cfg.DefaultRole != "" {
+		if _, ok := cfg.Roles[cfg.DefaultRole]; !ok {
+			logger.WarnCF("agent", "Default role is not defined; senders without a role may use no tools or commands",
+				map[string]interface{}{
+					"default_role": cfg.DefaultRole,
+				})
+		}
+	}
+}
@@ -11,9 +11,19 @@ type InboundMessage struct {
 }
 
 type OutboundMessage struct {
-	Channel string `json:"channel"`
-	ChatID  string `json:"chat_id"`
-	Content string `json:"content"`
+	Channel string   `json:"channel"`
+	ChatID  string   `json:"chat_id"`
+	Content string   `json:"content"`
+	Media   []string `json:"media,omitempty"`
+	Buttons []Button `json:"buttons,omitempty"`
+}
+
+// Button is a choice offered below an outbound message. Pressing it sends
+// Value back as an inbound message from the user, with
+// Metadata["button_value"] set to it.
+type Button struct {
+	Label string `json:"label"`
+	Value string `json:"value"`
 }
 
 type MessageHandler func(InboundMessage) error
@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
 type Channel interface {
@@ -17,12 +20,41 @@ type Channel interface {
 	IsAllowed(senderID string) bool
 }
 
+// ToolObserver is implemented by channels that show tool calls while the
+// agent runs them. The agent calls it for every tool call in a chat on
+// that channel.
+type ToolObserver interface {
+	ToolStarted(chatID, tool string, args map[string]interface{})
+	ToolFinished(chatID, tool, result string, isError bool)
+}
+
+// CommandAdvertiser is implemented by channels that list the bot's
+// commands in the platform's own UI, such as Telegram's command menu or
+// Discord slash commands. SetCommands is called before Start and again
+// whenever the commands change.
+type CommandAdvertiser interface {
+	SetCommands(cmds []*commands.Command)
+}
+
+// HealthReporter is implemented by channels that can tell whether their
+// connection is up. Health returns nil when the channel is healthy.
+type HealthReporter interface {
+	Health() error
+}
+
 type BaseChannel struct {
 	config    interface{}
 	bus       *bus.MessageBus
-	running   bool
+	running   atomic.Bool
+	received  atomic.Int64
 	name      string
 	allowList []string
+	groups    *groupPolicy
+	// pairing, when set, lets unknown senders ask the owner for access
+	pairing *Pairing
+	// access, when set, replaces the allow_from check for channels with
+	// their own access rules, such as Discord's per-guild roles
+	access func(senderID string, metadata map[string]string) bool
 }
 
 func NewBaseChannel(name string, config interface{}, bus *bus.MessageBus, allowList []string) *BaseChannel {
@@ -31,7 +63,6 @@ func NewBaseChannel(name string, config interface{}, bus *bus.MessageBus, allowL
 		bus:       bus,
 		name:      name,
 		allowList: allowList,
-		running:   false,
 	}
 }
 
@@ -40,14 +71,65 @@ func (c *BaseChannel) Name() string {
 }
 
 func (c *BaseChannel) IsRunning() bool {
-	return c.running
+	return c.running.Load()
+}
+
+// ReceivedCount returns the number of inbound messages passed to the agent.
+func (c *BaseChannel) ReceivedCount() int64 {
+	return c.received.Load()
 }
 
+// IsAllowed reports whether senderID is on the channel's allow_from list
+// or was approved through pairing. With pairing on, an empty allow_from
+// no longer lets everyone in.
 func (c *BaseChannel) IsAllowed(senderID string) bool {
-	if len(c.allowList) == 0 {
+	if len(c.allowList) == 0 && c.pairing == nil {
+		return true
+	}
+	if allowListMatches(c.allowList, senderID) {
 		return true
 	}
+	return c.pairing != nil && c.pairing.IsApproved(c.name, senderID)
+}
+
+// senderAllowed reports whether a message's sender may use the bot, by
+// the channel's own access rules if it has them.
+func (c *BaseChannel) senderAllowed(senderID string, metadata map[string]string) bool {
+	if c.access != nil {
+		return c.access(senderID, metadata)
+	}
+	return c.IsAllowed(senderID)
+}
 
+func (c *BaseChannel) setPairing(pairing *Pairing) {
+	c.pairing = pairing
+}
+
+// requestPairing sends an unknown sender the code the owner approves them
+// with. Group messages are ignored so the bot does not spam groups.
+func (c *BaseChannel) requestPairing(senderID, chatID string, isGroup bool) {
+	if c.pairing == nil || isGroup {
+		return
+	}
+	code, notify, err := c.pairing.Request(c.name, senderID, chatID)
+	if err != nil {
+		logger.ErrorCF("channels", "Failed to record pairing request", map[string]interface{}{
+			"channel": c.name,
+			"error":   err.Error(),
+		})
+	}
+	if !notify {
+		return
+	}
+	c.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: c.name,
+		ChatID:  chatID,
+		Content: fmt.Sprintf("I don't know you yet. Ask my owner to approve pairing code %s.", code),
+	})
+}
+
+// allowListMatches reports whether senderID is on allowList.
+func allowListMatches(allowList []string, senderID string) bool {
 	// Extract parts from compound senderID like "123456|username"
 	idPart := senderID
 	userPart := ""
@@ -56,7 +138,7 @@ func (c *BaseChannel) IsAllowed(senderID string) bool {
 		userPart = senderID[idx+1:]
 	}
 
-	for _, allowed := range c.allowList {
+	for _, allowed := range allowList {
 		// Strip leading "@" from allowed value for username matching
 		trimmed := strings.TrimPrefix(allowed, "@")
 		allowedID := trimmed
@@ -83,8 +165,20 @@ func (c *BaseChannel) IsAllowed(senderID string) bool {
 }
 
 func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []string, metadata map[string]string) {
-	if !c.IsAllowed(senderID) {
+	if !c.senderAllowed(senderID, metadata) {
+		c.requestPairing(senderID, chatID, metadata[MetaIsGroup] == "true")
+		return
+	}
+
+	action, content := c.groups.decide(senderID, chatID, content, metadata)
+	switch action {
+	case groupIgnore:
 		return
+	case groupListen:
+		if metadata == nil {
+			metadata = map[string]string{}
+		}
+		metadata[MetaListenOnly] = "true"
 	}
 
 	// Build session key: channel:chatID
@@ -100,9 +194,10 @@ func (c *BaseChannel) HandleMessage(senderID, chatID, content string, media []st
 		Metadata:   metadata,
 	}
 
+	c.received.Add(1)
 	c.bus.PublishInbound(msg)
 }
 
 func (c *BaseChannel) setRunning(running bool) {
-	c.running = running
+	c.running.Store(running)
 }
@@ -0,0 +1,67 @@
+package channels
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+// ButtonRenderer is implemented by channels that show
+// OutboundMessage.Buttons as native buttons. On other channels the choices
+// are listed below the text so the user can type one.
+type ButtonRenderer interface {
+	RendersButtons() bool
+}
+
+// withButtonText folds msg's buttons into its content unless ch renders
+// them itself.
+func withButtonText(ch Channel, msg bus.OutboundMessage) bus.OutboundMessage {
+	if len(msg.Buttons) == 0 {
+		return msg
+	}
+	if r, ok := ch.(ButtonRenderer); ok && r.RendersButtons() {
+		return msg
+	}
+
+	var sb strings.Builder
+	sb.WriteString(msg.Content)
+	sb.WriteString("\n")
+	for _, button := range msg.Buttons {
+		sb.WriteString("\n• ")
+		sb.WriteString(button.Label)
+	}
+	msg.Content = strings.TrimSpace(sb.String())
+	msg.Buttons = nil
+	return msg
+}
+
+// HandleButton passes a button press to the agent as a message from the
+// user whose content is the button's value. In groups a press counts as a
+// reply to the bot.
+func (c *BaseChannel) HandleButton(senderID, chatID, value string, metadata map[string]string) {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["button_value"] = value
+	metadata[MetaIsReplyToBot] = "true"
+	c.HandleMessage(senderID, chatID, value, nil, metadata)
+}
+
+// buttonRows lays buttons out in rows of at most perRow, keeping each
+// button on its own row when a label is too long to sit side by side.
+func buttonRows(buttons []bus.Button, perRow int) [][]bus.Button {
+	for _, button := range buttons {
+		if len([]rune(button.Label)) > 20 {
+			perRow = 1
+			break
+		}
+	}
+
+	var rows [][]bus.Button
+	for len(buttons) > 0 {
+		n := min(perRow, len(buttons))
+		rows = append(rows, buttons[:n])
+		buttons = buttons[n:]
+	}
+	return rows
+}
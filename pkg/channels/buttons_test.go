@@ -0,0 +1,50 @@
+package channels
+
+import (
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+func TestWithButtonText(t *testing.T) {
+	msg := bus.OutboundMessage{
+		Content: "Delete report.pdf?",
+		Buttons: []bus.Button{{Label: "Yes", Value: "yes"}, {Label: "No", Value: "no"}},
+	}
+
+	plain := withButtonText(&EmailChannel{}, msg)
+	if plain.Content != "Delete report.pdf?\n\n• Yes\n• No" || plain.Buttons != nil {
+		t.Errorf("fallback = %q, buttons %v", plain.Content, plain.Buttons)
+	}
+
+	native := withButtonText(&TelegramChannel{}, msg)
+	if native.Content != msg.Content || len(native.Buttons) != 2 {
+		t.Errorf("native message changed: %+v", native)
+	}
+}
+
+func TestButtonRows(t *testing.T) {
+	short := []bus.Button{{Label: "1"}, {Label: "2"}, {Label: "3"}, {Label: "4"}}
+	if rows := buttonRows(short, 3); len(rows) != 2 || len(rows[0]) != 3 || len(rows[1]) != 1 {
+		t.Errorf("short rows = %v", rows)
+	}
+
+	long := []bus.Button{{Label: "Keep the current configuration"}, {Label: "Reset"}}
+	if rows := buttonRows(long, 3); len(rows) != 2 {
+		t.Errorf("long labels should get a row each, got %v", rows)
+	}
+}
+
+func TestBuildTextMessageQuickReplies(t *testing.T) {
+	msg := buildTextMessage("Which size?", "", []bus.Button{{Label: "A very large pizza please", Value: "large"}})
+
+	items := msg["quickReply"].(map[string]interface{})["items"].([]map[string]interface{})
+	action := items[0]["action"].(map[string]string)
+	if action["type"] != "postback" || action["data"] != "large" || len([]rune(action["label"])) > lineMaxLabelLength {
+		t.Errorf("action = %v", action)
+	}
+
+	if _, ok := buildTextMessage("hi", "", nil)["quickReply"]; ok {
+		t.Error("quick replies added without buttons")
+	}
+}
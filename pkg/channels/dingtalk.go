@@ -16,6 +16,10 @@ import (
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
+// dingTalkMessageLimit stays under the 5000 character limit of a
+// markdown reply.
+const dingTalkMessageLimit = 4000
+
 // DingTalkChannel implements the Channel interface for DingTalk (钉钉)
 // It uses WebSocket for receiving messages via stream mode and API for sending
 type DingTalkChannel struct {
@@ -114,7 +118,12 @@ func (c *DingTalkChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 	})
 
 	// Use the session webhook to send the reply
-	return c.SendDirectReply(ctx, sessionWebhook, msg.Content)
+	for _, chunk := range RenderChunks(msg.Content, FormatMarkdown, dingTalkMessageLimit) {
+		if err := c.SendDirectReply(ctx, sessionWebhook, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // onChatBotMessageReceived implements the IChatBotMessageHandler function signature
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -18,6 +19,8 @@ import (
 const (
 	transcriptionTimeout = 30 * time.Second
 	sendTimeout          = 10 * time.Second
+	// Discord allows 2000 characters per message
+	discordMessageLimit = 1900
 )
 
 type DiscordChannel struct {
@@ -26,6 +29,14 @@ type DiscordChannel struct {
 	config      config.DiscordConfig
 	transcriber *voice.GroqTranscriber
 	ctx         context.Context
+
+	// Slash commands, see discord_commands.go
+	appCommands  []*discordgo.ApplicationCommand
+	commandArgs  map[string][]string
+	commandsMu   sync.Mutex
+	interactions sync.Map // channelID -> *discordgo.Interaction awaiting a reply
+
+	threads sync.Map // channelID -> discordThread, see discord_guilds.go
 }
 
 func NewDiscordChannel(cfg config.DiscordConfig, bus *bus.MessageBus) (*DiscordChannel, error) {
@@ -35,14 +46,21 @@ func NewDiscordChannel(cfg config.DiscordConfig, bus *bus.MessageBus) (*DiscordC
 	}
 
 	base := NewBaseChannel("discord", cfg, bus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
 
-	return &DiscordChannel{
+	c := &DiscordChannel{
 		BaseChannel: base,
 		session:     session,
 		config:      cfg,
 		transcriber: nil,
 		ctx:         context.Background(),
-	}, nil
+	}
+	base.access = c.discordAllowed
+	// Added once here rather than in Start, which runs again on restart
+	session.AddHandler(c.handleMessage)
+	session.AddHandler(c.handleInteraction)
+
+	return c, nil
 }
 
 func (c *DiscordChannel) SetTranscriber(transcriber *voice.GroqTranscriber) {
@@ -60,7 +78,6 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 	logger.InfoC("discord", "Starting Discord bot")
 
 	c.ctx = ctx
-	c.session.AddHandler(c.handleMessage)
 
 	if err := c.session.Open(); err != nil {
 		return fmt.Errorf("failed to open discord session: %w", err)
@@ -76,6 +93,7 @@ func (c *DiscordChannel) Start(ctx context.Context) error {
 		"username": botUser.Username,
 		"user_id":  botUser.ID,
 	})
+	go c.publishCommands()
 
 	return nil
 }
@@ -101,15 +119,20 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 		return fmt.Errorf("channel ID is empty")
 	}
 
-	runes := []rune(msg.Content)
-	if len(runes) == 0 {
+	chunks := RenderChunks(msg.Content, FormatDiscord, discordMessageLimit)
+	if len(chunks) == 0 {
 		return nil
 	}
 
-	chunks := splitMessage(msg.Content, 1500) // Discord has a limit of 2000 characters per message, leave 500 for natural split e.g. code blocks
-
-	for _, chunk := range chunks {
-		if err := c.sendChunk(ctx, channelID, chunk); err != nil {
+	// Buttons go below the last chunk
+	components := discordComponents(msg.Buttons)
+	sent := c.sendInteractionReply(channelID, chunks, components)
+	for i, chunk := range chunks[sent:] {
+		var chunkComponents []discordgo.MessageComponent
+		if sent+i == len(chunks)-1 {
+			chunkComponents = components
+		}
+		if err := c.sendChunk(ctx, channelID, chunk, chunkComponents); err != nil {
 			return err
 		}
 	}
@@ -117,140 +140,91 @@ func (c *DiscordChannel) Send(ctx context.Context, msg bus.OutboundMessage) erro
 	return nil
 }
 
-// splitMessage splits long messages into chunks, preserving code block integrity
-// Uses natural boundaries (newlines, spaces) and extends messages slightly to avoid breaking code blocks
-func splitMessage(content string, limit int) []string {
-	var messages []string
-
-	for len(content) > 0 {
-		if len(content) <= limit {
-			messages = append(messages, content)
-			break
-		}
-
-		msgEnd := limit
-
-		// Find natural split point within the limit
-		msgEnd = findLastNewline(content[:limit], 200)
-		if msgEnd <= 0 {
-			msgEnd = findLastSpace(content[:limit], 100)
-		}
-		if msgEnd <= 0 {
-			msgEnd = limit
-		}
-
-		// Check if this would end with an incomplete code block
-		candidate := content[:msgEnd]
-		unclosedIdx := findLastUnclosedCodeBlock(candidate)
-
-		if unclosedIdx >= 0 {
-			// Message would end with incomplete code block
-			// Try to extend to include the closing ``` (with some buffer)
-			extendedLimit := limit + 500 // Allow 500 char buffer for code blocks
-			if len(content) > extendedLimit {
-				closingIdx := findNextClosingCodeBlock(content, msgEnd)
-				if closingIdx > 0 && closingIdx <= extendedLimit {
-					// Extend to include the closing ```
-					msgEnd = closingIdx
-				} else {
-					// Can't find closing, split before the code block
-					msgEnd = findLastNewline(content[:unclosedIdx], 200)
-					if msgEnd <= 0 {
-						msgEnd = findLastSpace(content[:unclosedIdx], 100)
-					}
-					if msgEnd <= 0 {
-						msgEnd = unclosedIdx
-					}
-				}
-			} else {
-				// Remaining content fits within extended limit
-				msgEnd = len(content)
-			}
-		}
+// RendersButtons reports that buttons are shown as message components.
+func (c *DiscordChannel) RendersButtons() bool {
+	return true
+}
 
-		if msgEnd <= 0 {
-			msgEnd = limit
+// discordButtonPrefix marks the custom IDs of buttons sent by Send. The
+// button's index keeps IDs unique when two buttons share a value.
+const discordButtonPrefix = "picoclaw:"
+
+func discordComponents(buttons []bus.Button) []discordgo.MessageComponent {
+	var components []discordgo.MessageComponent
+	for i, row := range buttonRows(buttons, 5) {
+		var actions discordgo.ActionsRow
+		for j, button := range row {
+			actions.Components = append(actions.Components, discordgo.Button{
+				Label:    utils.Truncate(button.Label, 80),
+				Style:    discordgo.PrimaryButton,
+				CustomID: fmt.Sprintf("%s%d:%s", discordButtonPrefix, i*5+j, button.Value),
+			})
 		}
-
-		messages = append(messages, content[:msgEnd])
-		content = strings.TrimSpace(content[msgEnd:])
+		components = append(components, actions)
 	}
-
-	return messages
+	return components
 }
 
-// findLastUnclosedCodeBlock finds the last opening ``` that doesn't have a closing ```
-// Returns the position of the opening ``` or -1 if all code blocks are complete
-func findLastUnclosedCodeBlock(text string) int {
-	count := 0
-	lastOpenIdx := -1
-
-	for i := 0; i < len(text); i++ {
-		if i+2 < len(text) && text[i] == '`' && text[i+1] == '`' && text[i+2] == '`' {
-			if count == 0 {
-				lastOpenIdx = i
-			}
-			count++
-			i += 2
-		}
+// handleComponent passes a button press to the agent and removes the
+// buttons so the question is only answered once.
+func (c *DiscordChannel) handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.MessageComponentData()
+	rest, ok := strings.CutPrefix(data.CustomID, discordButtonPrefix)
+	if !ok {
+		return
 	}
-
-	// If odd number of ``` markers, last one is unclosed
-	if count%2 == 1 {
-		return lastOpenIdx
+	_, value, ok := strings.Cut(rest, ":")
+	if !ok || value == "" {
+		return
 	}
-	return -1
-}
 
-// findNextClosingCodeBlock finds the next closing ``` starting from a position
-// Returns the position after the closing ``` or -1 if not found
-func findNextClosingCodeBlock(text string, startIdx int) int {
-	for i := startIdx; i < len(text); i++ {
-		if i+2 < len(text) && text[i] == '`' && text[i+1] == '`' && text[i+2] == '`' {
-			return i + 3
-		}
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
 	}
-	return -1
-}
-
-// findLastNewline finds the last newline character within the last N characters
-// Returns the position of the newline or -1 if not found
-func findLastNewline(s string, searchWindow int) int {
-	searchStart := len(s) - searchWindow
-	if searchStart < 0 {
-		searchStart = 0
+	if user == nil {
+		return
 	}
-	for i := len(s) - 1; i >= searchStart; i-- {
-		if s[i] == '\n' {
-			return i
-		}
+	metadata := c.interactionMetadata(i, user)
+	if !c.discordAllowed(user.ID, metadata) {
+		return
 	}
-	return -1
-}
 
-// findLastSpace finds the last space character within the last N characters
-// Returns the position of the space or -1 if not found
-func findLastSpace(s string, searchWindow int) int {
-	searchStart := len(s) - searchWindow
-	if searchStart < 0 {
-		searchStart = 0
-	}
-	for i := len(s) - 1; i >= searchStart; i-- {
-		if s[i] == ' ' || s[i] == '\t' {
-			return i
+	response := &discordgo.InteractionResponse{Type: discordgo.InteractionResponseDeferredMessageUpdate}
+	if i.Message != nil {
+		response = &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    i.Message.Content,
+				Components: []discordgo.MessageComponent{},
+			},
 		}
 	}
-	return -1
+	if err := s.InteractionRespond(i.Interaction, response); err != nil {
+		logger.WarnCF("discord", "Failed to acknowledge button press", map[string]any{
+			"error": err.Error(),
+		})
+	}
+
+	metadata[MetaIsGroup] = fmt.Sprintf("%t", i.GuildID != "")
+	if i.Message != nil {
+		metadata["message_id"] = i.Message.ID
+	}
+
+	c.HandleButton(user.ID, i.ChannelID, value, metadata)
 }
 
-func (c *DiscordChannel) sendChunk(ctx context.Context, channelID, content string) error {
+func (c *DiscordChannel) sendChunk(ctx context.Context, channelID, content string, components []discordgo.MessageComponent) error {
 	// 使用传入的 ctx 进行超时控制
 	sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
 	defer cancel()
 
 	done := make(chan error, 1)
 	go func() {
-		_, err := c.session.ChannelMessageSend(channelID, content)
+		_, err := c.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Content:    content,
+			Components: components,
+		})
 		done <- err
 	}()
 
@@ -282,26 +256,41 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		return
 	}
 
-	if err := c.session.ChannelTyping(m.ChannelID); err != nil {
-		logger.ErrorCF("discord", "Failed to send typing indicator", map[string]any{
-			"error": err.Error(),
-		})
+	senderID := m.Author.ID
+	senderName := m.Author.Username
+	if m.Author.Discriminator != "" && m.Author.Discriminator != "0" {
+		senderName += "#" + m.Author.Discriminator
+	}
+
+	metadata := map[string]string{
+		"message_id":   m.ID,
+		"user_id":      senderID,
+		"username":     m.Author.Username,
+		"display_name": senderName,
+		"guild_id":     m.GuildID,
+		"channel_id":   m.ChannelID,
+		"is_dm":        fmt.Sprintf("%t", m.GuildID == ""),
+		"is_group":     fmt.Sprintf("%t", m.GuildID != ""),
+	}
+	var thread discordThread
+	inThread := false
+	if m.GuildID != "" {
+		metadata[metaRoleIDs] = memberRoles(m.Member)
+		if thread, inThread = c.thread(m.ChannelID); inThread {
+			metadata[MetaParentID] = thread.parentID
+			metadata["thread_id"] = m.ChannelID
+		}
 	}
 
 	// 检查白名单，避免为被拒绝的用户下载附件和转录
-	if !c.IsAllowed(m.Author.ID) {
+	if !c.discordAllowed(senderID, metadata) {
 		logger.DebugCF("discord", "Message rejected by allowlist", map[string]any{
-			"user_id": m.Author.ID,
+			"user_id": senderID,
 		})
+		c.requestPairing(senderID, m.ChannelID, m.GuildID != "")
 		return
 	}
 
-	senderID := m.Author.ID
-	senderName := m.Author.Username
-	if m.Author.Discriminator != "" && m.Author.Discriminator != "0" {
-		senderName += "#" + m.Author.Discriminator
-	}
-
 	content := m.Content
 	mediaPaths := make([]string, 0, len(m.Attachments))
 	localFiles := make([]string, 0, len(m.Attachments))
@@ -376,17 +365,77 @@ func (c *DiscordChannel) handleMessage(s *discordgo.Session, m *discordgo.Messag
 		"preview":     utils.Truncate(content, 50),
 	})
 
-	metadata := map[string]string{
-		"message_id":   m.ID,
-		"user_id":      senderID,
-		"username":     m.Author.Username,
-		"display_name": senderName,
-		"guild_id":     m.GuildID,
-		"channel_id":   m.ChannelID,
-		"is_dm":        fmt.Sprintf("%t", m.GuildID == ""),
+	if m.GuildID != "" {
+		botID := s.State.User.ID
+		for _, user := range m.Mentions {
+			if user.ID == botID {
+				metadata[MetaIsMentioned] = "true"
+				content = strings.TrimSpace(strings.NewReplacer("<@"+botID+">", "", "<@!"+botID+">", "").Replace(content))
+				break
+			}
+		}
+	}
+	if ref := m.ReferencedMessage; ref != nil {
+		if ref.Author != nil && ref.Author.ID == s.State.User.ID {
+			metadata[MetaIsReplyToBot] = "true"
+		}
+		var author string
+		if ref.Author != nil {
+			author = ref.Author.Username
+		}
+		replyText, replyMedia := discordReplyContent(ref)
+		mediaPaths = append(mediaPaths, replyMedia...)
+		setReplyContext(metadata, ref.ID, author, replyText)
+	}
+
+	// Everything said in a thread the bot started is addressed to it
+	if inThread && thread.own {
+		metadata[MetaIsMentioned] = "true"
+	}
+
+	chatID := m.ChannelID
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		if c.config.ThreadReplies && m.GuildID != "" && !inThread {
+			threadID, err := c.startThread(m.Message, content)
+			if err != nil {
+				logger.WarnCF("discord", "Failed to start thread, replying in channel", map[string]any{
+					"error": err.Error(),
+				})
+			} else {
+				chatID = threadID
+				metadata[MetaParentID] = m.ChannelID
+				metadata["thread_id"] = threadID
+			}
+		}
+		if err := c.session.ChannelTyping(chatID); err != nil {
+			logger.ErrorCF("discord", "Failed to send typing indicator", map[string]any{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// discordReplyContent returns the text of a message being replied to,
+// including link previews, and the URLs of its attachments.
+func discordReplyContent(ref *discordgo.Message) (string, []string) {
+	text := ref.Content
+	for _, embed := range ref.Embeds {
+		if embed.Title != "" {
+			text = appendContent(text, embed.Title)
+		}
+		if embed.Description != "" {
+			text = appendContent(text, embed.Description)
+		}
 	}
 
-	c.HandleMessage(senderID, m.ChannelID, content, mediaPaths, metadata)
+	media := make([]string, 0, len(ref.Attachments))
+	for _, attachment := range ref.Attachments {
+		media = append(media, attachment.URL)
+		text = appendContent(text, fmt.Sprintf("[attachment: %s]", attachment.URL))
+	}
+	return text, media
 }
 
 func (c *DiscordChannel) downloadAttachment(url, filename string) string {
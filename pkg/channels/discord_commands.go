@@ -0,0 +1,216 @@
+package channels
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/sipeed/picoclaw/pkg/commands"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Discord allows at most this many global application commands.
+const discordMaxCommands = 100
+
+// SetCommands registers the commands as Discord slash commands.
+func (c *DiscordChannel) SetCommands(cmds []*commands.Command) {
+	appCommands := make([]*discordgo.ApplicationCommand, 0, len(cmds))
+	argNames := make(map[string][]string, len(cmds))
+	for _, cmd := range cmds {
+		if len(appCommands) == discordMaxCommands {
+			break
+		}
+		appCommands = append(appCommands, discordAppCommand(cmd))
+		for _, arg := range cmd.Args {
+			argNames[cmd.Name] = append(argNames[cmd.Name], arg.Name)
+		}
+	}
+
+	c.commandsMu.Lock()
+	c.appCommands = appCommands
+	c.commandArgs = argNames
+	c.commandsMu.Unlock()
+
+	if c.IsRunning() {
+		go c.publishCommands()
+	}
+}
+
+func discordAppCommand(cmd *commands.Command) *discordgo.ApplicationCommand {
+	appCmd := &discordgo.ApplicationCommand{
+		Name:        cmd.Name,
+		Description: discordDescription(cmd.Description, cmd.Name),
+	}
+	for _, arg := range cmd.Args {
+		opt := &discordgo.ApplicationCommandOption{
+			Type:        discordgo.ApplicationCommandOptionString,
+			Name:        arg.Name,
+			Description: discordDescription(arg.Description, arg.Name),
+			Required:    arg.Required,
+		}
+		switch arg.Type {
+		case commands.ArgInt:
+			opt.Type = discordgo.ApplicationCommandOptionInteger
+		case commands.ArgBool:
+			opt.Type = discordgo.ApplicationCommandOptionBoolean
+		}
+		for _, choice := range arg.Choices {
+			opt.Choices = append(opt.Choices, &discordgo.ApplicationCommandOptionChoice{Name: choice, Value: choice})
+		}
+		appCmd.Options = append(appCmd.Options, opt)
+	}
+	return appCmd
+}
+
+// discordDescription fits a description into Discord's 1-100 characters.
+func discordDescription(description, fallback string) string {
+	if description == "" {
+		description = fallback
+	}
+	return utils.Truncate(description, 100)
+}
+
+// publishCommands replaces the bot's global slash commands with the
+// current set.
+func (c *DiscordChannel) publishCommands() {
+	c.commandsMu.Lock()
+	appCommands := c.appCommands
+	c.commandsMu.Unlock()
+	if appCommands == nil || c.session.State == nil || c.session.State.User == nil {
+		return
+	}
+
+	if _, err := c.session.ApplicationCommandBulkOverwrite(c.session.State.User.ID, "", appCommands); err != nil {
+		logger.WarnCF("discord", "Failed to register slash commands", map[string]any{
+			"error": err.Error(),
+		})
+	}
+}
+
+// handleInteraction turns a slash command into a "/name args" message for
+// the agent. Discord needs an answer within 3 seconds, so the interaction
+// is deferred and the agent's reply is posted as its follow-up in Send.
+func (c *DiscordChannel) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionMessageComponent {
+		c.handleComponent(s, i)
+		return
+	}
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	user := i.User
+	if i.Member != nil && i.Member.User != nil {
+		user = i.Member.User
+	}
+	if user == nil {
+		return
+	}
+
+	metadata := c.interactionMetadata(i, user)
+	if !c.discordAllowed(user.ID, metadata) {
+		logger.DebugCF("discord", "Slash command rejected by allowlist", map[string]any{
+			"user_id": user.ID,
+		})
+		s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: "You are not allowed to use this bot.",
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
+		return
+	}
+
+	if err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	}); err != nil {
+		logger.ErrorCF("discord", "Failed to acknowledge slash command", map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	content := c.commandText(data)
+	c.interactions.Store(i.ChannelID, i.Interaction)
+
+	logger.DebugCF("discord", "Slash command received", map[string]any{
+		"sender_id": user.ID,
+		"command":   content,
+	})
+
+	metadata["is_command"] = "true"
+	metadata["interaction_id"] = i.ID
+
+	c.HandleMessage(user.ID, i.ChannelID, content, nil, metadata)
+}
+
+// interactionMetadata describes who used a command or button and where.
+func (c *DiscordChannel) interactionMetadata(i *discordgo.InteractionCreate, user *discordgo.User) map[string]string {
+	metadata := map[string]string{
+		"user_id":    user.ID,
+		"username":   user.Username,
+		"guild_id":   i.GuildID,
+		"channel_id": i.ChannelID,
+		"is_dm":      fmt.Sprintf("%t", i.GuildID == ""),
+	}
+	if i.GuildID != "" {
+		metadata[metaRoleIDs] = memberRoles(i.Member)
+		if thread, ok := c.thread(i.ChannelID); ok {
+			metadata[MetaParentID] = thread.parentID
+			metadata["thread_id"] = i.ChannelID
+		}
+	}
+	return metadata
+}
+
+// commandText rebuilds "/name arg1 arg2" from slash command options, in
+// the order the command declares its arguments.
+func (c *DiscordChannel) commandText(data discordgo.ApplicationCommandInteractionData) string {
+	values := make(map[string]string, len(data.Options))
+	for _, opt := range data.Options {
+		values[opt.Name] = fmt.Sprint(opt.Value)
+	}
+
+	c.commandsMu.Lock()
+	argNames := c.commandArgs[data.Name]
+	c.commandsMu.Unlock()
+
+	parts := []string{"/" + data.Name}
+	for _, name := range argNames {
+		if value, ok := values[name]; ok {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// sendInteractionReply posts chunks as the follow-up to a deferred slash
+// command in the channel, if one is waiting, with components below the
+// last one. It returns how many chunks it sent; the caller sends the rest
+// as normal messages.
+func (c *DiscordChannel) sendInteractionReply(channelID string, chunks []string, components []discordgo.MessageComponent) int {
+	v, ok := c.interactions.LoadAndDelete(channelID)
+	if !ok {
+		return 0
+	}
+	interaction := v.(*discordgo.Interaction)
+
+	for i, chunk := range chunks {
+		params := &discordgo.WebhookParams{Content: chunk}
+		if i == len(chunks)-1 {
+			params.Components = components
+		}
+		if _, err := c.session.FollowupMessageCreate(interaction, true, params); err != nil {
+			// The interaction token lasts 15 minutes; past that, post normally
+			logger.WarnCF("discord", "Failed to answer slash command", map[string]any{
+				"error": err.Error(),
+			})
+			return i
+		}
+	}
+	return len(chunks)
+}
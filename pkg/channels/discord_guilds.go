@@ -0,0 +1,136 @@
+package channels
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// metaRoleIDs lists a server member's role IDs, comma separated, for the
+// per-guild role check.
+const metaRoleIDs = "role_ids"
+
+// discordThread is what the channel knows about a thread.
+type discordThread struct {
+	parentID string
+	// own is true for threads the bot started, where every message is
+	// addressed to it
+	own bool
+}
+
+// discordAllowed applies the guilds settings on top of allow_from. Direct
+// messages and servers without settings only use allow_from.
+func (c *DiscordChannel) discordAllowed(senderID string, metadata map[string]string) bool {
+	guildID := metadata["guild_id"]
+	if guildID == "" || len(c.config.Guilds) == 0 {
+		return c.IsAllowed(senderID)
+	}
+	guild, ok := c.config.Guilds[guildID]
+	if !ok {
+		return false
+	}
+
+	channelID := metadata[MetaParentID]
+	if channelID == "" {
+		channelID = metadata["channel_id"]
+	}
+	if len(guild.Channels) > 0 && !containsString(guild.Channels, channelID) {
+		return false
+	}
+
+	if len(guild.Roles) == 0 {
+		return c.IsAllowed(senderID)
+	}
+	if c.hasRole(guildID, metadata[metaRoleIDs], guild.Roles) {
+		return true
+	}
+	// With roles set, an empty allow_from lets no one else in
+	return (len(c.allowList) > 0 || c.pairing != nil) && c.IsAllowed(senderID)
+}
+
+// hasRole reports whether any of the comma separated role IDs is one of
+// roles, by ID or by name.
+func (c *DiscordChannel) hasRole(guildID, roleIDs string, roles []string) bool {
+	if roleIDs == "" {
+		return false
+	}
+	for _, id := range strings.Split(roleIDs, ",") {
+		if containsString(roles, id) {
+			return true
+		}
+		role, err := c.session.State.Role(guildID, id)
+		if err != nil {
+			continue
+		}
+		for _, r := range roles {
+			if strings.EqualFold(strings.TrimPrefix(r, "@"), role.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memberRoles returns a member's role IDs for metadata.
+func memberRoles(member *discordgo.Member) string {
+	if member == nil {
+		return ""
+	}
+	return strings.Join(member.Roles, ",")
+}
+
+// thread returns what is known about a guild channel if it is a thread.
+// Threads are looked up once and remembered.
+func (c *DiscordChannel) thread(channelID string) (discordThread, bool) {
+	if v, ok := c.threads.Load(channelID); ok {
+		t := v.(discordThread)
+		return t, t.parentID != ""
+	}
+
+	ch, err := c.session.State.Channel(channelID)
+	if err != nil {
+		ch, err = c.session.Channel(channelID)
+	}
+	if err != nil {
+		logger.DebugCF("discord", "Failed to look up channel", map[string]any{
+			"channel_id": channelID,
+			"error":      err.Error(),
+		})
+		return discordThread{}, false
+	}
+
+	var t discordThread
+	if ch.IsThread() {
+		t = discordThread{parentID: ch.ParentID, own: c.session.State.User != nil && ch.OwnerID == c.session.State.User.ID}
+	}
+	c.threads.Store(channelID, t)
+	return t, t.parentID != ""
+}
+
+// startThread opens a thread from a message for the bot's reply and
+// returns its channel ID.
+func (c *DiscordChannel) startThread(m *discordgo.Message, content string) (string, error) {
+	ch, err := c.session.MessageThreadStartComplex(m.ChannelID, m.ID, &discordgo.ThreadStart{
+		Name:                discordThreadName(content),
+		AutoArchiveDuration: 1440,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.threads.Store(ch.ID, discordThread{parentID: m.ChannelID, own: true})
+	return ch.ID, nil
+}
+
+// discordThreadName names a thread after the first line of the message
+// that started it, within Discord's 100 characters.
+func discordThreadName(content string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Conversation"
+	}
+	return utils.Truncate(name, 100)
+}
@@ -0,0 +1,77 @@
+package channels
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestDiscordGuildAccess(t *testing.T) {
+	c, err := NewDiscordChannel(config.DiscordConfig{
+		Token:     "test",
+		AllowFrom: config.FlexibleStringSlice{"owner"},
+		Guilds: map[string]config.DiscordGuildConfig{
+			"g1": {Channels: config.FlexibleStringSlice{"general"}, Roles: config.FlexibleStringSlice{"r-helpers"}},
+			"g2": {},
+		},
+	}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		sender   string
+		metadata map[string]string
+		want     bool
+	}{
+		{"direct message", "owner", map[string]string{}, true},
+		{"stranger in direct message", "bob", map[string]string{}, false},
+		{"member with role", "bob", map[string]string{"guild_id": "g1", "channel_id": "general", metaRoleIDs: "r-other,r-helpers"}, true},
+		{"member without role", "bob", map[string]string{"guild_id": "g1", "channel_id": "general", metaRoleIDs: "r-other"}, false},
+		{"allow_from without role", "owner", map[string]string{"guild_id": "g1", "channel_id": "general"}, true},
+		{"other channel", "owner", map[string]string{"guild_id": "g1", "channel_id": "random"}, false},
+		{"thread of allowed channel", "bob", map[string]string{"guild_id": "g1", "channel_id": "t1", MetaParentID: "general", metaRoleIDs: "r-helpers"}, true},
+		{"guild without roles", "owner", map[string]string{"guild_id": "g2", "channel_id": "any"}, true},
+		{"stranger in guild without roles", "bob", map[string]string{"guild_id": "g2", "channel_id": "any"}, false},
+		{"unlisted guild", "owner", map[string]string{"guild_id": "g3", "channel_id": "any"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.discordAllowed(tt.sender, tt.metadata); got != tt.want {
+				t.Errorf("discordAllowed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscordRoleMembersReachAgent(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	c, err := NewDiscordChannel(config.DiscordConfig{
+		Token:  "test",
+		Guilds: map[string]config.DiscordGuildConfig{"g1": {Roles: config.FlexibleStringSlice{"r1"}}},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.HandleMessage("bob", "t1", "hi", nil, map[string]string{"guild_id": "g1", "channel_id": "t1", metaRoleIDs: "r1"})
+	c.HandleMessage("eve", "t1", "hi", nil, map[string]string{"guild_id": "g1", "channel_id": "t1"})
+	if got := c.ReceivedCount(); got != 1 {
+		t.Errorf("received %d messages, want only the role member's", got)
+	}
+}
+
+func TestDiscordThreadName(t *testing.T) {
+	if got := discordThreadName("  how do I deploy?\nmore detail"); got != "how do I deploy?" {
+		t.Errorf("thread name = %q", got)
+	}
+	if got := discordThreadName(""); got != "Conversation" {
+		t.Errorf("empty thread name = %q", got)
+	}
+	if got := discordThreadName(strings.Repeat("x", 150)); len(got) != 100 {
+		t.Errorf("long thread name has %d characters", len(got))
+	}
+}
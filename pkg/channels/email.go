@@ -0,0 +1,745 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapclient"
+	_ "github.com/emersion/go-message/charset" // decode non-UTF-8 mail
+	"github.com/emersion/go-message/mail"
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	emailReconnectDelay = 10 * time.Second
+	emailStateFile      = "email.json"
+	// emailMaxThreads bounds the threads remembered for replies; the least
+	// recently active are forgotten first.
+	emailMaxThreads = 500
+)
+
+// EmailChannel receives mail from an IMAP mailbox and replies over SMTP.
+// Each mail thread is one chat, identified by the Message-ID of the mail
+// that started it.
+type EmailChannel struct {
+	*BaseChannel
+	config config.EmailConfig
+	from   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// How far into the mailbox mail has been handled, saved in statePath
+	// so mail that arrives while the gateway is down is picked up later
+	statePath   string
+	uidValidity uint32
+	lastUID     imap.UID
+	// stateMu serializes writes to statePath
+	stateMu sync.Mutex
+
+	mu sync.Mutex
+	// threads is saved in statePath too, so replies still reach their
+	// thread after a restart
+	threads map[string]*emailThread
+}
+
+// emailThread is what a reply needs to stay in the sender's thread.
+type emailThread struct {
+	To         string    `json:"to"`
+	Subject    string    `json:"subject"`
+	LastID     string    `json:"last_id"`
+	References []string  `json:"references,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// NewEmailChannel creates the channel. Its read position and threads are
+// kept in stateDir; an empty stateDir keeps them in memory only.
+func NewEmailChannel(cfg config.EmailConfig, stateDir string, messageBus *bus.MessageBus) (*EmailChannel, error) {
+	if cfg.IMAPHost == "" || cfg.SMTPHost == "" {
+		return nil, fmt.Errorf("email imap_host and smtp_host are required")
+	}
+	if cfg.Username == "" {
+		return nil, fmt.Errorf("email username is required")
+	}
+	if cfg.Mailbox == "" {
+		cfg.Mailbox = "INBOX"
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 60
+	}
+
+	from := cfg.FromAddress
+	if from == "" {
+		from = cfg.Username
+	}
+
+	// Addresses are compared in lower case
+	allowFrom := make([]string, len(cfg.AllowFrom))
+	for i, addr := range cfg.AllowFrom {
+		allowFrom[i] = strings.ToLower(addr)
+	}
+
+	base := NewBaseChannel("email", cfg, messageBus, allowFrom)
+
+	ch := &EmailChannel{
+		BaseChannel: base,
+		config:      cfg,
+		from:        from,
+		threads:     make(map[string]*emailThread),
+	}
+	if stateDir != "" {
+		ch.statePath = filepath.Join(stateDir, emailStateFile)
+		for chatID, thread := range ch.loadStates()[ch.stateKey()].Threads {
+			ch.threads[chatID] = thread
+		}
+	}
+	return ch, nil
+}
+
+func (c *EmailChannel) Start(ctx context.Context) error {
+	logger.InfoC("email", "Starting Email channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+
+	go c.watchLoop()
+
+	c.setRunning(true)
+	logger.InfoCF("email", "Email channel started", map[string]interface{}{
+		"imap_host": c.config.IMAPHost,
+		"mailbox":   c.config.Mailbox,
+	})
+	return nil
+}
+
+func (c *EmailChannel) Stop(ctx context.Context) error {
+	logger.InfoC("email", "Stopping Email channel")
+
+	if c.cancel != nil {
+		c.cancel()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+
+	c.setRunning(false)
+	logger.InfoC("email", "Email channel stopped")
+	return nil
+}
+
+func (c *EmailChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("email channel not running")
+	}
+
+	c.mu.Lock()
+	current, ok := c.threads[msg.ChatID]
+	var thread emailThread
+	if ok {
+		thread = *current
+	}
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown email thread: %s", msg.ChatID)
+	}
+
+	var h mail.Header
+	h.SetDate(time.Now())
+	h.SetAddressList("From", []*mail.Address{{Address: c.from}})
+	h.SetAddressList("To", []*mail.Address{{Address: thread.To}})
+	h.SetSubject(replySubject(thread.Subject))
+	if err := h.GenerateMessageIDWithHostname(emailDomain(c.from)); err != nil {
+		return fmt.Errorf("failed to generate message id: %w", err)
+	}
+	messageID, _ := h.MessageID()
+
+	// In-Reply-To names the mail being answered; References carries the
+	// whole chain so clients keep the thread together.
+	references := append(append([]string(nil), thread.References...), thread.LastID)
+	h.SetMsgIDList("In-Reply-To", []string{thread.LastID})
+	h.SetMsgIDList("References", references)
+	h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+
+	var buf bytes.Buffer
+	w, err := mail.CreateSingleInlineWriter(&buf, h)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+	io.WriteString(w, msg.Content)
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	if err := c.sendSMTP(thread.To, buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	c.mu.Lock()
+	current.References = references
+	current.LastID = messageID
+	current.Updated = time.Now()
+	c.mu.Unlock()
+	c.saveThreads()
+
+	logger.DebugCF("email", "Reply sent", map[string]interface{}{
+		"to":     thread.To,
+		"thread": msg.ChatID,
+	})
+	return nil
+}
+
+// sendSMTP delivers data to rcpt. Port 465 uses implicit TLS; otherwise
+// STARTTLS is used whenever the server offers it.
+func (c *EmailChannel) sendSMTP(rcpt string, data []byte) error {
+	addr := net.JoinHostPort(c.config.SMTPHost, strconv.Itoa(c.config.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: c.config.SMTPHost}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if c.config.SMTPPort == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, c.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && c.config.SMTPPort != 465 {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if ok, _ := client.Extension("AUTH"); ok && c.config.Password != "" {
+		auth := smtp.PlainAuth("", c.config.Username, c.config.Password, c.config.SMTPHost)
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(rcpt); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// watchLoop keeps an IMAP session open, reconnecting after failures.
+func (c *EmailChannel) watchLoop() {
+	defer close(c.done)
+
+	for c.ctx.Err() == nil {
+		if err := c.watch(); err != nil && c.ctx.Err() == nil {
+			logger.ErrorCF("email", "IMAP session failed, reconnecting", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(emailReconnectDelay):
+		}
+	}
+}
+
+// watch runs one IMAP session: it waits for new mail with IDLE when the
+// server supports it, or polls otherwise, and handles every new message.
+func (c *EmailChannel) watch() error {
+	newMail := make(chan struct{}, 1)
+	options := &imapclient.Options{
+		UnilateralDataHandler: &imapclient.UnilateralDataHandler{
+			Mailbox: func(data *imapclient.UnilateralDataMailbox) {
+				if data.NumMessages != nil {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			},
+		},
+	}
+
+	addr := net.JoinHostPort(c.config.IMAPHost, strconv.Itoa(c.config.IMAPPort))
+	var client *imapclient.Client
+	var err error
+	if c.config.IMAPTLS {
+		client, err = imapclient.DialTLS(addr, options)
+	} else {
+		client, err = imapclient.DialInsecure(addr, options)
+	}
+	if err != nil {
+		return fmt.Errorf("dial %s: %w", addr, err)
+	}
+	defer client.Close()
+
+	// Unblock any pending command when the channel stops
+	stop := context.AfterFunc(c.ctx, func() { client.Close() })
+	defer stop()
+
+	if err := client.Login(c.config.Username, c.config.Password).Wait(); err != nil {
+		return fmt.Errorf("login: %w", err)
+	}
+	selected, err := client.Select(c.config.Mailbox, nil).Wait()
+	if err != nil {
+		return fmt.Errorf("select %s: %w", c.config.Mailbox, err)
+	}
+	c.resume(selected)
+
+	canIdle := client.Caps().Has(imap.CapIdle) || client.Caps().Has(imap.CapIMAP4rev2)
+	poll := time.Duration(c.config.PollInterval) * time.Second
+
+	for {
+		if err := c.fetchNew(client); err != nil {
+			return err
+		}
+
+		if !canIdle {
+			select {
+			case <-c.ctx.Done():
+				return nil
+			case <-time.After(poll):
+			}
+			if err := client.Noop().Wait(); err != nil {
+				return fmt.Errorf("noop: %w", err)
+			}
+			continue
+		}
+
+		idle, err := client.Idle()
+		if err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+		select {
+		case <-c.ctx.Done():
+		case <-newMail:
+		case <-time.After(poll):
+			// Periodic wake-up in case a notification was missed
+		}
+		if err := idle.Close(); err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+		if err := idle.Wait(); err != nil {
+			return fmt.Errorf("idle: %w", err)
+		}
+		if c.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// fetchNew handles unseen messages that arrived after lastUID and marks them
+// as seen.
+func (c *EmailChannel) fetchNew(client *imapclient.Client) error {
+	criteria := &imap.SearchCriteria{
+		UID:     []imap.UIDSet{{imap.UIDRange{Start: c.lastUID + 1}}},
+		NotFlag: []imap.Flag{imap.FlagSeen},
+	}
+	data, err := client.UIDSearch(criteria, nil).Wait()
+	if err != nil {
+		return fmt.Errorf("search: %w", err)
+	}
+
+	var uids []imap.UID
+	for _, uid := range data.AllUIDs() {
+		// "n:*" always matches the highest UID, even if it is below n
+		if uid > c.lastUID {
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	section := &imap.FetchItemBodySection{Peek: true}
+	messages, err := client.Fetch(imap.UIDSetNum(uids...), &imap.FetchOptions{
+		UID:         true,
+		BodySection: []*imap.FetchItemBodySection{section},
+	}).Collect()
+	if err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
+
+	for _, msg := range messages {
+		if raw := msg.FindBodySection(section); raw != nil {
+			c.handleRaw(raw)
+		}
+		if msg.UID > c.lastUID {
+			c.lastUID = msg.UID
+		}
+	}
+	c.saveCursor()
+
+	store := &imap.StoreFlags{Op: imap.StoreFlagsAdd, Flags: []imap.Flag{imap.FlagSeen}, Silent: true}
+	if err := client.Store(imap.UIDSetNum(uids...), store, nil).Close(); err != nil {
+		return fmt.Errorf("store: %w", err)
+	}
+	return nil
+}
+
+// emailState is what is saved per mailbox: the last UID handled, which
+// only stays valid while the mailbox keeps its UIDVALIDITY, and the
+// threads replies can go to.
+type emailState struct {
+	UIDValidity uint32                  `json:"uid_validity"`
+	LastUID     imap.UID                `json:"last_uid"`
+	Threads     map[string]*emailThread `json:"threads,omitempty"`
+}
+
+// resume sets where fetchNew starts after selecting the mailbox: from the
+// saved cursor, from the unseen mail if the mailbox was rebuilt since, or
+// after the mail already there on the very first start.
+func (c *EmailChannel) resume(selected *imap.SelectData) {
+	if c.uidValidity == 0 {
+		if cursor, ok := c.loadCursor(); ok {
+			c.uidValidity, c.lastUID = cursor.UIDValidity, cursor.LastUID
+		}
+	}
+
+	switch {
+	case c.uidValidity == selected.UIDValidity:
+	case c.uidValidity != 0:
+		logger.WarnCF("email", "Mailbox UIDVALIDITY changed, handling all unseen mail", map[string]interface{}{
+			"mailbox": c.config.Mailbox,
+		})
+		c.lastUID = 0
+	case selected.UIDNext > 0:
+		c.lastUID = selected.UIDNext - 1
+	}
+	c.uidValidity = selected.UIDValidity
+	c.saveCursor()
+}
+
+// stateKey tells apart mailboxes of different accounts sharing the file.
+func (c *EmailChannel) stateKey() string {
+	return c.config.Username + "@" + c.config.IMAPHost + "/" + c.config.Mailbox
+}
+
+func (c *EmailChannel) loadStates() map[string]emailState {
+	states := make(map[string]emailState)
+	raw, err := os.ReadFile(c.statePath)
+	if err != nil {
+		return states
+	}
+	if err := json.Unmarshal(raw, &states); err != nil {
+		logger.ErrorCF("email", "Email state file unreadable", map[string]interface{}{
+			"path":  c.statePath,
+			"error": err.Error(),
+		})
+	}
+	return states
+}
+
+func (c *EmailChannel) loadCursor() (emailState, bool) {
+	if c.statePath == "" {
+		return emailState{}, false
+	}
+	state, ok := c.loadStates()[c.stateKey()]
+	return state, ok && state.UIDValidity != 0
+}
+
+func (c *EmailChannel) saveCursor() {
+	c.updateState(func(state *emailState) bool {
+		if state.UIDValidity == c.uidValidity && state.LastUID == c.lastUID {
+			return false
+		}
+		state.UIDValidity, state.LastUID = c.uidValidity, c.lastUID
+		return true
+	})
+}
+
+func (c *EmailChannel) saveThreads() {
+	c.mu.Lock()
+	threads := make(map[string]*emailThread, len(c.threads))
+	for chatID, thread := range c.threads {
+		copied := *thread
+		threads[chatID] = &copied
+	}
+	c.mu.Unlock()
+
+	c.updateState(func(state *emailState) bool {
+		state.Threads = threads
+		return true
+	})
+}
+
+// updateState rewrites this mailbox's entry in the state file when update
+// reports a change. The cursor and the threads are saved from different
+// goroutines, so each only touches its own part.
+func (c *EmailChannel) updateState(update func(*emailState) bool) {
+	if c.statePath == "" {
+		return
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	states := c.loadStates()
+	state := states[c.stateKey()]
+	if !update(&state) {
+		return
+	}
+	states[c.stateKey()] = state
+
+	raw, err := json.MarshalIndent(states, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(c.statePath, raw)
+	}
+	if err != nil {
+		logger.ErrorCF("email", "Failed to save email state", map[string]interface{}{
+			"path":  c.statePath,
+			"error": err.Error(),
+		})
+	}
+}
+
+// pruneThreadsLocked forgets the least recently active threads beyond
+// emailMaxThreads.
+func (c *EmailChannel) pruneThreadsLocked() {
+	for len(c.threads) > emailMaxThreads {
+		var oldest string
+		for chatID, thread := range c.threads {
+			if oldest == "" || thread.Updated.Before(c.threads[oldest].Updated) {
+				oldest = chatID
+			}
+		}
+		delete(c.threads, oldest)
+	}
+}
+
+// handleRaw parses one RFC 5322 message and publishes it.
+func (c *EmailChannel) handleRaw(raw []byte) {
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil {
+		logger.WarnCF("email", "Failed to parse email", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer mr.Close()
+
+	fromList, _ := mr.Header.AddressList("From")
+	if len(fromList) == 0 {
+		return
+	}
+	sender := strings.ToLower(fromList[0].Address)
+	if strings.EqualFold(sender, c.from) {
+		return // our own reply copied into the mailbox
+	}
+	if !c.IsAllowed(sender) {
+		logger.DebugCF("email", "Ignoring mail from sender not in allow_from", map[string]interface{}{
+			"from": sender,
+		})
+		return
+	}
+
+	subject, _ := mr.Header.Subject()
+	messageID, _ := mr.Header.MessageID()
+	references, _ := mr.Header.MsgIDList("References")
+	inReplyTo, _ := mr.Header.MsgIDList("In-Reply-To")
+	if messageID == "" {
+		messageID = uuid.New().String() + "@" + emailDomain(sender)
+	}
+
+	// The thread is named after its first message
+	chatID := messageID
+	switch {
+	case len(references) > 0:
+		chatID = references[0]
+	case len(inReplyTo) > 0:
+		chatID = inReplyTo[0]
+	}
+
+	var text, html string
+	var media []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			logger.WarnCF("email", "Failed to read email part", map[string]interface{}{
+				"error": err.Error(),
+			})
+			break
+		}
+
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			contentType, _, _ := h.ContentType()
+			body, _ := io.ReadAll(part.Body)
+			switch {
+			case contentType == "text/plain" && text == "":
+				text = string(body)
+			case contentType == "text/html" && html == "":
+				html = string(body)
+			}
+		case *mail.AttachmentHeader:
+			filename, _ := h.Filename()
+			if path := saveEmailAttachment(filename, part.Body); path != "" {
+				media = append(media, path)
+			}
+		}
+	}
+
+	if text == "" && html != "" {
+		text = htmlToText(html)
+	}
+	content := stripQuotedReply(text)
+	if chatID == messageID && subject != "" {
+		content = "Subject: " + subject + "\n\n" + content
+	}
+	if strings.TrimSpace(content) == "" && len(media) == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	thread, ok := c.threads[chatID]
+	if !ok {
+		thread = &emailThread{Subject: subject}
+		c.threads[chatID] = thread
+	}
+	thread.To = sender
+	thread.LastID = messageID
+	thread.References = references
+	if len(thread.References) == 0 && len(inReplyTo) > 0 {
+		thread.References = inReplyTo
+	}
+	thread.Updated = time.Now()
+	c.pruneThreadsLocked()
+	c.mu.Unlock()
+	c.saveThreads()
+
+	logger.InfoCF("email", "Received email", map[string]interface{}{
+		"from":    sender,
+		"subject": subject,
+		"thread":  chatID,
+	})
+
+	metadata := map[string]string{
+		"message_id": messageID,
+		"subject":    subject,
+	}
+	c.HandleMessage(sender, chatID, content, media, metadata)
+}
+
+// saveEmailAttachment stores an attachment next to other downloaded media
+// and returns its path, or "" on failure.
+func saveEmailAttachment(filename string, body io.Reader) string {
+	if filename == "" {
+		filename = "attachment"
+	}
+	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(mediaDir, 0700); err != nil {
+		logger.ErrorCF("email", "Failed to create media directory", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+
+	localPath := filepath.Join(mediaDir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(filename))
+	out, err := os.Create(localPath)
+	if err != nil {
+		logger.ErrorCF("email", "Failed to save attachment", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, body); err != nil {
+		os.Remove(localPath)
+		logger.ErrorCF("email", "Failed to save attachment", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	return localPath
+}
+
+var (
+	emailQuoteHeader = regexp.MustCompile(`(?m)^On .+wrote:\s*$`)
+	htmlTag          = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlBlockEnd     = regexp.MustCompile(`(?i)<(br|/p|/div|/li|/tr)[^>]*>`)
+)
+
+// stripQuotedReply drops the quoted previous mail that clients append to a
+// reply, which the agent already has in its session history.
+func stripQuotedReply(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if loc := emailQuoteHeader.FindStringIndex(text); loc != nil {
+		text = text[:loc[0]]
+	}
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// htmlToText is a rough conversion for HTML-only mail.
+func htmlToText(html string) string {
+	text := htmlBlockEnd.ReplaceAllString(html, "\n")
+	text = htmlTag.ReplaceAllString(text, "")
+	replacer := strings.NewReplacer("&nbsp;", " ", "&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'")
+	return strings.TrimSpace(replacer.Replace(text))
+}
+
+func replySubject(subject string) string {
+	if subject == "" {
+		return "Re: your message"
+	}
+	if strings.HasPrefix(strings.ToLower(subject), "re:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+func emailDomain(address string) string {
+	if idx := strings.LastIndex(address, "@"); idx != -1 && idx < len(address)-1 {
+		return address[idx+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,337 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap/v2"
+	"github.com/emersion/go-imap/v2/imapserver"
+	"github.com/emersion/go-imap/v2/imapserver/imapmemserver"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// fakeSMTP accepts any mail and records the DATA of the last one.
+type fakeSMTP struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	data string
+	got  chan struct{}
+}
+
+func startFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTP{ln: ln, got: make(chan struct{}, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			reply("235 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var buf strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				buf.WriteString(l)
+			}
+			s.mu.Lock()
+			s.data = buf.String()
+			s.mu.Unlock()
+			s.got <- struct{}{}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func startFakeIMAP(t *testing.T) (*imapmemserver.User, int) {
+	t.Helper()
+	mem := imapmemserver.New()
+	user := imapmemserver.NewUser("bot@example.com", "secret")
+	if err := user.Create("INBOX", nil); err != nil {
+		t.Fatal(err)
+	}
+	mem.AddUser(user)
+
+	server := imapserver.New(&imapserver.Options{
+		NewSession: func(*imapserver.Conn) (imapserver.Session, *imapserver.GreetingData, error) {
+			return mem.NewSession(), nil, nil
+		},
+		Caps:         imap.CapSet{imap.CapIMAP4rev1: {}, imap.CapIMAP4rev2: {}},
+		InsecureAuth: true,
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	t.Cleanup(func() { server.Close() })
+
+	return user, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestEmailChannel_ReceiveAndReply(t *testing.T) {
+	user, imapPort := startFakeIMAP(t)
+	smtpServer := startFakeSMTP(t)
+	smtpPort, _ := strconv.Atoi(strings.TrimPrefix(smtpServer.ln.Addr().String(), "127.0.0.1:"))
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewEmailChannel(config.EmailConfig{
+		IMAPHost:     "127.0.0.1",
+		IMAPPort:     imapPort,
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     smtpPort,
+		Username:     "bot@example.com",
+		Password:     "secret",
+		Mailbox:      "INBOX",
+		PollInterval: 1,
+		AllowFrom:    config.FlexibleStringSlice{"Alice@example.com"},
+	}, "", msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	// Mail already in the mailbox before the first select is skipped
+	time.Sleep(500 * time.Millisecond)
+
+	appendMail := func(raw string) {
+		raw = strings.ReplaceAll(raw, "\n", "\r\n")
+		if _, err := user.Append("INBOX", bytes.NewReader([]byte(raw)), &imap.AppendOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendMail(`From: Mallory <mallory@example.com>
+To: bot@example.com
+Subject: spam
+Message-ID: <spam@example.com>
+Content-Type: text/plain
+
+ignore me
+`)
+	appendMail(`From: Alice <Alice@Example.com>
+To: bot@example.com
+Subject: Hello
+Message-ID: <first@example.com>
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=XYZ
+
+--XYZ
+Content-Type: text/plain; charset=utf-8
+
+What is in this file?
+--XYZ
+Content-Type: text/plain
+Content-Disposition: attachment; filename="notes.txt"
+
+some notes
+--XYZ--
+`)
+
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.SenderID != "alice@example.com" {
+		t.Errorf("SenderID = %q", msg.SenderID)
+	}
+	if msg.ChatID != "first@example.com" {
+		t.Errorf("ChatID = %q, want first@example.com", msg.ChatID)
+	}
+	if !strings.Contains(msg.Content, "Subject: Hello") || !strings.Contains(msg.Content, "What is in this file?") {
+		t.Errorf("Content = %q", msg.Content)
+	}
+	if len(msg.Media) != 1 {
+		t.Fatalf("Media = %v, want one attachment", msg.Media)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); strings.TrimSpace(string(data)) != "some notes" {
+		t.Errorf("attachment = %q", data)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{Channel: "email", ChatID: msg.ChatID, Content: "It holds notes."}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-smtpServer.got:
+	case <-ctx.Done():
+		t.Fatal("reply not delivered")
+	}
+
+	smtpServer.mu.Lock()
+	data := smtpServer.data
+	smtpServer.mu.Unlock()
+	for _, want := range []string{
+		"To: <alice@example.com>",
+		"Subject: Re: Hello",
+		"In-Reply-To: <first@example.com>",
+		"References: <first@example.com>",
+		"It holds notes.",
+	} {
+		if !strings.Contains(data, want) {
+			t.Errorf("reply missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestEmailChannel_ResumesAfterRestart(t *testing.T) {
+	user, imapPort := startFakeIMAP(t)
+	stateDir := t.TempDir()
+	cfg := config.EmailConfig{
+		IMAPHost:     "127.0.0.1",
+		IMAPPort:     imapPort,
+		SMTPHost:     "127.0.0.1",
+		Username:     "bot@example.com",
+		Password:     "secret",
+		PollInterval: 1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first, _ := NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	first.Start(ctx)
+	time.Sleep(500 * time.Millisecond)
+	first.Stop(context.Background())
+
+	// Arrives while the gateway is down
+	raw := "From: alice@example.com\r\nSubject: While you were out\r\nMessage-ID: <away@example.com>\r\n\r\nping\r\n"
+	if _, err := user.Append("INBOX", bytes.NewReader([]byte(raw)), &imap.AppendOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	msgBus := bus.NewMessageBus()
+	second, _ := NewEmailChannel(cfg, stateDir, msgBus)
+	second.Start(ctx)
+	defer second.Stop(context.Background())
+
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "away@example.com" {
+		t.Fatalf("mail sent during the restart was not picked up: %+v", msg)
+	}
+}
+
+func TestEmailChannel_Resume(t *testing.T) {
+	cfg := config.EmailConfig{IMAPHost: "imap.example.com", SMTPHost: "smtp.example.com", Username: "bot@example.com"}
+	stateDir := t.TempDir()
+
+	ch, _ := NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	ch.resume(&imap.SelectData{UIDValidity: 7, UIDNext: 11})
+	if ch.lastUID != 10 {
+		t.Errorf("first start lastUID = %d, want 10", ch.lastUID)
+	}
+	ch.lastUID = 12
+	ch.saveCursor()
+
+	ch, _ = NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	ch.resume(&imap.SelectData{UIDValidity: 7, UIDNext: 20})
+	if ch.lastUID != 12 {
+		t.Errorf("restart lastUID = %d, want the saved 12", ch.lastUID)
+	}
+
+	ch, _ = NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	ch.resume(&imap.SelectData{UIDValidity: 8, UIDNext: 3})
+	if ch.lastUID != 0 {
+		t.Errorf("new UIDVALIDITY lastUID = %d, want 0 to handle unseen mail", ch.lastUID)
+	}
+}
+
+func TestEmailChannel_RepliesToThreadAfterRestart(t *testing.T) {
+	smtpServer := startFakeSMTP(t)
+	smtpPort, _ := strconv.Atoi(strings.TrimPrefix(smtpServer.ln.Addr().String(), "127.0.0.1:"))
+	cfg := config.EmailConfig{
+		IMAPHost: "imap.example.com",
+		SMTPHost: "127.0.0.1",
+		SMTPPort: smtpPort,
+		Username: "bot@example.com",
+	}
+	stateDir := t.TempDir()
+
+	first, _ := NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	first.handleRaw([]byte("From: alice@example.com\r\nSubject: Plans\r\nMessage-ID: <plans@example.com>\r\n\r\nAny news?\r\n"))
+
+	second, _ := NewEmailChannel(cfg, stateDir, bus.NewMessageBus())
+	second.setRunning(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := second.Send(ctx, bus.OutboundMessage{Channel: "email", ChatID: "plans@example.com", Content: "Not yet."}); err != nil {
+		t.Fatalf("Send after restart: %v", err)
+	}
+	select {
+	case <-smtpServer.got:
+	case <-ctx.Done():
+		t.Fatal("reply not delivered")
+	}
+
+	smtpServer.mu.Lock()
+	data := smtpServer.data
+	smtpServer.mu.Unlock()
+	for _, want := range []string{"To: <alice@example.com>", "Subject: Re: Plans", "In-Reply-To: <plans@example.com>"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("reply missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestStripQuotedReply(t *testing.T) {
+	text := "Sounds good.\r\n\r\nOn Mon, 1 Jan 2026 at 10:00, Bot <bot@example.com> wrote:\r\n> earlier reply\r\n"
+	if got := stripQuotedReply(text); got != "Sounds good." {
+		t.Errorf("stripQuotedReply() = %q", got)
+	}
+}
@@ -0,0 +1,36 @@
+package channels
+
+import "github.com/sipeed/picoclaw/pkg/bus"
+
+// Card helpers shared by the SDK-based Feishu channel in feishu_64.go and
+// the native one in feishu_32.go.
+
+// feishuButtonValueKey holds a button's value in the card action payload.
+const feishuButtonValueKey = "picoclaw_button"
+
+// feishuMessageLimit keeps each card well under Feishu's 30 KB limit.
+const feishuMessageLimit = 8000
+
+// feishuCard builds an interactive card showing content as markdown with
+// the buttons, if any, below it.
+func feishuCard(content string, buttons []bus.Button) map[string]interface{} {
+	elements := []map[string]interface{}{
+		{"tag": "markdown", "content": content},
+	}
+	if len(buttons) > 0 {
+		actions := make([]map[string]interface{}, 0, len(buttons))
+		for _, button := range buttons {
+			actions = append(actions, map[string]interface{}{
+				"tag":   "button",
+				"type":  "primary",
+				"text":  map[string]string{"tag": "plain_text", "content": button.Label},
+				"value": map[string]string{feishuButtonValueKey: button.Value},
+			})
+		}
+		elements = append(elements, map[string]interface{}{"tag": "action", "actions": actions})
+	}
+	return map[string]interface{}{
+		"config":   map[string]bool{"wide_screen_mode": true},
+		"elements": elements,
+	}
+}
@@ -4,33 +4,252 @@ package channels
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
-// FeishuChannel is a stub implementation for 32-bit architectures
+// FeishuChannel uses the native client in feishu_native.go on 32-bit
+// systems, where the Lark SDK does not build.
 type FeishuChannel struct {
 	*BaseChannel
+	config config.FeishuConfig
+	client *feishuClient
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	seen   map[string]bool // recent event IDs, as events can be redelivered
+	order  []string
 }
 
-// NewFeishuChannel returns an error on 32-bit architectures where the Feishu SDK is not supported
 func NewFeishuChannel(cfg config.FeishuConfig, bus *bus.MessageBus) (*FeishuChannel, error) {
-	return nil, errors.New("feishu channel is not supported on 32-bit architectures (armv7l, 386, etc.). Please use a 64-bit system or disable feishu in your config")
+	base := NewBaseChannel("feishu", cfg, bus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
+
+	return &FeishuChannel{
+		BaseChannel: base,
+		config:      cfg,
+		client:      newFeishuClient(cfg.AppID, cfg.AppSecret),
+		seen:        make(map[string]bool),
+	}, nil
 }
 
-// Start is a stub method to satisfy the Channel interface
 func (c *FeishuChannel) Start(ctx context.Context) error {
+	if c.config.AppID == "" || c.config.AppSecret == "" {
+		return fmt.Errorf("feishu app_id or app_secret is empty")
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	go newFeishuListener(c.client, c.handleEvent).run(runCtx)
+
+	c.setRunning(true)
+	logger.InfoC("feishu", "Feishu channel started (native websocket mode)")
 	return nil
 }
 
-// Stop is a stub method to satisfy the Channel interface
 func (c *FeishuChannel) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	c.mu.Unlock()
+
+	c.setRunning(false)
+	logger.InfoC("feishu", "Feishu channel stopped")
 	return nil
 }
 
-// Send is a stub method to satisfy the Channel interface
 func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
-	return errors.New("feishu channel is not supported on 32-bit architectures")
+	if !c.IsRunning() {
+		return fmt.Errorf("feishu channel not running")
+	}
+
+	if msg.ChatID == "" {
+		return fmt.Errorf("chat ID is empty")
+	}
+
+	// Cards render Markdown, which text messages show as typed
+	chunks := RenderChunks(msg.Content, FormatFeishu, feishuMessageLimit)
+	if len(chunks) == 0 && len(msg.Media) == 0 {
+		chunks = []string{""}
+	}
+	for i, chunk := range chunks {
+		var buttons []bus.Button
+		if i == len(chunks)-1 {
+			buttons = msg.Buttons
+		}
+		if _, err := c.client.sendMessage(ctx, msg.ChatID, "interactive", feishuCard(chunk, buttons)); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range msg.Media {
+		imageKey, err := c.client.uploadImage(ctx, path)
+		if err != nil {
+			return err
+		}
+		if _, err := c.client.sendMessage(ctx, msg.ChatID, "image", map[string]string{"image_key": imageKey}); err != nil {
+			return err
+		}
+	}
+
+	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
+		"chat_id": msg.ChatID,
+	})
+
+	return nil
+}
+
+// RendersButtons reports that buttons are shown in an interactive card.
+func (c *FeishuChannel) RendersButtons() bool {
+	return true
+}
+
+// handleEvent handles an event from the long connection.
+func (c *FeishuChannel) handleEvent(ctx context.Context, payload []byte) (interface{}, error) {
+	event, err := decodeFeishuEvent(payload, c.config.EncryptKey, c.config.VerificationToken)
+	if err != nil {
+		return nil, err
+	}
+	if c.isDuplicate(event.Header.EventID) {
+		return nil, nil
+	}
+
+	switch event.Header.EventType {
+	case "im.message.receive_v1":
+		var msg feishuMessageEvent
+		if err := json.Unmarshal(event.Event, &msg); err != nil {
+			return nil, fmt.Errorf("invalid message event: %w", err)
+		}
+		// Answered right away, as Feishu redelivers events that take long
+		go c.handleMessageReceive(ctx, &msg)
+		return nil, nil
+	case "card.action.trigger":
+		var action feishuCardActionEvent
+		if err := json.Unmarshal(event.Event, &action); err != nil {
+			return nil, fmt.Errorf("invalid card action event: %w", err)
+		}
+		return c.handleCardAction(&action), nil
+	}
+	return nil, nil
+}
+
+// isDuplicate reports whether an event was seen recently.
+func (c *FeishuChannel) isDuplicate(eventID string) bool {
+	if eventID == "" {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seen[eventID] {
+		return true
+	}
+	c.seen[eventID] = true
+	c.order = append(c.order, eventID)
+	if len(c.order) > 500 {
+		delete(c.seen, c.order[0])
+		c.order = c.order[1:]
+	}
+	return false
+}
+
+func (c *FeishuChannel) handleMessageReceive(ctx context.Context, event *feishuMessageEvent) {
+	message := event.Message
+	chatID := message.ChatID
+	if chatID == "" || event.Sender.SenderType == "app" {
+		return
+	}
+
+	senderID := event.Sender.SenderID.String()
+	if senderID == "" {
+		senderID = "unknown"
+	}
+
+	metadata := map[string]string{
+		"message_id":   message.MessageID,
+		"message_type": message.MessageType,
+		"chat_type":    message.ChatType,
+		"is_group":     fmt.Sprintf("%t", message.ChatType == "group"),
+	}
+	if event.Sender.TenantKey != "" {
+		metadata["tenant_key"] = event.Sender.TenantKey
+	}
+	if !c.senderAllowed(senderID, metadata) {
+		c.requestPairing(senderID, chatID, message.ChatType == "group")
+		return
+	}
+
+	content, imageKeys := feishuMessageContent(message.MessageType, message.Content)
+	// Mentions arrive as placeholders such as @_user_1
+	for _, mention := range message.Mentions {
+		content = strings.ReplaceAll(content, mention.Key, "@"+mention.Name)
+	}
+	// Without the group message permission Feishu only delivers group
+	// messages that @mention the bot, so any mention is taken as ours
+	if len(message.Mentions) > 0 {
+		metadata[MetaIsMentioned] = "true"
+	}
+
+	mediaPaths := []string{}
+	defer func() {
+		for _, file := range mediaPaths {
+			if err := os.Remove(file); err != nil {
+				logger.DebugCF("feishu", "Failed to cleanup temp file", map[string]interface{}{
+					"file":  file,
+					"error": err.Error(),
+				})
+			}
+		}
+	}()
+	for _, key := range imageKeys {
+		if path := c.client.downloadResource(ctx, message.MessageID, key, "image", key+".jpg"); path != "" {
+			mediaPaths = append(mediaPaths, path)
+		}
+		content = appendContent(content, "[image: photo]")
+	}
+
+	if content == "" {
+		content = "[empty message]"
+	}
+
+	logger.InfoCF("feishu", "Feishu message received", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"preview":   utils.Truncate(content, 80),
+	})
+
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// handleCardAction passes a card button press to the agent.
+func (c *FeishuChannel) handleCardAction(event *feishuCardActionEvent) interface{} {
+	value, _ := event.Action.Value[feishuButtonValueKey].(string)
+	chatID := event.Context.OpenChatID
+	if value == "" || chatID == "" {
+		return nil
+	}
+
+	senderID := event.Operator.String()
+	if senderID == "" {
+		senderID = "unknown"
+	}
+
+	c.HandleButton(senderID, chatID, value, map[string]string{
+		"message_id": event.Context.OpenMessageID,
+	})
+	return map[string]interface{}{
+		"toast": map[string]string{"type": "info", "content": value},
+	}
 }
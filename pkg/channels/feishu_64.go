@@ -11,6 +11,7 @@ import (
 
 	lark "github.com/larksuite/oapi-sdk-go/v3"
 	larkdispatcher "github.com/larksuite/oapi-sdk-go/v3/event/dispatcher"
+	larkcallback "github.com/larksuite/oapi-sdk-go/v3/event/dispatcher/callback"
 	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
 	larkws "github.com/larksuite/oapi-sdk-go/v3/ws"
 
@@ -32,6 +33,7 @@ type FeishuChannel struct {
 
 func NewFeishuChannel(cfg config.FeishuConfig, bus *bus.MessageBus) (*FeishuChannel, error) {
 	base := NewBaseChannel("feishu", cfg, bus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
 
 	return &FeishuChannel{
 		BaseChannel: base,
@@ -46,7 +48,8 @@ func (c *FeishuChannel) Start(ctx context.Context) error {
 	}
 
 	dispatcher := larkdispatcher.NewEventDispatcher(c.config.VerificationToken, c.config.EncryptKey).
-		OnP2MessageReceiveV1(c.handleMessageReceive)
+		OnP2MessageReceiveV1(c.handleMessageReceive).
+		OnP2CardActionTrigger(c.handleCardAction)
 
 	runCtx, cancel := context.WithCancel(ctx)
 
@@ -97,28 +100,39 @@ func (c *FeishuChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("chat ID is empty")
 	}
 
-	payload, err := json.Marshal(map[string]string{"text": msg.Content})
-	if err != nil {
-		return fmt.Errorf("failed to marshal feishu content: %w", err)
+	// Cards render Markdown, which text messages show as typed
+	chunks := RenderChunks(msg.Content, FormatFeishu, feishuMessageLimit)
+	if len(chunks) == 0 {
+		chunks = []string{""}
 	}
+	for i, chunk := range chunks {
+		var buttons []bus.Button
+		if i == len(chunks)-1 {
+			buttons = msg.Buttons
+		}
+		payload, err := json.Marshal(feishuCard(chunk, buttons))
+		if err != nil {
+			return fmt.Errorf("failed to marshal feishu content: %w", err)
+		}
 
-	req := larkim.NewCreateMessageReqBuilder().
-		ReceiveIdType(larkim.ReceiveIdTypeChatId).
-		Body(larkim.NewCreateMessageReqBodyBuilder().
-			ReceiveId(msg.ChatID).
-			MsgType(larkim.MsgTypeText).
-			Content(string(payload)).
-			Uuid(fmt.Sprintf("picoclaw-%d", time.Now().UnixNano())).
-			Build()).
-		Build()
-
-	resp, err := c.client.Im.V1.Message.Create(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to send feishu message: %w", err)
-	}
+		req := larkim.NewCreateMessageReqBuilder().
+			ReceiveIdType(larkim.ReceiveIdTypeChatId).
+			Body(larkim.NewCreateMessageReqBodyBuilder().
+				ReceiveId(msg.ChatID).
+				MsgType(larkim.MsgTypeInteractive).
+				Content(string(payload)).
+				Uuid(fmt.Sprintf("picoclaw-%d", time.Now().UnixNano())).
+				Build()).
+			Build()
+
+		resp, err := c.client.Im.V1.Message.Create(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to send feishu message: %w", err)
+		}
 
-	if !resp.Success() {
-		return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
+		if !resp.Success() {
+			return fmt.Errorf("feishu api error: code=%d msg=%s", resp.Code, resp.Msg)
+		}
 	}
 
 	logger.DebugCF("feishu", "Feishu message sent", map[string]interface{}{
@@ -160,6 +174,12 @@ func (c *FeishuChannel) handleMessageReceive(_ context.Context, event *larkim.P2
 	}
 	if chatType := stringValue(message.ChatType); chatType != "" {
 		metadata["chat_type"] = chatType
+		metadata["is_group"] = fmt.Sprintf("%t", chatType == "group")
+	}
+	// Without the group message permission Feishu only delivers group
+	// messages that @mention the bot, so any mention is taken as ours
+	if len(message.Mentions) > 0 {
+		metadata[MetaIsMentioned] = "true"
 	}
 	if sender != nil && sender.TenantKey != nil {
 		metadata["tenant_key"] = *sender.TenantKey
@@ -175,6 +195,40 @@ func (c *FeishuChannel) handleMessageReceive(_ context.Context, event *larkim.P2
 	return nil
 }
 
+// RendersButtons reports that buttons are shown in an interactive card.
+func (c *FeishuChannel) RendersButtons() bool {
+	return true
+}
+
+// handleCardAction passes a card button press to the agent.
+func (c *FeishuChannel) handleCardAction(_ context.Context, event *larkcallback.CardActionTriggerEvent) (*larkcallback.CardActionTriggerResponse, error) {
+	if event == nil || event.Event == nil || event.Event.Action == nil || event.Event.Context == nil {
+		return nil, nil
+	}
+
+	value, _ := event.Event.Action.Value[feishuButtonValueKey].(string)
+	chatID := event.Event.Context.OpenChatID
+	if value == "" || chatID == "" {
+		return nil, nil
+	}
+
+	senderID := "unknown"
+	if operator := event.Event.Operator; operator != nil {
+		if operator.UserID != nil && *operator.UserID != "" {
+			senderID = *operator.UserID
+		} else if operator.OpenID != "" {
+			senderID = operator.OpenID
+		}
+	}
+
+	c.HandleButton(senderID, chatID, value, map[string]string{
+		"message_id": event.Event.Context.OpenMessageID,
+	})
+	return &larkcallback.CardActionTriggerResponse{
+		Toast: &larkcallback.Toast{Type: "info", Content: value},
+	}, nil
+}
+
 func extractFeishuSenderID(sender *larkim.EventSender) string {
 	if sender == nil || sender.SenderId == nil {
 		return ""
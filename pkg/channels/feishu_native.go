@@ -0,0 +1,861 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// The native Feishu client speaks the Open Platform's HTTP API and its
+// long-connection event protocol directly. The Lark SDK does not build on
+// 32-bit systems, so feishu_32.go uses this instead.
+
+const feishuBaseURL = "https://open.feishu.cn"
+
+// feishuTokenMargin renews the tenant access token this long before it
+// expires.
+const feishuTokenMargin = 5 * time.Minute
+
+// feishuClient calls the Feishu Open Platform API as a custom app.
+type feishuClient struct {
+	baseURL   string
+	appID     string
+	appSecret string
+	http      *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+func newFeishuClient(appID, appSecret string) *feishuClient {
+	return &feishuClient{
+		baseURL:   feishuBaseURL,
+		appID:     appID,
+		appSecret: appSecret,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// feishuError is an error code returned by the API.
+type feishuError struct {
+	Code int
+	Msg  string
+}
+
+func (e *feishuError) Error() string {
+	return fmt.Sprintf("feishu api error: code=%d msg=%s", e.Code, e.Msg)
+}
+
+// feishuInvalidToken reports whether an API error means the tenant access
+// token is no longer valid.
+func feishuInvalidToken(err error) bool {
+	var apiErr *feishuError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case 99991661, 99991663, 99991668:
+		return true
+	}
+	return false
+}
+
+// tenantToken returns a cached tenant access token, fetching a new one
+// when it is about to expire.
+func (c *feishuClient) tenantToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Before(c.tokenExpiry) {
+		return c.token, nil
+	}
+
+	body, _ := json.Marshal(map[string]string{"app_id": c.appID, "app_secret": c.appSecret})
+	var resp struct {
+		Code              int    `json:"code"`
+		Msg               string `json:"msg"`
+		TenantAccessToken string `json:"tenant_access_token"`
+		Expire            int    `json:"expire"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/open-apis/auth/v3/tenant_access_token/internal", "", bytes.NewReader(body), "application/json", &resp); err != nil {
+		return "", fmt.Errorf("failed to get tenant access token: %w", err)
+	}
+	if resp.Code != 0 {
+		return "", fmt.Errorf("failed to get tenant access token: %w", &feishuError{Code: resp.Code, Msg: resp.Msg})
+	}
+
+	c.token = resp.TenantAccessToken
+	c.tokenExpiry = time.Now().Add(time.Duration(resp.Expire)*time.Second - feishuTokenMargin)
+	return c.token, nil
+}
+
+func (c *feishuClient) resetToken() {
+	c.mu.Lock()
+	c.token = ""
+	c.mu.Unlock()
+}
+
+// call sends a request and decodes the JSON response into out.
+func (c *feishuClient) call(ctx context.Context, method, path, token string, body io.Reader, contentType string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response (status %d): %s", resp.StatusCode, utils.Truncate(string(data), 200))
+	}
+	return nil
+}
+
+// api calls an authenticated endpoint and decodes its "data" into out.
+// A rejected token is renewed and the call tried once more.
+func (c *feishuClient) api(ctx context.Context, method, path string, body []byte, contentType string, out interface{}) error {
+	err := c.apiOnce(ctx, method, path, body, contentType, out)
+	if feishuInvalidToken(err) {
+		c.resetToken()
+		err = c.apiOnce(ctx, method, path, body, contentType, out)
+	}
+	return err
+}
+
+func (c *feishuClient) apiOnce(ctx context.Context, method, path string, body []byte, contentType string, out interface{}) error {
+	token, err := c.tenantToken(ctx)
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"msg"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := c.call(ctx, method, path, token, bytes.NewReader(body), contentType, &resp); err != nil {
+		return err
+	}
+	if resp.Code != 0 {
+		return &feishuError{Code: resp.Code, Msg: resp.Msg}
+	}
+	if out == nil || len(resp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// sendMessage sends a message of msgType to a chat and returns its ID.
+// content is marshalled into the JSON string the API expects.
+func (c *feishuClient) sendMessage(ctx context.Context, chatID, msgType string, content interface{}) (string, error) {
+	payload, err := json.Marshal(content)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal feishu content: %w", err)
+	}
+	body, _ := json.Marshal(map[string]string{
+		"receive_id": chatID,
+		"msg_type":   msgType,
+		"content":    string(payload),
+		"uuid":       fmt.Sprintf("picoclaw-%d", time.Now().UnixNano()),
+	})
+
+	var data struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := c.api(ctx, http.MethodPost, "/open-apis/im/v1/messages?receive_id_type=chat_id", body, "application/json", &data); err != nil {
+		return "", fmt.Errorf("failed to send feishu message: %w", err)
+	}
+	return data.MessageID, nil
+}
+
+// uploadImage uploads an image file for use in messages and returns its
+// image key.
+func (c *feishuClient) uploadImage(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	form.WriteField("image_type", "message")
+	part, err := form.CreateFormFile("image", filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", err
+	}
+	form.Close()
+
+	var data struct {
+		ImageKey string `json:"image_key"`
+	}
+	if err := c.api(ctx, http.MethodPost, "/open-apis/im/v1/images", buf.Bytes(), form.FormDataContentType(), &data); err != nil {
+		return "", fmt.Errorf("failed to upload feishu image: %w", err)
+	}
+	return data.ImageKey, nil
+}
+
+// downloadResource saves an image or file of a received message to a
+// temporary file and returns its path, or "" if it could not.
+func (c *feishuClient) downloadResource(ctx context.Context, messageID, key, kind, filename string) string {
+	token, err := c.tenantToken(ctx)
+	if err != nil {
+		logger.WarnCF("feishu", "Failed to download resource", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	resourceURL := fmt.Sprintf("%s/open-apis/im/v1/messages/%s/resources/%s?type=%s",
+		c.baseURL, url.PathEscape(messageID), url.PathEscape(key), kind)
+	return utils.DownloadFile(resourceURL, filename, utils.DownloadOptions{
+		ExtraHeaders: map[string]string{"Authorization": "Bearer " + token},
+		LoggerPrefix: "feishu",
+	})
+}
+
+// feishuWSConfig is how the server wants the long connection kept, in
+// seconds.
+type feishuWSConfig struct {
+	ReconnectCount    int `json:"ReconnectCount"`
+	ReconnectInterval int `json:"ReconnectInterval"`
+	ReconnectNonce    int `json:"ReconnectNonce"`
+	PingInterval      int `json:"PingInterval"`
+}
+
+// wsEndpoint asks for the address of a long connection.
+func (c *feishuClient) wsEndpoint(ctx context.Context) (string, *feishuWSConfig, error) {
+	body, _ := json.Marshal(map[string]string{"AppID": c.appID, "AppSecret": c.appSecret})
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Data *struct {
+			URL          string          `json:"URL"`
+			ClientConfig *feishuWSConfig `json:"ClientConfig"`
+		} `json:"data"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/callback/ws/endpoint", "", bytes.NewReader(body), "application/json", &resp); err != nil {
+		return "", nil, fmt.Errorf("failed to get feishu websocket endpoint: %w", err)
+	}
+	if resp.Code != 0 {
+		return "", nil, fmt.Errorf("failed to get feishu websocket endpoint: %w", &feishuError{Code: resp.Code, Msg: resp.Msg})
+	}
+	if resp.Data == nil || resp.Data.URL == "" {
+		return "", nil, errors.New("feishu websocket endpoint is empty")
+	}
+	return resp.Data.URL, resp.Data.ClientConfig, nil
+}
+
+// Frame methods and header values of the long-connection protocol.
+const (
+	feishuFrameControl = 0
+	feishuFrameData    = 1
+)
+
+// feishuFrame is a message of the long-connection protocol, a protobuf
+// with the fields numbered as below.
+type feishuFrame struct {
+	SeqID           uint64      // 1
+	LogID           uint64      // 2
+	Service         int32       // 3
+	Method          int32       // 4
+	Headers         [][2]string // 5, key and value
+	PayloadEncoding string      // 6
+	PayloadType     string      // 7
+	Payload         []byte      // 8
+	LogIDNew        string      // 9
+}
+
+func (f *feishuFrame) header(key string) string {
+	for _, h := range f.Headers {
+		if h[0] == key {
+			return h[1]
+		}
+	}
+	return ""
+}
+
+func (f *feishuFrame) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, f.SeqID)
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, f.LogID)
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(int64(f.Service)))
+	b = protowire.AppendTag(b, 4, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(int64(f.Method)))
+	for _, h := range f.Headers {
+		var hb []byte
+		hb = protowire.AppendTag(hb, 1, protowire.BytesType)
+		hb = protowire.AppendString(hb, h[0])
+		hb = protowire.AppendTag(hb, 2, protowire.BytesType)
+		hb = protowire.AppendString(hb, h[1])
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendBytes(b, hb)
+	}
+	for _, field := range []struct {
+		num   protowire.Number
+		value string
+	}{{6, f.PayloadEncoding}, {7, f.PayloadType}, {8, string(f.Payload)}, {9, f.LogIDNew}} {
+		if field.value != "" {
+			b = protowire.AppendTag(b, field.num, protowire.BytesType)
+			b = protowire.AppendString(b, field.value)
+		}
+	}
+	return b
+}
+
+func parseFeishuFrame(b []byte) (*feishuFrame, error) {
+	f := &feishuFrame{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType && num <= 4:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				f.SeqID = v
+			case 2:
+				f.LogID = v
+			case 3:
+				f.Service = int32(v)
+			case 4:
+				f.Method = int32(v)
+			}
+		case typ == protowire.BytesType && num >= 5 && num <= 9:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 5:
+				h, err := parseFeishuHeader(v)
+				if err != nil {
+					return nil, err
+				}
+				f.Headers = append(f.Headers, h)
+			case 6:
+				f.PayloadEncoding = string(v)
+			case 7:
+				f.PayloadType = string(v)
+			case 8:
+				f.Payload = append([]byte(nil), v...)
+			case 9:
+				f.LogIDNew = string(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return f, nil
+}
+
+func parseFeishuHeader(b []byte) ([2]string, error) {
+	var h [2]string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return h, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ == protowire.BytesType && (num == 1 || num == 2) {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return h, protowire.ParseError(n)
+			}
+			b = b[n:]
+			h[num-1] = string(v)
+			continue
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return h, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return h, nil
+}
+
+// feishuEventHandler handles the JSON payload of an event and returns
+// data for the response, which only card callbacks use.
+type feishuEventHandler func(ctx context.Context, payload []byte) (interface{}, error)
+
+// feishuListener receives events over a long connection, reconnecting
+// when it drops.
+type feishuListener struct {
+	client *feishuClient
+	handle feishuEventHandler
+
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	cfg   feishuWSConfig      // as the server last asked for
+	parts map[string][][]byte // fragments of split payloads by message ID
+}
+
+func newFeishuListener(client *feishuClient, handle feishuEventHandler) *feishuListener {
+	return &feishuListener{
+		client: client,
+		handle: handle,
+		cfg:    feishuWSConfig{ReconnectInterval: 120, ReconnectNonce: 30, PingInterval: 120},
+		parts:  make(map[string][][]byte),
+	}
+}
+
+func (l *feishuListener) config() feishuWSConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cfg
+}
+
+func (l *feishuListener) configure(cfg feishuWSConfig) {
+	l.mu.Lock()
+	l.cfg = cfg
+	l.mu.Unlock()
+}
+
+// run keeps a long connection open until ctx is done.
+func (l *feishuListener) run(ctx context.Context) {
+	for ctx.Err() == nil {
+		err := l.serve(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		cfg := l.config()
+		delay := time.Duration(cfg.ReconnectInterval) * time.Second
+		if cfg.ReconnectNonce > 0 {
+			delay += time.Duration(rand.Intn(cfg.ReconnectNonce*1000)) * time.Millisecond
+		}
+		logger.WarnCF("feishu", "Feishu websocket disconnected, reconnecting", map[string]interface{}{
+			"error": fmt.Sprint(err),
+			"delay": delay.String(),
+		})
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// serve connects and handles frames until the connection drops.
+func (l *feishuListener) serve(ctx context.Context) error {
+	connURL, serverCfg, err := l.client.wsEndpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if serverCfg != nil {
+		l.configure(*serverCfg)
+	}
+	u, err := url.Parse(connURL)
+	if err != nil {
+		return err
+	}
+	serviceID, _ := strconv.ParseInt(u.Query().Get("service_id"), 10, 32)
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, connURL, nil)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("%w (status %s, %s)", err, resp.Header.Get("Handshake-Status"), resp.Header.Get("Handshake-Msg"))
+		}
+		return err
+	}
+	defer conn.Close()
+	logger.InfoC("feishu", "Feishu websocket connected")
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	go l.ping(conn, int32(serviceID), done)
+
+	for {
+		mt, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+		frame, err := parseFeishuFrame(data)
+		if err != nil {
+			logger.WarnCF("feishu", "Invalid websocket frame", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+		switch frame.Method {
+		case feishuFrameControl:
+			l.handleControl(frame)
+		case feishuFrameData:
+			go l.handleData(ctx, conn, frame)
+		}
+	}
+}
+
+// ping keeps the connection alive at the interval the server asks for.
+func (l *feishuListener) ping(conn *websocket.Conn, serviceID int32, done <-chan struct{}) {
+	for {
+		interval := time.Duration(l.config().PingInterval) * time.Second
+		if interval <= 0 {
+			interval = 2 * time.Minute
+		}
+		frame := &feishuFrame{Service: serviceID, Method: feishuFrameControl, Headers: [][2]string{{"type", "ping"}}}
+		if err := l.write(conn, frame); err != nil {
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// handleControl applies the settings a pong may carry.
+func (l *feishuListener) handleControl(frame *feishuFrame) {
+	if frame.header("type") != "pong" || len(frame.Payload) == 0 {
+		return
+	}
+	var serverCfg feishuWSConfig
+	if err := json.Unmarshal(frame.Payload, &serverCfg); err == nil {
+		l.configure(serverCfg)
+	}
+}
+
+// handleData passes an event to the handler and answers the frame, as the
+// server redelivers events that are not acknowledged.
+func (l *feishuListener) handleData(ctx context.Context, conn *websocket.Conn, frame *feishuFrame) {
+	if frame.header("type") != "event" {
+		return
+	}
+	payload := l.combine(frame)
+	if payload == nil {
+		return
+	}
+
+	start := time.Now()
+	response := map[string]interface{}{"code": http.StatusOK}
+	data, err := l.handle(ctx, payload)
+	if err != nil {
+		logger.ErrorCF("feishu", "Failed to handle event", map[string]interface{}{
+			"error": err.Error(),
+		})
+		response["code"] = http.StatusInternalServerError
+	} else if data != nil {
+		if encoded, err := json.Marshal(data); err == nil {
+			response["data"] = encoded
+		}
+	}
+
+	frame.Headers = append(frame.Headers, [2]string{"biz_rt", strconv.FormatInt(time.Since(start).Milliseconds(), 10)})
+	frame.Payload, _ = json.Marshal(response)
+	if err := l.write(conn, frame); err != nil {
+		logger.WarnCF("feishu", "Failed to acknowledge event", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// combine returns a frame's payload, or nil until all parts of a split
+// payload have arrived.
+func (l *feishuListener) combine(frame *feishuFrame) []byte {
+	sum, _ := strconv.Atoi(frame.header("sum"))
+	if sum <= 1 {
+		return frame.Payload
+	}
+	seq, _ := strconv.Atoi(frame.header("seq"))
+	id := frame.header("message_id")
+	if seq < 0 || seq >= sum {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	parts := l.parts[id]
+	if len(parts) != sum {
+		parts = make([][]byte, sum)
+		l.parts[id] = parts
+	}
+	parts[seq] = frame.Payload
+	for _, part := range parts {
+		if part == nil {
+			return nil
+		}
+	}
+	delete(l.parts, id)
+	return bytes.Join(parts, nil)
+}
+
+func (l *feishuListener) write(conn *websocket.Conn, frame *feishuFrame) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteMessage(websocket.BinaryMessage, frame.marshal())
+}
+
+// feishuEvent is an event in the 2.0 schema.
+type feishuEvent struct {
+	Header struct {
+		EventID   string `json:"event_id"`
+		EventType string `json:"event_type"`
+		Token     string `json:"token"`
+	} `json:"header"`
+	Event json.RawMessage `json:"event"`
+}
+
+// decodeFeishuEvent decrypts an event if it is encrypted and checks its
+// verification token when one is configured.
+func decodeFeishuEvent(payload []byte, encryptKey, verificationToken string) (*feishuEvent, error) {
+	var encrypted struct {
+		Encrypt string `json:"encrypt"`
+	}
+	if err := json.Unmarshal(payload, &encrypted); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if encrypted.Encrypt != "" {
+		if encryptKey == "" {
+			return nil, errors.New("event is encrypted but encrypt_key is not set")
+		}
+		plain, err := feishuDecrypt(encrypted.Encrypt, encryptKey)
+		if err != nil {
+			return nil, err
+		}
+		payload = plain
+	}
+
+	var event feishuEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	if verificationToken != "" && event.Header.Token != verificationToken {
+		return nil, errors.New("event verification token does not match")
+	}
+	return &event, nil
+}
+
+// feishuDecrypt decrypts an encrypted event: AES-256-CBC keyed with the
+// SHA-256 of the encrypt key, with the IV in front.
+func feishuDecrypt(encrypted, key string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted event: %w", err)
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted event has an invalid length")
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || pad > len(plain) {
+		return nil, errors.New("failed to decrypt event, check encrypt_key")
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// feishuUserID identifies a user by whichever ID the event carries,
+// preferring user_id as the SDK-based channel does.
+type feishuUserID struct {
+	UserID  string `json:"user_id"`
+	OpenID  string `json:"open_id"`
+	UnionID string `json:"union_id"`
+}
+
+func (id feishuUserID) String() string {
+	switch {
+	case id.UserID != "":
+		return id.UserID
+	case id.OpenID != "":
+		return id.OpenID
+	}
+	return id.UnionID
+}
+
+// feishuMessageEvent is the event of im.message.receive_v1.
+type feishuMessageEvent struct {
+	Sender struct {
+		SenderID   feishuUserID `json:"sender_id"`
+		SenderType string       `json:"sender_type"`
+		TenantKey  string       `json:"tenant_key"`
+	} `json:"sender"`
+	Message struct {
+		MessageID   string `json:"message_id"`
+		ParentID    string `json:"parent_id"`
+		ChatID      string `json:"chat_id"`
+		ChatType    string `json:"chat_type"`
+		MessageType string `json:"message_type"`
+		Content     string `json:"content"`
+		Mentions    []struct {
+			Key  string       `json:"key"`
+			ID   feishuUserID `json:"id"`
+			Name string       `json:"name"`
+		} `json:"mentions"`
+	} `json:"message"`
+}
+
+// feishuCardActionEvent is the event of card.action.trigger.
+type feishuCardActionEvent struct {
+	Operator feishuUserID `json:"operator"`
+	Action   struct {
+		Value map[string]interface{} `json:"value"`
+	} `json:"action"`
+	Context struct {
+		OpenMessageID string `json:"open_message_id"`
+		OpenChatID    string `json:"open_chat_id"`
+	} `json:"context"`
+}
+
+// feishuMessageContent returns the text of a received message and the
+// keys of the images in it.
+func feishuMessageContent(msgType, content string) (string, []string) {
+	switch msgType {
+	case "text":
+		var text struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal([]byte(content), &text); err == nil {
+			return text.Text, nil
+		}
+	case "image":
+		var image struct {
+			ImageKey string `json:"image_key"`
+		}
+		if err := json.Unmarshal([]byte(content), &image); err == nil && image.ImageKey != "" {
+			return "", []string{image.ImageKey}
+		}
+	case "post":
+		if text, images, ok := feishuPostContent(content); ok {
+			return text, images
+		}
+	}
+	return content, nil
+}
+
+type feishuPost struct {
+	Title   string `json:"title"`
+	Content [][]struct {
+		Tag      string `json:"tag"`
+		Text     string `json:"text"`
+		Href     string `json:"href"`
+		UserName string `json:"user_name"`
+		ImageKey string `json:"image_key"`
+	} `json:"content"`
+}
+
+// feishuPostContent flattens a rich text post into lines of text. Posts
+// arrive either as one post or keyed by language.
+func feishuPostContent(content string) (string, []string, bool) {
+	var post feishuPost
+	if err := json.Unmarshal([]byte(content), &post); err != nil {
+		return "", nil, false
+	}
+	if post.Title == "" && len(post.Content) == 0 {
+		var byLanguage map[string]feishuPost
+		if err := json.Unmarshal([]byte(content), &byLanguage); err != nil {
+			return "", nil, false
+		}
+		for _, lang := range []string{"zh_cn", "en_us", "ja_jp"} {
+			if p, ok := byLanguage[lang]; ok {
+				post = p
+				break
+			}
+		}
+		if post.Title == "" && len(post.Content) == 0 {
+			for _, p := range byLanguage {
+				post = p
+				break
+			}
+		}
+	}
+
+	var lines []string
+	if post.Title != "" {
+		lines = append(lines, post.Title)
+	}
+	var images []string
+	for _, paragraph := range post.Content {
+		var line strings.Builder
+		for _, el := range paragraph {
+			switch el.Tag {
+			case "text", "md":
+				line.WriteString(el.Text)
+			case "a":
+				if el.Href != "" && el.Href != el.Text {
+					fmt.Fprintf(&line, "%s (%s)", el.Text, el.Href)
+				} else {
+					line.WriteString(el.Text)
+				}
+			case "at":
+				line.WriteString("@" + el.UserName)
+			case "img":
+				images = append(images, el.ImageKey)
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), images, true
+}
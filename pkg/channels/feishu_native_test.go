@@ -0,0 +1,201 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFeishuFrameRoundTrip(t *testing.T) {
+	frame := &feishuFrame{
+		SeqID:   7,
+		LogID:   9,
+		Service: 3,
+		Method:  feishuFrameData,
+		Headers: [][2]string{{"type", "event"}, {"message_id", "m1"}},
+		Payload: []byte(`{"a":1}`),
+	}
+	got, err := parseFeishuFrame(frame.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SeqID != 7 || got.LogID != 9 || got.Service != 3 || got.Method != feishuFrameData {
+		t.Errorf("frame = %+v", got)
+	}
+	if got.header("type") != "event" || got.header("message_id") != "m1" || string(got.Payload) != `{"a":1}` {
+		t.Errorf("headers %v, payload %q", got.Headers, got.Payload)
+	}
+
+	if _, err := parseFeishuFrame([]byte{0x0a, 0x05}); err == nil {
+		t.Error("truncated frame parsed")
+	}
+}
+
+// feishuEncrypt encrypts an event the way Feishu does.
+func feishuEncrypt(t *testing.T, plain, key string) string {
+	t.Helper()
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	data := append([]byte(plain), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, aes.BlockSize+len(data))
+	copy(out, "0123456789abcdef")
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], data)
+	return base64.StdEncoding.EncodeToString(out)
+}
+
+func TestDecodeFeishuEvent(t *testing.T) {
+	plain := `{"schema":"2.0","header":{"event_id":"e1","event_type":"im.message.receive_v1","token":"vt"},"event":{"message":{"chat_id":"oc_1"}}}`
+
+	event, err := decodeFeishuEvent([]byte(plain), "", "vt")
+	if err != nil || event.Header.EventType != "im.message.receive_v1" {
+		t.Fatalf("plain event = %+v, %v", event, err)
+	}
+
+	encrypted, _ := json.Marshal(map[string]string{"encrypt": feishuEncrypt(t, plain, "secret")})
+	event, err = decodeFeishuEvent(encrypted, "secret", "vt")
+	if err != nil || event.Header.EventID != "e1" {
+		t.Fatalf("encrypted event = %+v, %v", event, err)
+	}
+	if _, err := decodeFeishuEvent(encrypted, "", ""); err == nil {
+		t.Error("encrypted event decoded without a key")
+	}
+	if _, err := decodeFeishuEvent([]byte(plain), "", "other"); err == nil {
+		t.Error("wrong verification token accepted")
+	}
+}
+
+func TestFeishuMessageContent(t *testing.T) {
+	if text, _ := feishuMessageContent("text", `{"text":"hello @_user_1"}`); text != "hello @_user_1" {
+		t.Errorf("text = %q", text)
+	}
+	if text, images := feishuMessageContent("image", `{"image_key":"img_1"}`); text != "" || len(images) != 1 || images[0] != "img_1" {
+		t.Errorf("image = %q, %v", text, images)
+	}
+
+	post := `{"title":"Plan","content":[[{"tag":"text","text":"see "},{"tag":"a","text":"docs","href":"https://example.com"}],[{"tag":"at","user_name":"Bob"},{"tag":"img","image_key":"img_2"}]]}`
+	text, images := feishuMessageContent("post", post)
+	if text != "Plan\nsee docs (https://example.com)\n@Bob" || len(images) != 1 || images[0] != "img_2" {
+		t.Errorf("post = %q, %v", text, images)
+	}
+	if text, _ := feishuMessageContent("post", `{"en_us":{"title":"","content":[[{"tag":"text","text":"hi"}]]}}`); text != "hi" {
+		t.Errorf("post by language = %q", text)
+	}
+}
+
+func TestFeishuClientRenewsToken(t *testing.T) {
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/open-apis/auth/v3/tenant_access_token/internal":
+			n := tokens.Add(1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "tenant_access_token": "t" + string(rune('0'+n)), "expire": 7200})
+		case "/open-apis/im/v1/messages":
+			// The first token is revoked
+			if r.Header.Get("Authorization") == "Bearer t1" {
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 99991663, "msg": "invalid token"})
+				return
+			}
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["receive_id"] != "oc_1" || body["msg_type"] != "interactive" || !strings.Contains(body["content"], "hello") {
+				t.Errorf("message body = %v", body)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "data": map[string]string{"message_id": "om_1"}})
+		}
+	}))
+	defer server.Close()
+
+	client := newFeishuClient("app", "secret")
+	client.baseURL = server.URL
+	id, err := client.sendMessage(context.Background(), "oc_1", "interactive", feishuCard("hello", nil))
+	if err != nil || id != "om_1" {
+		t.Fatalf("sendMessage = %q, %v", id, err)
+	}
+	if _, err := client.sendMessage(context.Background(), "oc_1", "interactive", feishuCard("hello", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens.Load(); got != 2 {
+		t.Errorf("fetched %d tokens, want the cached one reused after renewal", got)
+	}
+}
+
+func TestFeishuListenerAcknowledgesEvents(t *testing.T) {
+	acks := make(chan *feishuFrame, 1)
+	upgrader := websocket.Upgrader{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/callback/ws/endpoint" {
+			wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?service_id=5"
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": 0, "data": map[string]interface{}{"URL": wsURL}})
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Send the event in two parts
+		payload := []byte(`{"header":{"event_type":"im.message.receive_v1"},"event":{}}`)
+		for seq, part := range [][]byte{payload[:10], payload[10:]} {
+			frame := &feishuFrame{Service: 5, Method: feishuFrameData, Payload: part, Headers: [][2]string{
+				{"type", "event"}, {"message_id", "m1"}, {"sum", "2"}, {"seq", string(rune('0' + seq))},
+			}}
+			conn.WriteMessage(websocket.BinaryMessage, frame.marshal())
+		}
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if frame, err := parseFeishuFrame(data); err == nil && frame.Method == feishuFrameData {
+				acks <- frame
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := newFeishuClient("app", "secret")
+	client.baseURL = server.URL
+	handled := make(chan []byte, 1)
+	listener := newFeishuListener(client, func(ctx context.Context, payload []byte) (interface{}, error) {
+		handled <- payload
+		return nil, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go listener.run(ctx)
+
+	select {
+	case ack := <-acks:
+		var resp struct {
+			Code int `json:"code"`
+		}
+		json.Unmarshal(ack.Payload, &resp)
+		if resp.Code != http.StatusOK || ack.header("message_id") != "m1" {
+			t.Errorf("ack = %+v", ack)
+		}
+		if got := <-handled; !strings.Contains(string(got), "im.message.receive_v1") {
+			t.Errorf("handler got %q", got)
+		}
+	case <-ctx.Done():
+		t.Fatal("event was not acknowledged")
+	}
+}
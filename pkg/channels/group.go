@@ -0,0 +1,202 @@
+package channels
+
+import (
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// Metadata keys channels set on group messages for the group policy.
+// A message is only judged by the policy when is_group is "true".
+const (
+	MetaIsGroup      = "is_group"
+	MetaIsMentioned  = "is_mentioned"
+	MetaIsReplyToBot = "is_reply_to_bot"
+	// MetaListenOnly marks a message the agent should keep as context
+	// without answering.
+	MetaListenOnly = "listen_only"
+	// MetaParentID is set on messages in a thread with a chat ID of its
+	// own, such as a Discord thread, to the chat the thread belongs to. The
+	// parent's group settings apply to the thread.
+	MetaParentID = "parent_id"
+)
+
+// Group policy modes.
+const (
+	GroupModeAll     = "all"
+	GroupModeMention = "mention"
+	GroupModeListen  = "listen"
+)
+
+type groupAction int
+
+const (
+	groupReply groupAction = iota
+	groupListen
+	groupIgnore
+)
+
+// groupPolicy applies a channel's GroupsConfig to incoming messages.
+type groupPolicy struct {
+	cfg config.GroupsConfig
+	now func() time.Time
+}
+
+// SetGroupPolicy sets which group messages the channel passes to the
+// agent. Channels call it from their constructor.
+func (c *BaseChannel) SetGroupPolicy(cfg config.GroupsConfig) {
+	for key, policy := range cfg.Overrides {
+		if _, _, ok := parseQuietHours(policy.QuietHours); policy.QuietHours != "" && !ok {
+			logger.WarnCF("channels", "Invalid quiet_hours ignored", map[string]interface{}{
+				"channel": c.name,
+				"group":   key,
+				"value":   policy.QuietHours,
+			})
+		}
+	}
+	if _, _, ok := parseQuietHours(cfg.QuietHours); cfg.QuietHours != "" && !ok {
+		logger.WarnCF("channels", "Invalid quiet_hours ignored", map[string]interface{}{
+			"channel": c.name,
+			"value":   cfg.QuietHours,
+		})
+	}
+	c.groups = &groupPolicy{cfg: cfg, now: time.Now}
+}
+
+// withRequireMention turns a channel's older require_mention setting into
+// the "mention" group mode, unless a mode is configured.
+func withRequireMention(cfg config.GroupsConfig, requireMention bool) config.GroupsConfig {
+	if requireMention && cfg.Mode == "" {
+		cfg.Mode = GroupModeMention
+	}
+	return cfg
+}
+
+// ShouldReply reports whether the agent will answer a message, so channels
+// can skip typing indicators and the like for messages it won't.
+func (c *BaseChannel) ShouldReply(senderID, chatID, content string, metadata map[string]string) bool {
+	if !c.senderAllowed(senderID, metadata) {
+		return false
+	}
+	action, _ := c.groups.decide(senderID, chatID, content, metadata)
+	return action == groupReply
+}
+
+// decide returns what to do with a message and its content with any
+// trigger prefix removed. Direct messages are always answered.
+func (p *groupPolicy) decide(senderID, chatID, content string, metadata map[string]string) (groupAction, string) {
+	if p == nil || metadata[MetaIsGroup] != "true" {
+		return groupReply, content
+	}
+
+	groupID := groupKey(chatID)
+	if parent := metadata[MetaParentID]; parent != "" {
+		groupID = parent
+	}
+	if len(p.cfg.Allow) > 0 && !containsString(p.cfg.Allow, groupID) {
+		return groupIgnore, content
+	}
+	policy := p.policyFor(groupID)
+
+	triggered := policy.Mode == "" || policy.Mode == GroupModeAll ||
+		metadata[MetaIsMentioned] == "true" || metadata[MetaIsReplyToBot] == "true"
+	trimmed := strings.TrimSpace(content)
+	for _, prefix := range policy.TriggerPrefixes {
+		if prefix != "" && strings.HasPrefix(trimmed, prefix) {
+			triggered = true
+			content = strings.TrimSpace(strings.TrimPrefix(trimmed, prefix))
+			break
+		}
+	}
+
+	if triggered && len(policy.AllowFrom) > 0 && !allowListMatches(policy.AllowFrom, senderID) {
+		triggered = false
+	}
+	if triggered && inQuietHours(policy.QuietHours, p.now()) {
+		triggered = false
+	}
+
+	switch {
+	case triggered:
+		return groupReply, content
+	case policy.Mode == GroupModeListen:
+		return groupListen, content
+	default:
+		return groupIgnore, content
+	}
+}
+
+// policyFor returns the channel's policy with the group's overrides
+// applied.
+func (p *groupPolicy) policyFor(groupID string) config.GroupPolicyConfig {
+	policy := p.cfg.GroupPolicyConfig
+	override, ok := p.cfg.Overrides[groupID]
+	if !ok {
+		return policy
+	}
+	if override.Mode != "" {
+		policy.Mode = override.Mode
+	}
+	if len(override.TriggerPrefixes) > 0 {
+		policy.TriggerPrefixes = override.TriggerPrefixes
+	}
+	if override.QuietHours != "" {
+		policy.QuietHours = override.QuietHours
+	}
+	if len(override.AllowFrom) > 0 {
+		policy.AllowFrom = override.AllowFrom
+	}
+	return policy
+}
+
+// groupKey is the chat ID a group is configured under: the chat ID without
+// a "/thread" suffix, so a policy covers the group's threads too.
+func groupKey(chatID string) string {
+	if i := strings.Index(chatID, "/"); i > 0 {
+		return chatID[:i]
+	}
+	return chatID
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes after midnight.
+func parseQuietHours(s string) (start, end int, ok bool) {
+	from, to, found := strings.Cut(s, "-")
+	if !found {
+		return 0, 0, false
+	}
+	parse := func(v string) (int, bool) {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return 0, false
+		}
+		return t.Hour()*60 + t.Minute(), true
+	}
+	start, ok1 := parse(from)
+	end, ok2 := parse(to)
+	return start, end, ok1 && ok2
+}
+
+// inQuietHours reports whether now falls in the range, which may span
+// midnight.
+func inQuietHours(quietHours string, now time.Time) bool {
+	start, end, ok := parseQuietHours(quietHours)
+	if !ok || start == end {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
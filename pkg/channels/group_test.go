@@ -0,0 +1,118 @@
+package channels
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestGroupPolicyDecide(t *testing.T) {
+	noon := time.Date(2026, 1, 1, 12, 0, 0, 0, time.Local)
+	policy := &groupPolicy{
+		cfg: config.GroupsConfig{
+			GroupPolicyConfig: config.GroupPolicyConfig{
+				Mode:            GroupModeMention,
+				TriggerPrefixes: config.FlexibleStringSlice{"!bot"},
+			},
+			Overrides: map[string]config.GroupPolicyConfig{
+				"quiet":   {QuietHours: "11:00-13:00"},
+				"night":   {QuietHours: "22:00-07:00", Mode: GroupModeAll},
+				"team":    {AllowFrom: config.FlexibleStringSlice{"alice"}},
+				"chatter": {Mode: GroupModeListen},
+			},
+		},
+		now: func() time.Time { return noon },
+	}
+	group := map[string]string{MetaIsGroup: "true"}
+	mentioned := map[string]string{MetaIsGroup: "true", MetaIsMentioned: "true"}
+	reply := map[string]string{MetaIsGroup: "true", MetaIsReplyToBot: "true"}
+
+	tests := []struct {
+		name        string
+		sender      string
+		chatID      string
+		content     string
+		metadata    map[string]string
+		want        groupAction
+		wantContent string
+	}{
+		{name: "direct message", chatID: "dm", content: "hi", metadata: nil, want: groupReply, wantContent: "hi"},
+		{name: "unaddressed", chatID: "g1", content: "hi all", metadata: group, want: groupIgnore, wantContent: "hi all"},
+		{name: "mentioned", chatID: "g1", content: "hi", metadata: mentioned, want: groupReply, wantContent: "hi"},
+		{name: "reply to bot", chatID: "g1", content: "yes", metadata: reply, want: groupReply, wantContent: "yes"},
+		{name: "prefix", chatID: "g1/thread", content: " !bot weather", metadata: group, want: groupReply, wantContent: "weather"},
+		{name: "quiet hours", chatID: "quiet", content: "hi", metadata: mentioned, want: groupIgnore, wantContent: "hi"},
+		{name: "outside quiet hours spanning midnight", chatID: "night", content: "hi", metadata: group, want: groupReply, wantContent: "hi"},
+		{name: "group allowlist denies", sender: "bob", chatID: "team", content: "hi", metadata: mentioned, want: groupIgnore, wantContent: "hi"},
+		{name: "group allowlist allows", sender: "alice", chatID: "team", content: "hi", metadata: mentioned, want: groupReply, wantContent: "hi"},
+		{name: "listen", chatID: "chatter", content: "lunch?", metadata: group, want: groupListen, wantContent: "lunch?"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, content := policy.decide(tt.sender, tt.chatID, tt.content, tt.metadata)
+			if got != tt.want || content != tt.wantContent {
+				t.Errorf("decide() = %v, %q, want %v, %q", got, content, tt.want, tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestGroupPolicyAllowedGroups(t *testing.T) {
+	policy := &groupPolicy{
+		cfg: config.GroupsConfig{Allow: config.FlexibleStringSlice{"g1"}},
+		now: time.Now,
+	}
+	group := map[string]string{MetaIsGroup: "true"}
+
+	if got, _ := policy.decide("alice", "g1", "hi", group); got != groupReply {
+		t.Errorf("allowed group: %v", got)
+	}
+	if got, _ := policy.decide("alice", "g2", "hi", group); got != groupIgnore {
+		t.Errorf("other group: %v", got)
+	}
+	thread := map[string]string{MetaIsGroup: "true", MetaParentID: "g1"}
+	if got, _ := policy.decide("alice", "t1", "hi", thread); got != groupReply {
+		t.Errorf("thread of allowed group: %v", got)
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2026, 1, 1, hour, minute, 0, 0, time.Local) }
+
+	tests := []struct {
+		quietHours string
+		now        time.Time
+		want       bool
+	}{
+		{"23:00-07:00", at(23, 30), true},
+		{"23:00-07:00", at(6, 59), true},
+		{"23:00-07:00", at(7, 0), false},
+		{"09:00-17:00", at(12, 0), true},
+		{"09:00-17:00", at(18, 0), false},
+		{"bogus", at(12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := inQuietHours(tt.quietHours, tt.now); got != tt.want {
+			t.Errorf("inQuietHours(%q, %s) = %v, want %v", tt.quietHours, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestHandleMessageMarksListenOnly(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	ch := NewBaseChannel("test", nil, msgBus, nil)
+	ch.SetGroupPolicy(config.GroupsConfig{GroupPolicyConfig: config.GroupPolicyConfig{Mode: GroupModeListen}})
+
+	ch.HandleMessage("alice", "g1", "lunch?", nil, map[string]string{MetaIsGroup: "true"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok || msg.Metadata[MetaListenOnly] != "true" {
+		t.Errorf("message = %+v, received %v", msg, ok)
+	}
+}
@@ -0,0 +1,516 @@
+package channels
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	httpMaxReplies     = 100
+	httpMaxBodyBytes   = 1 << 20
+	httpMaxPollWait    = 60 * time.Second
+	httpSSEKeepalive   = 15 * time.Second
+	httpDefaultSession = "default"
+	httpModelName      = "picoclaw"
+)
+
+var httpSessionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.:@-]{1,128}$`)
+
+// AgentProcessor runs a message from a sender through the agent and
+// returns its reply. It is satisfied by *agent.AgentLoop.
+type AgentProcessor interface {
+	ProcessDirectFrom(ctx context.Context, content, sessionKey, channel, chatID, senderID string) (string, error)
+}
+
+// HTTPChannel exposes picoclaw over HTTP on the gateway port:
+//
+//   - a REST API where a message posted to a session is handled like any
+//     other inbound message and replies are read by long polling or SSE;
+//   - an OpenAI-compatible /v1/chat/completions endpoint that runs the
+//     agent synchronously.
+//
+// The session ID is the chat ID, so each session keeps its own history.
+type HTTPChannel struct {
+	*BaseChannel
+	config config.HTTPConfig
+	agent  AgentProcessor
+
+	mu       sync.Mutex
+	sessions map[string]*httpSession
+}
+
+// httpSession buffers replies for one chat until clients fetch them.
+type httpSession struct {
+	replies []httpReply
+	nextSeq int64
+	// changed is closed and replaced whenever a reply is added
+	changed chan struct{}
+}
+
+type httpReply struct {
+	Seq     int64     `json:"seq"`
+	Content string    `json:"content"`
+	Media   []string  `json:"media,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// NewHTTPChannel creates the HTTP API channel. A token is required because
+// the API can run tools on the host.
+func NewHTTPChannel(cfg config.HTTPConfig, messageBus *bus.MessageBus) (*HTTPChannel, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("http channel token is required")
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 300
+	}
+
+	base := NewBaseChannel("http", cfg, messageBus, cfg.AllowFrom)
+
+	return &HTTPChannel{
+		BaseChannel: base,
+		config:      cfg,
+		sessions:    make(map[string]*httpSession),
+	}, nil
+}
+
+// SetAgent sets the agent that serves /v1/chat/completions.
+func (c *HTTPChannel) SetAgent(agent AgentProcessor) {
+	c.agent = agent
+}
+
+// Start marks the channel running. Its handler is served by the gateway's
+// HTTP server, see Handler.
+func (c *HTTPChannel) Start(ctx context.Context) error {
+	c.setRunning(true)
+	logger.InfoC("http", "HTTP API channel started")
+	return nil
+}
+
+func (c *HTTPChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+	logger.InfoC("http", "HTTP API channel stopped")
+	return nil
+}
+
+// Send stores a reply for the session named by msg.ChatID and wakes
+// clients waiting on it.
+func (c *HTTPChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("http channel not running")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session := c.sessionLocked(msg.ChatID)
+	session.nextSeq++
+	session.replies = append(session.replies, httpReply{
+		Seq:     session.nextSeq,
+		Content: msg.Content,
+		Media:   msg.Media,
+		Time:    time.Now(),
+	})
+	if len(session.replies) > httpMaxReplies {
+		session.replies = session.replies[len(session.replies)-httpMaxReplies:]
+	}
+	close(session.changed)
+	session.changed = make(chan struct{})
+	return nil
+}
+
+func (c *HTTPChannel) sessionLocked(id string) *httpSession {
+	session, ok := c.sessions[id]
+	if !ok {
+		session = &httpSession{changed: make(chan struct{})}
+		c.sessions[id] = session
+	}
+	return session
+}
+
+// repliesAfter returns the replies newer than seq, the latest sequence
+// number, and a channel closed on the next change.
+func (c *HTTPChannel) repliesAfter(id string, seq int64) ([]httpReply, int64, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	session := c.sessionLocked(id)
+	var out []httpReply
+	for _, r := range session.replies {
+		if r.Seq > seq {
+			out = append(out, r)
+		}
+	}
+	return out, session.nextSeq, session.changed
+}
+
+// Handler returns the API handler, to be mounted on "/api/" and "/v1/".
+func (c *HTTPChannel) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/sessions/{id}/messages", c.handlePostMessage)
+	mux.HandleFunc("GET /api/v1/sessions/{id}/messages", c.handlePollMessages)
+	mux.HandleFunc("GET /api/v1/sessions/{id}/events", c.handleEvents)
+	mux.HandleFunc("POST /v1/chat/completions", c.handleChatCompletions)
+	mux.HandleFunc("GET /v1/models", c.handleModels)
+	return c.authenticate(mux)
+}
+
+func (c *HTTPChannel) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(c.config.Token)) != 1 {
+			writeHTTPError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		if !c.IsRunning() {
+			writeHTTPError(w, http.StatusServiceUnavailable, "http channel not running")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handlePostMessage queues a message for the agent. The response carries
+// the session's current sequence number; replies after it answer this
+// message.
+func (c *HTTPChannel) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !httpSessionIDPattern.MatchString(id) {
+		writeHTTPError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+
+	var req struct {
+		Content  string            `json:"content"`
+		SenderID string            `json:"sender_id"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpMaxBodyBytes)).Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeHTTPError(w, http.StatusBadRequest, "content is required")
+		return
+	}
+	if req.SenderID == "" {
+		req.SenderID = "api"
+	}
+	if !c.IsAllowed(req.SenderID) {
+		writeHTTPError(w, http.StatusForbidden, "sender not allowed")
+		return
+	}
+
+	_, seq, _ := c.repliesAfter(id, 0)
+
+	logger.DebugCF("http", "Received message", map[string]interface{}{
+		"sender_id": req.SenderID,
+		"chat_id":   id,
+		"preview":   utils.Truncate(req.Content, 50),
+	})
+	c.HandleMessage(req.SenderID, id, req.Content, nil, req.Metadata)
+
+	writeHTTPJSON(w, http.StatusAccepted, map[string]interface{}{
+		"session_id": id,
+		"after":      seq,
+	})
+}
+
+// handlePollMessages returns replies after ?after=N, waiting up to ?wait=
+// seconds for one to arrive.
+func (c *HTTPChannel) handlePollMessages(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !httpSessionIDPattern.MatchString(id) {
+		writeHTTPError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	waitSeconds, _ := strconv.Atoi(r.URL.Query().Get("wait"))
+	wait := min(time.Duration(waitSeconds)*time.Second, httpMaxPollWait)
+
+	replies, seq, changed := c.repliesAfter(id, after)
+	if len(replies) == 0 && wait > 0 {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 5*time.Second))
+		select {
+		case <-changed:
+			replies, seq, _ = c.repliesAfter(id, after)
+		case <-time.After(wait):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if replies == nil {
+		replies = []httpReply{}
+	}
+
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{
+		"session_id": id,
+		"messages":   replies,
+		"last_seq":   seq,
+	})
+}
+
+// handleEvents streams replies as server-sent events, starting after
+// ?after=N or the Last-Event-ID header.
+func (c *HTTPChannel) handleEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !httpSessionIDPattern.MatchString(id) {
+		writeHTTPError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	cursor := r.URL.Query().Get("after")
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		cursor = last
+	}
+	after, _ := strconv.ParseInt(cursor, 10, 64)
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepalive := time.NewTicker(httpSSEKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		replies, _, changed := c.repliesAfter(id, after)
+		for _, reply := range replies {
+			data, _ := json.Marshal(reply)
+			fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", reply.Seq, data)
+			after = reply.Seq
+		}
+		if len(replies) > 0 {
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+type chatCompletionRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+	Stream bool   `json:"stream"`
+	User   string `json:"user"`
+}
+
+// handleChatCompletions answers with the agent's reply to the last user
+// message. Earlier messages are ignored: the agent keeps the history of the
+// session, named by the X-Session-ID header or the "user" field.
+func (c *HTTPChannel) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if c.agent == nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, "agent not available")
+		return
+	}
+
+	var req chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, httpMaxBodyBytes)).Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	content := ""
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == "user" {
+			content = chatMessageText(req.Messages[i].Content)
+			break
+		}
+	}
+	if strings.TrimSpace(content) == "" {
+		writeHTTPError(w, http.StatusBadRequest, "a user message is required")
+		return
+	}
+
+	sessionID := r.Header.Get("X-Session-ID")
+	if sessionID == "" {
+		sessionID = req.User
+	}
+	if sessionID == "" {
+		sessionID = httpDefaultSession
+	}
+	if !httpSessionIDPattern.MatchString(sessionID) {
+		writeHTTPError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	senderID := req.User
+	if senderID == "" {
+		senderID = "api"
+	}
+	if !c.IsAllowed(senderID) {
+		writeHTTPError(w, http.StatusForbidden, "sender not allowed")
+		return
+	}
+	model := req.Model
+	if model == "" {
+		model = httpModelName
+	}
+
+	timeout := time.Duration(c.config.RequestTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(timeout + 10*time.Second))
+
+	logger.DebugCF("http", "Chat completion request", map[string]interface{}{
+		"session": sessionID,
+		"stream":  req.Stream,
+		"preview": utils.Truncate(content, 50),
+	})
+
+	id := "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	created := time.Now().Unix()
+
+	if !req.Stream {
+		reply, err := c.agent.ProcessDirectFrom(ctx, content, "http:"+sessionID, "http", sessionID, senderID)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       map[string]string{"role": "assistant", "content": reply},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+		})
+		return
+	}
+
+	// The agent's reply is not produced incrementally; the stream sends it
+	// as one chunk, with comments keeping the connection alive meanwhile.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	type result struct {
+		reply string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		reply, err := c.agent.ProcessDirectFrom(ctx, content, "http:"+sessionID, "http", sessionID, senderID)
+		done <- result{reply, err}
+	}()
+
+	keepalive := time.NewTicker(httpSSEKeepalive)
+	defer keepalive.Stop()
+
+	var res result
+	for waiting := true; waiting; {
+		select {
+		case res = <-done:
+			waiting = false
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			rc.Flush()
+		}
+	}
+	if res.err != nil {
+		res.reply = "Error: " + res.err.Error()
+	}
+
+	chunk := func(delta map[string]string, finish interface{}) {
+		data, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finish,
+			}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	chunk(map[string]string{"role": "assistant", "content": res.reply}, nil)
+	chunk(map[string]string{}, "stop")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	rc.Flush()
+}
+
+func (c *HTTPChannel) handleModels(w http.ResponseWriter, r *http.Request) {
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{
+		"object": "list",
+		"data": []map[string]interface{}{{
+			"id":       httpModelName,
+			"object":   "model",
+			"owned_by": "picoclaw",
+		}},
+	})
+}
+
+// chatMessageText returns the text of an OpenAI message content, which is a
+// string or an array of content parts.
+func chatMessageText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, p := range parts {
+		if p.Type == "text" && p.Text != "" {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func writeHTTPJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeHTTPError writes an error in the OpenAI error format, which the
+// REST API shares.
+func writeHTTPError(w http.ResponseWriter, status int, message string) {
+	writeHTTPJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"message": message},
+	})
+}
@@ -0,0 +1,188 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+type fakeAgent struct {
+	content, sessionKey, chatID, senderID string
+}
+
+func (a *fakeAgent) ProcessDirectFrom(ctx context.Context, content, sessionKey, channel, chatID, senderID string) (string, error) {
+	a.content, a.sessionKey, a.chatID, a.senderID = content, sessionKey, chatID, senderID
+	return "echo: " + content, nil
+}
+
+func newTestHTTPChannel(t *testing.T) (*HTTPChannel, *bus.MessageBus, *httptest.Server) {
+	t.Helper()
+	msgBus := bus.NewMessageBus()
+	ch, err := NewHTTPChannel(config.HTTPConfig{Token: "secret"}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Start(context.Background())
+	server := httptest.NewServer(ch.Handler())
+	t.Cleanup(server.Close)
+	return ch, msgBus, server
+}
+
+func doHTTP(t *testing.T, method, url, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHTTPChannel_RequiresToken(t *testing.T) {
+	if _, err := NewHTTPChannel(config.HTTPConfig{}, bus.NewMessageBus()); err == nil {
+		t.Error("expected an error without a token")
+	}
+
+	_, _, server := newTestHTTPChannel(t)
+	resp := doHTTP(t, http.MethodGet, server.URL+"/v1/models", "", map[string]string{"Authorization": "Bearer wrong"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestHTTPChannel_PostAndPoll(t *testing.T) {
+	ch, msgBus, server := newTestHTTPChannel(t)
+
+	resp := doHTTP(t, http.MethodPost, server.URL+"/api/v1/sessions/kitchen/messages", `{"content":"lights off","sender_id":"ha"}`, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.ChatID != "kitchen" || msg.SenderID != "ha" || msg.SessionKey != "http:kitchen" || msg.Content != "lights off" {
+		t.Errorf("inbound = %+v", msg)
+	}
+
+	// The long poll returns as soon as the reply is sent
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		ch.Send(ctx, bus.OutboundMessage{Channel: "http", ChatID: "kitchen", Content: "Done."})
+	}()
+	resp = doHTTP(t, http.MethodGet, server.URL+"/api/v1/sessions/kitchen/messages?after=0&wait=5", "", nil)
+	defer resp.Body.Close()
+	var poll struct {
+		Messages []httpReply `json:"messages"`
+		LastSeq  int64       `json:"last_seq"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&poll); err != nil {
+		t.Fatal(err)
+	}
+	if len(poll.Messages) != 1 || poll.Messages[0].Content != "Done." || poll.LastSeq != 1 {
+		t.Errorf("poll = %+v", poll)
+	}
+}
+
+func TestHTTPChannel_EventStream(t *testing.T) {
+	ch, _, server := newTestHTTPChannel(t)
+
+	resp := doHTTP(t, http.MethodGet, server.URL+"/api/v1/sessions/s1/events", "", nil)
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	ch.Send(context.Background(), bus.OutboundMessage{Channel: "http", ChatID: "s1", Content: "hello"})
+
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "id: 1" || lines[1] != "event: message" || !strings.Contains(lines[2], `"content":"hello"`) {
+		t.Errorf("event = %q", lines)
+	}
+}
+
+func TestHTTPChannel_ChatCompletions(t *testing.T) {
+	ch, _, server := newTestHTTPChannel(t)
+	agent := &fakeAgent{}
+	ch.SetAgent(agent)
+
+	body := `{"model":"picoclaw","messages":[
+		{"role":"system","content":"ignored"},
+		{"role":"user","content":"earlier"},
+		{"role":"user","content":[{"type":"text","text":"what time is it?"}]}]}`
+	resp := doHTTP(t, http.MethodPost, server.URL+"/v1/chat/completions", body, map[string]string{"X-Session-ID": "ha-1"})
+	defer resp.Body.Close()
+
+	var completion struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		t.Fatal(err)
+	}
+	if completion.Object != "chat.completion" || len(completion.Choices) != 1 ||
+		completion.Choices[0].Message.Content != "echo: what time is it?" {
+		t.Errorf("completion = %+v", completion)
+	}
+	if agent.sessionKey != "http:ha-1" || agent.chatID != "ha-1" || agent.senderID != "api" {
+		t.Errorf("session key = %q, chat id = %q, sender = %q", agent.sessionKey, agent.chatID, agent.senderID)
+	}
+}
+
+func TestHTTPChannel_ChatCompletionsStream(t *testing.T) {
+	ch, _, server := newTestHTTPChannel(t)
+	ch.SetAgent(&fakeAgent{})
+
+	resp := doHTTP(t, http.MethodPost, server.URL+"/v1/chat/completions",
+		`{"stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := string(data)
+	if !strings.Contains(stream, `"object":"chat.completion.chunk"`) ||
+		!strings.Contains(stream, `"content":"echo: hi"`) ||
+		!strings.Contains(stream, `"finish_reason":"stop"`) ||
+		!strings.HasSuffix(stream, "data: [DONE]\n\n") {
+		t.Errorf("stream = %s", stream)
+	}
+}
@@ -30,6 +30,10 @@ const (
 	lineBotInfoEndpoint  = lineAPIBase + "/info"
 	lineLoadingEndpoint  = lineAPIBase + "/chat/loading/start"
 	lineReplyTokenMaxAge = 25 * time.Second
+
+	// A text message holds 5000 characters, and a request up to 5 messages
+	lineMessageLimit          = 5000
+	lineMaxMessagesPerRequest = 5
 )
 
 type replyTokenEntry struct {
@@ -49,6 +53,7 @@ type LINEChannel struct {
 	botDisplayName string   // Bot's display name for text-based mention detection
 	replyTokens    sync.Map // chatID -> replyTokenEntry
 	quoteTokens    sync.Map // chatID -> quoteToken (string)
+	recent         *lineRecentMessages
 	ctx            context.Context
 	cancel         context.CancelFunc
 }
@@ -60,10 +65,12 @@ func NewLINEChannel(cfg config.LINEConfig, messageBus *bus.MessageBus) (*LINECha
 	}
 
 	base := NewBaseChannel("line", cfg, messageBus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
 
 	return &LINEChannel{
 		BaseChannel: base,
 		config:      cfg,
+		recent:      newLineRecentMessages(lineRecentMessagesSize),
 	}, nil
 }
 
@@ -234,9 +241,14 @@ type lineEvent struct {
 	ReplyToken string          `json:"replyToken"`
 	Source     lineSource      `json:"source"`
 	Message    json.RawMessage `json:"message"`
+	Postback   *linePostback   `json:"postback"`
 	Timestamp  int64           `json:"timestamp"`
 }
 
+type linePostback struct {
+	Data string `json:"data"`
+}
+
 type lineSource struct {
 	Type    string `json:"type"` // "user", "group", "room"
 	UserID  string `json:"userId"`
@@ -245,11 +257,12 @@ type lineSource struct {
 }
 
 type lineMessage struct {
-	ID         string `json:"id"`
-	Type       string `json:"type"` // "text", "image", "video", "audio", "file", "sticker"
-	Text       string `json:"text"`
-	QuoteToken string `json:"quoteToken"`
-	Mention    *struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"` // "text", "image", "video", "audio", "file", "sticker"
+	Text            string `json:"text"`
+	QuoteToken      string `json:"quoteToken"`
+	QuotedMessageID string `json:"quotedMessageId"` // Message the user quoted, if any
+	Mention         *struct {
 		Mentionees []lineMentionee `json:"mentionees"`
 	} `json:"mention"`
 	ContentProvider struct {
@@ -265,6 +278,10 @@ type lineMentionee struct {
 }
 
 func (c *LINEChannel) processEvent(event lineEvent) {
+	if event.Type == "postback" {
+		c.processPostback(event)
+		return
+	}
 	if event.Type != "message" {
 		logger.DebugCF("line", "Ignoring non-message event", map[string]interface{}{
 			"type": event.Type,
@@ -284,14 +301,6 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 		return
 	}
 
-	// In group chats, only respond when the bot is mentioned
-	if isGroup && !c.isBotMentioned(msg) {
-		logger.DebugCF("line", "Ignoring group message without mention", map[string]interface{}{
-			"chat_id": chatID,
-		})
-		return
-	}
-
 	// Store reply token for later use
 	if event.ReplyToken != "" {
 		c.replyTokens.Store(chatID, replyTokenEntry{
@@ -364,6 +373,16 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 		"platform":    "line",
 		"source_type": event.Source.Type,
 		"message_id":  msg.ID,
+		"is_group":    fmt.Sprintf("%t", isGroup),
+	}
+	if isGroup && c.isBotMentioned(msg) {
+		metadata[MetaIsMentioned] = "true"
+	}
+	c.recent.add(msg.ID, lineRecentMessage{Type: msg.Type, Text: content})
+	if msg.QuotedMessageID != "" {
+		replyMedia := c.addQuotedMessage(msg.QuotedMessageID, metadata)
+		localFiles = append(localFiles, replyMedia...)
+		mediaPaths = append(mediaPaths, replyMedia...)
 	}
 
 	logger.DebugCF("line", "Received message", map[string]interface{}{
@@ -375,11 +394,60 @@ func (c *LINEChannel) processEvent(event lineEvent) {
 	})
 
 	// Show typing/loading indicator (requires user ID, not group ID)
-	c.sendLoading(senderID)
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		c.sendLoading(senderID)
+	}
 
 	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
 }
 
+// addQuotedMessage records the message a user quoted. LINE only sends its
+// ID, so the text comes from the messages seen recently; a quoted image,
+// video or audio message is downloaded again.
+func (c *LINEChannel) addQuotedMessage(id string, metadata map[string]string) []string {
+	quoted, ok := c.recent.get(id)
+	if !ok {
+		return nil
+	}
+	if quoted.FromBot {
+		metadata[MetaIsReplyToBot] = "true"
+	}
+
+	var media []string
+	if filename, ok := lineMediaFilenames[quoted.Type]; ok {
+		if path := c.downloadContent(id, filename); path != "" {
+			media = append(media, path)
+		}
+	}
+	author := ""
+	if quoted.FromBot {
+		author = c.botDisplayName
+	}
+	setReplyContext(metadata, id, author, quoted.Text)
+	return media
+}
+
+// processPostback passes a quick reply press to the agent.
+func (c *LINEChannel) processPostback(event lineEvent) {
+	if event.Postback == nil || event.Postback.Data == "" {
+		return
+	}
+
+	chatID := c.resolveChatID(event.Source)
+	if event.ReplyToken != "" {
+		c.replyTokens.Store(chatID, replyTokenEntry{
+			token:     event.ReplyToken,
+			timestamp: time.Now(),
+		})
+	}
+
+	c.sendLoading(event.Source.UserID)
+	c.HandleButton(event.Source.UserID, chatID, event.Postback.Data, map[string]string{
+		"platform":    "line",
+		"source_type": event.Source.Type,
+	})
+}
+
 // isBotMentioned checks if the bot is mentioned in the message.
 // It first checks the mention metadata (userId match), then falls back
 // to text-based detection using the bot's display name, since LINE may
@@ -492,55 +560,142 @@ func (c *LINEChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 		quoteToken = qt.(string)
 	}
 
+	chunks := RenderChunks(msg.Content, FormatPlain, lineMessageLimit)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+	// The first message quotes, the last carries the quick replies
+	messages := make([]map[string]interface{}, len(chunks))
+	for i, chunk := range chunks {
+		var token string
+		var buttons []bus.Button
+		if i == 0 {
+			token = quoteToken
+		}
+		if i == len(chunks)-1 {
+			buttons = msg.Buttons
+		}
+		messages[i] = buildTextMessage(chunk, token, buttons)
+	}
+
 	// Try reply token first (free, valid for ~25 seconds)
 	if entry, ok := c.replyTokens.LoadAndDelete(msg.ChatID); ok {
 		tokenEntry := entry.(replyTokenEntry)
 		if time.Since(tokenEntry.timestamp) < lineReplyTokenMaxAge {
-			if err := c.sendReply(ctx, tokenEntry.token, msg.Content, quoteToken); err == nil {
+			batch := messages[:min(len(messages), lineMaxMessagesPerRequest)]
+			if err := c.sendReply(ctx, tokenEntry.token, batch); err == nil {
 				logger.DebugCF("line", "Message sent via Reply API", map[string]interface{}{
 					"chat_id": msg.ChatID,
 					"quoted":  quoteToken != "",
 				})
-				return nil
+				messages = messages[len(batch):]
+			} else {
+				logger.DebugC("line", "Reply API failed, falling back to Push API")
 			}
-			logger.DebugC("line", "Reply API failed, falling back to Push API")
 		}
 	}
 
 	// Fall back to Push API
-	return c.sendPush(ctx, msg.ChatID, msg.Content, quoteToken)
+	for len(messages) > 0 {
+		batch := messages[:min(len(messages), lineMaxMessagesPerRequest)]
+		if err := c.sendPush(ctx, msg.ChatID, batch); err != nil {
+			return err
+		}
+		messages = messages[len(batch):]
+	}
+	return nil
+}
+
+// RendersButtons reports that buttons are shown as quick replies.
+func (c *LINEChannel) RendersButtons() bool {
+	return true
 }
 
-// buildTextMessage creates a text message object, optionally with quoteToken.
-func buildTextMessage(content, quoteToken string) map[string]string {
-	msg := map[string]string{
+// LINE shows at most 13 quick replies, with labels of up to 20 characters.
+const (
+	lineMaxQuickReplies = 13
+	lineMaxLabelLength  = 20
+)
+
+// buildTextMessage creates a text message object, optionally with
+// quoteToken and with buttons as quick replies. A quick reply posts its
+// value back as a postback event.
+func buildTextMessage(content, quoteToken string, buttons []bus.Button) map[string]interface{} {
+	msg := map[string]interface{}{
 		"type": "text",
 		"text": content,
 	}
 	if quoteToken != "" {
 		msg["quoteToken"] = quoteToken
 	}
+
+	var items []map[string]interface{}
+	for _, button := range buttons {
+		if len(items) == lineMaxQuickReplies {
+			break
+		}
+		items = append(items, map[string]interface{}{
+			"type": "action",
+			"action": map[string]string{
+				"type":        "postback",
+				"label":       utils.Truncate(button.Label, lineMaxLabelLength),
+				"data":        button.Value,
+				"displayText": button.Label,
+			},
+		})
+	}
+	if len(items) > 0 {
+		msg["quickReply"] = map[string]interface{}{"items": items}
+	}
 	return msg
 }
 
-// sendReply sends a message using the LINE Reply API.
-func (c *LINEChannel) sendReply(ctx context.Context, replyToken, content, quoteToken string) error {
+// sendReply sends messages using the LINE Reply API.
+func (c *LINEChannel) sendReply(ctx context.Context, replyToken string, messages []map[string]interface{}) error {
 	payload := map[string]interface{}{
 		"replyToken": replyToken,
-		"messages":   []map[string]string{buildTextMessage(content, quoteToken)},
+		"messages":   messages,
 	}
 
-	return c.callAPI(ctx, lineReplyEndpoint, payload)
+	var resp lineSendResponse
+	if err := c.callAPI(ctx, lineReplyEndpoint, payload, &resp); err != nil {
+		return err
+	}
+	c.rememberSent(messages, resp)
+	return nil
 }
 
-// sendPush sends a message using the LINE Push API.
-func (c *LINEChannel) sendPush(ctx context.Context, to, content, quoteToken string) error {
+// sendPush sends messages using the LINE Push API.
+func (c *LINEChannel) sendPush(ctx context.Context, to string, messages []map[string]interface{}) error {
 	payload := map[string]interface{}{
 		"to":       to,
-		"messages": []map[string]string{buildTextMessage(content, quoteToken)},
+		"messages": messages,
+	}
+
+	var resp lineSendResponse
+	if err := c.callAPI(ctx, linePushEndpoint, payload, &resp); err != nil {
+		return err
 	}
+	c.rememberSent(messages, resp)
+	return nil
+}
+
+// lineSendResponse lists the IDs of the messages a send created.
+type lineSendResponse struct {
+	SentMessages []struct {
+		ID string `json:"id"`
+	} `json:"sentMessages"`
+}
 
-	return c.callAPI(ctx, linePushEndpoint, payload)
+// rememberSent keeps the bot's own messages so users can quote them.
+func (c *LINEChannel) rememberSent(messages []map[string]interface{}, resp lineSendResponse) {
+	for i, sent := range resp.SentMessages {
+		if i >= len(messages) {
+			break
+		}
+		text, _ := messages[i]["text"].(string)
+		c.recent.add(sent.ID, lineRecentMessage{Type: "text", Text: text, FromBot: true})
+	}
 }
 
 // sendLoading sends a loading animation indicator to the chat.
@@ -549,15 +704,16 @@ func (c *LINEChannel) sendLoading(chatID string) {
 		"chatId":         chatID,
 		"loadingSeconds": 60,
 	}
-	if err := c.callAPI(c.ctx, lineLoadingEndpoint, payload); err != nil {
+	if err := c.callAPI(c.ctx, lineLoadingEndpoint, payload, nil); err != nil {
 		logger.DebugCF("line", "Failed to send loading indicator", map[string]interface{}{
 			"error": err.Error(),
 		})
 	}
 }
 
-// callAPI makes an authenticated POST request to the LINE API.
-func (c *LINEChannel) callAPI(ctx context.Context, endpoint string, payload interface{}) error {
+// callAPI makes an authenticated POST request to the LINE API, decoding
+// the response into out unless it is nil.
+func (c *LINEChannel) callAPI(ctx context.Context, endpoint string, payload, out interface{}) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
@@ -583,6 +739,11 @@ func (c *LINEChannel) callAPI(ctx context.Context, endpoint string, payload inte
 		return fmt.Errorf("LINE API error (status %d): %s", resp.StatusCode, string(respBody))
 	}
 
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -596,3 +757,57 @@ func (c *LINEChannel) downloadContent(messageID, filename string) string {
 		},
 	})
 }
+
+// lineRecentMessagesSize is how many messages a user can quote back.
+const lineRecentMessagesSize = 1000
+
+// lineMediaFilenames are the message types whose content can be
+// downloaded, with the file name to save them under.
+var lineMediaFilenames = map[string]string{
+	"image": "image.jpg",
+	"video": "video.mp4",
+	"audio": "audio.m4a",
+}
+
+type lineRecentMessage struct {
+	Type    string
+	Text    string
+	FromBot bool
+}
+
+// lineRecentMessages remembers the last messages by ID, dropping the
+// oldest once full.
+type lineRecentMessages struct {
+	mu       sync.Mutex
+	messages map[string]lineRecentMessage
+	ring     []string
+	next     int
+}
+
+func newLineRecentMessages(size int) *lineRecentMessages {
+	return &lineRecentMessages{
+		messages: make(map[string]lineRecentMessage, size),
+		ring:     make([]string, size),
+	}
+}
+
+func (r *lineRecentMessages) add(id string, msg lineRecentMessage) {
+	if id == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.messages[id]; !ok {
+		delete(r.messages, r.ring[r.next])
+		r.ring[r.next] = id
+		r.next = (r.next + 1) % len(r.ring)
+	}
+	r.messages[id] = msg
+}
+
+func (r *lineRecentMessages) get(id string) (lineRecentMessage, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	msg, ok := r.messages[id]
+	return msg, ok
+}
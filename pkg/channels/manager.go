@@ -9,9 +9,12 @@ package channels
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
@@ -22,7 +25,14 @@ type Manager struct {
 	bus          *bus.MessageBus
 	config       *config.Config
 	dispatchTask *asyncTask
+	outbox       *Outbox
+	pairing      *Pairing
 	mu           sync.RWMutex
+
+	// Supervisor state, see supervisor.go
+	states            map[string]*channelState
+	statesMu          sync.Mutex
+	superviseInterval time.Duration
 }
 
 type asyncTask struct {
@@ -31,9 +41,13 @@ type asyncTask struct {
 
 func NewManager(cfg *config.Config, messageBus *bus.MessageBus) (*Manager, error) {
 	m := &Manager{
-		channels: make(map[string]Channel),
-		bus:      messageBus,
-		config:   cfg,
+		channels:          make(map[string]Channel),
+		bus:               messageBus,
+		config:            cfg,
+		outbox:            NewOutbox(filepath.Join(cfg.WorkspacePath(), "state"), cfg.Outbox),
+		pairing:           NewPairing(filepath.Join(cfg.WorkspacePath(), "state")),
+		states:            make(map[string]*channelState),
+		superviseInterval: defaultSuperviseInterval,
 	}
 
 	if err := m.initChannels(); err != nil {
@@ -59,7 +73,18 @@ func (m *Manager) initChannels() error {
 		}
 	}
 
-	if m.config.Channels.WhatsApp.Enabled && m.config.Channels.WhatsApp.BridgeURL != "" {
+	if m.config.Channels.WhatsApp.Enabled && m.config.Channels.WhatsApp.Mode == "native" {
+		logger.DebugC("channels", "Attempting to initialize native WhatsApp channel")
+		whatsapp, err := NewWhatsAppNativeChannel(m.config.Channels.WhatsApp, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize WhatsApp channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["whatsapp"] = whatsapp
+			logger.InfoC("channels", "WhatsApp channel enabled successfully")
+		}
+	} else if m.config.Channels.WhatsApp.Enabled && m.config.Channels.WhatsApp.BridgeURL != "" {
 		logger.DebugC("channels", "Attempting to initialize WhatsApp channel")
 		whatsapp, err := NewWhatsAppChannel(m.config.Channels.WhatsApp, m.bus)
 		if err != nil {
@@ -163,7 +188,7 @@ func (m *Manager) initChannels() error {
 		}
 	}
 
-	if m.config.Channels.OneBot.Enabled && m.config.Channels.OneBot.WSUrl != "" {
+	if m.config.Channels.OneBot.Enabled && oneBotConfigured(m.config.Channels.OneBot) {
 		logger.DebugC("channels", "Attempting to initialize OneBot channel")
 		onebot, err := NewOneBotChannel(m.config.Channels.OneBot, m.bus)
 		if err != nil {
@@ -176,6 +201,82 @@ func (m *Manager) initChannels() error {
 		}
 	}
 
+	if m.config.Channels.Email.Enabled && m.config.Channels.Email.IMAPHost != "" {
+		logger.DebugC("channels", "Attempting to initialize Email channel")
+		email, err := NewEmailChannel(m.config.Channels.Email, filepath.Join(m.config.WorkspacePath(), "state"), m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Email channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["email"] = email
+			logger.InfoC("channels", "Email channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Matrix.Enabled && m.config.Channels.Matrix.Homeserver != "" {
+		logger.DebugC("channels", "Attempting to initialize Matrix channel")
+		matrix, err := NewMatrixChannel(m.config.Channels.Matrix, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Matrix channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["matrix"] = matrix
+			logger.InfoC("channels", "Matrix channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.Signal.Enabled && m.config.Channels.Signal.RPCAddress != "" {
+		logger.DebugC("channels", "Attempting to initialize Signal channel")
+		signal, err := NewSignalChannel(m.config.Channels.Signal, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize Signal channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["signal"] = signal
+			logger.InfoC("channels", "Signal channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.HTTP.Enabled {
+		logger.DebugC("channels", "Attempting to initialize HTTP channel")
+		httpCh, err := NewHTTPChannel(m.config.Channels.HTTP, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize HTTP channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["http"] = httpCh
+			logger.InfoC("channels", "HTTP channel enabled successfully")
+		}
+	}
+
+	if m.config.Channels.WebChat.Enabled {
+		logger.DebugC("channels", "Attempting to initialize web chat channel")
+		webchat, err := NewWebChatChannel(m.config.Channels.WebChat, m.bus)
+		if err != nil {
+			logger.ErrorCF("channels", "Failed to initialize web chat channel", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			m.channels["webchat"] = webchat
+			logger.InfoC("channels", "Web chat channel enabled successfully")
+		}
+	}
+
+	if m.config.Pairing.Enabled {
+		for name, channel := range m.channels {
+			if unpairedChannels[name] {
+				continue
+			}
+			if c, ok := channel.(interface{ setPairing(*Pairing) }); ok {
+				c.setPairing(m.pairing)
+			}
+		}
+	}
+
 	logger.InfoCF("channels", "Channel initialization completed", map[string]interface{}{
 		"enabled_channels": len(m.channels),
 	})
@@ -199,18 +300,28 @@ func (m *Manager) StartAll(ctx context.Context) error {
 
 	go m.dispatchOutbound(dispatchCtx)
 
+	m.outbox.rejectUnknown(func(name string) bool {
+		_, ok := m.channels[name]
+		return ok
+	})
+	if pending := len(m.outbox.Pending()); pending > 0 {
+		logger.InfoCF("channels", "Resuming delivery of queued messages", map[string]interface{}{
+			"pending": pending,
+		})
+	}
+
 	for name, channel := range m.channels {
 		logger.InfoCF("channels", "Starting channel", map[string]interface{}{
 			"channel": name,
 		})
-		if err := channel.Start(ctx); err != nil {
-			logger.ErrorCF("channels", "Failed to start channel", map[string]interface{}{
-				"channel": name,
-				"error":   err.Error(),
-			})
-		}
+		m.stateFor(name).setState(ChannelStarting)
+		m.startChannel(ctx, name, channel)
+		go m.deliver(dispatchCtx, name, channel)
 	}
 
+	// Channels that failed to start or drop later are restarted
+	go m.supervise(dispatchCtx)
+
 	logger.InfoC("channels", "All channels started")
 	return nil
 }
@@ -236,6 +347,7 @@ func (m *Manager) StopAll(ctx context.Context) error {
 				"error":   err.Error(),
 			})
 		}
+		m.stateFor(name).setState(ChannelStopped)
 	}
 
 	logger.InfoC("channels", "All channels stopped")
@@ -262,18 +374,20 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 			}
 
 			m.mu.RLock()
-			channel, exists := m.channels[msg.Channel]
+			_, exists := m.channels[msg.Channel]
 			m.mu.RUnlock()
 
 			if !exists {
 				logger.WarnCF("channels", "Unknown channel for outbound message", map[string]interface{}{
 					"channel": msg.Channel,
 				})
+				m.outbox.reject(msg, "channel not enabled")
 				continue
 			}
 
-			if err := channel.Send(ctx, msg); err != nil {
-				logger.ErrorCF("channels", "Error sending message to channel", map[string]interface{}{
+			// Delivered by the channel's worker in deliver, see outbox.go
+			if _, err := m.outbox.Enqueue(msg); err != nil {
+				logger.WarnCF("channels", "Outbound message queued in memory only", map[string]interface{}{
 					"channel": msg.Channel,
 					"error":   err.Error(),
 				})
@@ -282,6 +396,28 @@ func (m *Manager) dispatchOutbound(ctx context.Context) {
 	}
 }
 
+// SetCommands shows the registry's commands on every channel that can
+// advertise them, and keeps them current as commands are registered.
+func (m *Manager) SetCommands(registry *commands.Registry) {
+	advertise := func() {
+		cmds := registry.List()
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		for _, channel := range m.channels {
+			if advertiser, ok := channel.(CommandAdvertiser); ok {
+				advertiser.SetCommands(cmds)
+			}
+		}
+	}
+	registry.OnChange(advertise)
+	advertise()
+}
+
+// Pairing returns the store of senders approved through pairing.
+func (m *Manager) Pairing() *Pairing {
+	return m.pairing
+}
+
 func (m *Manager) GetChannel(name string) (Channel, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -341,5 +477,22 @@ func (m *Manager) SendToChannel(ctx context.Context, channelName, chatID, conten
 		Content: content,
 	}
 
-	return channel.Send(ctx, msg)
+	err := channel.Send(ctx, msg)
+	m.recordSend(channelName, err)
+	return err
+}
+
+// recordSend counts an outbound message and logs a failed send.
+func (m *Manager) recordSend(channelName string, err error) {
+	state := m.stateFor(channelName)
+	if err == nil {
+		state.sent.Add(1)
+		return
+	}
+	state.sendErrors.Add(1)
+	state.recordError(fmt.Errorf("send: %w", err))
+	logger.ErrorCF("channels", "Error sending message to channel", map[string]interface{}{
+		"channel": channelName,
+		"error":   err.Error(),
+	})
 }
@@ -0,0 +1,675 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+const (
+	matrixSyncTimeout    = 30 * time.Second
+	matrixRetryDelay     = 5 * time.Second
+	matrixTypingTimeout  = 30 * time.Second
+	matrixTypingMaxTotal = 5 * time.Minute
+	// Events are limited to 64 KB, which holds the body and its HTML
+	matrixMessageLimit = 16000
+)
+
+// MatrixChannel implements the Channel interface for Matrix using the
+// client-server API: a long-polling /sync loop for receiving and room
+// message events for sending. Chat IDs are room IDs, or "roomID/threadRoot"
+// for messages in an m.thread thread.
+type MatrixChannel struct {
+	*BaseChannel
+	config      config.MatrixConfig
+	homeserver  string
+	accessToken string
+	userID      string
+	displayName string
+	client      *http.Client
+	ctx         context.Context
+	cancel      context.CancelFunc
+	txnCounter  atomic.Int64
+	syncErr     atomic.Pointer[string] // last sync failure, nil once a sync succeeds
+
+	mu          sync.Mutex
+	memberCount map[string]int // roomID -> joined members
+	lastEvent   map[string]string
+
+	stopTyping sync.Map // chatID -> *thinkingCancel
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Summary struct {
+				JoinedMemberCount *int `json:"m.joined_member_count"`
+			} `json:"summary"`
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]struct {
+			InviteState struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"invite_state"`
+		} `json:"invite"`
+	} `json:"rooms"`
+}
+
+type matrixEvent struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key,omitempty"`
+	Content  json.RawMessage `json:"content"`
+}
+
+type matrixMessageContent struct {
+	MsgType   string `json:"msgtype"`
+	Body      string `json:"body"`
+	Filename  string `json:"filename"`
+	URL       string `json:"url"`
+	RelatesTo *struct {
+		RelType       string `json:"rel_type"`
+		EventID       string `json:"event_id"`
+		IsFallingBack bool   `json:"is_falling_back"`
+		InReplyTo     *struct {
+			EventID string `json:"event_id"`
+		} `json:"m.in_reply_to"`
+	} `json:"m.relates_to"`
+	Mentions *struct {
+		UserIDs []string `json:"user_ids"`
+	} `json:"m.mentions"`
+}
+
+// NewMatrixChannel creates a new Matrix channel instance.
+func NewMatrixChannel(cfg config.MatrixConfig, messageBus *bus.MessageBus) (*MatrixChannel, error) {
+	if cfg.Homeserver == "" {
+		return nil, fmt.Errorf("matrix homeserver is required")
+	}
+	if cfg.AccessToken == "" && (cfg.UserID == "" || cfg.Password == "") {
+		return nil, fmt.Errorf("matrix access_token, or user_id and password, are required")
+	}
+
+	base := NewBaseChannel("matrix", cfg, messageBus, cfg.AllowFrom)
+	base.SetGroupPolicy(withRequireMention(cfg.Groups, cfg.RequireMention))
+
+	return &MatrixChannel{
+		BaseChannel: base,
+		config:      cfg,
+		homeserver:  strings.TrimRight(cfg.Homeserver, "/"),
+		accessToken: cfg.AccessToken,
+		userID:      cfg.UserID,
+		client:      &http.Client{Timeout: matrixSyncTimeout + 30*time.Second},
+		memberCount: make(map[string]int),
+		lastEvent:   make(map[string]string),
+	}, nil
+}
+
+// Start logs in if needed and launches the sync loop.
+func (c *MatrixChannel) Start(ctx context.Context) error {
+	logger.InfoC("matrix", "Starting Matrix channel")
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	if c.accessToken == "" {
+		if err := c.login(); err != nil {
+			return fmt.Errorf("matrix login failed: %w", err)
+		}
+	}
+	if err := c.whoami(); err != nil {
+		return fmt.Errorf("matrix whoami failed: %w", err)
+	}
+	c.fetchDisplayName()
+
+	go c.syncLoop()
+
+	c.setRunning(true)
+	logger.InfoCF("matrix", "Matrix channel started", map[string]interface{}{
+		"user_id":    c.userID,
+		"homeserver": c.homeserver,
+	})
+	return nil
+}
+
+// Stop stops the sync loop.
+func (c *MatrixChannel) Stop(ctx context.Context) error {
+	logger.InfoC("matrix", "Stopping Matrix channel")
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.setRunning(false)
+	logger.InfoC("matrix", "Matrix channel stopped")
+	return nil
+}
+
+// Health reports the last sync failure, if the latest sync failed.
+func (c *MatrixChannel) Health() error {
+	if msg := c.syncErr.Load(); msg != nil {
+		return fmt.Errorf("sync failing: %s", *msg)
+	}
+	return nil
+}
+
+// Send posts msg to its room, inside the thread when the chat ID names one.
+// Markdown content is sent with an HTML rendering as formatted_body, split
+// into several events when it is very long.
+func (c *MatrixChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("matrix channel not running")
+	}
+
+	roomID, threadRoot := parseMatrixChatID(msg.ChatID)
+	if roomID == "" {
+		return Permanent(fmt.Errorf("invalid matrix chat ID: %s", msg.ChatID))
+	}
+
+	c.stopTypingFor(msg.ChatID)
+
+	c.mu.Lock()
+	replyTo := c.lastEvent[msg.ChatID]
+	c.mu.Unlock()
+	if replyTo == "" {
+		replyTo = threadRoot
+	}
+
+	for _, part := range SplitMarkdown(msg.Content, matrixMessageLimit) {
+		content := map[string]interface{}{
+			"msgtype":        "m.text",
+			"body":           part,
+			"format":         "org.matrix.custom.html",
+			"formatted_body": RenderMarkdown(part, FormatMatrixHTML),
+		}
+		if threadRoot != "" {
+			// is_falling_back marks the reply as a plain thread message for
+			// clients that render m.in_reply_to without thread support.
+			content["m.relates_to"] = map[string]interface{}{
+				"rel_type":        "m.thread",
+				"event_id":        threadRoot,
+				"is_falling_back": true,
+				"m.in_reply_to":   map[string]string{"event_id": replyTo},
+			}
+		}
+
+		txnID := fmt.Sprintf("picoclaw-%d-%d", time.Now().UnixNano(), c.txnCounter.Add(1))
+		path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.room.message/%s", url.PathEscape(roomID), url.PathEscape(txnID))
+		if err := c.callAPI(ctx, http.MethodPut, path, content, nil); err != nil {
+			return fmt.Errorf("failed to send matrix message: %w", err)
+		}
+	}
+
+	logger.DebugCF("matrix", "Message sent", map[string]interface{}{
+		"room_id": roomID,
+		"thread":  threadRoot,
+	})
+	return nil
+}
+
+func (c *MatrixChannel) login() error {
+	payload := map[string]interface{}{
+		"type": "m.login.password",
+		"identifier": map[string]string{
+			"type": "m.id.user",
+			"user": c.config.UserID,
+		},
+		"password":                    c.config.Password,
+		"initial_device_display_name": "picoclaw",
+	}
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		UserID      string `json:"user_id"`
+	}
+	if err := c.callAPI(c.ctx, http.MethodPost, "/_matrix/client/v3/login", payload, &resp); err != nil {
+		return err
+	}
+	if resp.AccessToken == "" {
+		return fmt.Errorf("no access token in login response")
+	}
+	c.accessToken = resp.AccessToken
+	c.userID = resp.UserID
+	return nil
+}
+
+func (c *MatrixChannel) whoami() error {
+	var resp struct {
+		UserID string `json:"user_id"`
+	}
+	if err := c.callAPI(c.ctx, http.MethodGet, "/_matrix/client/v3/account/whoami", nil, &resp); err != nil {
+		return err
+	}
+	c.userID = resp.UserID
+	return nil
+}
+
+// fetchDisplayName loads the bot's display name for text mention detection.
+func (c *MatrixChannel) fetchDisplayName() {
+	var resp struct {
+		DisplayName string `json:"displayname"`
+	}
+	path := fmt.Sprintf("/_matrix/client/v3/profile/%s/displayname", url.PathEscape(c.userID))
+	if err := c.callAPI(c.ctx, http.MethodGet, path, nil, &resp); err != nil {
+		logger.DebugCF("matrix", "Failed to fetch display name", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.displayName = resp.DisplayName
+}
+
+// syncLoop long-polls /sync. Events in the first response are history and
+// are only used to learn the room state.
+func (c *MatrixChannel) syncLoop() {
+	since := ""
+	for c.ctx.Err() == nil {
+		resp, err := c.sync(since)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			msg := err.Error()
+			c.syncErr.Store(&msg)
+			logger.ErrorCF("matrix", "Sync failed", map[string]interface{}{
+				"error": msg,
+			})
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(matrixRetryDelay):
+			}
+			continue
+		}
+
+		c.syncErr.Store(nil)
+		c.processSync(resp, since == "")
+		since = resp.NextBatch
+	}
+}
+
+func (c *MatrixChannel) sync(since string) (*matrixSyncResponse, error) {
+	params := url.Values{}
+	params.Set("timeout", fmt.Sprintf("%d", matrixSyncTimeout.Milliseconds()))
+	if since != "" {
+		params.Set("since", since)
+	} else {
+		// Only the latest event per room is needed to start
+		params.Set("filter", `{"room":{"timeline":{"limit":1}}}`)
+	}
+
+	var resp matrixSyncResponse
+	if err := c.callAPI(c.ctx, http.MethodGet, "/_matrix/client/v3/sync?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *MatrixChannel) processSync(resp *matrixSyncResponse, initial bool) {
+	for roomID, invite := range resp.Rooms.Invite {
+		c.handleInvite(roomID, invite.InviteState.Events)
+	}
+
+	for roomID, room := range resp.Rooms.Join {
+		if n := room.Summary.JoinedMemberCount; n != nil {
+			c.mu.Lock()
+			c.memberCount[roomID] = *n
+			c.mu.Unlock()
+		}
+		if initial {
+			continue
+		}
+		for _, event := range room.Timeline.Events {
+			c.handleEvent(roomID, event)
+		}
+	}
+}
+
+// handleInvite joins rooms the bot is invited to by an allowed user.
+func (c *MatrixChannel) handleInvite(roomID string, events []matrixEvent) {
+	if !c.config.AutoJoin {
+		return
+	}
+	for _, event := range events {
+		if event.Type != "m.room.member" || event.StateKey == nil || *event.StateKey != c.userID {
+			continue
+		}
+		if !c.IsAllowed(event.Sender) {
+			logger.DebugCF("matrix", "Ignoring invite from sender not in allow_from", map[string]interface{}{
+				"room_id": roomID,
+				"sender":  event.Sender,
+			})
+			return
+		}
+		path := fmt.Sprintf("/_matrix/client/v3/join/%s", url.PathEscape(roomID))
+		if err := c.callAPI(c.ctx, http.MethodPost, path, map[string]interface{}{}, nil); err != nil {
+			logger.ErrorCF("matrix", "Failed to join room", map[string]interface{}{
+				"room_id": roomID,
+				"error":   err.Error(),
+			})
+			return
+		}
+		logger.InfoCF("matrix", "Joined room", map[string]interface{}{
+			"room_id": roomID,
+			"inviter": event.Sender,
+		})
+		return
+	}
+}
+
+func (c *MatrixChannel) handleEvent(roomID string, event matrixEvent) {
+	if event.Sender == c.userID {
+		return
+	}
+	if event.Type == "m.room.encrypted" {
+		logger.WarnCF("matrix", "Ignoring encrypted event, end-to-end encryption is not supported", map[string]interface{}{
+			"room_id": roomID,
+		})
+		return
+	}
+	if event.Type != "m.room.message" {
+		return
+	}
+
+	var msg matrixMessageContent
+	if err := json.Unmarshal(event.Content, &msg); err != nil {
+		logger.ErrorCF("matrix", "Failed to parse message", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	// Edits repeat the original message
+	if msg.RelatesTo != nil && msg.RelatesTo.RelType == "m.replace" {
+		return
+	}
+
+	c.mu.Lock()
+	isGroup := c.memberCount[roomID] > 2
+	c.mu.Unlock()
+
+	if !c.IsAllowed(event.Sender) {
+		logger.DebugCF("matrix", "Message rejected by allowlist", map[string]interface{}{
+			"sender": event.Sender,
+		})
+		c.requestPairing(event.Sender, roomID, isGroup)
+		return
+	}
+
+	chatID := roomID
+	threadRoot := ""
+	if msg.RelatesTo != nil && msg.RelatesTo.RelType == "m.thread" && msg.RelatesTo.EventID != "" {
+		threadRoot = msg.RelatesTo.EventID
+		chatID = roomID + "/" + threadRoot
+	}
+
+	var content string
+	var mediaPaths []string
+	switch msg.MsgType {
+	case "m.text", "m.notice", "m.emote":
+		content = c.stripMention(stripReplyFallback(msg.Body))
+	case "m.image", "m.file", "m.audio", "m.video":
+		filename := msg.Filename
+		if filename == "" {
+			filename = msg.Body
+		}
+		if localPath := c.downloadMedia(msg.URL, filename); localPath != "" {
+			mediaPaths = append(mediaPaths, localPath)
+		}
+		content = fmt.Sprintf("[%s]", strings.TrimPrefix(msg.MsgType, "m."))
+		if msg.Filename != "" && msg.Body != "" && msg.Body != msg.Filename {
+			// body is a caption when filename is set
+			content += " " + c.stripMention(msg.Body)
+		}
+	default:
+		return
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	c.mu.Lock()
+	c.lastEvent[chatID] = event.EventID
+	c.mu.Unlock()
+
+	logger.DebugCF("matrix", "Received message", map[string]interface{}{
+		"sender_id": event.Sender,
+		"chat_id":   chatID,
+		"is_group":  isGroup,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	metadata := map[string]string{
+		"message_id": event.EventID,
+		"room_id":    roomID,
+		"thread_id":  threadRoot,
+		"is_group":   fmt.Sprintf("%t", isGroup),
+	}
+	if isGroup && c.isMentioned(msg) {
+		metadata[MetaIsMentioned] = "true"
+	}
+	// In threads, a falling-back reply only points at the last message
+	if rel := msg.RelatesTo; rel != nil && rel.InReplyTo != nil && rel.InReplyTo.EventID != "" && !rel.IsFallingBack {
+		mediaPaths = append(mediaPaths, c.addRepliedEvent(roomID, rel.InReplyTo.EventID, metadata)...)
+	}
+
+	if c.ShouldReply(event.Sender, chatID, content, metadata) {
+		c.startTyping(roomID, chatID)
+	}
+
+	c.HandleMessage(event.Sender, chatID, content, mediaPaths, metadata)
+}
+
+// isMentioned reports whether a room message addresses the bot, through
+// m.mentions or by user ID or display name in the body.
+// addRepliedEvent records the event a message replies to, downloading its
+// media.
+func (c *MatrixChannel) addRepliedEvent(roomID, eventID string, metadata map[string]string) []string {
+	var event matrixEvent
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/event/%s", url.PathEscape(roomID), url.PathEscape(eventID))
+	if err := c.callAPI(c.ctx, http.MethodGet, path, nil, &event); err != nil {
+		logger.DebugCF("matrix", "Failed to fetch replied-to event", map[string]interface{}{
+			"event_id": eventID,
+			"error":    err.Error(),
+		})
+		return nil
+	}
+	var msg matrixMessageContent
+	if err := json.Unmarshal(event.Content, &msg); err != nil {
+		return nil
+	}
+
+	if event.Sender == c.userID {
+		metadata[MetaIsReplyToBot] = "true"
+	}
+	text := stripReplyFallback(msg.Body)
+	var media []string
+	if msg.URL != "" {
+		filename := msg.Filename
+		if filename == "" {
+			filename = msg.Body
+		}
+		if localPath := c.downloadMedia(msg.URL, filename); localPath != "" {
+			media = append(media, localPath)
+			text = fmt.Sprintf("[%s]", strings.TrimPrefix(msg.MsgType, "m."))
+			if msg.Filename != "" && msg.Body != "" && msg.Body != msg.Filename {
+				text += " " + msg.Body
+			}
+		}
+	}
+	setReplyContext(metadata, eventID, event.Sender, text)
+	return media
+}
+
+// stripReplyFallback removes the quote of the replied-to message that
+// older clients put at the start of a reply's body.
+func stripReplyFallback(body string) string {
+	if !strings.HasPrefix(body, "> <") {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	return strings.TrimLeft(strings.Join(lines[i:], "\n"), "\n")
+}
+
+func (c *MatrixChannel) isMentioned(msg matrixMessageContent) bool {
+	if msg.Mentions != nil {
+		for _, id := range msg.Mentions.UserIDs {
+			if id == c.userID {
+				return true
+			}
+		}
+	}
+	body := strings.ToLower(msg.Body)
+	if strings.Contains(body, strings.ToLower(c.userID)) {
+		return true
+	}
+	return c.displayName != "" && strings.Contains(body, strings.ToLower(c.displayName))
+}
+
+// stripMention removes a leading "Name:" or user ID mention from text.
+func (c *MatrixChannel) stripMention(text string) string {
+	for _, name := range []string{c.userID, c.displayName} {
+		if name == "" {
+			continue
+		}
+		if len(text) >= len(name) && strings.EqualFold(text[:len(name)], name) {
+			text = strings.TrimLeft(text[len(name):], ":, ")
+			break
+		}
+	}
+	return strings.TrimSpace(text)
+}
+
+// startTyping shows the typing notification until the reply is sent,
+// refreshing it because the homeserver expires it after a timeout.
+func (c *MatrixChannel) startTyping(roomID, chatID string) {
+	c.stopTypingFor(chatID)
+
+	ctx, cancel := context.WithTimeout(c.ctx, matrixTypingMaxTotal)
+	c.stopTyping.Store(chatID, &thinkingCancel{fn: cancel})
+
+	go func() {
+		ticker := time.NewTicker(matrixTypingTimeout * 2 / 3)
+		defer ticker.Stop()
+		c.setTyping(roomID, true)
+		for {
+			select {
+			case <-ctx.Done():
+				c.setTyping(roomID, false)
+				return
+			case <-ticker.C:
+				c.setTyping(roomID, true)
+			}
+		}
+	}()
+}
+
+func (c *MatrixChannel) stopTypingFor(chatID string) {
+	if stop, ok := c.stopTyping.LoadAndDelete(chatID); ok {
+		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
+			cf.Cancel()
+		}
+	}
+}
+
+func (c *MatrixChannel) setTyping(roomID string, typing bool) {
+	payload := map[string]interface{}{"typing": typing}
+	if typing {
+		payload["timeout"] = matrixTypingTimeout.Milliseconds()
+	}
+	path := fmt.Sprintf("/_matrix/client/v3/rooms/%s/typing/%s", url.PathEscape(roomID), url.PathEscape(c.userID))
+	// The channel context may already be cancelled when typing is turned off
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.callAPI(ctx, http.MethodPut, path, payload, nil); err != nil {
+		logger.DebugCF("matrix", "Failed to set typing", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
+
+// downloadMedia fetches an mxc:// URI through the authenticated media API.
+func (c *MatrixChannel) downloadMedia(mxcURL, filename string) string {
+	serverAndID, ok := strings.CutPrefix(mxcURL, "mxc://")
+	if !ok || !strings.Contains(serverAndID, "/") {
+		return ""
+	}
+	if filename == "" {
+		filename = "file"
+	}
+	return utils.DownloadFile(c.homeserver+"/_matrix/client/v1/media/download/"+serverAndID, filename, utils.DownloadOptions{
+		LoggerPrefix: "matrix",
+		ExtraHeaders: map[string]string{
+			"Authorization": "Bearer " + c.accessToken,
+		},
+	})
+}
+
+// callAPI makes an authenticated JSON request to the homeserver and decodes
+// the response into out, if non-nil.
+func (c *MatrixChannel) callAPI(ctx context.Context, method, path string, payload, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.homeserver+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("matrix API error (status %d): %s", resp.StatusCode, string(respBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("failed to parse response: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseMatrixChatID splits "roomID/threadRoot" into its parts. Room IDs and
+// event IDs never contain "/".
+func parseMatrixChatID(chatID string) (roomID, threadRoot string) {
+	roomID, threadRoot, _ = strings.Cut(chatID, "/")
+	return roomID, threadRoot
+}
@@ -0,0 +1,219 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/session"
+)
+
+// fakeHomeserver serves the client-server API endpoints the channel uses.
+type fakeHomeserver struct {
+	mu     sync.Mutex
+	syncs  int
+	sent   []map[string]interface{}
+	typing []bool
+}
+
+func (h *fakeHomeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	if path != "/_matrix/client/v3/login" && r.Header.Get("Authorization") != "Bearer tok" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case path == "/_matrix/client/v3/login":
+		io.WriteString(w, `{"access_token":"tok","user_id":"@pico:example.org"}`)
+	case path == "/_matrix/client/v3/account/whoami":
+		io.WriteString(w, `{"user_id":"@pico:example.org"}`)
+	case strings.HasSuffix(path, "/displayname"):
+		io.WriteString(w, `{"displayname":"Pico"}`)
+	case path == "/_matrix/client/v3/sync":
+		h.mu.Lock()
+		h.syncs++
+		n := h.syncs
+		h.mu.Unlock()
+		switch n {
+		case 1:
+			io.WriteString(w, `{"next_batch":"s1","rooms":{"join":{
+				"!group:example.org":{"summary":{"m.joined_member_count":5},"timeline":{"events":[
+					{"type":"m.room.message","event_id":"$old","sender":"@alice:example.org","content":{"msgtype":"m.text","body":"history"}}]}},
+				"!dm:example.org":{"summary":{"m.joined_member_count":2},"timeline":{"events":[]}}}}}`)
+		case 2:
+			io.WriteString(w, `{"next_batch":"s2","rooms":{"join":{
+				"!group:example.org":{"timeline":{"events":[
+					{"type":"m.room.message","event_id":"$chatter","sender":"@alice:example.org","content":{"msgtype":"m.text","body":"not for the bot"}},
+					{"type":"m.room.message","event_id":"$q","sender":"@alice:example.org","content":{
+						"msgtype":"m.text","body":"Pico: summarize this thread",
+						"m.mentions":{"user_ids":["@pico:example.org"]},
+						"m.relates_to":{"rel_type":"m.thread","event_id":"$root"}}}]}},
+				"!dm:example.org":{"timeline":{"events":[
+					{"type":"m.room.message","event_id":"$img","sender":"@alice:example.org","content":{
+						"msgtype":"m.image","body":"cat.png","url":"mxc://example.org/abc"}}]}}}}}`)
+		default:
+			<-r.Context().Done()
+		}
+	case path == "/_matrix/client/v1/media/download/example.org/abc":
+		io.WriteString(w, "PNGDATA")
+	case strings.Contains(path, "/typing/"):
+		var body struct {
+			Typing bool `json:"typing"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		h.mu.Lock()
+		h.typing = append(h.typing, body.Typing)
+		h.mu.Unlock()
+		io.WriteString(w, `{}`)
+	case strings.Contains(path, "/send/m.room.message/"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		h.mu.Lock()
+		h.sent = append(h.sent, body)
+		h.mu.Unlock()
+		io.WriteString(w, `{"event_id":"$reply"}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestMatrixChannel_SyncAndReply(t *testing.T) {
+	hs := &fakeHomeserver{}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewMatrixChannel(config.MatrixConfig{
+		Homeserver:     server.URL,
+		UserID:         "@pico:example.org",
+		Password:       "secret",
+		RequireMention: true,
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	got := map[string]bus.InboundMessage{}
+	for i := 0; i < 2; i++ {
+		msg, ok := msgBus.ConsumeInbound(ctx)
+		if !ok {
+			t.Fatal("missing inbound message")
+		}
+		got[msg.ChatID] = msg
+	}
+
+	threaded, ok := got["!group:example.org/$root"]
+	if !ok {
+		t.Fatalf("thread message not keyed by room and thread root: %v", got)
+	}
+	if threaded.Content != "summarize this thread" {
+		t.Errorf("Content = %q", threaded.Content)
+	}
+	if threaded.SessionKey != "matrix:!group:example.org/$root" {
+		t.Errorf("SessionKey = %q", threaded.SessionKey)
+	}
+
+	// The thread's session must survive a restart despite the "/" in its key
+	dir := t.TempDir()
+	sessions := session.NewSessionManager(dir)
+	sessions.AddMessage(threaded.SessionKey, "user", threaded.Content)
+	if err := sessions.Save(threaded.SessionKey); err != nil {
+		t.Fatalf("Save(%q) failed: %v", threaded.SessionKey, err)
+	}
+	if history := session.NewSessionManager(dir).GetHistory(threaded.SessionKey); len(history) != 1 {
+		t.Errorf("thread session after reload = %+v", history)
+	}
+
+	image, ok := got["!dm:example.org"]
+	if !ok {
+		t.Fatalf("DM message missing: %v", got)
+	}
+	if len(image.Media) != 1 {
+		t.Fatalf("Media = %v", image.Media)
+	}
+	defer os.Remove(image.Media[0])
+	if data, _ := os.ReadFile(image.Media[0]); string(data) != "PNGDATA" {
+		t.Errorf("media = %q", data)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{Channel: "matrix", ChatID: threaded.ChatID, Content: "**Done**\nok"}); err != nil {
+		t.Fatal(err)
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if len(hs.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(hs.sent))
+	}
+	sent := hs.sent[0]
+	if sent["formatted_body"] != "<b>Done</b><br>ok" || sent["format"] != "org.matrix.custom.html" {
+		t.Errorf("formatted reply = %v", sent)
+	}
+	rel, _ := sent["m.relates_to"].(map[string]interface{})
+	if rel["rel_type"] != "m.thread" || rel["event_id"] != "$root" {
+		t.Errorf("m.relates_to = %v", rel)
+	}
+	if inReplyTo, _ := rel["m.in_reply_to"].(map[string]interface{}); inReplyTo["event_id"] != "$q" {
+		t.Errorf("m.in_reply_to = %v", rel["m.in_reply_to"])
+	}
+}
+
+func TestMatrixChannel_TypingStopsOnSend(t *testing.T) {
+	hs := &fakeHomeserver{}
+	server := httptest.NewServer(hs)
+	defer server.Close()
+
+	ch, err := NewMatrixChannel(config.MatrixConfig{Homeserver: server.URL, AccessToken: "tok"}, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.ctx, ch.cancel = context.WithCancel(context.Background())
+	defer ch.cancel()
+	ch.userID = "@pico:example.org"
+	ch.setRunning(true)
+
+	ch.startTyping("!dm:example.org", "!dm:example.org")
+	if err := ch.Send(context.Background(), bus.OutboundMessage{ChatID: "!dm:example.org", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		hs.mu.Lock()
+		typing := append([]bool(nil), hs.typing...)
+		hs.mu.Unlock()
+		if len(typing) > 0 && !typing[len(typing)-1] {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("typing notification was not cleared after the reply")
+}
+
+func TestParseMatrixChatID(t *testing.T) {
+	room, thread := parseMatrixChatID("!abc:example.org/$event")
+	if room != "!abc:example.org" || thread != "$event" {
+		t.Errorf("parseMatrixChatID() = %q, %q", room, thread)
+	}
+	room, thread = parseMatrixChatID("!abc:example.org")
+	if room != "!abc:example.org" || thread != "" {
+		t.Errorf("parseMatrixChatID() = %q, %q", room, thread)
+	}
+}
@@ -2,11 +2,16 @@ package channels
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -14,11 +19,23 @@ import (
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// oneBotMessageLimit keeps long replies readable in QQ clients.
+const oneBotMessageLimit = 4000
+
+// How the channel receives events, set by the mode config.
+const (
+	oneBotModeWS        = "ws"
+	oneBotModeReverseWS = "reverse_ws"
+	oneBotModeHTTP      = "http"
 )
 
 type OneBotChannel struct {
 	*BaseChannel
 	config      config.OneBotConfig
+	mode        string
 	conn        *websocket.Conn
 	ctx         context.Context
 	cancel      context.CancelFunc
@@ -28,6 +45,12 @@ type OneBotChannel struct {
 	mu          sync.Mutex
 	writeMu     sync.Mutex
 	echoCounter int64
+	http        *http.Client
+	// Reverse WebSocket connections, keyed by self ID and role
+	bots map[string]*oneBotConn
+	// The self ID of the bot each chat was last heard from, so replies go
+	// out through the same account
+	chatBots map[string]string
 }
 
 type oneBotRawEvent struct {
@@ -81,40 +104,98 @@ type oneBotAPIRequest struct {
 	Echo   string      `json:"echo,omitempty"`
 }
 
+// oneBotSegment is one part of a message in array format.
+type oneBotSegment struct {
+	Type string            `json:"type"`
+	Data map[string]string `json:"data"`
+}
+
 type oneBotSendPrivateMsgParams struct {
-	UserID  int64  `json:"user_id"`
-	Message string `json:"message"`
+	UserID  int64           `json:"user_id"`
+	Message []oneBotSegment `json:"message"`
 }
 
 type oneBotSendGroupMsgParams struct {
-	GroupID int64  `json:"group_id"`
-	Message string `json:"message"`
+	GroupID int64           `json:"group_id"`
+	Message []oneBotSegment `json:"message"`
 }
 
 func NewOneBotChannel(cfg config.OneBotConfig, messageBus *bus.MessageBus) (*OneBotChannel, error) {
 	base := NewBaseChannel("onebot", cfg, messageBus, cfg.AllowFrom)
+	groups := cfg.Groups
+	groups.TriggerPrefixes = append(groups.TriggerPrefixes, cfg.GroupTriggerPrefix...)
+	base.SetGroupPolicy(groups)
+
+	mode := cfg.Mode
+	if mode == "" {
+		mode = oneBotModeWS
+	}
 
 	const dedupSize = 1024
 	return &OneBotChannel{
 		BaseChannel: base,
 		config:      cfg,
+		mode:        mode,
 		dedup:       make(map[string]struct{}, dedupSize),
 		dedupRing:   make([]string, dedupSize),
 		dedupIdx:    0,
+		http:        &http.Client{Timeout: 30 * time.Second},
+		bots:        make(map[string]*oneBotConn),
+		chatBots:    make(map[string]string),
 	}, nil
 }
 
+// oneBotConfigured reports whether cfg has what its mode needs to reach the
+// implementation. Credentials are checked by Start, so a missing one is
+// reported rather than leaving the channel out.
+func oneBotConfigured(cfg config.OneBotConfig) bool {
+	switch cfg.Mode {
+	case "", oneBotModeWS:
+		return cfg.WSUrl != ""
+	case oneBotModeHTTP:
+		return cfg.HTTPURL != ""
+	default:
+		return true
+	}
+}
+
 func (c *OneBotChannel) Start(ctx context.Context) error {
-	if c.config.WSUrl == "" {
-		return fmt.Errorf("OneBot ws_url not configured")
+	switch c.mode {
+	case oneBotModeWS:
+		if c.config.WSUrl == "" {
+			return fmt.Errorf("OneBot ws_url not configured")
+		}
+	case oneBotModeReverseWS:
+		// Anyone reaching the gateway could pose as the implementation
+		if c.config.AccessToken == "" {
+			return fmt.Errorf("OneBot access_token not configured, reverse WebSocket mode needs it")
+		}
+	case oneBotModeHTTP:
+		if c.config.HTTPURL == "" {
+			return fmt.Errorf("OneBot http_url not configured, HTTP POST mode sends through it")
+		}
+		if c.config.Secret == "" && c.config.AccessToken == "" {
+			return fmt.Errorf("OneBot secret or access_token not configured, HTTP POST mode needs one")
+		}
+	default:
+		return fmt.Errorf("unknown OneBot mode %q", c.mode)
 	}
 
 	logger.InfoCF("onebot", "Starting OneBot channel", map[string]interface{}{
+		"mode":   c.mode,
 		"ws_url": c.config.WSUrl,
+		"path":   c.EventPath(),
 	})
 
 	c.ctx, c.cancel = context.WithCancel(ctx)
 
+	if c.mode != oneBotModeWS {
+		// Events arrive on the gateway, see Handler
+		c.setRunning(true)
+		logger.InfoC("onebot", "OneBot channel started successfully")
+		return nil
+	}
+
 	if err := c.connect(); err != nil {
 		logger.WarnCF("onebot", "Initial connection failed, will retry in background", map[string]interface{}{
 			"error": err.Error(),
@@ -202,38 +283,99 @@ func (c *OneBotChannel) Stop(ctx context.Context) error {
 		c.conn.Close()
 		c.conn = nil
 	}
+	for key, bot := range c.bots {
+		bot.conn.Close()
+		delete(c.bots, key)
+	}
 	c.mu.Unlock()
 
 	return nil
 }
 
+// Health reports whether the WebSocket to the OneBot implementation is up,
+// or in reverse WebSocket mode whether any implementation is connected.
+func (c *OneBotChannel) Health() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.mode {
+	case oneBotModeReverseWS:
+		if len(c.bots) == 0 {
+			return fmt.Errorf("no OneBot implementation connected on %s", c.EventPath())
+		}
+	case oneBotModeWS:
+		if c.conn == nil {
+			return fmt.Errorf("not connected to %s", c.config.WSUrl)
+		}
+	}
+	return nil
+}
+
 func (c *OneBotChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 	if !c.IsRunning() {
 		return fmt.Errorf("OneBot channel not running")
 	}
 
-	c.mu.Lock()
-	conn := c.conn
-	c.mu.Unlock()
+	media := make([]oneBotSegment, 0, len(msg.Media))
+	for _, path := range msg.Media {
+		seg, err := oneBotMediaSegment(path)
+		if err != nil {
+			return Permanent(err)
+		}
+		media = append(media, seg)
+	}
 
-	if conn == nil {
-		return fmt.Errorf("OneBot WebSocket not connected")
+	chunks := RenderChunks(msg.Content, FormatPlain, oneBotMessageLimit)
+	if len(chunks) == 0 && len(media) > 0 {
+		chunks = []string{""}
+	}
+	for i, chunk := range chunks {
+		var message []oneBotSegment
+		if chunk != "" {
+			message = append(message, oneBotSegment{Type: "text", Data: map[string]string{"text": chunk}})
+		}
+		if i == 0 {
+			// Voice messages cannot share a message with anything else
+			for _, seg := range media {
+				if seg.Type == "record" {
+					if err := c.sendSegments(ctx, msg.ChatID, []oneBotSegment{seg}); err != nil {
+						return err
+					}
+					continue
+				}
+				message = append(message, seg)
+			}
+		}
+		if len(message) == 0 {
+			continue
+		}
+		if err := c.sendSegments(ctx, msg.ChatID, message); err != nil {
+			return err
+		}
 	}
 
-	action, params, err := c.buildSendRequest(msg)
+	return nil
+}
+
+// sendSegments sends one message to a chat.
+func (c *OneBotChannel) sendSegments(ctx context.Context, chatID string, message []oneBotSegment) error {
+	action, params, err := c.buildSendRequest(chatID, message)
 	if err != nil {
-		return err
+		return Permanent(err)
+	}
+
+	if c.config.HTTPURL != "" {
+		return c.callHTTP(ctx, action, params)
 	}
 
-	c.writeMu.Lock()
-	c.echoCounter++
-	echo := fmt.Sprintf("send_%d", c.echoCounter)
-	c.writeMu.Unlock()
+	conn, writeMu := c.apiConn(chatID)
+	if conn == nil {
+		return fmt.Errorf("OneBot WebSocket not connected")
+	}
 
 	req := oneBotAPIRequest{
 		Action: action,
 		Params: params,
-		Echo:   echo,
+		Echo:   fmt.Sprintf("send_%d", atomic.AddInt64(&c.echoCounter, 1)),
 	}
 
 	data, err := json.Marshal(req)
@@ -241,9 +383,9 @@ func (c *OneBotChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		return fmt.Errorf("failed to marshal OneBot request: %w", err)
 	}
 
-	c.writeMu.Lock()
+	writeMu.Lock()
 	err = conn.WriteMessage(websocket.TextMessage, data)
-	c.writeMu.Unlock()
+	writeMu.Unlock()
 
 	if err != nil {
 		logger.ErrorCF("onebot", "Failed to send message", map[string]interface{}{
@@ -251,13 +393,26 @@ func (c *OneBotChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 		})
 		return err
 	}
-
 	return nil
 }
 
-func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, interface{}, error) {
-	chatID := msg.ChatID
+// apiConn returns the WebSocket to send a chat's messages on, with the
+// lock that guards writes to it.
+func (c *OneBotChannel) apiConn(chatID string) (*websocket.Conn, *sync.Mutex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
+	if c.mode != oneBotModeReverseWS {
+		return c.conn, &c.writeMu
+	}
+	bot := c.botFor(c.chatBots[chatID])
+	if bot == nil {
+		return nil, nil
+	}
+	return bot.conn, &bot.writeMu
+}
+
+func (c *OneBotChannel) buildSendRequest(chatID string, message []oneBotSegment) (string, interface{}, error) {
 	if len(chatID) > 6 && chatID[:6] == "group:" {
 		groupID, err := strconv.ParseInt(chatID[6:], 10, 64)
 		if err != nil {
@@ -265,7 +420,7 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 		}
 		return "send_group_msg", oneBotSendGroupMsgParams{
 			GroupID: groupID,
-			Message: msg.Content,
+			Message: message,
 		}, nil
 	}
 
@@ -276,7 +431,7 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 		}
 		return "send_private_msg", oneBotSendPrivateMsgParams{
 			UserID:  userID,
-			Message: msg.Content,
+			Message: message,
 		}, nil
 	}
 
@@ -287,7 +442,26 @@ func (c *OneBotChannel) buildSendRequest(msg bus.OutboundMessage) (string, inter
 
 	return "send_private_msg", oneBotSendPrivateMsgParams{
 		UserID:  userID,
-		Message: msg.Content,
+		Message: message,
+	}, nil
+}
+
+// oneBotMediaSegment turns a local file into an image or record segment.
+// Files are sent inline, as the implementation may not share our disk.
+func oneBotMediaSegment(path string) (oneBotSegment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return oneBotSegment{}, fmt.Errorf("failed to read media %s: %w", path, err)
+	}
+
+	segType := "image"
+	ext := strings.ToLower(filepath.Ext(path))
+	if utils.IsAudioFile(path, "") || ext == ".amr" || ext == ".silk" {
+		segType = "record"
+	}
+	return oneBotSegment{
+		Type: segType,
+		Data: map[string]string{"file": "base64://" + base64.StdEncoding.EncodeToString(data)},
 	}, nil
 }
 
@@ -320,38 +494,43 @@ func (c *OneBotChannel) listen() {
 				return
 			}
 
-			logger.DebugCF("onebot", "Raw WebSocket message received", map[string]interface{}{
-				"length":  len(message),
-				"payload": string(message),
-			})
-
-			var raw oneBotRawEvent
-			if err := json.Unmarshal(message, &raw); err != nil {
-				logger.WarnCF("onebot", "Failed to unmarshal raw event", map[string]interface{}{
-					"error":   err.Error(),
-					"payload": string(message),
-				})
-				continue
-			}
+			c.handlePayload(message)
+		}
+	}
+}
 
-			if raw.Echo != "" || raw.Status.Online || raw.Status.Good {
-				logger.DebugCF("onebot", "Received API response, skipping", map[string]interface{}{
-					"echo":   raw.Echo,
-					"status": raw.Status,
-				})
-				continue
-			}
+// handlePayload handles an event or API response however it arrived.
+func (c *OneBotChannel) handlePayload(message []byte) {
+	logger.DebugCF("onebot", "Raw event received", map[string]interface{}{
+		"length":  len(message),
+		"payload": string(message),
+	})
 
-			logger.DebugCF("onebot", "Parsed raw event", map[string]interface{}{
-				"post_type":       raw.PostType,
-				"message_type":    raw.MessageType,
-				"sub_type":        raw.SubType,
-				"meta_event_type": raw.MetaEventType,
-			})
+	var raw oneBotRawEvent
+	if err := json.Unmarshal(message, &raw); err != nil {
+		logger.WarnCF("onebot", "Failed to unmarshal raw event", map[string]interface{}{
+			"error":   err.Error(),
+			"payload": string(message),
+		})
+		return
+	}
 
-			c.handleRawEvent(&raw)
-		}
+	if raw.Echo != "" || raw.Status.Online || raw.Status.Good {
+		logger.DebugCF("onebot", "Received API response, skipping", map[string]interface{}{
+			"echo":   raw.Echo,
+			"status": raw.Status,
+		})
+		return
 	}
+
+	logger.DebugCF("onebot", "Parsed raw event", map[string]interface{}{
+		"post_type":       raw.PostType,
+		"message_type":    raw.MessageType,
+		"sub_type":        raw.SubType,
+		"meta_event_type": raw.MetaEventType,
+	})
+
+	c.handleRawEvent(&raw)
 }
 
 func parseJSONInt64(raw json.RawMessage) (int64, error) {
@@ -595,17 +774,11 @@ func (c *OneBotChannel) handleMessage(evt *oneBotEvent) {
 			metadata["sender_name"] = evt.Sender.Nickname
 		}
 
-		triggered, strippedContent := c.checkGroupTrigger(content, evt.IsBotMentioned)
-		if !triggered {
-			logger.DebugCF("onebot", "Group message ignored (no trigger)", map[string]interface{}{
-				"sender":       senderID,
-				"group":        groupIDStr,
-				"is_mentioned": evt.IsBotMentioned,
-				"content":      truncate(content, 100),
-			})
-			return
+		metadata["is_group"] = "true"
+		if evt.IsBotMentioned {
+			metadata[MetaIsMentioned] = "true"
 		}
-		content = strippedContent
+		content = strings.TrimSpace(content)
 
 		logger.InfoCF("onebot", "Received group message", map[string]interface{}{
 			"sender":       senderID,
@@ -628,6 +801,13 @@ func (c *OneBotChannel) handleMessage(evt *oneBotEvent) {
 	if evt.Sender.Nickname != "" {
 		metadata["nickname"] = evt.Sender.Nickname
 	}
+	if evt.SelfID > 0 {
+		selfID := strconv.FormatInt(evt.SelfID, 10)
+		metadata["self_id"] = selfID
+		c.mu.Lock()
+		c.chatBots[chatID] = selfID
+		c.mu.Unlock()
+	}
 
 	logger.DebugCF("onebot", "Forwarding message to bus", map[string]interface{}{
 		"sender_id": senderID,
@@ -667,20 +847,3 @@ func truncate(s string, n int) string {
 	}
 	return string(runes[:n]) + "..."
 }
-
-func (c *OneBotChannel) checkGroupTrigger(content string, isBotMentioned bool) (triggered bool, strippedContent string) {
-	if isBotMentioned {
-		return true, strings.TrimSpace(content)
-	}
-
-	for _, prefix := range c.config.GroupTriggerPrefix {
-		if prefix == "" {
-			continue
-		}
-		if strings.HasPrefix(content, prefix) {
-			return true, strings.TrimSpace(strings.TrimPrefix(content, prefix))
-		}
-	}
-
-	return false, content
-}
@@ -0,0 +1,249 @@
+package channels
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// oneBotMaxEventSize bounds events posted in HTTP POST mode.
+const oneBotMaxEventSize = 4 << 20
+
+// oneBotConn is a reverse WebSocket connection from an implementation.
+type oneBotConn struct {
+	conn    *websocket.Conn
+	selfID  string
+	role    string // Universal, API or Event
+	writeMu sync.Mutex
+}
+
+var oneBotUpgrader = websocket.Upgrader{
+	// Implementations are not browsers; the access token guards the socket
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// EventPath returns the path the gateway serves reverse WebSocket or HTTP
+// POST mode on, or "" when the channel dials out.
+func (c *OneBotChannel) EventPath() string {
+	if c.mode != oneBotModeReverseWS && c.mode != oneBotModeHTTP {
+		return ""
+	}
+	if c.config.Path == "" {
+		return "/onebot"
+	}
+	return c.config.Path
+}
+
+// Handler accepts reverse WebSocket connections or posted events,
+// depending on the mode. It stays valid across restarts of the channel, so
+// the gateway mounts it once.
+func (c *OneBotChannel) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.IsRunning() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if c.mode == oneBotModeHTTP {
+			c.serveEvent(w, r)
+			return
+		}
+		c.serveReverseWS(w, r)
+	})
+}
+
+// serveReverseWS reads events from one implementation until it hangs up.
+// Each bot account connects with its self ID, so several can share the
+// path.
+func (c *OneBotChannel) serveReverseWS(w http.ResponseWriter, r *http.Request) {
+	if !c.validAccessToken(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := oneBotUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.WarnCF("onebot", "Reverse WebSocket upgrade failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	bot := &oneBotConn{
+		conn:   conn,
+		selfID: r.Header.Get("X-Self-ID"),
+		role:   r.Header.Get("X-Client-Role"),
+	}
+	if bot.role == "" {
+		bot.role = "Universal"
+	}
+	c.addBot(bot)
+	defer c.removeBot(bot)
+
+	logger.InfoCF("onebot", "Reverse WebSocket connected", map[string]interface{}{
+		"self_id": bot.selfID,
+		"role":    bot.role,
+	})
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			logger.InfoCF("onebot", "Reverse WebSocket closed", map[string]interface{}{
+				"self_id": bot.selfID,
+				"error":   err.Error(),
+			})
+			return
+		}
+		c.handlePayload(message)
+	}
+}
+
+func (c *OneBotChannel) addBot(bot *oneBotConn) {
+	key := bot.selfID + "/" + bot.role
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A reconnecting bot replaces its old connection
+	if old := c.bots[key]; old != nil {
+		old.conn.Close()
+	}
+	c.bots[key] = bot
+}
+
+func (c *OneBotChannel) removeBot(bot *oneBotConn) {
+	key := bot.selfID + "/" + bot.role
+	c.mu.Lock()
+	if c.bots[key] == bot {
+		delete(c.bots, key)
+	}
+	c.mu.Unlock()
+	bot.conn.Close()
+}
+
+// botFor returns the connection to call the API of a bot account on,
+// falling back to any connected bot. The caller holds c.mu.
+func (c *OneBotChannel) botFor(selfID string) *oneBotConn {
+	for _, role := range []string{"Universal", "API"} {
+		if bot := c.bots[selfID+"/"+role]; bot != nil {
+			return bot
+		}
+	}
+	var fallback *oneBotConn
+	for _, bot := range c.bots {
+		if bot.role != "Event" && (fallback == nil || bot.selfID < fallback.selfID) {
+			fallback = bot
+		}
+	}
+	return fallback
+}
+
+// validAccessToken checks the token an implementation connects or posts
+// with, sent as an Authorization header or an access_token query parameter.
+func (c *OneBotChannel) validAccessToken(r *http.Request) bool {
+	if c.config.AccessToken == "" {
+		return true
+	}
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(auth, "Bearer "), "Token "))
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.config.AccessToken)) == 1
+}
+
+// serveEvent handles an event posted in HTTP POST mode.
+func (c *OneBotChannel) serveEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, oneBotMaxEventSize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// Start refuses to run without at least one of the two
+	if !c.validAccessToken(r) ||
+		(c.config.Secret != "" && !validOneBotSignature(c.config.Secret, body, r.Header.Get("X-Signature"))) {
+		logger.WarnC("onebot", "Rejected event with a bad signature or access token")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	c.handlePayload(body)
+	// No quick operation, replies are sent through the API
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validOneBotSignature checks an X-Signature header of the form
+// sha1=<hex HMAC-SHA1 of the body>.
+func validOneBotSignature(secret string, body []byte, signature string) bool {
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, "sha1="))
+	if err != nil || !strings.HasPrefix(signature, "sha1=") {
+		return false
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sum, mac.Sum(nil))
+}
+
+// callHTTP calls an action on the implementation's HTTP API.
+func (c *OneBotChannel) callHTTP(ctx context.Context, action string, params interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal OneBot request: %w", err)
+	}
+
+	url := strings.TrimRight(c.config.HTTPURL, "/") + "/" + action
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.AccessToken)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("OneBot %s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("OneBot %s failed: HTTP %d", action, resp.StatusCode)
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return Permanent(err)
+		}
+		return err
+	}
+
+	var result struct {
+		Status  string `json:"status"`
+		RetCode int    `json:"retcode"`
+		Message string `json:"message"`
+		Wording string `json:"wording"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("OneBot %s returned an invalid response: %w", action, err)
+	}
+	if result.RetCode != 0 {
+		reason := result.Wording
+		if reason == "" {
+			reason = result.Message
+		}
+		return fmt.Errorf("OneBot %s failed: retcode %d %s", action, result.RetCode, reason)
+	}
+	return nil
+}
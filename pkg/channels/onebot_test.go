@@ -0,0 +1,261 @@
+package channels
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+const oneBotTestEvent = `{"post_type":"message","message_type":"private","message_id":7,"user_id":1001,"self_id":42,"message":[{"type":"text","data":{"text":"hello"}}],"sender":{"nickname":"alice"}}`
+
+func oneBotSign(secret, body string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestOneBotConfigured(t *testing.T) {
+	for _, tc := range []struct {
+		cfg  config.OneBotConfig
+		want bool
+	}{
+		{config.OneBotConfig{WSUrl: "ws://127.0.0.1:3001"}, true},
+		{config.OneBotConfig{Mode: "ws"}, false},
+		{config.OneBotConfig{Mode: "reverse_ws"}, true},
+		{config.OneBotConfig{Mode: "http"}, false},
+		{config.OneBotConfig{Mode: "http", HTTPURL: "http://127.0.0.1:3000"}, true},
+	} {
+		if got := oneBotConfigured(tc.cfg); got != tc.want {
+			t.Errorf("oneBotConfigured(%+v) = %v", tc.cfg, got)
+		}
+	}
+}
+
+func TestOneBotSignature(t *testing.T) {
+	body := []byte(`{"post_type":"message"}`)
+	if !validOneBotSignature("s3cret", body, oneBotSign("s3cret", string(body))) {
+		t.Error("valid signature rejected")
+	}
+	for _, sig := range []string{"", oneBotSign("other", string(body)), strings.TrimPrefix(oneBotSign("s3cret", string(body)), "sha1=")} {
+		if validOneBotSignature("s3cret", body, sig) {
+			t.Errorf("signature %q accepted", sig)
+		}
+	}
+}
+
+func TestOneBotHTTPPostMode(t *testing.T) {
+	var calls []string
+	var sent oneBotSendPrivateMsgParams
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.Path+" "+r.Header.Get("Authorization"))
+		json.NewDecoder(r.Body).Decode(&sent)
+		io.WriteString(w, `{"status":"ok","retcode":0,"data":{"message_id":1}}`)
+	}))
+	defer api.Close()
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewOneBotChannel(config.OneBotConfig{
+		Mode:        "http",
+		HTTPURL:     api.URL + "/",
+		Secret:      "s3cret",
+		AccessToken: "tok",
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.EventPath() != "/onebot" {
+		t.Errorf("EventPath = %q", ch.EventPath())
+	}
+
+	post := func(signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/onebot", strings.NewReader(oneBotTestEvent))
+		req.Header.Set("X-Signature", signature)
+		req.Header.Set("Authorization", "Bearer tok")
+		rec := httptest.NewRecorder()
+		ch.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(oneBotSign("s3cret", oneBotTestEvent)); code != http.StatusServiceUnavailable {
+		t.Errorf("before start = %d", code)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	if code := post(oneBotSign("wrong", oneBotTestEvent)); code != http.StatusUnauthorized {
+		t.Errorf("bad signature = %d", code)
+	}
+	if code := post(oneBotSign("s3cret", oneBotTestEvent)); code != http.StatusNoContent {
+		t.Errorf("valid event = %d", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok || msg.ChatID != "private:1001" || msg.Content != "hello" || msg.Metadata["self_id"] != "42" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "private:1001", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "/send_private_msg Bearer tok" {
+		t.Errorf("calls = %v", calls)
+	}
+	if sent.UserID != 1001 || len(sent.Message) != 1 || sent.Message[0].Data["text"] != "hi" {
+		t.Errorf("sent = %+v", sent)
+	}
+}
+
+func TestOneBotGatewayModesNeedCredentials(t *testing.T) {
+	for _, cfg := range []config.OneBotConfig{
+		{Mode: "http", HTTPURL: "http://127.0.0.1:3000"},
+		{Mode: "reverse_ws", Secret: "s3cret"},
+	} {
+		ch, _ := NewOneBotChannel(cfg, bus.NewMessageBus())
+		if err := ch.Start(context.Background()); err == nil {
+			ch.Stop(context.Background())
+			t.Errorf("%s mode started without credentials", cfg.Mode)
+		}
+	}
+
+	// Without a secret, posted events must carry the access token
+	msgBus := bus.NewMessageBus()
+	ch, _ := NewOneBotChannel(config.OneBotConfig{
+		Mode:        "http",
+		HTTPURL:     "http://127.0.0.1:3000",
+		AccessToken: "tok",
+	}, msgBus)
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	post := func(target string) int {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(oneBotTestEvent))
+		rec := httptest.NewRecorder()
+		ch.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post("/onebot"); code != http.StatusUnauthorized {
+		t.Errorf("unsigned event without token = %d", code)
+	}
+	if code := post("/onebot?access_token=wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d", code)
+	}
+	if code := post("/onebot?access_token=tok"); code != http.StatusNoContent {
+		t.Errorf("valid token = %d", code)
+	}
+}
+
+func TestOneBotReverseWS(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	ch, err := NewOneBotChannel(config.OneBotConfig{
+		Mode:        "reverse_ws",
+		Path:        "/onebot/ws",
+		AccessToken: "tok",
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+	if ch.Health() == nil {
+		t.Error("healthy with no bot connected")
+	}
+
+	srv := httptest.NewServer(ch.Handler())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/onebot/ws"
+
+	dial := func(selfID, auth string) (*websocket.Conn, int) {
+		header := http.Header{"X-Self-ID": {selfID}, "X-Client-Role": {"Universal"}}
+		if auth != "" {
+			header.Set("Authorization", auth)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if err != nil {
+			return nil, resp.StatusCode
+		}
+		return conn, resp.StatusCode
+	}
+
+	if _, code := dial("42", "Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token = %d", code)
+	}
+	bot42, _ := dial("42", "Bearer tok")
+	bot43, _ := dial("43", "Token tok")
+	if bot42 == nil || bot43 == nil {
+		t.Fatal("bots could not connect")
+	}
+	defer bot42.Close()
+	defer bot43.Close()
+
+	// The message reaches bot 42, so the reply must go out through it
+	if err := bot42.WriteMessage(websocket.TextMessage, []byte(oneBotTestEvent)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if msg, ok := msgBus.ConsumeInbound(ctx); !ok || msg.ChatID != "private:1001" {
+		t.Fatalf("inbound = %+v", msg)
+	}
+	if err := ch.Health(); err != nil {
+		t.Errorf("Health = %v", err)
+	}
+
+	image := filepath.Join(t.TempDir(), "cat.png")
+	os.WriteFile(image, []byte("png"), 0o644)
+	if err := ch.Send(ctx, bus.OutboundMessage{ChatID: "private:1001", Content: "look", Media: []string{image}}); err != nil {
+		t.Fatal(err)
+	}
+
+	bot42.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var req struct {
+		Action string                     `json:"action"`
+		Params oneBotSendPrivateMsgParams `json:"params"`
+	}
+	if err := bot42.ReadJSON(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Action != "send_private_msg" || len(req.Params.Message) != 2 {
+		t.Fatalf("request = %+v", req)
+	}
+	if seg := req.Params.Message[1]; seg.Type != "image" || seg.Data["file"] != "base64://cG5n" {
+		t.Errorf("image segment = %+v", seg)
+	}
+}
+
+func TestOneBotMediaSegment(t *testing.T) {
+	dir := t.TempDir()
+	for name, want := range map[string]string{"a.jpg": "image", "b.mp3": "record", "c.silk": "record"} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("x"), 0o644)
+		seg, err := oneBotMediaSegment(path)
+		if err != nil || seg.Type != want {
+			t.Errorf("%s = %+v, %v", name, seg, err)
+		}
+	}
+	if _, err := oneBotMediaSegment(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("missing file accepted")
+	}
+}
@@ -0,0 +1,646 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/google/uuid"
+	"github.com/mymmrac/telego/telegoapi"
+	"github.com/slack-go/slack"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	outboxFile     = "outbox.json"
+	deadLetterFile = "outbox_dead.jsonl"
+
+	outboxRetryMin = 2 * time.Second
+	outboxRetryMax = 5 * time.Minute
+	// How often a delivery worker checks whether its stopped channel is
+	// back.
+	outboxIdlePoll = time.Second
+)
+
+// defaultRateLimits keeps sends under each platform's documented limits.
+// Channels not listed are not limited.
+var defaultRateLimits = map[string]config.RateLimitConfig{
+	"telegram": {PerSecond: 25, PerChatPerSecond: 1},
+	"discord":  {PerSecond: 40, PerChatPerSecond: 1},
+	"slack":    {PerChatPerSecond: 1},
+	"whatsapp": {PerChatPerSecond: 1},
+	"line":     {PerSecond: 20},
+	"feishu":   {PerSecond: 50, PerChatPerSecond: 5},
+	"dingtalk": {PerChatPerSecond: 0.3},
+	"qq":       {PerChatPerSecond: 1},
+	"matrix":   {PerChatPerSecond: 2},
+	"signal":   {PerChatPerSecond: 1},
+}
+
+// OutboxEntry is an outbound message waiting for delivery, or one that
+// was given up on.
+type OutboxEntry struct {
+	ID          string              `json:"id"`
+	Message     bus.OutboundMessage `json:"message"`
+	Attempts    int                 `json:"attempts"`
+	CreatedAt   time.Time           `json:"created_at"`
+	NextAttempt time.Time           `json:"next_attempt,omitempty"`
+	LastError   string              `json:"last_error,omitempty"`
+	FailedAt    *time.Time          `json:"failed_at,omitempty"`
+}
+
+// Outbox is the persistent queue between the agent and the channels.
+// Pending messages are kept in <dir>/outbox.json so they survive a
+// restart; messages that cannot be delivered are appended to
+// <dir>/outbox_dead.jsonl.
+type Outbox struct {
+	dir         string
+	maxAttempts int
+	maxAge      time.Duration
+
+	mu      sync.Mutex
+	pending []*OutboxEntry
+	wake    map[string]chan struct{}
+}
+
+// NewOutbox opens the outbox stored in dir, loading any messages left
+// pending by a previous run.
+func NewOutbox(dir string, cfg config.OutboxConfig) *Outbox {
+	o := &Outbox{
+		dir:         dir,
+		maxAttempts: cfg.MaxAttempts,
+		maxAge:      time.Duration(cfg.MaxAge) * time.Minute,
+		wake:        make(map[string]chan struct{}),
+	}
+	if o.maxAttempts <= 0 {
+		o.maxAttempts = 8
+	}
+	if o.maxAge <= 0 {
+		o.maxAge = time.Hour
+	}
+
+	data, err := os.ReadFile(o.path(outboxFile))
+	if err != nil {
+		return o
+	}
+	if err := json.Unmarshal(data, &o.pending); err != nil {
+		// Keep the damaged file for inspection rather than overwriting it
+		corrupt := o.path(outboxFile) + ".corrupt"
+		os.Rename(o.path(outboxFile), corrupt)
+		logger.ErrorCF("channels", "Outbox file unreadable, moved aside", map[string]interface{}{
+			"path":  corrupt,
+			"error": err.Error(),
+		})
+		o.pending = nil
+	}
+	return o
+}
+
+func (o *Outbox) path(name string) string {
+	return filepath.Join(o.dir, name)
+}
+
+// Enqueue stores a message for delivery.
+func (o *Outbox) Enqueue(msg bus.OutboundMessage) (*OutboxEntry, error) {
+	entry := &OutboxEntry{
+		ID:        uuid.New().String()[:8],
+		Message:   msg,
+		CreatedAt: time.Now(),
+	}
+
+	o.mu.Lock()
+	o.pending = append(o.pending, entry)
+	err := o.saveLocked()
+	o.mu.Unlock()
+
+	o.notify(msg.Channel)
+	return entry, err
+}
+
+// Pending returns a copy of the messages waiting for delivery.
+func (o *Outbox) Pending() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries := make([]OutboxEntry, len(o.pending))
+	for i, entry := range o.pending {
+		entries[i] = *entry
+	}
+	return entries
+}
+
+// DeadLetters returns the messages that were given up on, oldest first.
+func (o *Outbox) DeadLetters() ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.readDeadLocked()
+}
+
+// Retry moves dead letters back into the queue with a fresh attempt
+// budget. With no ids, every dead letter is retried. It returns the number
+// of messages requeued.
+func (o *Outbox) Retry(ids []string) (int, error) {
+	o.mu.Lock()
+
+	dead, err := o.readDeadLocked()
+	if err != nil {
+		o.mu.Unlock()
+		return 0, err
+	}
+
+	var keep []OutboxEntry
+	var channels []string
+	requeued := 0
+	for _, entry := range dead {
+		if len(ids) > 0 && !slices.Contains(ids, entry.ID) {
+			keep = append(keep, entry)
+			continue
+		}
+		entry.Attempts = 0
+		entry.NextAttempt = time.Time{}
+		entry.FailedAt = nil
+		// The age limit counts from the retry, not the original reply
+		entry.CreatedAt = time.Now()
+		o.pending = append(o.pending, &entry)
+		channels = append(channels, entry.Message.Channel)
+		requeued++
+	}
+
+	if requeued > 0 {
+		if err := o.saveLocked(); err != nil {
+			o.mu.Unlock()
+			return 0, err
+		}
+		if err := o.writeDeadLocked(keep); err != nil {
+			o.mu.Unlock()
+			return requeued, err
+		}
+	}
+	o.mu.Unlock()
+
+	for _, channel := range channels {
+		o.notify(channel)
+	}
+	return requeued, nil
+}
+
+// next returns the first message for channel that is due. Messages to the
+// same chat go out in order, so a chat waiting on a retry holds back its
+// later messages. When nothing is due, next returns how long until
+// something is, or zero if the channel has nothing queued. Messages past
+// the age limit are moved to the dead letters.
+func (o *Outbox) next(channel string, now time.Time) (*OutboxEntry, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var expired []*OutboxEntry
+	var found *OutboxEntry
+	var wait time.Duration
+	blocked := make(map[string]bool)
+	for _, entry := range o.pending {
+		if entry.Message.Channel != channel || blocked[entry.Message.ChatID] {
+			continue
+		}
+		if now.Sub(entry.CreatedAt) > o.maxAge {
+			expired = append(expired, entry)
+			continue
+		}
+		blocked[entry.Message.ChatID] = true
+		if !entry.NextAttempt.After(now) {
+			found = entry
+			break
+		}
+		if d := entry.NextAttempt.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	for _, entry := range expired {
+		if entry.LastError == "" {
+			entry.LastError = "not delivered in time"
+		}
+		o.killLocked(entry)
+	}
+	if len(expired) > 0 {
+		o.saveLocked()
+	}
+	return found, wait
+}
+
+// complete records the outcome of a delivery attempt. Failed messages are
+// retried with exponential backoff until they run out of attempts or hit a
+// permanent error.
+func (o *Outbox) complete(entry *OutboxEntry, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err == nil {
+		o.removeLocked(entry)
+		o.saveLocked()
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	permanent, retryAfter := classifySendError(err)
+	if permanent || entry.Attempts >= o.maxAttempts {
+		o.killLocked(entry)
+		o.saveLocked()
+		return
+	}
+
+	backoff := min(outboxRetryMin<<(entry.Attempts-1), outboxRetryMax)
+	entry.NextAttempt = time.Now().Add(max(backoff, retryAfter))
+	logger.WarnCF("channels", "Send failed, will retry", map[string]interface{}{
+		"channel":  entry.Message.Channel,
+		"chat_id":  entry.Message.ChatID,
+		"id":       entry.ID,
+		"attempts": entry.Attempts,
+		"retry_in": time.Until(entry.NextAttempt).Round(time.Second).String(),
+		"error":    entry.LastError,
+	})
+	o.saveLocked()
+}
+
+// reject moves a message straight to the dead letters, for messages that
+// can never be delivered such as those addressed to a disabled channel.
+func (o *Outbox) reject(msg bus.OutboundMessage, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.killLocked(&OutboxEntry{
+		ID:        uuid.New().String()[:8],
+		Message:   msg,
+		CreatedAt: time.Now(),
+		LastError: reason,
+	})
+}
+
+// rejectUnknown dead-letters pending messages for channels that are not
+// enabled, so they do not sit in the queue forever.
+func (o *Outbox) rejectUnknown(known func(string) bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var unknown []*OutboxEntry
+	for _, entry := range o.pending {
+		if !known(entry.Message.Channel) {
+			unknown = append(unknown, entry)
+		}
+	}
+	for _, entry := range unknown {
+		entry.LastError = "channel not enabled"
+		o.killLocked(entry)
+	}
+	if len(unknown) > 0 {
+		o.saveLocked()
+	}
+}
+
+// wakeup returns a channel that receives when a message for the given
+// channel is queued.
+func (o *Outbox) wakeup(channel string) <-chan struct{} {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.wakeLocked(channel)
+}
+
+func (o *Outbox) wakeLocked(channel string) chan struct{} {
+	ch, ok := o.wake[channel]
+	if !ok {
+		ch = make(chan struct{}, 1)
+		o.wake[channel] = ch
+	}
+	return ch
+}
+
+func (o *Outbox) notify(channel string) {
+	o.mu.Lock()
+	ch := o.wakeLocked(channel)
+	o.mu.Unlock()
+
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) removeLocked(entry *OutboxEntry) {
+	o.pending = slices.DeleteFunc(o.pending, func(e *OutboxEntry) bool { return e == entry })
+}
+
+// killLocked removes entry from the queue and appends it to the dead
+// letters.
+func (o *Outbox) killLocked(entry *OutboxEntry) {
+	o.removeLocked(entry)
+
+	now := time.Now()
+	entry.FailedAt = &now
+	logger.ErrorCF("channels", "Giving up on outbound message", map[string]interface{}{
+		"channel":  entry.Message.Channel,
+		"chat_id":  entry.Message.ChatID,
+		"id":       entry.ID,
+		"attempts": entry.Attempts,
+		"error":    entry.LastError,
+	})
+
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = o.appendDeadLocked(data)
+	}
+	if err != nil {
+		logger.ErrorCF("channels", "Failed to write dead letter", map[string]interface{}{
+			"id":    entry.ID,
+			"error": err.Error(),
+		})
+	}
+}
+
+func (o *Outbox) appendDeadLocked(line []byte) error {
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(o.path(deadLetterFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+func (o *Outbox) readDeadLocked() ([]OutboxEntry, error) {
+	f, err := os.Open(o.path(deadLetterFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []OutboxEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry OutboxEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (o *Outbox) writeDeadLocked(entries []OutboxEntry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return writeFileAtomic(o.path(deadLetterFile), buf.Bytes())
+}
+
+// saveLocked writes the pending queue, replacing the file atomically so a
+// crash never leaves it half written.
+func (o *Outbox) saveLocked() error {
+	data, err := json.MarshalIndent(o.pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(o.path(outboxFile), data); err != nil {
+		logger.ErrorCF("channels", "Failed to save outbox", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// permanentError marks a send failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the outbox gives up on the message straight away
+// instead of retrying it, for failures such as a malformed chat ID.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// classifySendError reports whether a send error is permanent, and how
+// long the platform asked us to wait before trying again.
+func classifySendError(err error) (permanent bool, retryAfter time.Duration) {
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return true, 0
+	}
+
+	var tgErr *telegoapi.Error
+	if errors.As(err, &tgErr) {
+		if tgErr.ErrorCode == http.StatusTooManyRequests {
+			if tgErr.Parameters != nil {
+				retryAfter = time.Duration(tgErr.Parameters.RetryAfter) * time.Second
+			}
+			return false, retryAfter
+		}
+		// Chat not found, bot blocked or kicked, message rejected
+		return tgErr.ErrorCode >= 400 && tgErr.ErrorCode < 500, 0
+	}
+
+	var slackRate *slack.RateLimitedError
+	if errors.As(err, &slackRate) {
+		return false, slackRate.RetryAfter
+	}
+	var slackErr slack.SlackErrorResponse
+	if errors.As(err, &slackErr) {
+		switch slackErr.Err {
+		case "channel_not_found", "not_in_channel", "is_archived", "invalid_auth",
+			"account_inactive", "msg_too_long", "no_text", "restricted_action":
+			return true, 0
+		}
+		return false, 0
+	}
+
+	var discordRate discordgo.RateLimitError
+	if errors.As(err, &discordRate) && discordRate.RateLimit != nil {
+		return false, discordRate.RetryAfter
+	}
+	var discordErr *discordgo.RESTError
+	if errors.As(err, &discordErr) && discordErr.Response != nil {
+		code := discordErr.Response.StatusCode
+		return code >= 400 && code < 500 && code != http.StatusTooManyRequests, 0
+	}
+
+	return false, 0
+}
+
+// sendLimiter spaces out sends to stay under a platform's rate limits.
+type sendLimiter struct {
+	interval     time.Duration
+	chatInterval time.Duration
+	last         time.Time
+	lastChat     map[string]time.Time
+}
+
+func newSendLimiter(limit config.RateLimitConfig) *sendLimiter {
+	l := &sendLimiter{lastChat: make(map[string]time.Time)}
+	if limit.PerSecond > 0 {
+		l.interval = time.Duration(float64(time.Second) / limit.PerSecond)
+	}
+	if limit.PerChatPerSecond > 0 {
+		l.chatInterval = time.Duration(float64(time.Second) / limit.PerChatPerSecond)
+	}
+	return l
+}
+
+// wait blocks until a message may be sent to chatID and reserves the slot.
+func (l *sendLimiter) wait(ctx context.Context, chatID string) error {
+	now := time.Now()
+	at := now
+	if next := l.last.Add(l.interval); next.After(at) {
+		at = next
+	}
+	if next := l.lastChat[chatID].Add(l.chatInterval); next.After(at) {
+		at = next
+	}
+
+	if d := at.Sub(now); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	l.last = at
+	if l.chatInterval > 0 {
+		if len(l.lastChat) > 1000 {
+			for chat, t := range l.lastChat {
+				if at.Sub(t) > l.chatInterval {
+					delete(l.lastChat, chat)
+				}
+			}
+		}
+		l.lastChat[chatID] = at
+	}
+	return nil
+}
+
+// rateLimitFor returns the configured rate limit of a channel, falling
+// back to the built-in default.
+func (m *Manager) rateLimitFor(name string) config.RateLimitConfig {
+	if limit, ok := m.config.Outbox.RateLimits[name]; ok {
+		return limit
+	}
+	return defaultRateLimits[name]
+}
+
+// deliver sends queued messages for one channel until ctx is cancelled.
+func (m *Manager) deliver(ctx context.Context, name string, channel Channel) {
+	limiter := newSendLimiter(m.rateLimitFor(name))
+	wake := m.outbox.wakeup(name)
+
+	for {
+		entry, wait := m.outbox.next(name, time.Now())
+		if entry == nil || !channel.IsRunning() {
+			// Nothing due, or the supervisor is bringing the channel back
+			var timeout <-chan time.Time
+			if entry != nil {
+				timeout = time.After(outboxIdlePoll)
+			} else if wait > 0 {
+				timeout = time.After(wait)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-wake:
+			case <-timeout:
+			}
+			continue
+		}
+
+		if err := limiter.wait(ctx, entry.Message.ChatID); err != nil {
+			return
+		}
+		err := channel.Send(ctx, withButtonText(channel, entry.Message))
+		if ctx.Err() != nil {
+			// Shutting down; the message stays queued for the next start
+			return
+		}
+		m.recordSend(name, err)
+		m.outbox.complete(entry, err)
+	}
+}
+
+// OutboxRetryHandler requeues dead letters on request from "picoclaw
+// outbox retry". The body is {"ids": [...]}; no ids retries everything.
+func (m *Manager) OutboxRetryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if !isLocalRequest(r) {
+			writeHTTPError(w, http.StatusForbidden, "outbox is only available locally")
+			return
+		}
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeHTTPError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+		n, err := m.outbox.Retry(req.IDs)
+		if err != nil {
+			writeHTTPError(w, http.StatusInternalServerError, fmt.Sprintf("retry failed: %v", err))
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"requeued": n})
+	})
+}
@@ -0,0 +1,219 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mymmrac/telego/telegoapi"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func testOutbox(t *testing.T) (*Outbox, string) {
+	t.Helper()
+	dir := t.TempDir()
+	return NewOutbox(dir, config.OutboxConfig{MaxAttempts: 3, MaxAge: 60}), dir
+}
+
+func TestOutbox_PersistsPendingMessages(t *testing.T) {
+	o, dir := testOutbox(t)
+	entry, err := o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := NewOutbox(dir, config.OutboxConfig{})
+	pending := reopened.Pending()
+	if len(pending) != 1 || pending[0].ID != entry.ID || pending[0].Message.Content != "hello" {
+		t.Fatalf("pending after reopen = %+v", pending)
+	}
+}
+
+func TestOutbox_RetryKeepsChatOrder(t *testing.T) {
+	o, _ := testOutbox(t)
+	first, _ := o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "first"})
+	o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "second"})
+	other, _ := o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "2", Content: "other chat"})
+
+	entry, _ := o.next("telegram", time.Now())
+	if entry != first {
+		t.Fatalf("next = %+v, want first", entry)
+	}
+	o.complete(entry, errors.New("connection reset"))
+	if first.Attempts != 1 || !first.NextAttempt.After(time.Now()) {
+		t.Fatalf("after failure: %+v", first)
+	}
+
+	// Chat 1 waits for its retry; chat 2 is not held up
+	entry, _ = o.next("telegram", time.Now())
+	if entry != other {
+		t.Fatalf("next = %+v, want other chat", entry)
+	}
+	o.complete(entry, nil)
+
+	entry, wait := o.next("telegram", time.Now())
+	if entry != nil || wait <= 0 || wait > outboxRetryMin {
+		t.Fatalf("next = %+v, wait %s", entry, wait)
+	}
+
+	entry, _ = o.next("telegram", first.NextAttempt)
+	if entry != first {
+		t.Fatalf("next after backoff = %+v", entry)
+	}
+}
+
+func TestOutbox_DeadLettersAndRetry(t *testing.T) {
+	o, _ := testOutbox(t)
+	o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "blocked"})
+	o.Enqueue(bus.OutboundMessage{Channel: "slack", ChatID: "C1", Content: "flaky"})
+
+	entry, _ := o.next("telegram", time.Now())
+	o.complete(entry, Permanent(errors.New("invalid chat ID")))
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		entry, _ = o.next("slack", now)
+		if entry == nil {
+			t.Fatalf("attempt %d: nothing due", i+1)
+		}
+		o.complete(entry, errors.New("timeout"))
+		now = entry.NextAttempt
+	}
+
+	if pending := o.Pending(); len(pending) != 0 {
+		t.Fatalf("pending = %+v", pending)
+	}
+	dead, err := o.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 || dead[0].Attempts != 1 || dead[1].Attempts != 3 || dead[1].LastError != "timeout" || dead[1].FailedAt == nil {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	n, err := o.Retry([]string{dead[1].ID})
+	if err != nil || n != 1 {
+		t.Fatalf("Retry = %d, %v", n, err)
+	}
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].Message.Content != "flaky" || pending[0].Attempts != 0 {
+		t.Fatalf("pending after retry = %+v", pending)
+	}
+	if dead, _ := o.DeadLetters(); len(dead) != 1 || dead[0].Message.Content != "blocked" {
+		t.Fatalf("dead letters after retry = %+v", dead)
+	}
+}
+
+func TestOutbox_ExpiresOldMessages(t *testing.T) {
+	o, _ := testOutbox(t)
+	o.Enqueue(bus.OutboundMessage{Channel: "telegram", ChatID: "1", Content: "late"})
+
+	entry, _ := o.next("telegram", time.Now().Add(2*time.Hour))
+	if entry != nil {
+		t.Fatalf("expired message returned: %+v", entry)
+	}
+	if dead, _ := o.DeadLetters(); len(dead) != 1 || dead[0].LastError != "not delivered in time" {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+}
+
+func TestClassifySendError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		permanent  bool
+		retryAfter time.Duration
+	}{
+		{"plain", errors.New("connection reset"), false, 0},
+		{"permanent", Permanent(errors.New("bad chat")), true, 0},
+		{"telegram flood", fmt.Errorf("api: %w", &telegoapi.Error{
+			ErrorCode:  429,
+			Parameters: &telegoapi.ResponseParameters{RetryAfter: 30},
+		}), false, 30 * time.Second},
+		{"telegram blocked", fmt.Errorf("api: %w", &telegoapi.Error{ErrorCode: 403}), true, 0},
+		{"telegram server", fmt.Errorf("api: %w", &telegoapi.Error{ErrorCode: 502}), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			permanent, retryAfter := classifySendError(tt.err)
+			if permanent != tt.permanent || retryAfter != tt.retryAfter {
+				t.Errorf("got (%v, %s), want (%v, %s)", permanent, retryAfter, tt.permanent, tt.retryAfter)
+			}
+		})
+	}
+}
+
+func TestSendLimiter_SpacesMessagesPerChat(t *testing.T) {
+	l := newSendLimiter(config.RateLimitConfig{PerChatPerSecond: 20})
+	ctx := context.Background()
+
+	start := time.Now()
+	l.wait(ctx, "a")
+	l.wait(ctx, "b")
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("different chats were throttled: %s", elapsed)
+	}
+	l.wait(ctx, "a")
+	if elapsed := time.Since(start); elapsed < 45*time.Millisecond {
+		t.Errorf("same chat not throttled: %s", elapsed)
+	}
+}
+
+type recordingChannel struct {
+	*BaseChannel
+	mu   sync.Mutex
+	sent []string
+}
+
+func (c *recordingChannel) Start(ctx context.Context) error { c.setRunning(true); return nil }
+func (c *recordingChannel) Stop(ctx context.Context) error  { c.setRunning(false); return nil }
+
+func (c *recordingChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, msg.Content)
+	return nil
+}
+
+func TestManager_DeliversQueuedMessages(t *testing.T) {
+	m, err := NewManager(config.DefaultConfig(), bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.outbox, _ = testOutbox(t)
+	ch := &recordingChannel{BaseChannel: NewBaseChannel("test", nil, nil, nil)}
+	ch.Start(context.Background())
+	m.RegisterChannel("test", ch)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.deliver(ctx, "test", ch)
+
+	m.outbox.Enqueue(bus.OutboundMessage{Channel: "test", ChatID: "1", Content: "one"})
+	m.outbox.Enqueue(bus.OutboundMessage{Channel: "test", ChatID: "1", Content: "two"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		ch.mu.Lock()
+		n := len(ch.sent)
+		ch.mu.Unlock()
+		if n == 2 && len(m.outbox.Pending()) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if len(ch.sent) != 2 || ch.sent[0] != "one" || ch.sent[1] != "two" {
+		t.Fatalf("sent = %v", ch.sent)
+	}
+	if status := statusOf(t, m, "test"); status.Sent != 2 {
+		t.Errorf("sent counter = %d", status.Sent)
+	}
+}
@@ -0,0 +1,260 @@
+package channels
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	pairingFile = "pairing.json"
+
+	// pairingCodeAlphabet leaves out characters that are easy to misread.
+	pairingCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	pairingCodeLength   = 6
+	pairingRequestTTL   = 24 * time.Hour
+	// How long before an unknown sender is reminded of their code.
+	pairingRemindAfter = time.Hour
+)
+
+// unpairedChannels keep using allow_from alone: their senders are not
+// chat users who could be sent a code.
+var unpairedChannels = map[string]bool{
+	"http":    true,
+	"webchat": true,
+	"email":   true,
+	"maixcam": true,
+}
+
+var (
+	ErrUnknownPairingCode = errors.New("no pending request with that code")
+	ErrNotPaired          = errors.New("sender is not paired")
+)
+
+// PairedSender is a sender the owner approved.
+type PairedSender struct {
+	Channel    string    `json:"channel"`
+	SenderID   string    `json:"sender_id"`
+	ApprovedAt time.Time `json:"approved_at"`
+}
+
+// PairingRequest is an unknown sender waiting for approval.
+type PairingRequest struct {
+	Code      string    `json:"code"`
+	Channel   string    `json:"channel"`
+	SenderID  string    `json:"sender_id"`
+	ChatID    string    `json:"chat_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// NotifiedAt is when the sender was last sent the code.
+	NotifiedAt time.Time `json:"notified_at"`
+}
+
+type pairingData struct {
+	Approved []PairedSender   `json:"approved"`
+	Pending  []PairingRequest `json:"pending"`
+}
+
+// Pairing is the allowlist of senders approved at runtime, kept in
+// <dir>/pairing.json next to the outbox. The CLI edits the same file, so
+// it is read again whenever it changes on disk.
+type Pairing struct {
+	path string
+	now  func() time.Time
+
+	mu      sync.Mutex
+	data    pairingData
+	modTime time.Time
+}
+
+// NewPairing opens the pairing store in dir.
+func NewPairing(dir string) *Pairing {
+	p := &Pairing{path: filepath.Join(dir, pairingFile), now: time.Now}
+	p.reloadLocked()
+	return p
+}
+
+// IsApproved reports whether the owner approved senderID on channel.
+func (p *Pairing) IsApproved(channel, senderID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadLocked()
+	idPart, _, _ := strings.Cut(senderID, "|")
+	for _, s := range p.data.Approved {
+		if s.Channel == channel && s.SenderID == idPart {
+			return true
+		}
+	}
+	return false
+}
+
+// Request returns the pairing code for an unknown sender, creating one if
+// needed. notify is true when the sender should be sent the code: the
+// first time, and again once an hour while the request is pending.
+func (p *Pairing) Request(channel, senderID, chatID string) (code string, notify bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadLocked()
+	p.expireLocked()
+
+	idPart, _, _ := strings.Cut(senderID, "|")
+	now := p.now()
+	for i := range p.data.Pending {
+		req := &p.data.Pending[i]
+		if req.Channel != channel || req.SenderID != idPart {
+			continue
+		}
+		if now.Sub(req.NotifiedAt) < pairingRemindAfter {
+			return req.Code, false, nil
+		}
+		req.NotifiedAt = now
+		req.ChatID = chatID
+		return req.Code, true, p.saveLocked()
+	}
+
+	code, err = p.newCodeLocked()
+	if err != nil {
+		return "", false, err
+	}
+	p.data.Pending = append(p.data.Pending, PairingRequest{
+		Code:       code,
+		Channel:    channel,
+		SenderID:   idPart,
+		ChatID:     chatID,
+		CreatedAt:  now,
+		NotifiedAt: now,
+	})
+	logger.InfoCF("channels", "Pairing requested by unknown sender", map[string]interface{}{
+		"channel":   channel,
+		"sender_id": senderID,
+		"code":      code,
+	})
+	return code, true, p.saveLocked()
+}
+
+// Approve adds the sender of a pending request to the allowlist.
+func (p *Pairing) Approve(code string) (PairingRequest, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadLocked()
+	p.expireLocked()
+	for i, req := range p.data.Pending {
+		if req.Code != code {
+			continue
+		}
+		p.data.Pending = append(p.data.Pending[:i], p.data.Pending[i+1:]...)
+		p.data.Approved = append(p.data.Approved, PairedSender{
+			Channel:    req.Channel,
+			SenderID:   req.SenderID,
+			ApprovedAt: p.now(),
+		})
+		return req, p.saveLocked()
+	}
+	return PairingRequest{}, ErrUnknownPairingCode
+}
+
+// Revoke removes a sender from the allowlist.
+func (p *Pairing) Revoke(channel, senderID string) error {
+	idPart, _, _ := strings.Cut(senderID, "|")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadLocked()
+	for i, s := range p.data.Approved {
+		if s.Channel == channel && s.SenderID == idPart {
+			p.data.Approved = append(p.data.Approved[:i], p.data.Approved[i+1:]...)
+			return p.saveLocked()
+		}
+	}
+	return ErrNotPaired
+}
+
+// List returns the approved senders and the pending requests.
+func (p *Pairing) List() ([]PairedSender, []PairingRequest) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadLocked()
+	p.expireLocked()
+	approved := append([]PairedSender(nil), p.data.Approved...)
+	pending := append([]PairingRequest(nil), p.data.Pending...)
+	sort.Slice(approved, func(i, j int) bool { return approved[i].ApprovedAt.Before(approved[j].ApprovedAt) })
+	return approved, pending
+}
+
+func (p *Pairing) newCodeLocked() (string, error) {
+	for {
+		b := make([]byte, pairingCodeLength)
+		for i := range b {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(pairingCodeAlphabet))))
+			if err != nil {
+				return "", err
+			}
+			b[i] = pairingCodeAlphabet[n.Int64()]
+		}
+		code := string(b)
+		taken := false
+		for _, req := range p.data.Pending {
+			taken = taken || req.Code == code
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+}
+
+func (p *Pairing) expireLocked() {
+	now := p.now()
+	kept := p.data.Pending[:0]
+	for _, req := range p.data.Pending {
+		if now.Sub(req.CreatedAt) < pairingRequestTTL {
+			kept = append(kept, req)
+		}
+	}
+	p.data.Pending = kept
+}
+
+// reloadLocked reads the file again if another process changed it.
+func (p *Pairing) reloadLocked() {
+	info, err := os.Stat(p.path)
+	if err != nil || info.ModTime().Equal(p.modTime) {
+		return
+	}
+	raw, err := os.ReadFile(p.path)
+	if err != nil {
+		return
+	}
+	var data pairingData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		logger.ErrorCF("channels", "Pairing file unreadable", map[string]interface{}{
+			"path":  p.path,
+			"error": err.Error(),
+		})
+		return
+	}
+	p.data = data
+	p.modTime = info.ModTime()
+}
+
+func (p *Pairing) saveLocked() error {
+	raw, err := json.MarshalIndent(p.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.path, raw); err != nil {
+		return err
+	}
+	if info, err := os.Stat(p.path); err == nil {
+		p.modTime = info.ModTime()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+)
+
+func TestPairingApprove(t *testing.T) {
+	dir := t.TempDir()
+	gateway := NewPairing(dir)
+
+	code, notify, err := gateway.Request("telegram", "42|alice", "42")
+	if err != nil || !notify || len(code) != pairingCodeLength {
+		t.Fatalf("Request = %q, %v, %v", code, notify, err)
+	}
+	if again, notify, _ := gateway.Request("telegram", "42", "42"); again != code || notify {
+		t.Errorf("repeat Request = %q, notify %v; want same code without notifying", again, notify)
+	}
+
+	// The CLI approves in its own process through the same file
+	cli := NewPairing(dir)
+	req, err := cli.Approve(strings.ToLower(code))
+	if err != nil || req.SenderID != "42" {
+		t.Fatalf("Approve = %+v, %v", req, err)
+	}
+	if !gateway.IsApproved("telegram", "42|alice") {
+		t.Error("gateway did not see the approval")
+	}
+	if gateway.IsApproved("discord", "42") {
+		t.Error("approval leaked to another channel")
+	}
+
+	if err := cli.Revoke("telegram", "42"); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if gateway.IsApproved("telegram", "42") {
+		t.Error("revoked sender still approved")
+	}
+	if _, err := cli.Approve(code); !errors.Is(err, ErrUnknownPairingCode) {
+		t.Errorf("code reused: %v", err)
+	}
+}
+
+func TestPairingReminder(t *testing.T) {
+	p := NewPairing(t.TempDir())
+	now := time.Now()
+	p.now = func() time.Time { return now }
+
+	code, _, _ := p.Request("slack", "U1", "D1")
+	now = now.Add(pairingRemindAfter)
+	if again, notify, _ := p.Request("slack", "U1", "D1"); again != code || !notify {
+		t.Errorf("reminder = %q, notify %v", again, notify)
+	}
+
+	now = now.Add(pairingRequestTTL)
+	if _, err := p.Approve(code); !errors.Is(err, ErrUnknownPairingCode) {
+		t.Errorf("expired request approved: %v", err)
+	}
+}
+
+func TestHandleMessageRequestsPairing(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	ch := NewBaseChannel("telegram", nil, msgBus, []string{"1"})
+	ch.setPairing(NewPairing(t.TempDir()))
+
+	ch.HandleMessage("2", "g1", "hi", nil, map[string]string{MetaIsGroup: "true"})
+	ch.HandleMessage("2", "2", "hi", nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok || out.ChatID != "2" || !strings.Contains(out.Content, "pairing code") {
+		t.Fatalf("outbound = %+v, received %v", out, ok)
+	}
+	if ch.ReceivedCount() != 0 {
+		t.Error("unknown sender's message reached the agent")
+	}
+
+	if !ch.IsAllowed("1") {
+		t.Error("allow_from sender rejected with pairing on")
+	}
+}
@@ -18,6 +18,9 @@ import (
 	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
+// qqMessageLimit stays under the QQ bot API's limit on message length.
+const qqMessageLimit = 2000
+
 type QQChannel struct {
 	*BaseChannel
 	config         config.QQConfig
@@ -116,18 +119,19 @@ func (c *QQChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 		return fmt.Errorf("QQ bot not running")
 	}
 
-	// 构造消息
-	msgToCreate := &dto.MessageToCreate{
-		Content: msg.Content,
-	}
+	for _, chunk := range RenderChunks(msg.Content, FormatPlain, qqMessageLimit) {
+		// 构造消息
+		msgToCreate := &dto.MessageToCreate{
+			Content: chunk,
+		}
 
-	// C2C 消息发送
-	_, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate)
-	if err != nil {
-		logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return err
+		// C2C 消息发送
+		if _, err := c.api.PostC2CMessage(ctx, msg.ChatID, msgToCreate); err != nil {
+			logger.ErrorCF("qq", "Failed to send C2C message", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return err
+		}
 	}
 
 	return nil
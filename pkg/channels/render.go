@@ -0,0 +1,463 @@
+package channels
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format is a platform's dialect of message formatting. The agent writes
+// Markdown; RenderMarkdown turns it into the dialect a channel sends.
+type Format int
+
+const (
+	// FormatMarkdown passes Markdown through, for clients that render it.
+	FormatMarkdown Format = iota
+	// FormatPlain strips Markdown, for platforms that show it literally.
+	FormatPlain
+	FormatTelegramHTML
+	FormatMatrixHTML
+	FormatSlack
+	FormatDiscord
+	FormatWhatsApp
+	// FormatFeishu is the Markdown subset of Feishu card markdown elements.
+	FormatFeishu
+)
+
+// dialect renders the parts of a Markdown document in one Format.
+type dialect struct {
+	escape    func(string) string
+	bold      func(string) string
+	italic    func(string) string
+	strike    func(string) string
+	code      func(string) string
+	codeBlock func(lang, code string) string
+	link      func(label, url string) string
+	heading   func(level int, text string) string
+	// quote renders consecutive quoted lines, joined by newlines.
+	quote  func(string) string
+	bullet string
+	rule   string
+	// finish post-processes the rendered message, if set.
+	finish func(string) string
+}
+
+func wrap(before, after string) func(string) string {
+	return func(s string) string { return before + s + after }
+}
+
+func keep(s string) string { return s }
+
+func prefixLines(prefix string) func(string) string {
+	return func(s string) string {
+		return prefix + strings.ReplaceAll(s, "\n", "\n"+prefix)
+	}
+}
+
+func fencedBlock(withLang bool) func(lang, code string) string {
+	return func(lang, code string) string {
+		if !withLang {
+			lang = ""
+		}
+		return "```" + lang + "\n" + code + "\n```"
+	}
+}
+
+func markdownLink(label, url string) string { return "[" + label + "](" + url + ")" }
+
+// textLink shows a link as "label (url)" where links cannot be embedded.
+func textLink(label, url string) string {
+	if label == url {
+		return url
+	}
+	return label + " (" + url + ")"
+}
+
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+func htmlLink(label, url string) string {
+	return `<a href="` + strings.ReplaceAll(escapeHTML(url), `"`, "&quot;") + `">` + label + "</a>"
+}
+
+func htmlCodeBlock(lang, code string) string {
+	if lang == "" {
+		return "<pre><code>" + code + "</code></pre>"
+	}
+	return `<pre><code class="language-` + escapeHTML(lang) + `">` + code + "</code></pre>"
+}
+
+const textRule = "──────────"
+
+var dialects = map[Format]*dialect{
+	FormatPlain: {
+		escape:    keep,
+		bold:      keep,
+		italic:    keep,
+		strike:    keep,
+		code:      keep,
+		codeBlock: func(lang, code string) string { return code },
+		link:      textLink,
+		heading:   func(level int, text string) string { return text },
+		quote:     prefixLines("> "),
+		bullet:    "• ",
+		rule:      textRule,
+	},
+	FormatTelegramHTML: {
+		escape:    escapeHTML,
+		bold:      wrap("<b>", "</b>"),
+		italic:    wrap("<i>", "</i>"),
+		strike:    wrap("<s>", "</s>"),
+		code:      wrap("<code>", "</code>"),
+		codeBlock: htmlCodeBlock,
+		link:      htmlLink,
+		heading:   func(level int, text string) string { return "<b>" + text + "</b>" },
+		quote:     wrap("<blockquote>", "</blockquote>"),
+		bullet:    "• ",
+		rule:      textRule,
+	},
+	FormatMatrixHTML: {
+		escape:    escapeHTML,
+		bold:      wrap("<b>", "</b>"),
+		italic:    wrap("<i>", "</i>"),
+		strike:    wrap("<del>", "</del>"),
+		code:      wrap("<code>", "</code>"),
+		codeBlock: htmlCodeBlock,
+		link:      htmlLink,
+		heading: func(level int, text string) string {
+			return fmt.Sprintf("<h%d>%s</h%d>", level, text, level)
+		},
+		quote:  wrap("<blockquote>", "</blockquote>"),
+		bullet: "• ",
+		rule:   "<hr>",
+		finish: htmlLineBreaks,
+	},
+	FormatSlack: {
+		escape:    escapeHTML,
+		bold:      wrap("*", "*"),
+		italic:    wrap("_", "_"),
+		strike:    wrap("~", "~"),
+		code:      wrap("`", "`"),
+		codeBlock: fencedBlock(false),
+		link:      func(label, url string) string { return "<" + url + "|" + label + ">" },
+		heading:   func(level int, text string) string { return "*" + text + "*" },
+		quote:     prefixLines("> "),
+		bullet:    "• ",
+		rule:      textRule,
+	},
+	FormatDiscord: {
+		escape:    keep,
+		bold:      wrap("**", "**"),
+		italic:    wrap("*", "*"),
+		strike:    wrap("~~", "~~"),
+		code:      wrap("`", "`"),
+		codeBlock: fencedBlock(true),
+		link:      markdownLink,
+		heading: func(level int, text string) string {
+			// Discord renders three levels of headings
+			if level > 3 {
+				return "**" + text + "**"
+			}
+			return strings.Repeat("#", level) + " " + text
+		},
+		quote:  prefixLines("> "),
+		bullet: "- ",
+		rule:   textRule,
+	},
+	FormatWhatsApp: {
+		escape:    keep,
+		bold:      wrap("*", "*"),
+		italic:    wrap("_", "_"),
+		strike:    wrap("~", "~"),
+		code:      wrap("`", "`"),
+		codeBlock: func(lang, code string) string { return "```" + code + "```" },
+		link:      textLink,
+		heading:   func(level int, text string) string { return "*" + text + "*" },
+		quote:     prefixLines("> "),
+		bullet:    "• ",
+		rule:      textRule,
+	},
+	FormatFeishu: {
+		escape:    escapeHTML,
+		bold:      wrap("**", "**"),
+		italic:    wrap("*", "*"),
+		strike:    wrap("~~", "~~"),
+		code:      wrap("`", "`"),
+		codeBlock: fencedBlock(true),
+		link:      markdownLink,
+		heading:   func(level int, text string) string { return "**" + text + "**" },
+		quote:     keep,
+		bullet:    "- ",
+		rule:      textRule,
+	},
+}
+
+var (
+	fenceRe      = regexp.MustCompile("^\\s*```\\s*([\\w+#.-]*)")
+	headingRe    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	quoteRe      = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	ruleRe       = regexp.MustCompile(`^\s*(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	inlineCodeRe = regexp.MustCompile("`([^`\n]+)`")
+	linkRe       = regexp.MustCompile(`\[([^\]\n]+)\]\(([^)\s]+)\)`)
+	boldRe       = regexp.MustCompile(`\*\*([^*\n]+?)\*\*|__([^_\n]+?)__`)
+	strikeRe     = regexp.MustCompile(`~~([^~\n]+?)~~`)
+	// Emphasis markers must not touch a letter on the outside, so
+	// snake_case names and 2*3*4 stay as they are.
+	italicRe = regexp.MustCompile(`(^|[^*_\w])(?:\*([^*\s](?:[^*\n]*[^*\s])?)\*|_([^_\s](?:[^_\n]*[^_\s])?)_)($|[^*_\w])`)
+)
+
+// RenderMarkdown converts the agent's Markdown into format.
+func RenderMarkdown(text string, format Format) string {
+	d, ok := dialects[format]
+	if !ok || text == "" {
+		return text
+	}
+
+	var out []string
+	var quoted []string
+	flushQuote := func() {
+		if len(quoted) > 0 {
+			out = append(out, d.quote(strings.Join(quoted, "\n")))
+			quoted = nil
+		}
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fenceRe.FindStringSubmatch(line); m != nil {
+			flushQuote()
+			var code []string
+			for i++; i < len(lines) && !fenceRe.MatchString(lines[i]); i++ {
+				code = append(code, lines[i])
+			}
+			out = append(out, d.codeBlock(m[1], d.escape(strings.Join(code, "\n"))))
+			continue
+		}
+
+		if m := quoteRe.FindStringSubmatch(line); m != nil {
+			quoted = append(quoted, renderInline(d, m[1]))
+			continue
+		}
+		flushQuote()
+
+		switch {
+		case ruleRe.MatchString(line):
+			out = append(out, d.rule)
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			out = append(out, d.heading(len(m[1]), renderInline(d, m[2])))
+		case bulletRe.MatchString(line):
+			m := bulletRe.FindStringSubmatch(line)
+			out = append(out, m[1]+d.bullet+renderInline(d, m[2]))
+		default:
+			out = append(out, renderInline(d, line))
+		}
+	}
+	flushQuote()
+
+	rendered := strings.Join(out, "\n")
+	if d.finish != nil {
+		rendered = d.finish(rendered)
+	}
+	return rendered
+}
+
+// renderInline renders code spans, links and emphasis within a line.
+// Rendered spans are set aside behind placeholders so later patterns do
+// not match inside them.
+func renderInline(d *dialect, text string) string {
+	var spans []string
+	hold := func(s string) string {
+		spans = append(spans, s)
+		return fmt.Sprintf("\x00%d\x00", len(spans)-1)
+	}
+
+	text = inlineCodeRe.ReplaceAllStringFunc(text, func(m string) string {
+		return hold(d.code(d.escape(m[1 : len(m)-1])))
+	})
+	text = linkRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := linkRe.FindStringSubmatch(m)
+		return hold(d.link(d.escape(sub[1]), sub[2]))
+	})
+	text = d.escape(text)
+
+	// Italics first, so the inside of **bold** is never read as *italic*
+	for {
+		replaced := italicRe.ReplaceAllStringFunc(text, func(m string) string {
+			sub := italicRe.FindStringSubmatch(m)
+			return sub[1] + hold(d.italic(sub[2]+sub[3])) + sub[4]
+		})
+		if replaced == text {
+			break
+		}
+		text = replaced
+	}
+	text = strikeRe.ReplaceAllStringFunc(text, func(m string) string {
+		return hold(d.strike(strikeRe.FindStringSubmatch(m)[1]))
+	})
+	text = boldRe.ReplaceAllStringFunc(text, func(m string) string {
+		sub := boldRe.FindStringSubmatch(m)
+		return hold(d.bold(sub[1] + sub[2]))
+	})
+
+	// Later spans can contain earlier ones, so restore them last first
+	for i := len(spans) - 1; i >= 0; i-- {
+		text = strings.ReplaceAll(text, fmt.Sprintf("\x00%d\x00", i), spans[i])
+	}
+	return text
+}
+
+// htmlLineBreaks turns newlines outside <pre> blocks into <br>, for HTML
+// that is not displayed with preserved whitespace.
+func htmlLineBreaks(html string) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(html, "<pre>")
+		end := strings.Index(html, "</pre>")
+		if start == -1 || end < start {
+			sb.WriteString(strings.ReplaceAll(html, "\n", "<br>"))
+			return sb.String()
+		}
+		end += len("</pre>")
+		sb.WriteString(strings.ReplaceAll(html[:start], "\n", "<br>"))
+		sb.WriteString(html[start:end])
+		html = html[end:]
+	}
+}
+
+// minRenderLimit stops RenderChunks from splitting ever smaller when
+// rendering expands text a lot.
+const minRenderLimit = 200
+
+// RenderChunks renders text in format and splits it into messages of at
+// most limit characters, on line boundaries where possible and without
+// breaking code blocks. A limit of 0 means no limit.
+func RenderChunks(text string, format Format, limit int) []string {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if limit <= 0 {
+		return []string{RenderMarkdown(text, format)}
+	}
+	return renderChunks(text, format, limit, limit)
+}
+
+func renderChunks(text string, format Format, limit, sourceLimit int) []string {
+	var chunks []string
+	for _, chunk := range SplitMarkdown(text, sourceLimit) {
+		rendered := RenderMarkdown(chunk, format)
+		n := utf8.RuneCountInString(rendered)
+		if n > limit && sourceLimit > minRenderLimit {
+			// Markup made the chunk too long; split its source finer
+			smaller := max(sourceLimit*limit/n*9/10, minRenderLimit)
+			chunks = append(chunks, renderChunks(chunk, format, limit, smaller)...)
+			continue
+		}
+		chunks = append(chunks, rendered)
+	}
+	return chunks
+}
+
+// SplitMarkdown splits Markdown into parts of at most limit characters.
+// It breaks between lines, and inside a line only when the line alone is
+// too long. A code block that has to be split is closed at the end of one
+// part and reopened at the start of the next, so each part renders on its
+// own.
+func SplitMarkdown(text string, limit int) []string {
+	text = strings.TrimSpace(text)
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	}
+
+	var (
+		parts   []string
+		current []string
+		size    int
+		fence   string // opening line of the code block we are in, if any
+	)
+	const closing = "```"
+	// room keeps space to close an open code block
+	room := func() int {
+		if fence != "" {
+			return limit - len(closing) - 1
+		}
+		return limit
+	}
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		if fence != "" {
+			current = append(current, closing)
+		}
+		if part := strings.TrimSpace(strings.Join(current, "\n")); part != "" {
+			parts = append(parts, part)
+		}
+		current, size = nil, 0
+		if fence != "" {
+			current, size = []string{fence}, utf8.RuneCountInString(fence)
+		}
+	}
+	add := func(line string) {
+		if len(current) > 0 {
+			size++ // newline
+		}
+		current = append(current, line)
+		size += utf8.RuneCountInString(line)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		isFence := fenceRe.MatchString(line)
+		n := utf8.RuneCountInString(line)
+
+		if size+1+n > room() && len(current) > 0 && !(fence != "" && len(current) == 1) {
+			flush()
+		}
+		for n > room()-size-1 && n > 0 {
+			// A single line longer than a part: cut it at a space
+			head, tail := cutLine(line, max(room()-size-1, 1))
+			add(head)
+			flush()
+			line, n = tail, utf8.RuneCountInString(tail)
+		}
+		add(line)
+
+		if isFence {
+			if fence == "" {
+				fence = strings.TrimSpace(line)
+			} else {
+				fence = ""
+			}
+		}
+	}
+	fence = ""
+	flush()
+	return parts
+}
+
+// cutLine splits line after at most n characters, at the last space if
+// there is one in the second half.
+func cutLine(line string, n int) (string, string) {
+	runes := []rune(line)
+	if len(runes) <= n {
+		return line, ""
+	}
+	cut := n
+	for i := n; i > n/2; i-- {
+		if runes[i] == ' ' {
+			cut = i
+			break
+		}
+	}
+	return string(runes[:cut]), strings.TrimLeft(string(runes[cut:]), " ")
+}
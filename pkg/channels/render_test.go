@@ -0,0 +1,88 @@
+package channels
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	input := "# Plan\n**Bold** and *italic* and ~~gone~~, see [docs](https://x.io/?a=1&b=2).\n- run `go test`\n> quoted <tag>\n```go\nif a < b {}\n```"
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{FormatTelegramHTML, "<b>Plan</b>\n<b>Bold</b> and <i>italic</i> and <s>gone</s>, see <a href=\"https://x.io/?a=1&amp;b=2\">docs</a>.\n• run <code>go test</code>\n<blockquote>quoted &lt;tag&gt;</blockquote>\n<pre><code class=\"language-go\">if a &lt; b {}</code></pre>"},
+		{FormatSlack, "*Plan*\n*Bold* and _italic_ and ~gone~, see <https://x.io/?a=1&b=2|docs>.\n• run `go test`\n> quoted &lt;tag&gt;\n```\nif a &lt; b {}\n```"},
+		{FormatWhatsApp, "*Plan*\n*Bold* and _italic_ and ~gone~, see docs (https://x.io/?a=1&b=2).\n• run `go test`\n> quoted <tag>\n```if a < b {}```"},
+		{FormatPlain, "Plan\nBold and italic and gone, see docs (https://x.io/?a=1&b=2).\n• run go test\n> quoted <tag>\nif a < b {}"},
+		{FormatDiscord, "# Plan\n**Bold** and *italic* and ~~gone~~, see [docs](https://x.io/?a=1&b=2).\n- run `go test`\n> quoted <tag>\n```go\nif a < b {}\n```"},
+		{FormatMarkdown, input},
+	}
+	for _, tt := range tests {
+		if got := RenderMarkdown(input, tt.format); got != tt.want {
+			t.Errorf("format %d:\ngot  %q\nwant %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdownLeavesIdentifiersAlone(t *testing.T) {
+	for _, input := range []string{"call get_user_name now", "2*3*4 = 24", "a * b * c"} {
+		if got := RenderMarkdown(input, FormatTelegramHTML); got != input {
+			t.Errorf("RenderMarkdown(%q) = %q", input, got)
+		}
+	}
+	if got := RenderMarkdown("**bold with *italic* inside**", FormatTelegramHTML); got != "<b>bold with <i>italic</i> inside</b>" {
+		t.Errorf("nested = %q", got)
+	}
+	if got := RenderMarkdown("*one* *two*", FormatSlack); got != "_one_ _two_" {
+		t.Errorf("adjacent = %q", got)
+	}
+}
+
+func TestSplitMarkdownKeepsCodeBlocks(t *testing.T) {
+	code := strings.Repeat("fmt.Println(\"hello\")\n", 20)
+	text := "Intro line\n```go\n" + code + "```\nOutro"
+
+	parts := SplitMarkdown(text, 120)
+	if len(parts) < 3 {
+		t.Fatalf("parts = %d", len(parts))
+	}
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > 120 {
+			t.Errorf("part %d has %d characters", i, n)
+		}
+		if strings.Count(part, "```")%2 != 0 {
+			t.Errorf("part %d has an unbalanced fence:\n%s", i, part)
+		}
+	}
+	if !strings.HasPrefix(parts[1], "```go\n") {
+		t.Errorf("code block not reopened with its language: %q", parts[1])
+	}
+}
+
+func TestSplitMarkdownLongLine(t *testing.T) {
+	line := strings.Repeat("word ", 100)
+	parts := SplitMarkdown(line, 60)
+	for i, part := range parts {
+		if n := utf8.RuneCountInString(part); n > 60 {
+			t.Errorf("part %d has %d characters", i, n)
+		}
+	}
+	if joined := strings.Join(parts, " "); joined != strings.TrimSpace(line) {
+		t.Errorf("words lost: %q", joined)
+	}
+}
+
+func TestRenderChunksFitAfterMarkup(t *testing.T) {
+	text := strings.Repeat("**a** ", 300)
+	for _, chunk := range RenderChunks(text, FormatTelegramHTML, 500) {
+		if n := utf8.RuneCountInString(chunk); n > 500 {
+			t.Errorf("chunk has %d characters", n)
+		}
+	}
+	if chunks := RenderChunks("  \n", FormatPlain, 100); chunks != nil {
+		t.Errorf("blank message gave %q", chunks)
+	}
+}
@@ -0,0 +1,36 @@
+package channels
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Metadata keys channels set when a message replies to or quotes an
+// earlier one. Media of the earlier message is added to the message's own
+// media, and noted in its text as "[image: photo]" and the like.
+const (
+	MetaReplyToID     = "reply_to_id"
+	MetaReplyToAuthor = "reply_to_author"
+	MetaReplyToText   = "reply_to_text"
+)
+
+// maxReplyTextLength bounds how much of a quoted message is passed on, so
+// replying to a long document does not fill the context.
+const maxReplyTextLength = 4000
+
+// setReplyContext records the message being replied to in metadata. A
+// message with neither text nor author is left out.
+func setReplyContext(metadata map[string]string, id, author, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" && author == "" {
+		return
+	}
+	if id != "" {
+		metadata[MetaReplyToID] = id
+	}
+	if author != "" {
+		metadata[MetaReplyToAuthor] = author
+	}
+	metadata[MetaReplyToText] = utils.Truncate(text, maxReplyTextLength)
+}
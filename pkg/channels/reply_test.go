@@ -0,0 +1,47 @@
+package channels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSetReplyContext(t *testing.T) {
+	metadata := map[string]string{}
+	setReplyContext(metadata, "7", "alice", "  "+strings.Repeat("x", maxReplyTextLength+10))
+	if metadata[MetaReplyToID] != "7" || metadata[MetaReplyToAuthor] != "alice" {
+		t.Errorf("metadata = %v", metadata)
+	}
+	if n := len([]rune(metadata[MetaReplyToText])); n != maxReplyTextLength {
+		t.Errorf("quoted text has %d characters", n)
+	}
+
+	empty := map[string]string{}
+	setReplyContext(empty, "8", "", " ")
+	if len(empty) != 0 {
+		t.Errorf("empty reply recorded: %v", empty)
+	}
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	body := "> <@alice:example.org> original\n> second line\n\nmy answer"
+	if got := stripReplyFallback(body); got != "my answer" {
+		t.Errorf("stripReplyFallback = %q", got)
+	}
+	if got := stripReplyFallback("> a quote I typed"); got != "> a quote I typed" {
+		t.Errorf("plain quote changed to %q", got)
+	}
+}
+
+func TestLineRecentMessages(t *testing.T) {
+	recent := newLineRecentMessages(2)
+	recent.add("1", lineRecentMessage{Type: "text", Text: "one"})
+	recent.add("2", lineRecentMessage{Type: "image", Text: "[image]"})
+	recent.add("3", lineRecentMessage{Type: "text", Text: "three", FromBot: true})
+
+	if _, ok := recent.get("1"); ok {
+		t.Error("oldest message kept past the limit")
+	}
+	if msg, ok := recent.get("3"); !ok || msg.Text != "three" || !msg.FromBot {
+		t.Errorf("get(3) = %+v, %v", msg, ok)
+	}
+}
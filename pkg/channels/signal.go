@@ -0,0 +1,606 @@
+package channels
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+	"github.com/sipeed/picoclaw/pkg/voice"
+)
+
+const (
+	signalRPCTimeout     = 30 * time.Second
+	signalTypingInterval = 10 * time.Second
+	signalTypingMaxTotal = 5 * time.Minute
+	signalGroupPrefix    = "group:"
+	// Longer messages are sent as a text attachment by Signal clients
+	signalMessageLimit = 2000
+)
+
+// SignalChannel talks to a signal-cli daemon in JSON-RPC mode
+// ("signal-cli -a ACCOUNT daemon --tcp" or "--socket") over newline-delimited
+// JSON. Direct chats use the sender's number as chat ID, groups use
+// "group:<groupId>" with the ID in URL-safe base64, since "/" in a chat ID
+// would mark a thread.
+type SignalChannel struct {
+	*BaseChannel
+	config      config.SignalConfig
+	transcriber *voice.GroqTranscriber
+	ctx         context.Context
+	cancel      context.CancelFunc
+
+	mu      sync.Mutex
+	conn    net.Conn
+	writeMu sync.Mutex
+	nextID  atomic.Int64
+	pending sync.Map // request id -> chan signalRPCMessage
+
+	stopTyping sync.Map // chatID -> *thinkingCancel
+}
+
+type signalRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type signalEnvelope struct {
+	Source       string `json:"source"`
+	SourceNumber string `json:"sourceNumber"`
+	SourceUUID   string `json:"sourceUuid"`
+	SourceName   string `json:"sourceName"`
+	Timestamp    int64  `json:"timestamp"`
+	DataMessage  *struct {
+		Message   string `json:"message"`
+		GroupInfo *struct {
+			GroupID string `json:"groupId"`
+		} `json:"groupInfo"`
+		Attachments []signalAttachment `json:"attachments"`
+		Quote       *signalQuote       `json:"quote"`
+	} `json:"dataMessage"`
+}
+
+// signalQuote is the message a reply quotes. Its attachments only come
+// as thumbnails, so they are just noted.
+type signalQuote struct {
+	ID           int64  `json:"id"`
+	Author       string `json:"author"`
+	AuthorNumber string `json:"authorNumber"`
+	Text         string `json:"text"`
+	Attachments  []struct {
+		ContentType string `json:"contentType"`
+	} `json:"attachments"`
+}
+
+type signalAttachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+}
+
+// NewSignalChannel creates a new Signal channel instance.
+func NewSignalChannel(cfg config.SignalConfig, messageBus *bus.MessageBus) (*SignalChannel, error) {
+	if cfg.RPCAddress == "" {
+		return nil, fmt.Errorf("signal rpc_address is required")
+	}
+	if cfg.AttachmentsDir == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			cfg.AttachmentsDir = filepath.Join(home, ".local", "share", "signal-cli", "attachments")
+		}
+	}
+
+	base := NewBaseChannel("signal", cfg, messageBus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
+
+	return &SignalChannel{
+		BaseChannel: base,
+		config:      cfg,
+	}, nil
+}
+
+func (c *SignalChannel) SetTranscriber(transcriber *voice.GroqTranscriber) {
+	c.transcriber = transcriber
+}
+
+// Start connects to the daemon. A failed first connection is retried in
+// the background like any later disconnect.
+func (c *SignalChannel) Start(ctx context.Context) error {
+	logger.InfoCF("signal", "Starting Signal channel", map[string]interface{}{
+		"rpc_address": c.config.RPCAddress,
+	})
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	go c.connectLoop()
+
+	c.setRunning(true)
+	logger.InfoC("signal", "Signal channel started")
+	return nil
+}
+
+func (c *SignalChannel) Stop(ctx context.Context) error {
+	logger.InfoC("signal", "Stopping Signal channel")
+	c.setRunning(false)
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	c.mu.Lock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Health reports whether the connection to signal-cli is up.
+func (c *SignalChannel) Health() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return fmt.Errorf("not connected to signal-cli at %s", c.config.RPCAddress)
+	}
+	return nil
+}
+
+// Send sends msg, with any attachments, to a number or group.
+func (c *SignalChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("signal channel not running")
+	}
+
+	c.stopTypingFor(msg.ChatID)
+
+	// Attachments go with the first message
+	chunks := RenderChunks(msg.Content, FormatPlain, signalMessageLimit)
+	if len(chunks) == 0 {
+		chunks = []string{""}
+	}
+	for i, chunk := range chunks {
+		params := c.targetParams(msg.ChatID)
+		params["message"] = chunk
+		if i == 0 && len(msg.Media) > 0 {
+			params["attachments"] = msg.Media
+		}
+
+		if _, err := c.call(ctx, "send", params); err != nil {
+			return fmt.Errorf("failed to send signal message: %w", err)
+		}
+	}
+	return nil
+}
+
+// signalGroupIDs converts signal-cli's standard base64 group IDs to the
+// URL-safe alphabet used in chat IDs and back. Padding is kept, so IDs
+// without "+" or "/" read the same either way.
+var signalGroupIDs = struct{ toChat, fromChat *strings.Replacer }{
+	toChat:   strings.NewReplacer("+", "-", "/", "_"),
+	fromChat: strings.NewReplacer("-", "+", "_", "/"),
+}
+
+// targetParams addresses a request to the chat: a group or a recipient.
+func (c *SignalChannel) targetParams(chatID string) map[string]interface{} {
+	params := map[string]interface{}{}
+	if c.config.Account != "" {
+		params["account"] = c.config.Account
+	}
+	if groupID, ok := strings.CutPrefix(chatID, signalGroupPrefix); ok {
+		params["groupId"] = signalGroupIDs.fromChat.Replace(groupID)
+	} else {
+		params["recipient"] = []string{chatID}
+	}
+	return params
+}
+
+func (c *SignalChannel) connectLoop() {
+	interval := time.Duration(c.config.ReconnectInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	for c.ctx.Err() == nil {
+		if err := c.connect(); err != nil {
+			logger.ErrorCF("signal", "Failed to connect to signal-cli", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			c.listen()
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(interval):
+			logger.InfoC("signal", "Attempting to reconnect...")
+		}
+	}
+}
+
+// connect dials the daemon: "unix:///path" or a bare path is a UNIX socket,
+// anything else a TCP address.
+func (c *SignalChannel) connect() error {
+	network, address := "tcp", c.config.RPCAddress
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unix", path
+	} else if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(c.ctx, network, address)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+
+	logger.InfoC("signal", "Connected to signal-cli")
+	return nil
+}
+
+// listen reads messages until the connection fails, then fails every
+// request still waiting for a response.
+func (c *SignalChannel) listen() {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg signalRPCMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			logger.WarnCF("signal", "Failed to parse JSON-RPC message", map[string]interface{}{
+				"error": err.Error(),
+			})
+			continue
+		}
+
+		if msg.Method == "" {
+			if ch, ok := c.pending.LoadAndDelete(string(msg.ID)); ok {
+				ch.(chan signalRPCMessage) <- msg
+			}
+			continue
+		}
+		if msg.Method == "receive" {
+			go c.handleReceive(msg.Params)
+		}
+	}
+
+	if c.ctx.Err() == nil {
+		errMsg := "connection closed"
+		if err := scanner.Err(); err != nil {
+			errMsg = err.Error()
+		}
+		logger.ErrorCF("signal", "signal-cli connection lost", map[string]interface{}{
+			"error": errMsg,
+		})
+	}
+
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.mu.Unlock()
+
+	c.pending.Range(func(key, value interface{}) bool {
+		c.pending.Delete(key)
+		close(value.(chan signalRPCMessage))
+		return true
+	})
+}
+
+// call sends a JSON-RPC request and waits for its result.
+func (c *SignalChannel) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return nil, fmt.Errorf("signal-cli not connected")
+	}
+
+	id := fmt.Sprintf("%d", c.nextID.Add(1))
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+	data, err := json.Marshal(signalRPCMessage{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage(id),
+		Method:  method,
+		Params:  paramsJSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	respCh := make(chan signalRPCMessage, 1)
+	c.pending.Store(id, respCh)
+	defer c.pending.Delete(id)
+
+	c.writeMu.Lock()
+	_, err = conn.Write(append(data, '\n'))
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to write request: %w", err)
+	}
+
+	timer := time.NewTimer(signalRPCTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-respCh:
+		if !ok {
+			return nil, fmt.Errorf("signal-cli connection lost")
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("signal-cli error %d: %s", resp.Error.Code, resp.Error.Message)
+		}
+		return resp.Result, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%s timed out", method)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *SignalChannel) handleReceive(params json.RawMessage) {
+	var notification struct {
+		Envelope signalEnvelope `json:"envelope"`
+	}
+	if err := json.Unmarshal(params, &notification); err != nil {
+		logger.WarnCF("signal", "Failed to parse receive notification", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	env := notification.Envelope
+	// Receipts, typing notifications and messages sent from our other devices
+	if env.DataMessage == nil {
+		return
+	}
+
+	number := env.SourceNumber
+	if number == "" {
+		number = env.Source
+	}
+	senderID := number
+	switch {
+	case number == "":
+		senderID = env.SourceUUID
+	case env.SourceUUID != "" && env.SourceUUID != number:
+		senderID = number + "|" + env.SourceUUID
+	}
+	if senderID == "" {
+		return
+	}
+
+	chatID := number
+	if chatID == "" {
+		chatID = env.SourceUUID
+	}
+	isGroup := false
+	if env.DataMessage.GroupInfo != nil && env.DataMessage.GroupInfo.GroupID != "" {
+		chatID = signalGroupPrefix + signalGroupIDs.toChat.Replace(env.DataMessage.GroupInfo.GroupID)
+		isGroup = true
+	}
+
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("signal", "Message rejected by allowlist", map[string]interface{}{
+			"sender": senderID,
+		})
+		c.requestPairing(senderID, chatID, isGroup)
+		return
+	}
+
+	content := env.DataMessage.Message
+	var mediaPaths []string
+	for _, att := range env.DataMessage.Attachments {
+		localPath := c.fetchAttachment(chatID, att)
+		if localPath == "" {
+			continue
+		}
+		mediaPaths = append(mediaPaths, localPath)
+
+		tag := "[file]"
+		switch {
+		case utils.IsAudioFile(att.Filename, att.ContentType):
+			tag = c.transcribe(localPath)
+		case strings.HasPrefix(att.ContentType, "image/"):
+			tag = "[image]"
+		case strings.HasPrefix(att.ContentType, "video/"):
+			tag = "[video]"
+		}
+		if content != "" {
+			content += "\n"
+		}
+		content += tag
+	}
+
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	logger.DebugCF("signal", "Received message", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"is_group":  isGroup,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	metadata := map[string]string{
+		"message_id":  fmt.Sprintf("%d", env.Timestamp),
+		"sender_name": env.SourceName,
+		"is_group":    fmt.Sprintf("%t", isGroup),
+	}
+	if quote := env.DataMessage.Quote; quote != nil {
+		author := quote.AuthorNumber
+		if author == "" {
+			author = quote.Author
+		}
+		if author != "" && author == c.config.Account {
+			metadata[MetaIsReplyToBot] = "true"
+		}
+		text := quote.Text
+		for range quote.Attachments {
+			text = appendContent(text, "[attachment]")
+		}
+		setReplyContext(metadata, fmt.Sprintf("%d", quote.ID), author, text)
+	}
+
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		c.startTyping(chatID)
+	}
+
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// fetchAttachment copies an attachment out of signal-cli's attachment
+// directory, or asks the daemon for it when that directory is not local.
+func (c *SignalChannel) fetchAttachment(chatID string, att signalAttachment) string {
+	if att.ID == "" {
+		return ""
+	}
+	filename := att.Filename
+	if filename == "" {
+		filename = att.ID
+	}
+
+	var data []byte
+	if c.config.AttachmentsDir != "" {
+		data, _ = os.ReadFile(filepath.Join(c.config.AttachmentsDir, filepath.Base(att.ID)))
+	}
+	if data == nil {
+		params := c.targetParams(chatID)
+		params["id"] = att.ID
+		result, err := c.call(c.ctx, "getAttachment", params)
+		if err != nil {
+			logger.ErrorCF("signal", "Failed to fetch attachment", map[string]interface{}{
+				"id":    att.ID,
+				"error": err.Error(),
+			})
+			return ""
+		}
+		var encoded struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal(result, &encoded); err != nil {
+			json.Unmarshal(result, &encoded.Data)
+		}
+		data, err = base64.StdEncoding.DecodeString(encoded.Data)
+		if err != nil {
+			logger.ErrorCF("signal", "Failed to decode attachment", map[string]interface{}{
+				"id":    att.ID,
+				"error": err.Error(),
+			})
+			return ""
+		}
+	}
+
+	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(mediaDir, 0700); err != nil {
+		logger.ErrorCF("signal", "Failed to create media directory", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	localPath := filepath.Join(mediaDir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(filename))
+	if err := os.WriteFile(localPath, data, 0600); err != nil {
+		logger.ErrorCF("signal", "Failed to save attachment", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return ""
+	}
+	return localPath
+}
+
+func (c *SignalChannel) transcribe(path string) string {
+	if c.transcriber == nil || !c.transcriber.IsAvailable() {
+		return "[voice]"
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := c.transcriber.Transcribe(ctx, path)
+	if err != nil {
+		logger.ErrorCF("signal", "Voice transcription failed", map[string]interface{}{
+			"error": err.Error(),
+			"path":  path,
+		})
+		return "[voice (transcription failed)]"
+	}
+	return fmt.Sprintf("[voice transcription: %s]", result.Text)
+}
+
+// startTyping shows the typing indicator until the reply is sent, which
+// clears it. Signal clients drop it after about 15 seconds, so it is
+// refreshed.
+func (c *SignalChannel) startTyping(chatID string) {
+	c.stopTypingFor(chatID)
+
+	ctx, cancel := context.WithTimeout(c.ctx, signalTypingMaxTotal)
+	c.stopTyping.Store(chatID, &thinkingCancel{fn: cancel})
+
+	go func() {
+		ticker := time.NewTicker(signalTypingInterval)
+		defer ticker.Stop()
+		c.sendTyping(ctx, chatID)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.sendTyping(ctx, chatID)
+			}
+		}
+	}()
+}
+
+func (c *SignalChannel) stopTypingFor(chatID string) {
+	if stop, ok := c.stopTyping.LoadAndDelete(chatID); ok {
+		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
+			cf.Cancel()
+		}
+	}
+}
+
+func (c *SignalChannel) sendTyping(ctx context.Context, chatID string) {
+	if _, err := c.call(ctx, "sendTyping", c.targetParams(chatID)); err != nil && ctx.Err() == nil {
+		logger.DebugCF("signal", "Failed to send typing indicator", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package channels
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// fakeSignalCLI is a JSON-RPC stand-in for "signal-cli daemon --tcp".
+type fakeSignalCLI struct {
+	ln       net.Listener
+	conns    chan net.Conn
+	requests chan signalRPCMessage
+}
+
+func startFakeSignalCLI(t *testing.T) *fakeSignalCLI {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSignalCLI{ln: ln, conns: make(chan net.Conn, 4), requests: make(chan signalRPCMessage, 32)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.conns <- conn
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeSignalCLI) serve(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req signalRPCMessage
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			continue
+		}
+		result := `{}`
+		if req.Method == "getAttachment" {
+			result = fmt.Sprintf(`{"data":%q}`, base64.StdEncoding.EncodeToString([]byte("voice-bytes")))
+		}
+		fmt.Fprintf(conn, "{\"jsonrpc\":\"2.0\",\"id\":%s,\"result\":%s}\n", req.ID, result)
+		f.requests <- req
+	}
+}
+
+// notify writes a notification to conn on a single line, as signal-cli does.
+func notify(t *testing.T, conn net.Conn, notification string) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(notification)); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteByte('\n')
+	conn.Write(buf.Bytes())
+}
+
+func (f *fakeSignalCLI) nextRequest(t *testing.T, method string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case req := <-f.requests:
+			if req.Method != method {
+				continue
+			}
+			var params map[string]interface{}
+			json.Unmarshal(req.Params, &params)
+			return params
+		case <-timeout:
+			t.Fatalf("no %s request", method)
+			return nil
+		}
+	}
+}
+
+func TestSignalChannel_ReceiveAndReply(t *testing.T) {
+	daemon := startFakeSignalCLI(t)
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewSignalChannel(config.SignalConfig{
+		RPCAddress:        daemon.ln.Addr().String(),
+		AttachmentsDir:    t.TempDir(),
+		ReconnectInterval: 1,
+		AllowFrom:         config.FlexibleStringSlice{"+15550001"},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	conn := <-daemon.conns
+	notify(t, conn, `{"jsonrpc":"2.0","method":"receive","params":{"envelope":{
+		"source":"+15550001","sourceNumber":"+15550001","sourceUuid":"u-1","sourceName":"Alice","timestamp":1700000000000,
+		"dataMessage":{"message":"listen to this","groupInfo":{"groupId":"Z3JvdXA="},
+		"attachments":[{"id":"abc.aac","contentType":"audio/aac","size":11}]}}}}`)
+
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.ChatID != "group:Z3JvdXA=" || msg.SenderID != "+15550001|u-1" {
+		t.Errorf("ChatID = %q, SenderID = %q", msg.ChatID, msg.SenderID)
+	}
+	if msg.Content != "listen to this\n[voice]" {
+		t.Errorf("Content = %q", msg.Content)
+	}
+	if len(msg.Media) != 1 {
+		t.Fatalf("Media = %v", msg.Media)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "voice-bytes" {
+		t.Errorf("attachment = %q", data)
+	}
+	if params := daemon.nextRequest(t, "sendTyping"); params["groupId"] != "Z3JvdXA=" {
+		t.Errorf("sendTyping params = %v", params)
+	}
+
+	err = ch.Send(ctx, bus.OutboundMessage{ChatID: "+15550001", Content: "hi", Media: []string{"/tmp/a.png"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := daemon.nextRequest(t, "send")
+	if params["message"] != "hi" || fmt.Sprint(params["recipient"]) != "[+15550001]" || fmt.Sprint(params["attachments"]) != "[/tmp/a.png]" {
+		t.Errorf("send params = %v", params)
+	}
+
+	// After the daemon drops the connection the channel reconnects
+	conn.Close()
+	select {
+	case conn = <-daemon.conns:
+	case <-ctx.Done():
+		t.Fatal("channel did not reconnect")
+	}
+	notify(t, conn, `{"jsonrpc":"2.0","method":"receive","params":{"envelope":{
+		"sourceNumber":"+15550001","timestamp":1700000000001,"dataMessage":{"message":"still there?"}}}}`)
+	msg, ok = msgBus.ConsumeInbound(ctx)
+	if !ok || msg.Content != "still there?" || msg.ChatID != "+15550001" {
+		t.Errorf("after reconnect got %+v", msg)
+	}
+}
+
+func TestSignalChannel_IgnoresDisallowedSender(t *testing.T) {
+	msgBus := bus.NewMessageBus()
+	ch, err := NewSignalChannel(config.SignalConfig{
+		RPCAddress: "127.0.0.1:1",
+		AllowFrom:  config.FlexibleStringSlice{"+15550001"},
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.ctx = context.Background()
+
+	ch.handleReceive(json.RawMessage(`{"envelope":{"sourceNumber":"+15559999","dataMessage":{"message":"hi"}}}`))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if msg, ok := msgBus.ConsumeInbound(ctx); ok {
+		t.Errorf("unexpected inbound message %+v", msg)
+	}
+}
+
+func TestSignalGroupIDsHaveNoSlash(t *testing.T) {
+	ch := &SignalChannel{}
+	chatID := signalGroupPrefix + signalGroupIDs.toChat.Replace("ab/c+d==")
+	if chatID != "group:ab_c-d==" || groupKey(chatID) != chatID {
+		t.Errorf("chat ID = %q, group key = %q", chatID, groupKey(chatID))
+	}
+	if params := ch.targetParams(chatID); params["groupId"] != "ab/c+d==" {
+		t.Errorf("groupId = %v, want the signal-cli form", params["groupId"])
+	}
+}
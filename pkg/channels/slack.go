@@ -13,6 +13,7 @@ import (
 	"github.com/slack-go/slack/socketmode"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/logger"
 	"github.com/sipeed/picoclaw/pkg/utils"
@@ -29,6 +30,10 @@ type SlackChannel struct {
 	ctx          context.Context
 	cancel       context.CancelFunc
 	pendingAcks  sync.Map
+	commands     map[string]bool // Agent command names, for slash commands
+	commandsMu   sync.RWMutex
+	threads      sync.Map // "channel/threadTS" -> slack.Message, thread parents
+	userNames    sync.Map // user ID -> display name
 }
 
 type slackMessageRef struct {
@@ -49,6 +54,7 @@ func NewSlackChannel(cfg config.SlackConfig, messageBus *bus.MessageBus) (*Slack
 	socketClient := socketmode.New(api)
 
 	base := NewBaseChannel("slack", cfg, messageBus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
 
 	return &SlackChannel{
 		BaseChannel:  base,
@@ -114,20 +120,30 @@ func (c *SlackChannel) Send(ctx context.Context, msg bus.OutboundMessage) error
 
 	channelID, threadTS := parseSlackChatID(msg.ChatID)
 	if channelID == "" {
-		return fmt.Errorf("invalid slack chat ID: %s", msg.ChatID)
+		return Permanent(fmt.Errorf("invalid slack chat ID: %s", msg.ChatID))
 	}
 
-	opts := []slack.MsgOption{
-		slack.MsgOptionText(msg.Content, false),
+	// Section blocks hold up to 3000 characters, so chunks that carry
+	// buttons fit in one
+	chunks := RenderChunks(msg.Content, FormatSlack, slackMessageLimit)
+	if len(chunks) == 0 {
+		chunks = []string{""}
 	}
+	for i, chunk := range chunks {
+		opts := []slack.MsgOption{
+			slack.MsgOptionText(chunk, false),
+		}
+		if i == len(chunks)-1 && len(msg.Buttons) > 0 {
+			opts = append(opts, slack.MsgOptionBlocks(slackButtonBlocks(chunk, msg.Buttons)...))
+		}
 
-	if threadTS != "" {
-		opts = append(opts, slack.MsgOptionTS(threadTS))
-	}
+		if threadTS != "" {
+			opts = append(opts, slack.MsgOptionTS(threadTS))
+		}
 
-	_, _, err := c.api.PostMessageContext(ctx, channelID, opts...)
-	if err != nil {
-		return fmt.Errorf("failed to send slack message: %w", err)
+		if _, _, err := c.api.PostMessageContext(ctx, channelID, opts...); err != nil {
+			return fmt.Errorf("failed to send slack message: %w", err)
+		}
 	}
 
 	if ref, ok := c.pendingAcks.LoadAndDelete(msg.ChatID); ok {
@@ -164,6 +180,7 @@ func (c *SlackChannel) eventLoop() {
 				if event.Request != nil {
 					c.socketClient.Ack(*event.Request)
 				}
+				c.handleInteractive(event)
 			}
 		}
 	}
@@ -203,6 +220,7 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 		logger.DebugCF("slack", "Message rejected by allowlist", map[string]interface{}{
 			"user_id": ev.User,
 		})
+		c.requestPairing(ev.User, ev.Channel, ev.ChannelType != "im")
 		return
 	}
 
@@ -216,19 +234,32 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 		chatID = channelID + "/" + threadTS
 	}
 
-	c.api.AddReaction("eyes", slack.ItemRef{
-		Channel:   channelID,
-		Timestamp: messageTS,
-	})
-
-	c.pendingAcks.Store(chatID, slackMessageRef{
-		ChannelID: channelID,
-		Timestamp: messageTS,
-	})
+	metadata := map[string]string{
+		"message_ts": messageTS,
+		"channel_id": channelID,
+		"thread_ts":  threadTS,
+		"platform":   "slack",
+		"is_group":   fmt.Sprintf("%t", ev.ChannelType != "im"),
+	}
+	if c.botUserID != "" && strings.Contains(ev.Text, "<@"+c.botUserID+">") {
+		metadata[MetaIsMentioned] = "true"
+	}
 
 	content := ev.Text
 	content = c.stripBotMention(content)
 
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		c.api.AddReaction("eyes", slack.ItemRef{
+			Channel:   channelID,
+			Timestamp: messageTS,
+		})
+
+		c.pendingAcks.Store(chatID, slackMessageRef{
+			ChannelID: channelID,
+			Timestamp: messageTS,
+		})
+	}
+
 	var mediaPaths []string
 	localFiles := []string{} // 跟踪需要清理的本地文件
 
@@ -274,12 +305,9 @@ func (c *SlackChannel) handleMessageEvent(ev *slackevents.MessageEvent) {
 		return
 	}
 
-	metadata := map[string]string{
-		"message_ts": messageTS,
-		"channel_id": channelID,
-		"thread_ts":  threadTS,
-		"platform":   "slack",
-	}
+	replyMedia := c.addThreadParent(channelID, threadTS, messageTS, metadata)
+	localFiles = append(localFiles, replyMedia...)
+	mediaPaths = append(mediaPaths, replyMedia...)
 
 	logger.DebugCF("slack", "Received message", map[string]interface{}{
 		"sender_id":  senderID,
@@ -315,16 +343,6 @@ func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
 		chatID = channelID + "/" + messageTS
 	}
 
-	c.api.AddReaction("eyes", slack.ItemRef{
-		Channel:   channelID,
-		Timestamp: messageTS,
-	})
-
-	c.pendingAcks.Store(chatID, slackMessageRef{
-		ChannelID: channelID,
-		Timestamp: messageTS,
-	})
-
 	content := c.stripBotMention(ev.Text)
 
 	if strings.TrimSpace(content) == "" {
@@ -332,14 +350,105 @@ func (c *SlackChannel) handleAppMention(ev *slackevents.AppMentionEvent) {
 	}
 
 	metadata := map[string]string{
-		"message_ts": messageTS,
-		"channel_id": channelID,
-		"thread_ts":  threadTS,
-		"platform":   "slack",
-		"is_mention": "true",
+		"message_ts":   messageTS,
+		"channel_id":   channelID,
+		"thread_ts":    threadTS,
+		"platform":     "slack",
+		"is_mention":   "true",
+		"is_group":     "true",
+		"is_mentioned": "true",
+	}
+
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		c.api.AddReaction("eyes", slack.ItemRef{
+			Channel:   channelID,
+			Timestamp: messageTS,
+		})
+
+		c.pendingAcks.Store(chatID, slackMessageRef{
+			ChannelID: channelID,
+			Timestamp: messageTS,
+		})
 	}
 
-	c.HandleMessage(senderID, chatID, content, nil, metadata)
+	replyMedia := c.addThreadParent(channelID, threadTS, messageTS, metadata)
+	defer func() {
+		for _, file := range replyMedia {
+			os.Remove(file)
+		}
+	}()
+
+	c.HandleMessage(senderID, chatID, content, replyMedia, metadata)
+}
+
+// addThreadParent records the message that started the thread a message
+// was posted in as the message it replies to. It returns the paths of the
+// parent's downloaded files.
+func (c *SlackChannel) addThreadParent(channelID, threadTS, messageTS string, metadata map[string]string) []string {
+	if threadTS == "" || threadTS == messageTS {
+		return nil
+	}
+
+	key := channelID + "/" + threadTS
+	var parent slack.Message
+	if cached, ok := c.threads.Load(key); ok {
+		parent = cached.(slack.Message)
+	} else {
+		msgs, _, _, err := c.api.GetConversationRepliesContext(c.ctx, &slack.GetConversationRepliesParameters{
+			ChannelID: channelID,
+			Timestamp: threadTS,
+			Inclusive: true,
+			Limit:     1,
+		})
+		if err != nil || len(msgs) == 0 {
+			logger.DebugCF("slack", "Failed to fetch thread parent", map[string]interface{}{
+				"channel_id": channelID,
+				"thread_ts":  threadTS,
+				"error":      fmt.Sprintf("%v", err),
+			})
+			return nil
+		}
+		parent = msgs[0]
+		c.threads.Store(key, parent)
+	}
+
+	if parent.User != "" && parent.User == c.botUserID {
+		metadata[MetaIsReplyToBot] = "true"
+	}
+	text := parent.Text
+	var media []string
+	for _, file := range parent.Files {
+		if path := c.downloadSlackFile(file); path != "" {
+			media = append(media, path)
+			text = appendContent(text, fmt.Sprintf("[file: %s]", file.Name))
+		}
+	}
+	setReplyContext(metadata, threadTS, c.userName(parent.User, parent.Username), text)
+	return media
+}
+
+// userName returns the display name of a Slack user, falling back to
+// fallback when it cannot be looked up.
+func (c *SlackChannel) userName(userID, fallback string) string {
+	if userID == "" {
+		return fallback
+	}
+	if name, ok := c.userNames.Load(userID); ok {
+		return name.(string)
+	}
+	user, err := c.api.GetUserInfoContext(c.ctx, userID)
+	if err != nil {
+		return fallback
+	}
+	name := user.Profile.DisplayName
+	if name == "" {
+		name = user.RealName
+	}
+	if name == "" {
+		name = user.Name
+	}
+	c.userNames.Store(userID, name)
+	return name
 }
 
 func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
@@ -362,11 +471,7 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	senderID := cmd.UserID
 	channelID := cmd.ChannelID
 	chatID := channelID
-	content := cmd.Text
-
-	if strings.TrimSpace(content) == "" {
-		content = "help"
-	}
+	content := c.slashCommandContent(cmd.Command, cmd.Text)
 
 	metadata := map[string]string{
 		"channel_id": channelID,
@@ -384,6 +489,113 @@ func (c *SlackChannel) handleSlashCommand(event socketmode.Event) {
 	c.HandleMessage(senderID, chatID, content, nil, metadata)
 }
 
+// RendersButtons reports that buttons are shown as Block Kit buttons.
+func (c *SlackChannel) RendersButtons() bool {
+	return true
+}
+
+// slackMessageLimit keeps each message within one section block.
+const slackMessageLimit = 3000
+
+// slackButtonPrefix marks the action IDs of buttons sent by Send.
+const slackButtonPrefix = "picoclaw_button_"
+
+// slackButtonBlocks shows content as a section block followed by the
+// buttons.
+func slackButtonBlocks(content string, buttons []bus.Button) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, content, false, false), nil, nil),
+	}
+
+	var elements []slack.BlockElement
+	for i, button := range buttons {
+		label := slack.NewTextBlockObject(slack.PlainTextType, utils.Truncate(button.Label, 75), false, false)
+		elements = append(elements, slack.NewButtonBlockElement(fmt.Sprintf("%s%d", slackButtonPrefix, i), button.Value, label))
+	}
+	return append(blocks, slack.NewActionBlock("picoclaw_buttons", elements...))
+}
+
+// handleInteractive passes a button press to the agent and removes the
+// buttons so the question is only answered once.
+func (c *SlackChannel) handleInteractive(event socketmode.Event) {
+	callback, ok := event.Data.(slack.InteractionCallback)
+	if !ok || callback.Type != slack.InteractionTypeBlockActions {
+		return
+	}
+
+	var action *slack.BlockAction
+	for _, a := range callback.ActionCallback.BlockActions {
+		if strings.HasPrefix(a.ActionID, slackButtonPrefix) {
+			action = a
+			break
+		}
+	}
+	if action == nil || !c.IsAllowed(callback.User.ID) {
+		return
+	}
+
+	channelID := callback.Channel.ID
+	message := callback.Message
+	chatID := channelID
+	if message.ThreadTimestamp != "" {
+		chatID = channelID + "/" + message.ThreadTimestamp
+	}
+
+	var kept []slack.Block
+	for _, block := range message.Blocks.BlockSet {
+		if block.BlockType() != slack.MBTAction {
+			kept = append(kept, block)
+		}
+	}
+	if _, _, _, err := c.api.UpdateMessage(channelID, message.Timestamp,
+		slack.MsgOptionText(message.Text, false), slack.MsgOptionBlocks(kept...)); err != nil {
+		logger.DebugCF("slack", "Failed to remove buttons", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	c.HandleButton(callback.User.ID, chatID, action.Value, map[string]string{
+		"message_ts": message.Timestamp,
+		"channel_id": channelID,
+		"thread_ts":  message.ThreadTimestamp,
+		"platform":   "slack",
+	})
+}
+
+// SetCommands records the agent's commands. Slack slash commands can only
+// be created in the app settings, so they are not registered here.
+func (c *SlackChannel) SetCommands(cmds []*commands.Command) {
+	names := make(map[string]bool, len(cmds))
+	for _, cmd := range cmds {
+		names[cmd.Name] = true
+	}
+	c.commandsMu.Lock()
+	c.commands = names
+	c.commandsMu.Unlock()
+}
+
+// slashCommandContent maps a Slack slash command onto the agent's
+// commands. A Slack command named like one of ours ("/show model") passes
+// through; any other, such as a catch-all "/picoclaw", takes a command or
+// a plain request as its text.
+func (c *SlackChannel) slashCommandContent(command, text string) string {
+	text = strings.TrimSpace(text)
+
+	c.commandsMu.RLock()
+	defer c.commandsMu.RUnlock()
+
+	if name := strings.TrimPrefix(command, "/"); c.commands[name] {
+		return strings.TrimSpace("/" + name + " " + text)
+	}
+	if text == "" {
+		return "/help"
+	}
+	if first, _, _ := strings.Cut(text, " "); c.commands[strings.ToLower(first)] {
+		return "/" + text
+	}
+	return text
+}
+
 func (c *SlackChannel) downloadSlackFile(file slack.File) string {
 	downloadURL := file.URLPrivateDownload
 	if downloadURL == "" {
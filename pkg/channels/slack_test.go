@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/commands"
 	"github.com/sipeed/picoclaw/pkg/config"
 )
 
@@ -98,6 +99,31 @@ func TestStripBotMention(t *testing.T) {
 	}
 }
 
+func TestSlackSlashCommandContent(t *testing.T) {
+	ch := &SlackChannel{}
+	ch.SetCommands([]*commands.Command{{Name: "show"}, {Name: "help"}})
+
+	tests := []struct {
+		name    string
+		command string
+		text    string
+		want    string
+	}{
+		{name: "matching command", command: "/show", text: "model", want: "/show model"},
+		{name: "catch-all with command", command: "/picoclaw", text: "show model", want: "/show model"},
+		{name: "catch-all with request", command: "/picoclaw", text: "what's the weather", want: "what's the weather"},
+		{name: "catch-all empty", command: "/picoclaw", text: " ", want: "/help"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ch.slashCommandContent(tt.command, tt.text); got != tt.want {
+				t.Errorf("slashCommandContent(%q, %q) = %q, want %q", tt.command, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestNewSlackChannel(t *testing.T) {
 	msgBus := bus.NewMessageBus()
 
@@ -0,0 +1,291 @@
+package channels
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const (
+	defaultSuperviseInterval = 15 * time.Second
+	restartBackoffMin        = 5 * time.Second
+	restartBackoffMax        = 5 * time.Minute
+	// A channel that reports itself unhealthy this long is restarted, in
+	// case its own reconnect logic is stuck.
+	unhealthyRestartAfter = 5 * time.Minute
+)
+
+// Channel states reported by the supervisor.
+const (
+	ChannelStarting   = "starting"
+	ChannelRunning    = "running"
+	ChannelUnhealthy  = "unhealthy"
+	ChannelRestarting = "restarting"
+	ChannelFailed     = "failed"
+	ChannelStopped    = "stopped"
+)
+
+// ChannelStatus is the supervisor's view of one channel.
+type ChannelStatus struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	Since       time.Time  `json:"since"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Restarts    int        `json:"restarts"`
+	Received    int64      `json:"received"`
+	Sent        int64      `json:"sent"`
+	SendErrors  int64      `json:"send_errors"`
+}
+
+// channelState tracks one channel between supervisor checks.
+type channelState struct {
+	mu             sync.Mutex
+	status         ChannelStatus
+	unhealthySince time.Time
+	nextAttempt    time.Time
+	backoff        time.Duration
+
+	sent       atomic.Int64
+	sendErrors atomic.Int64
+}
+
+func (s *channelState) setState(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setStateLocked(state)
+}
+
+func (s *channelState) setStateLocked(state string) {
+	if s.status.State != state {
+		s.status.State = state
+		s.status.Since = time.Now()
+	}
+}
+
+func (s *channelState) recordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordErrorLocked(err)
+}
+
+func (s *channelState) recordErrorLocked(err error) {
+	now := time.Now()
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = &now
+}
+
+// stateFor returns the tracked state of a channel, creating it on first use.
+func (m *Manager) stateFor(name string) *channelState {
+	m.statesMu.Lock()
+	defer m.statesMu.Unlock()
+	state, ok := m.states[name]
+	if !ok {
+		state = &channelState{status: ChannelStatus{Name: name, State: ChannelStopped, Since: time.Now()}}
+		m.states[name] = state
+	}
+	return state
+}
+
+// startChannel starts a channel and records the outcome. A failed start is
+// retried by the supervisor with exponential backoff.
+func (m *Manager) startChannel(ctx context.Context, name string, channel Channel) {
+	state := m.stateFor(name)
+	err := channel.Start(ctx)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if err != nil {
+		logger.ErrorCF("channels", "Failed to start channel", map[string]interface{}{
+			"channel": name,
+			"error":   err.Error(),
+		})
+		state.recordErrorLocked(err)
+		state.setStateLocked(ChannelFailed)
+		state.backoff = min(max(state.backoff*2, restartBackoffMin), restartBackoffMax)
+		state.nextAttempt = time.Now().Add(state.backoff)
+		return
+	}
+	state.setStateLocked(ChannelRunning)
+	state.backoff = 0
+	state.unhealthySince = time.Time{}
+}
+
+// supervise checks every channel periodically until ctx is cancelled.
+func (m *Manager) supervise(ctx context.Context) {
+	ticker := time.NewTicker(m.superviseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.mu.RLock()
+			channels := make(map[string]Channel, len(m.channels))
+			for name, channel := range m.channels {
+				channels[name] = channel
+			}
+			m.mu.RUnlock()
+
+			for name, channel := range channels {
+				m.checkChannel(ctx, name, channel)
+			}
+		}
+	}
+}
+
+// checkChannel restarts a channel that stopped running or that has been
+// unhealthy for too long, and keeps its reported state current.
+func (m *Manager) checkChannel(ctx context.Context, name string, channel Channel) {
+	state := m.stateFor(name)
+	now := time.Now()
+
+	state.mu.Lock()
+	restart := false
+	running := channel.IsRunning()
+	switch {
+	case !running:
+		if state.status.State == ChannelRunning || state.status.State == ChannelUnhealthy {
+			logger.WarnCF("channels", "Channel stopped unexpectedly", map[string]interface{}{
+				"channel": name,
+			})
+			state.setStateLocked(ChannelFailed)
+		}
+		restart = !now.Before(state.nextAttempt)
+	default:
+		var healthErr error
+		if reporter, ok := channel.(HealthReporter); ok {
+			healthErr = reporter.Health()
+		}
+		if healthErr != nil {
+			if state.status.State != ChannelUnhealthy {
+				logger.WarnCF("channels", "Channel unhealthy", map[string]interface{}{
+					"channel": name,
+					"error":   healthErr.Error(),
+				})
+				state.unhealthySince = now
+				state.setStateLocked(ChannelUnhealthy)
+			}
+			state.recordErrorLocked(healthErr)
+			restart = now.Sub(state.unhealthySince) >= unhealthyRestartAfter && !now.Before(state.nextAttempt)
+		} else if state.status.State != ChannelRunning {
+			logger.InfoCF("channels", "Channel healthy", map[string]interface{}{
+				"channel": name,
+			})
+			state.setStateLocked(ChannelRunning)
+		}
+	}
+	if restart {
+		state.status.Restarts++
+		state.setStateLocked(ChannelRestarting)
+	}
+	state.mu.Unlock()
+
+	if !restart {
+		return
+	}
+
+	logger.InfoCF("channels", "Restarting channel", map[string]interface{}{
+		"channel": name,
+	})
+	if running {
+		if err := channel.Stop(ctx); err != nil {
+			logger.WarnCF("channels", "Error stopping channel for restart", map[string]interface{}{
+				"channel": name,
+				"error":   err.Error(),
+			})
+		}
+	}
+	m.startChannel(ctx, name, channel)
+}
+
+// ChannelStatuses returns the state and counters of every channel, sorted
+// by name.
+func (m *Manager) ChannelStatuses() []ChannelStatus {
+	m.mu.RLock()
+	channels := make(map[string]Channel, len(m.channels))
+	for name, channel := range m.channels {
+		channels[name] = channel
+	}
+	m.mu.RUnlock()
+
+	statuses := make([]ChannelStatus, 0, len(channels))
+	for name, channel := range channels {
+		state := m.stateFor(name)
+		state.mu.Lock()
+		status := state.status
+		state.mu.Unlock()
+
+		if counter, ok := channel.(interface{ ReceivedCount() int64 }); ok {
+			status.Received = counter.ReceivedCount()
+		}
+		status.Sent = state.sent.Load()
+		status.SendErrors = state.sendErrors.Load()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// CheckChannel reports whether a channel is running and healthy right now,
+// for the gateway's /ready endpoint.
+func (m *Manager) CheckChannel(name string) (bool, string) {
+	channel, ok := m.GetChannel(name)
+	if !ok {
+		return false, "not configured"
+	}
+	if !channel.IsRunning() {
+		state := m.stateFor(name)
+		state.mu.Lock()
+		defer state.mu.Unlock()
+		if state.status.LastError != "" {
+			return false, state.status.LastError
+		}
+		return false, "not running"
+	}
+	if reporter, ok := channel.(HealthReporter); ok {
+		if err := reporter.Health(); err != nil {
+			return false, err.Error()
+		}
+	}
+	return true, ""
+}
+
+// StatusHandler serves ChannelStatuses as JSON for "picoclaw status". Error
+// messages can contain URLs and account names, so only requests from the
+// same host are answered.
+func (m *Manager) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLocalRequest(r) {
+			writeHTTPError(w, http.StatusForbidden, "channel status is only available locally")
+			return
+		}
+		writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"channels": m.ChannelStatuses()})
+	})
+}
+
+// isLocalRequest reports whether r comes from loopback or from the address
+// it was received on.
+func isLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	remote := net.ParseIP(host)
+	if err != nil || remote == nil {
+		return false
+	}
+	if remote.IsLoopback() {
+		return true
+	}
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if tcp, ok := local.(*net.TCPAddr); ok && tcp.IP.Equal(remote) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+type flakyChannel struct {
+	*BaseChannel
+	startErrs []error
+	starts    int
+	stops     int
+	health    error
+}
+
+func (c *flakyChannel) Start(ctx context.Context) error {
+	c.starts++
+	if len(c.startErrs) > 0 {
+		err := c.startErrs[0]
+		c.startErrs = c.startErrs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	c.setRunning(true)
+	return nil
+}
+
+func (c *flakyChannel) Stop(ctx context.Context) error {
+	c.stops++
+	c.setRunning(false)
+	return nil
+}
+
+func (c *flakyChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	return nil
+}
+
+func (c *flakyChannel) Health() error {
+	return c.health
+}
+
+func newSupervisedChannel(t *testing.T, startErrs ...error) (*Manager, *flakyChannel) {
+	t.Helper()
+	m, err := NewManager(config.DefaultConfig(), bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := &flakyChannel{
+		BaseChannel: NewBaseChannel("flaky", nil, bus.NewMessageBus(), nil),
+		startErrs:   startErrs,
+	}
+	m.RegisterChannel("flaky", ch)
+	return m, ch
+}
+
+func statusOf(t *testing.T, m *Manager, name string) ChannelStatus {
+	t.Helper()
+	for _, status := range m.ChannelStatuses() {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("no status for %s", name)
+	return ChannelStatus{}
+}
+
+func TestSupervisor_RetriesFailedStartWithBackoff(t *testing.T) {
+	m, ch := newSupervisedChannel(t, errors.New("connection refused"))
+	ctx := context.Background()
+
+	m.startChannel(ctx, "flaky", ch)
+	status := statusOf(t, m, "flaky")
+	if status.State != ChannelFailed || status.LastError != "connection refused" {
+		t.Fatalf("after failed start: %+v", status)
+	}
+	if ok, reason := m.CheckChannel("flaky"); ok || reason != "connection refused" {
+		t.Errorf("CheckChannel = %v, %q", ok, reason)
+	}
+
+	// Within the backoff window nothing happens
+	m.checkChannel(ctx, "flaky", ch)
+	if ch.starts != 1 {
+		t.Fatalf("restarted during backoff: %d starts", ch.starts)
+	}
+
+	m.stateFor("flaky").nextAttempt = time.Time{}
+	m.checkChannel(ctx, "flaky", ch)
+	status = statusOf(t, m, "flaky")
+	if ch.starts != 2 || status.State != ChannelRunning || status.Restarts != 1 {
+		t.Fatalf("after retry: %d starts, %+v", ch.starts, status)
+	}
+	if ok, _ := m.CheckChannel("flaky"); !ok {
+		t.Error("CheckChannel reports running channel as not ready")
+	}
+}
+
+func TestSupervisor_RestartsStoppedChannel(t *testing.T) {
+	m, ch := newSupervisedChannel(t)
+	ctx := context.Background()
+
+	m.startChannel(ctx, "flaky", ch)
+	ch.setRunning(false)
+	m.checkChannel(ctx, "flaky", ch)
+
+	if ch.starts != 2 || !ch.IsRunning() {
+		t.Fatalf("stopped channel not restarted: %d starts", ch.starts)
+	}
+	if status := statusOf(t, m, "flaky"); status.State != ChannelRunning || status.Restarts != 1 {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestSupervisor_UnhealthyChannel(t *testing.T) {
+	m, ch := newSupervisedChannel(t)
+	ctx := context.Background()
+
+	m.startChannel(ctx, "flaky", ch)
+	ch.health = errors.New("sync failing")
+	m.checkChannel(ctx, "flaky", ch)
+
+	status := statusOf(t, m, "flaky")
+	if status.State != ChannelUnhealthy || status.LastError != "sync failing" || ch.stops != 0 {
+		t.Fatalf("status = %+v, %d stops", status, ch.stops)
+	}
+	if ok, reason := m.CheckChannel("flaky"); ok || reason != "sync failing" {
+		t.Errorf("CheckChannel = %v, %q", ok, reason)
+	}
+
+	// Unhealthy for too long: stop and start again
+	m.stateFor("flaky").unhealthySince = time.Now().Add(-unhealthyRestartAfter)
+	m.checkChannel(ctx, "flaky", ch)
+	if ch.stops != 1 || ch.starts != 2 {
+		t.Fatalf("unhealthy channel not restarted: %d stops, %d starts", ch.stops, ch.starts)
+	}
+
+	ch.health = nil
+	m.checkChannel(ctx, "flaky", ch)
+	if status := statusOf(t, m, "flaky"); status.State != ChannelRunning || status.Restarts != 1 {
+		t.Errorf("status after recovery = %+v", status)
+	}
+}
+
+func TestSupervisor_StatusHandler(t *testing.T) {
+	m, ch := newSupervisedChannel(t)
+	m.startChannel(context.Background(), "flaky", ch)
+	m.recordSend("flaky", nil)
+	m.recordSend("flaky", errors.New("rate limited"))
+	ch.HandleMessage("user", "chat", "hi", nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/status/channels", nil)
+	req.RemoteAddr = "127.0.0.1:50000"
+	rec := httptest.NewRecorder()
+	m.StatusHandler().ServeHTTP(rec, req)
+
+	var body struct {
+		Channels []ChannelStatus `json:"channels"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Channels) != 1 {
+		t.Fatalf("channels = %+v", body.Channels)
+	}
+	got := body.Channels[0]
+	if got.State != ChannelRunning || got.Received != 1 || got.Sent != 1 || got.SendErrors != 1 || got.LastError != "send: rate limited" {
+		t.Errorf("status = %+v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/status/channels", nil)
+	req.RemoteAddr = "203.0.113.7:50000"
+	rec = httptest.NewRecorder()
+	m.StatusHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("remote request: status %d", rec.Code)
+	}
+}
@@ -2,11 +2,15 @@ package channels
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -24,15 +28,27 @@ import (
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
+const (
+	// telegramMessageLimit stays under Telegram's 4096 character limit.
+	telegramMessageLimit = 4000
+	// telegramMaxUpdateSize bounds a webhook request body.
+	telegramMaxUpdateSize = 1 << 20
+)
+
 type TelegramChannel struct {
 	*BaseChannel
 	bot          *telego.Bot
-	commands     TelegramCommander
 	config       *config.Config
 	chatIDs      map[string]int64
 	transcriber  *voice.GroqTranscriber
 	placeholders sync.Map // chatID -> messageID
 	stopThinking sync.Map // chatID -> thinkingCancel
+	botCommands  []telego.BotCommand
+	commandsMu   sync.Mutex
+
+	webhookSecret string
+	webhook       telego.WebhookHandler // Set while running in webhook mode
+	webhookMu     sync.RWMutex
 }
 
 type thinkingCancel struct {
@@ -67,16 +83,22 @@ func NewTelegramChannel(cfg *config.Config, bus *bus.MessageBus) (*TelegramChann
 	}
 
 	base := NewBaseChannel("telegram", telegramCfg, bus, telegramCfg.AllowFrom)
+	base.SetGroupPolicy(telegramCfg.Groups)
+
+	secret := telegramCfg.WebhookSecret
+	if secret == "" {
+		secret = randomWebhookSecret()
+	}
 
 	return &TelegramChannel{
-		BaseChannel:  base,
-		commands:     NewTelegramCommands(bot, cfg),
-		bot:          bot,
-		config:       cfg,
-		chatIDs:      make(map[string]int64),
-		transcriber:  nil,
-		placeholders: sync.Map{},
-		stopThinking: sync.Map{},
+		BaseChannel:   base,
+		bot:           bot,
+		config:        cfg,
+		chatIDs:       make(map[string]int64),
+		transcriber:   nil,
+		placeholders:  sync.Map{},
+		stopThinking:  sync.Map{},
+		webhookSecret: secret,
 	}, nil
 }
 
@@ -85,13 +107,36 @@ func (c *TelegramChannel) SetTranscriber(transcriber *voice.GroqTranscriber) {
 }
 
 func (c *TelegramChannel) Start(ctx context.Context) error {
-	logger.InfoC("telegram", "Starting Telegram bot (polling mode)...")
-
-	updates, err := c.bot.UpdatesViaLongPolling(ctx, &telego.GetUpdatesParams{
-		Timeout: 30,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to start long polling: %w", err)
+	var updates <-chan telego.Update
+	var err error
+	if c.webhookMode() {
+		if _, err := c.webhookURLPath(); err != nil {
+			return err
+		}
+		logger.InfoC("telegram", "Starting Telegram bot (webhook mode)...")
+		updates, err = c.bot.UpdatesViaWebhook(ctx, c.setWebhookHandler,
+			telego.WithWebhookSet(ctx, &telego.SetWebhookParams{
+				URL:            c.config.Channels.Telegram.WebhookURL,
+				SecretToken:    c.webhookSecret,
+				AllowedUpdates: []string{"message", "callback_query"},
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to set webhook: %w", err)
+		}
+	} else {
+		logger.InfoC("telegram", "Starting Telegram bot (polling mode)...")
+		// Polling fails while a webhook from an earlier setup is set
+		if err := c.bot.DeleteWebhook(ctx, &telego.DeleteWebhookParams{}); err != nil {
+			logger.WarnCF("telegram", "Failed to delete webhook", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		updates, err = c.bot.UpdatesViaLongPolling(ctx, &telego.GetUpdatesParams{
+			Timeout: 30,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start long polling: %w", err)
+		}
 	}
 
 	bh, err := telegohandler.NewBotHandler(c.bot, updates)
@@ -99,25 +144,12 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to create bot handler: %w", err)
 	}
 
-	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
-		c.commands.Help(ctx, message)
-		return nil
-	}, th.CommandEqual("help"))
-	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
-		return c.commands.Start(ctx, message)
-	}, th.CommandEqual("start"))
-
-	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
-		return c.commands.Show(ctx, message)
-	}, th.CommandEqual("show"))
-
-	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
-		return c.commands.List(ctx, message)
-	}, th.CommandEqual("list"))
-
 	bh.HandleMessage(func(ctx *th.Context, message telego.Message) error {
 		return c.handleMessage(ctx, &message)
 	}, th.AnyMessage())
+	bh.HandleCallbackQuery(func(ctx *th.Context, query telego.CallbackQuery) error {
+		return c.handleCallbackQuery(ctx, &query)
+	}, th.AnyCallbackQueryWithMessage())
 
 	c.setRunning(true)
 	logger.InfoCF("telegram", "Telegram bot connected", map[string]interface{}{
@@ -125,6 +157,7 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 	})
 
 	go bh.Start()
+	go c.publishCommands(ctx)
 
 	go func() {
 		<-ctx.Done()
@@ -133,9 +166,93 @@ func (c *TelegramChannel) Start(ctx context.Context) error {
 
 	return nil
 }
+
 func (c *TelegramChannel) Stop(ctx context.Context) error {
 	logger.InfoC("telegram", "Stopping Telegram bot...")
 	c.setRunning(false)
+	if c.webhookMode() {
+		c.setWebhookHandler(nil)
+		if err := c.bot.DeleteWebhook(ctx, &telego.DeleteWebhookParams{}); err != nil {
+			logger.WarnCF("telegram", "Failed to delete webhook", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	return nil
+}
+
+func (c *TelegramChannel) webhookMode() bool {
+	return c.config.Channels.Telegram.WebhookURL != ""
+}
+
+// WebhookPath returns the path the gateway serves the webhook on, or ""
+// in polling mode or when webhook_url is unusable.
+func (c *TelegramChannel) WebhookPath() string {
+	if !c.webhookMode() {
+		return ""
+	}
+	path, err := c.webhookURLPath()
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// webhookURLPath checks that webhook_url has a path of its own, so the
+// webhook is not mounted on the gateway root next to other handlers.
+func (c *TelegramChannel) webhookURLPath() (string, error) {
+	u, err := url.Parse(c.config.Channels.Telegram.WebhookURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid telegram webhook_url: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		return "", fmt.Errorf("telegram webhook_url needs a path, e.g. https://bot.example.com/telegram/webhook")
+	}
+	return u.Path, nil
+}
+
+// WebhookHandler receives updates from Telegram in webhook mode. It stays
+// valid across restarts of the channel, so the gateway mounts it once.
+func (c *TelegramChannel) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get(telego.WebhookSecretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.webhookSecret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		c.webhookMu.RLock()
+		handler := c.webhook
+		c.webhookMu.RUnlock()
+		if handler == nil {
+			// Telegram retries until the channel is running again
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		data, err := io.ReadAll(io.LimitReader(r.Body, telegramMaxUpdateSize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Updates are handled after the request returns
+		if err := handler(context.WithoutCancel(r.Context()), data); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// setWebhookHandler is the registration callback for UpdatesViaWebhook.
+func (c *TelegramChannel) setWebhookHandler(handler telego.WebhookHandler) error {
+	c.webhookMu.Lock()
+	c.webhook = handler
+	c.webhookMu.Unlock()
 	return nil
 }
 
@@ -144,9 +261,9 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		return fmt.Errorf("telegram bot not running")
 	}
 
-	chatID, err := parseChatID(msg.ChatID)
+	chatID, threadID, err := parseChatID(msg.ChatID)
 	if err != nil {
-		return fmt.Errorf("invalid chat ID: %w", err)
+		return Permanent(fmt.Errorf("invalid chat ID: %w", err))
 	}
 
 	// Stop thinking animation
@@ -157,32 +274,110 @@ func (c *TelegramChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		c.stopThinking.Delete(msg.ChatID)
 	}
 
-	htmlContent := markdownToTelegramHTML(msg.Content)
+	chunks := RenderChunks(msg.Content, FormatTelegramHTML, telegramMessageLimit)
+	if len(chunks) == 0 {
+		return nil
+	}
 
-	// Try to edit placeholder
+	// The first chunk replaces the placeholder; buttons go below the last
+	start := 0
 	if pID, ok := c.placeholders.Load(msg.ChatID); ok {
 		c.placeholders.Delete(msg.ChatID)
-		editMsg := tu.EditMessageText(tu.ID(chatID), pID.(int), htmlContent)
+		editMsg := tu.EditMessageText(tu.ID(chatID), pID.(int), chunks[0])
 		editMsg.ParseMode = telego.ModeHTML
+		if len(chunks) == 1 {
+			editMsg.ReplyMarkup = telegramKeyboard(msg.Buttons)
+		}
 
 		if _, err = c.bot.EditMessageText(ctx, editMsg); err == nil {
-			return nil
+			start = 1
 		}
 		// Fallback to new message if edit fails
 	}
 
-	tgMsg := tu.Message(tu.ID(chatID), htmlContent)
-	tgMsg.ParseMode = telego.ModeHTML
+	for i := start; i < len(chunks); i++ {
+		tgMsg := tu.Message(tu.ID(chatID), chunks[i])
+		tgMsg.MessageThreadID = threadID
+		tgMsg.ParseMode = telego.ModeHTML
+		if i == len(chunks)-1 {
+			if keyboard := telegramKeyboard(msg.Buttons); keyboard != nil {
+				tgMsg.ReplyMarkup = keyboard
+			}
+		}
+
+		if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
+			logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
+				"error": err.Error(),
+			})
+			tgMsg.ParseMode = ""
+			if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
 
-	if _, err = c.bot.SendMessage(ctx, tgMsg); err != nil {
-		logger.ErrorCF("telegram", "HTML parse failed, falling back to plain text", map[string]interface{}{
+// RendersButtons reports that buttons are shown as an inline keyboard.
+func (c *TelegramChannel) RendersButtons() bool {
+	return true
+}
+
+func telegramKeyboard(buttons []bus.Button) *telego.InlineKeyboardMarkup {
+	if len(buttons) == 0 {
+		return nil
+	}
+	var rows [][]telego.InlineKeyboardButton
+	for _, row := range buttonRows(buttons, 3) {
+		var tgRow []telego.InlineKeyboardButton
+		for _, button := range row {
+			tgRow = append(tgRow, tu.InlineKeyboardButton(button.Label).WithCallbackData(button.Value))
+		}
+		rows = append(rows, tgRow)
+	}
+	return tu.InlineKeyboard(rows...)
+}
+
+// handleCallbackQuery passes an inline keyboard press to the agent and
+// removes the keyboard so the question is only answered once.
+func (c *TelegramChannel) handleCallbackQuery(ctx context.Context, query *telego.CallbackQuery) error {
+	senderID := fmt.Sprintf("%d", query.From.ID)
+	if query.From.Username != "" {
+		senderID = fmt.Sprintf("%d|%s", query.From.ID, query.From.Username)
+	}
+
+	if err := c.bot.AnswerCallbackQuery(ctx, tu.CallbackQuery(query.ID)); err != nil {
+		logger.WarnCF("telegram", "Failed to answer callback query", map[string]interface{}{
 			"error": err.Error(),
 		})
-		tgMsg.ParseMode = ""
-		_, err = c.bot.SendMessage(ctx, tgMsg)
-		return err
+	}
+	if !c.IsAllowed(senderID) || query.Data == "" {
+		return nil
 	}
 
+	chat := query.Message.GetChat()
+	messageID := query.Message.GetMessageID()
+	if _, err := c.bot.EditMessageReplyMarkup(ctx, &telego.EditMessageReplyMarkupParams{
+		ChatID:    tu.ID(chat.ID),
+		MessageID: messageID,
+	}); err != nil {
+		logger.DebugCF("telegram", "Failed to remove inline keyboard", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+
+	chatID := fmt.Sprintf("%d", chat.ID)
+	if message, ok := query.Message.(*telego.Message); ok {
+		chatID = telegramChatID(message)
+	}
+	c.HandleButton(senderID, chatID, query.Data, map[string]string{
+		"message_id": fmt.Sprintf("%d", messageID),
+		"user_id":    fmt.Sprintf("%d", query.From.ID),
+		"username":   query.From.Username,
+		"first_name": query.From.FirstName,
+		"is_group":   fmt.Sprintf("%t", chat.Type != "private"),
+	})
 	return nil
 }
 
@@ -206,6 +401,7 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 		logger.DebugCF("telegram", "Message rejected by allowlist", map[string]interface{}{
 			"user_id": senderID,
 		})
+		c.requestPairing(senderID, fmt.Sprintf("%d", message.Chat.ID), message.Chat.Type != "private")
 		return nil
 	}
 
@@ -317,12 +513,56 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 
 	logger.DebugCF("telegram", "Received message", map[string]interface{}{
 		"sender_id": senderID,
-		"chat_id":   fmt.Sprintf("%d", chatID),
+		"chat_id":   telegramChatID(message),
 		"preview":   utils.Truncate(content, 50),
 	})
 
+	chatIDStr := telegramChatID(message)
+	isGroup := message.Chat.Type != "private"
+	metadata := map[string]string{
+		"message_id": fmt.Sprintf("%d", message.MessageID),
+		"user_id":    fmt.Sprintf("%d", user.ID),
+		"username":   user.Username,
+		"first_name": user.FirstName,
+		"is_group":   fmt.Sprintf("%t", isGroup),
+	}
+	if message.IsTopicMessage {
+		metadata["thread_id"] = fmt.Sprintf("%d", message.MessageThreadID)
+	}
+	if isGroup {
+		mention := "@" + c.bot.Username()
+		if strings.Contains(content, mention) {
+			metadata[MetaIsMentioned] = "true"
+			content = strings.TrimSpace(strings.ReplaceAll(content, mention, ""))
+		}
+	}
+	// Every message in a forum topic replies to the topic's first message
+	if reply := message.ReplyToMessage; reply != nil && reply.ForumTopicCreated == nil {
+		if reply.From != nil && reply.From.ID == c.bot.ID() {
+			metadata[MetaIsReplyToBot] = "true"
+		}
+		replyText, replyMedia := c.replyContent(ctx, reply)
+		localFiles = append(localFiles, replyMedia...)
+		mediaPaths = append(mediaPaths, replyMedia...)
+		if message.Quote != nil && message.Quote.Text != "" {
+			// The user picked the part they mean
+			replyText = message.Quote.Text
+		}
+		setReplyContext(metadata, fmt.Sprintf("%d", reply.MessageID), telegramAuthor(reply), replyText)
+	}
+
+	if !c.ShouldReply(fmt.Sprintf("%d", user.ID), chatIDStr, content, metadata) {
+		// Kept as context or ignored; no thinking indicator
+		c.HandleMessage(fmt.Sprintf("%d", user.ID), chatIDStr, content, mediaPaths, metadata)
+		return nil
+	}
+
 	// Thinking indicator
-	err := c.bot.SendChatAction(ctx, tu.ChatAction(tu.ID(chatID), telego.ChatActionTyping))
+	action := tu.ChatAction(tu.ID(chatID), telego.ChatActionTyping)
+	if message.IsTopicMessage {
+		action.MessageThreadID = message.MessageThreadID
+	}
+	err := c.bot.SendChatAction(ctx, action)
 	if err != nil {
 		logger.ErrorCF("telegram", "Failed to send chat action", map[string]interface{}{
 			"error": err.Error(),
@@ -330,7 +570,6 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 	}
 
 	// Stop any previous thinking animation
-	chatIDStr := fmt.Sprintf("%d", chatID)
 	if prevStop, ok := c.stopThinking.Load(chatIDStr); ok {
 		if cf, ok := prevStop.(*thinkingCancel); ok && cf != nil {
 			cf.Cancel()
@@ -341,21 +580,17 @@ func (c *TelegramChannel) handleMessage(ctx context.Context, message *telego.Mes
 	_, thinkCancel := context.WithTimeout(ctx, 5*time.Minute)
 	c.stopThinking.Store(chatIDStr, &thinkingCancel{fn: thinkCancel})
 
-	pMsg, err := c.bot.SendMessage(ctx, tu.Message(tu.ID(chatID), "Thinking... 💭"))
+	placeholder := tu.Message(tu.ID(chatID), "Thinking... 💭")
+	if message.IsTopicMessage {
+		placeholder.MessageThreadID = message.MessageThreadID
+	}
+	pMsg, err := c.bot.SendMessage(ctx, placeholder)
 	if err == nil {
 		pID := pMsg.MessageID
 		c.placeholders.Store(chatIDStr, pID)
 	}
 
-	metadata := map[string]string{
-		"message_id": fmt.Sprintf("%d", message.MessageID),
-		"user_id":    fmt.Sprintf("%d", user.ID),
-		"username":   user.Username,
-		"first_name": user.FirstName,
-		"is_group":   fmt.Sprintf("%t", message.Chat.Type != "private"),
-	}
-
-	c.HandleMessage(fmt.Sprintf("%d", user.ID), fmt.Sprintf("%d", chatID), content, mediaPaths, metadata)
+	c.HandleMessage(fmt.Sprintf("%d", user.ID), chatIDStr, content, mediaPaths, metadata)
 	return nil
 }
 
@@ -398,112 +633,92 @@ func (c *TelegramChannel) downloadFile(ctx context.Context, fileID, ext string)
 	return c.downloadFileWithInfo(file, ext)
 }
 
-func parseChatID(chatIDStr string) (int64, error) {
-	var id int64
-	_, err := fmt.Sscanf(chatIDStr, "%d", &id)
-	return id, err
-}
-
-func markdownToTelegramHTML(text string) string {
-	if text == "" {
-		return ""
+// replyContent returns the text of a message being replied to, and
+// downloads its photo or document.
+func (c *TelegramChannel) replyContent(ctx context.Context, reply *telego.Message) (string, []string) {
+	text := reply.Text
+	if reply.Caption != "" {
+		text = appendContent(text, reply.Caption)
 	}
 
-	codeBlocks := extractCodeBlocks(text)
-	text = codeBlocks.text
-
-	inlineCodes := extractInlineCodes(text)
-	text = inlineCodes.text
-
-	text = regexp.MustCompile(`^#{1,6}\s+(.+)$`).ReplaceAllString(text, "$1")
-
-	text = regexp.MustCompile(`^>\s*(.*)$`).ReplaceAllString(text, "$1")
-
-	text = escapeHTML(text)
-
-	text = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`).ReplaceAllString(text, `<a href="$2">$1</a>`)
-
-	text = regexp.MustCompile(`\*\*(.+?)\*\*`).ReplaceAllString(text, "<b>$1</b>")
-
-	text = regexp.MustCompile(`__(.+?)__`).ReplaceAllString(text, "<b>$1</b>")
-
-	reItalic := regexp.MustCompile(`_([^_]+)_`)
-	text = reItalic.ReplaceAllStringFunc(text, func(s string) string {
-		match := reItalic.FindStringSubmatch(s)
-		if len(match) < 2 {
-			return s
+	var media []string
+	if len(reply.Photo) > 0 {
+		if path := c.downloadPhoto(ctx, reply.Photo[len(reply.Photo)-1].FileID); path != "" {
+			media = append(media, path)
+			text = appendContent(text, "[image: photo]")
 		}
-		return "<i>" + match[1] + "</i>"
-	})
-
-	text = regexp.MustCompile(`~~(.+?)~~`).ReplaceAllString(text, "<s>$1</s>")
-
-	text = regexp.MustCompile(`^[-*]\s+`).ReplaceAllString(text, "• ")
-
-	for i, code := range inlineCodes.codes {
-		escaped := escapeHTML(code)
-		text = strings.ReplaceAll(text, fmt.Sprintf("\x00IC%d\x00", i), fmt.Sprintf("<code>%s</code>", escaped))
 	}
-
-	for i, code := range codeBlocks.codes {
-		escaped := escapeHTML(code)
-		text = strings.ReplaceAll(text, fmt.Sprintf("\x00CB%d\x00", i), fmt.Sprintf("<pre><code>%s</code></pre>", escaped))
+	if reply.Document != nil {
+		if path := c.downloadFile(ctx, reply.Document.FileID, ""); path != "" {
+			media = append(media, path)
+			text = appendContent(text, "[file]")
+		}
 	}
-
-	return text
-}
-
-type codeBlockMatch struct {
-	text  string
-	codes []string
+	return text, media
 }
 
-func extractCodeBlocks(text string) codeBlockMatch {
-	re := regexp.MustCompile("```[\\w]*\\n?([\\s\\S]*?)```")
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	codes := make([]string, 0, len(matches))
-	for _, match := range matches {
-		codes = append(codes, match[1])
+// telegramAuthor names the sender of a message, and where it was forwarded
+// from.
+func telegramAuthor(message *telego.Message) string {
+	var author string
+	if message.From != nil {
+		author = message.From.FirstName
+		if author == "" {
+			author = message.From.Username
+		}
 	}
 
-	i := 0
-	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		placeholder := fmt.Sprintf("\x00CB%d\x00", i)
-		i++
-		return placeholder
-	})
-
-	return codeBlockMatch{text: text, codes: codes}
+	var origin string
+	switch o := message.ForwardOrigin.(type) {
+	case *telego.MessageOriginUser:
+		origin = o.SenderUser.FirstName
+	case *telego.MessageOriginHiddenUser:
+		origin = o.SenderUserName
+	case *telego.MessageOriginChat:
+		origin = o.SenderChat.Title
+	case *telego.MessageOriginChannel:
+		origin = o.Chat.Title
+	}
+	if origin == "" {
+		return author
+	}
+	if author == "" {
+		return origin
+	}
+	return fmt.Sprintf("%s (forwarded from %s)", author, origin)
 }
 
-type inlineCodeMatch struct {
-	text  string
-	codes []string
+// parseChatID splits "chatID" or "chatID/threadID" into its parts.
+func parseChatID(chatIDStr string) (int64, int, error) {
+	chatPart, threadPart, hasThread := strings.Cut(chatIDStr, "/")
+	id, err := strconv.ParseInt(chatPart, 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !hasThread {
+		return id, 0, nil
+	}
+	threadID, err := strconv.Atoi(threadPart)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, threadID, nil
 }
 
-func extractInlineCodes(text string) inlineCodeMatch {
-	re := regexp.MustCompile("`([^`]+)`")
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	codes := make([]string, 0, len(matches))
-	for _, match := range matches {
-		codes = append(codes, match[1])
+// telegramChatID returns the chat ID for a message: the chat, with the
+// topic appended for messages in a forum topic so each topic has its own
+// session.
+func telegramChatID(message *telego.Message) string {
+	if message.IsTopicMessage && message.MessageThreadID != 0 {
+		return fmt.Sprintf("%d/%d", message.Chat.ID, message.MessageThreadID)
 	}
-
-	i := 0
-	text = re.ReplaceAllStringFunc(text, func(m string) string {
-		placeholder := fmt.Sprintf("\x00IC%d\x00", i)
-		i++
-		return placeholder
-	})
-
-	return inlineCodeMatch{text: text, codes: codes}
+	return fmt.Sprintf("%d", message.Chat.ID)
 }
 
-func escapeHTML(text string) string {
-	text = strings.ReplaceAll(text, "&", "&amp;")
-	text = strings.ReplaceAll(text, "<", "&lt;")
-	text = strings.ReplaceAll(text, ">", "&gt;")
-	return text
+func randomWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
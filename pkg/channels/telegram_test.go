@@ -0,0 +1,109 @@
+package channels
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mymmrac/telego"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestTelegramChatIDs(t *testing.T) {
+	topic := &telego.Message{Chat: telego.Chat{ID: -100123}, IsTopicMessage: true, MessageThreadID: 42}
+	if got := telegramChatID(topic); got != "-100123/42" {
+		t.Errorf("topic chat ID = %q", got)
+	}
+	// Replies in groups without topics carry a thread ID too
+	reply := &telego.Message{Chat: telego.Chat{ID: -100123}, MessageThreadID: 7}
+	if got := telegramChatID(reply); got != "-100123" {
+		t.Errorf("plain chat ID = %q", got)
+	}
+
+	chatID, threadID, err := parseChatID("-100123/42")
+	if err != nil || chatID != -100123 || threadID != 42 {
+		t.Errorf("parseChatID = %d, %d, %v", chatID, threadID, err)
+	}
+	if chatID, threadID, err := parseChatID("555"); err != nil || chatID != 555 || threadID != 0 {
+		t.Errorf("parseChatID(555) = %d, %d, %v", chatID, threadID, err)
+	}
+	if _, _, err := parseChatID("555/x"); err == nil {
+		t.Error("bad thread ID accepted")
+	}
+}
+
+func TestTelegramWebhookHandler(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Channels.Telegram = config.TelegramConfig{
+		Token:         "123456:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghi",
+		WebhookURL:    "https://bot.example.com/hooks/telegram",
+		WebhookSecret: "s3cret",
+	}
+	ch, err := NewTelegramChannel(cfg, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := ch.WebhookPath(); path != "/hooks/telegram" {
+		t.Errorf("WebhookPath = %q", path)
+	}
+
+	post := func(secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/hooks/telegram", strings.NewReader(`{"update_id":1}`))
+		req.Header.Set(telego.WebhookSecretTokenHeader, secret)
+		rec := httptest.NewRecorder()
+		ch.WebhookHandler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post("s3cret"); code != http.StatusServiceUnavailable {
+		t.Errorf("before start = %d", code)
+	}
+
+	var received string
+	ch.setWebhookHandler(func(ctx context.Context, data []byte) error {
+		received = string(data)
+		return nil
+	})
+	if code := post("wrong"); code != http.StatusUnauthorized || received != "" {
+		t.Errorf("wrong secret = %d, received %q", code, received)
+	}
+	if code := post("s3cret"); code != http.StatusOK || received != `{"update_id":1}` {
+		t.Errorf("valid request = %d, received %q", code, received)
+	}
+}
+
+func TestTelegramWebhookNeedsPath(t *testing.T) {
+	for _, webhookURL := range []string{"https://bot.example.com", "https://bot.example.com/", "://bad"} {
+		cfg := &config.Config{}
+		cfg.Channels.Telegram = config.TelegramConfig{
+			Token:      "123456:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghi",
+			WebhookURL: webhookURL,
+		}
+		ch, err := NewTelegramChannel(cfg, bus.NewMessageBus())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := ch.WebhookPath(); path != "" {
+			t.Errorf("%q: WebhookPath = %q, want none", webhookURL, path)
+		}
+		if err := ch.Start(context.Background()); err == nil {
+			t.Errorf("%q: Start accepted a webhook_url without a path", webhookURL)
+		}
+	}
+}
+
+func TestTelegramPollingHasNoWebhook(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Channels.Telegram.Token = "123456:ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghi"
+	ch, err := NewTelegramChannel(cfg, bus.NewMessageBus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch.WebhookPath() != "" || len(ch.webhookSecret) != 64 {
+		t.Errorf("path %q, secret %q", ch.WebhookPath(), ch.webhookSecret)
+	}
+}
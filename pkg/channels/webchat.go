@@ -0,0 +1,507 @@
+package channels
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+//go:embed webchat
+var webchatAssets embed.FS
+
+const (
+	webchatCookie      = "picoclaw_webchat"
+	webchatSenderID    = "webchat"
+	webchatMaxEvents   = 500
+	webchatTitleLength = 40
+	webchatPingPeriod  = 30 * time.Second
+	webchatPongWait    = 75 * time.Second
+	webchatWriteWait   = 10 * time.Second
+	webchatLoginTTL    = 30 * 24 * time.Hour
+)
+
+// WebChatChannel serves a small single-page chat UI on the gateway port.
+// The browser talks to it over a WebSocket; every session is a chat ID,
+// and replies, tool calls and typing state are pushed to all open tabs.
+//
+// Sessions and logins live in memory, so after a restart the list starts
+// empty and the browser signs in again, while the agent keeps each
+// session's history as usual. Replies arrive whole once the agent has
+// finished; tool calls and messages sent mid-turn show up as they happen.
+type WebChatChannel struct {
+	*BaseChannel
+	config   config.WebChatConfig
+	upgrader websocket.Upgrader
+
+	mu       sync.Mutex
+	clients  map[*webchatClient]struct{}
+	sessions map[string]*webchatSession
+	// logins maps random cookie values to their expiry
+	logins map[string]time.Time
+	// files maps download IDs to media the agent sent
+	files map[string]string
+}
+
+type webchatClient struct {
+	conn    *websocket.Conn
+	login   string
+	writeMu sync.Mutex
+}
+
+type webchatSession struct {
+	ID      string         `json:"id"`
+	Title   string         `json:"title"`
+	Updated time.Time      `json:"updated"`
+	Events  []webchatEvent `json:"-"`
+}
+
+// webchatEvent is pushed to the browser and kept in the session transcript.
+type webchatEvent struct {
+	Type    string                 `json:"type"` // user, assistant, tool_start, tool_result, typing
+	Session string                 `json:"session"`
+	Content string                 `json:"content,omitempty"`
+	Files   []webchatFile          `json:"files,omitempty"`
+	Tool    string                 `json:"tool,omitempty"`
+	Args    map[string]interface{} `json:"args,omitempty"`
+	IsError bool                   `json:"is_error,omitempty"`
+	Time    time.Time              `json:"time"`
+}
+
+type webchatFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+}
+
+// webchatRequest is sent by the browser.
+type webchatRequest struct {
+	Type    string `json:"type"`
+	Session string `json:"session"`
+	Content string `json:"content"`
+	Files   []struct {
+		Name string `json:"name"`
+		Data string `json:"data"` // base64
+	} `json:"files"`
+}
+
+// NewWebChatChannel creates the web chat channel. A token is required
+// because the chat can run tools on the host.
+func NewWebChatChannel(cfg config.WebChatConfig, messageBus *bus.MessageBus) (*WebChatChannel, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("webchat token is required")
+	}
+	if cfg.MaxUploadMB <= 0 {
+		cfg.MaxUploadMB = 20
+	}
+
+	base := NewBaseChannel("webchat", cfg, messageBus, nil)
+
+	return &WebChatChannel{
+		BaseChannel: base,
+		config:      cfg,
+		clients:     make(map[*webchatClient]struct{}),
+		sessions:    make(map[string]*webchatSession),
+		logins:      make(map[string]time.Time),
+		files:       make(map[string]string),
+	}, nil
+}
+
+// Start marks the channel running. Its handler is served by the gateway's
+// HTTP server, see Handler.
+func (c *WebChatChannel) Start(ctx context.Context) error {
+	c.setRunning(true)
+	logger.InfoC("webchat", "Web chat channel started")
+	return nil
+}
+
+func (c *WebChatChannel) Stop(ctx context.Context) error {
+	c.setRunning(false)
+
+	c.mu.Lock()
+	for client := range c.clients {
+		client.conn.Close()
+	}
+	c.mu.Unlock()
+
+	logger.InfoC("webchat", "Web chat channel stopped")
+	return nil
+}
+
+// Send pushes a reply to the browser. Media paths are offered as downloads.
+func (c *WebChatChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("webchat channel not running")
+	}
+
+	event := webchatEvent{Type: "assistant", Session: msg.ChatID, Content: msg.Content}
+	if len(msg.Media) > 0 {
+		c.mu.Lock()
+		for _, path := range msg.Media {
+			id := uuid.New().String()
+			c.files[id] = path
+			event.Files = append(event.Files, webchatFile{
+				Name: filepath.Base(path),
+				URL:  "/chat/api/files/" + id,
+			})
+		}
+		c.mu.Unlock()
+	}
+	c.publish(event)
+	return nil
+}
+
+// ToolStarted shows a tool call in the chat while it runs.
+func (c *WebChatChannel) ToolStarted(chatID, tool string, args map[string]interface{}) {
+	c.publish(webchatEvent{Type: "tool_start", Session: chatID, Tool: tool, Args: args})
+}
+
+// ToolFinished shows the result the agent got back from a tool.
+func (c *WebChatChannel) ToolFinished(chatID, tool, result string, isError bool) {
+	c.publish(webchatEvent{
+		Type:    "tool_result",
+		Session: chatID,
+		Tool:    tool,
+		Content: utils.Truncate(result, 2000),
+		IsError: isError,
+	})
+}
+
+// publish records an event in its session and sends it to every client.
+// Typing events are not recorded.
+func (c *WebChatChannel) publish(event webchatEvent) {
+	event.Time = time.Now()
+
+	c.mu.Lock()
+	if event.Type != "typing" {
+		session := c.sessionLocked(event.Session)
+		if session.Title == "" && event.Type == "user" {
+			session.Title = utils.Truncate(event.Content, webchatTitleLength)
+		}
+		session.Updated = event.Time
+		session.Events = append(session.Events, event)
+		if len(session.Events) > webchatMaxEvents {
+			session.Events = session.Events[len(session.Events)-webchatMaxEvents:]
+		}
+	}
+	clients := make([]*webchatClient, 0, len(c.clients))
+	for client := range c.clients {
+		clients = append(clients, client)
+	}
+	c.mu.Unlock()
+
+	for _, client := range clients {
+		if err := client.write(event); err != nil {
+			logger.DebugCF("webchat", "Failed to push event", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+}
+
+func (c *WebChatChannel) sessionLocked(id string) *webchatSession {
+	session, ok := c.sessions[id]
+	if !ok {
+		session = &webchatSession{ID: id}
+		c.sessions[id] = session
+	}
+	return session
+}
+
+func (client *webchatClient) write(v interface{}) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+	client.conn.SetWriteDeadline(time.Now().Add(webchatWriteWait))
+	return client.conn.WriteJSON(v)
+}
+
+// Handler returns the UI, the login endpoint, the session API and the
+// WebSocket, all under /chat/.
+func (c *WebChatChannel) Handler() http.Handler {
+	assets, _ := fs.Sub(webchatAssets, "webchat")
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /chat/", http.StripPrefix("/chat/", http.FileServerFS(assets)))
+	mux.HandleFunc("POST /chat/api/login", c.handleLogin)
+	mux.HandleFunc("POST /chat/api/logout", c.handleLogout)
+	mux.Handle("GET /chat/api/sessions", c.authenticate(http.HandlerFunc(c.handleSessions)))
+	mux.Handle("GET /chat/api/sessions/{id}", c.authenticate(http.HandlerFunc(c.handleSessionEvents)))
+	mux.Handle("GET /chat/api/files/{id}", c.authenticate(http.HandlerFunc(c.handleFile)))
+	mux.Handle("GET /chat/ws", c.authenticate(http.HandlerFunc(c.handleWebSocket)))
+	return mux
+}
+
+// newLogin issues a random login ID for the cookie. Expired logins are
+// dropped on the way.
+func (c *WebChatChannel) newLogin() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	now := time.Now()
+	c.mu.Lock()
+	for login, expires := range c.logins {
+		if now.After(expires) {
+			delete(c.logins, login)
+		}
+	}
+	c.logins[id] = now.Add(webchatLoginTTL)
+	c.mu.Unlock()
+	return id, nil
+}
+
+// loginFor returns the request's login ID if it is still valid.
+func (c *WebChatChannel) loginFor(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(webchatCookie)
+	if err != nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.logins[cookie.Value]
+	if !ok || time.Now().After(expires) {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+func (c *WebChatChannel) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := c.loginFor(r); !ok {
+			writeHTTPError(w, http.StatusUnauthorized, "login required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *WebChatChannel) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(c.config.Token)) != 1 {
+		logger.WarnCF("webchat", "Rejected login", map[string]interface{}{
+			"remote": r.RemoteAddr,
+		})
+		writeHTTPError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	login, err := c.newLogin()
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, "could not start session")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     webchatCookie,
+		Value:    login,
+		Path:     "/chat/",
+		MaxAge:   int(webchatLoginTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Secure:   r.TLS != nil,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLogout revokes the login and closes its open sockets.
+func (c *WebChatChannel) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if login, ok := c.loginFor(r); ok {
+		c.mu.Lock()
+		delete(c.logins, login)
+		for client := range c.clients {
+			if client.login == login {
+				client.conn.Close()
+			}
+		}
+		c.mu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: webchatCookie, Path: "/chat/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessions lists sessions, most recently active first.
+func (c *WebChatChannel) handleSessions(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	list := make([]webchatSession, 0, len(c.sessions))
+	for _, session := range c.sessions {
+		list = append(list, *session)
+	}
+	c.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Updated.After(list[j].Updated) })
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"sessions": list})
+}
+
+func (c *WebChatChannel) handleSessionEvents(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	var events []webchatEvent
+	if session, ok := c.sessions[r.PathValue("id")]; ok {
+		events = append(events, session.Events...)
+	}
+	c.mu.Unlock()
+
+	writeHTTPJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+}
+
+func (c *WebChatChannel) handleFile(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	path, ok := c.files[r.PathValue("id")]
+	c.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
+
+func (c *WebChatChannel) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	login, _ := c.loginFor(r)
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	// Uploads arrive base64-encoded inside the message frame
+	conn.SetReadLimit(int64(c.config.MaxUploadMB)<<20*4/3 + 64<<10)
+
+	client := &webchatClient{conn: conn, login: login}
+	c.mu.Lock()
+	c.clients[client] = struct{}{}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.clients, client)
+		c.mu.Unlock()
+		conn.Close()
+	}()
+
+	// The gateway's read timeout still applies to the hijacked connection,
+	// so keep it alive with pings instead.
+	conn.SetReadDeadline(time.Now().Add(webchatPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(webchatPongWait))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(webchatPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				client.writeMu.Lock()
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webchatWriteWait))
+				client.writeMu.Unlock()
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var req webchatRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.DebugCF("webchat", "WebSocket closed", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(webchatPongWait))
+
+		if req.Type != "message" {
+			continue
+		}
+		if err := c.handleUserMessage(req); err != nil {
+			client.write(map[string]string{"type": "error", "session": req.Session, "content": err.Error()})
+		}
+	}
+}
+
+// handleUserMessage saves uploads, echoes the message to every tab and
+// hands it to the agent. A message without a session starts a new one.
+func (c *WebChatChannel) handleUserMessage(req webchatRequest) error {
+	if req.Session == "" {
+		req.Session = uuid.New().String()[:8]
+	}
+	if !httpSessionIDPattern.MatchString(req.Session) {
+		return fmt.Errorf("invalid session ID")
+	}
+	if req.Content == "" && len(req.Files) == 0 {
+		return fmt.Errorf("message is empty")
+	}
+
+	var media []string
+	var files []webchatFile
+	for _, f := range req.Files {
+		data, err := base64.StdEncoding.DecodeString(f.Data)
+		if err != nil {
+			return fmt.Errorf("invalid upload %q", f.Name)
+		}
+		path, err := saveWebChatUpload(f.Name, data)
+		if err != nil {
+			logger.ErrorCF("webchat", "Failed to save upload", map[string]interface{}{
+				"error": err.Error(),
+			})
+			return fmt.Errorf("could not save %q", f.Name)
+		}
+		media = append(media, path)
+		files = append(files, webchatFile{Name: f.Name})
+	}
+
+	c.publish(webchatEvent{Type: "user", Session: req.Session, Content: req.Content, Files: files})
+	c.publish(webchatEvent{Type: "typing", Session: req.Session})
+
+	content := req.Content
+	for _, f := range files {
+		if content != "" {
+			content += "\n"
+		}
+		content += fmt.Sprintf("[file: %s]", f.Name)
+	}
+
+	c.HandleMessage(webchatSenderID, req.Session, content, media, nil)
+	return nil
+}
+
+func saveWebChatUpload(name string, data []byte) (string, error) {
+	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(mediaDir, 0700); err != nil {
+		return "", err
+	}
+	localPath := filepath.Join(mediaDir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(name))
+	if err := os.WriteFile(localPath, data, 0600); err != nil {
+		return "", err
+	}
+	return localPath, nil
+}
@@ -0,0 +1,255 @@
+// picoclaw web chat. Plain JavaScript, no external dependencies.
+(function () {
+  "use strict";
+
+  const $ = (id) => document.getElementById(id);
+  const log = $("log");
+  const input = $("input");
+  const fileInput = $("files");
+
+  let socket = null;
+  let current = "";      // active session ID, "" for a new chat
+  let pending = [];      // files waiting to be sent
+  let retryDelay = 1000;
+
+  async function api(path, options) {
+    const resp = await fetch("api/" + path, Object.assign({ credentials: "same-origin" }, options));
+    if (resp.status === 401) {
+      showLogin();
+      throw new Error("login required");
+    }
+    if (!resp.ok) throw new Error("request failed: " + resp.status);
+    return resp.status === 204 ? null : resp.json();
+  }
+
+  function showLogin() {
+    if (socket) socket.onclose = null, socket.close();
+    socket = null;
+    $("app").hidden = true;
+    $("login").hidden = false;
+    $("token").focus();
+  }
+
+  async function showApp() {
+    $("login").hidden = true;
+    $("app").hidden = false;
+    connect();
+    await loadSessions();
+    input.focus();
+  }
+
+  $("login").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const resp = await fetch("api/login", {
+      method: "POST",
+      credentials: "same-origin",
+      body: JSON.stringify({ token: $("token").value }),
+    });
+    if (resp.ok) {
+      $("token").value = "";
+      $("login-error").textContent = "";
+      showApp();
+    } else {
+      $("login-error").textContent = "Invalid token";
+    }
+  });
+
+  $("logout").addEventListener("click", async () => {
+    await fetch("api/logout", { method: "POST", credentials: "same-origin" });
+    showLogin();
+  });
+
+  // --- WebSocket ---
+
+  function connect() {
+    const proto = location.protocol === "https:" ? "wss:" : "ws:";
+    socket = new WebSocket(proto + "//" + location.host + location.pathname.replace(/[^/]*$/, "") + "ws");
+    socket.onopen = () => {
+      retryDelay = 1000;
+      $("status").textContent = "";
+    };
+    socket.onmessage = (e) => handleEvent(JSON.parse(e.data));
+    socket.onclose = () => {
+      $("status").textContent = "Disconnected, reconnecting…";
+      setTimeout(async () => {
+        try {
+          await api("sessions"); // shows the login form if the cookie expired
+          connect();
+        } catch (err) {
+          if (!$("app").hidden) socket.onclose();
+        }
+      }, retryDelay);
+      retryDelay = Math.min(retryDelay * 2, 30000);
+    };
+  }
+
+  function handleEvent(ev) {
+    if (ev.type === "user" && current === "" && ev.session) {
+      // The server assigned an ID to the chat we just started
+      current = ev.session;
+    }
+    if (ev.type !== "typing" && ev.type !== "error") loadSessions();
+    if (ev.session !== current) return;
+
+    if (ev.type === "typing") {
+      $("typing").hidden = false;
+      return;
+    }
+    if (ev.type === "assistant" || ev.type === "error") $("typing").hidden = true;
+    render(ev);
+  }
+
+  // --- Rendering ---
+
+  function render(ev) {
+    const atBottom = log.scrollHeight - log.scrollTop - log.clientHeight < 40;
+
+    switch (ev.type) {
+      case "user":
+      case "assistant":
+      case "error":
+        log.appendChild(messageNode(ev));
+        break;
+      case "tool_start":
+        log.appendChild(toolNode(ev));
+        break;
+      case "tool_result":
+        finishTool(ev);
+        break;
+    }
+
+    if (atBottom) log.scrollTop = log.scrollHeight;
+  }
+
+  function messageNode(ev) {
+    const div = document.createElement("div");
+    div.className = "msg " + ev.type;
+    div.textContent = ev.content || "";
+    for (const f of ev.files || []) {
+      if (f.url && /\.(png|jpe?g|gif|webp)$/i.test(f.name)) {
+        const img = document.createElement("img");
+        img.src = f.url;
+        img.alt = f.name;
+        div.appendChild(img);
+      }
+      const el = document.createElement(f.url ? "a" : "span");
+      el.className = "file";
+      el.textContent = "📎 " + f.name;
+      if (f.url) {
+        el.href = f.url;
+        el.target = "_blank";
+      }
+      div.appendChild(el);
+    }
+    return div;
+  }
+
+  function toolNode(ev) {
+    const details = document.createElement("details");
+    details.className = "tool running";
+    details.dataset.tool = ev.tool;
+    const summary = document.createElement("summary");
+    summary.textContent = "🔧 " + ev.tool;
+    const args = document.createElement("pre");
+    args.textContent = JSON.stringify(ev.args || {}, null, 2);
+    details.append(summary, args);
+    return details;
+  }
+
+  function finishTool(ev) {
+    let node = null;
+    for (const el of log.querySelectorAll(".tool.running")) {
+      if (el.dataset.tool === ev.tool) node = el;
+    }
+    if (!node) {
+      node = toolNode(ev);
+      log.appendChild(node);
+    }
+    node.classList.remove("running");
+    if (ev.is_error) node.classList.add("failed");
+    const result = document.createElement("pre");
+    result.textContent = ev.content || "(no output)";
+    node.appendChild(result);
+    // A tool result means the agent is still working
+    $("typing").hidden = false;
+  }
+
+  // --- Sessions ---
+
+  async function loadSessions() {
+    const data = await api("sessions");
+    const list = $("sessions");
+    list.textContent = "";
+    for (const s of data.sessions) {
+      const li = document.createElement("li");
+      li.textContent = s.title || s.id;
+      li.title = new Date(s.updated).toLocaleString();
+      if (s.id === current) li.className = "active";
+      li.onclick = () => openSession(s.id);
+      list.appendChild(li);
+    }
+  }
+
+  async function openSession(id) {
+    current = id;
+    log.textContent = "";
+    $("typing").hidden = true;
+    if (id) {
+      const data = await api("sessions/" + encodeURIComponent(id));
+      for (const ev of data.events || []) render(ev);
+      log.scrollTop = log.scrollHeight;
+    }
+    loadSessions();
+    input.focus();
+  }
+
+  $("new-chat").addEventListener("click", () => openSession(""));
+
+  // --- Composer ---
+
+  fileInput.addEventListener("change", async () => {
+    for (const file of fileInput.files) {
+      pending.push({ name: file.name, data: await readBase64(file) });
+    }
+    fileInput.value = "";
+    $("attachments").textContent = pending.map((f) => "📎 " + f.name).join("  ");
+  });
+
+  function readBase64(file) {
+    return new Promise((resolve, reject) => {
+      const reader = new FileReader();
+      reader.onload = () => resolve(reader.result.split(",", 2)[1] || "");
+      reader.onerror = () => reject(reader.error);
+      reader.readAsDataURL(file);
+    });
+  }
+
+  $("composer").addEventListener("submit", (e) => {
+    e.preventDefault();
+    const content = input.value.trim();
+    if (!content && pending.length === 0) return;
+    if (!socket || socket.readyState !== WebSocket.OPEN) {
+      $("status").textContent = "Not connected";
+      return;
+    }
+    socket.send(JSON.stringify({ type: "message", session: current, content: content, files: pending }));
+    input.value = "";
+    input.style.height = "";
+    pending = [];
+    $("attachments").textContent = "";
+  });
+
+  input.addEventListener("keydown", (e) => {
+    if (e.key === "Enter" && !e.shiftKey && !e.isComposing) {
+      e.preventDefault();
+      $("composer").requestSubmit();
+    }
+  });
+
+  input.addEventListener("input", () => {
+    input.style.height = "";
+    input.style.height = input.scrollHeight + "px";
+  });
+
+  api("sessions").then(showApp, () => {});
+})();
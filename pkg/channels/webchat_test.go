@@ -0,0 +1,201 @@
+package channels
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newTestWebChat(t *testing.T) (*WebChatChannel, *bus.MessageBus, *httptest.Server) {
+	t.Helper()
+	msgBus := bus.NewMessageBus()
+	ch, err := NewWebChatChannel(config.WebChatConfig{Token: "secret"}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.Start(context.Background())
+	server := httptest.NewServer(ch.Handler())
+	t.Cleanup(server.Close)
+	return ch, msgBus, server
+}
+
+func webchatLogin(t *testing.T, server *httptest.Server, token string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(server.URL+"/chat/api/login", "application/json", strings.NewReader(`{"token":"`+token+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestWebChat_ServesUIAndRequiresLogin(t *testing.T) {
+	_, _, server := newTestWebChat(t)
+
+	resp, err := http.Get(server.URL + "/chat/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `<script src="app.js">`) {
+		t.Fatalf("index: status %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/chat/api/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("sessions without login: status %d", resp.StatusCode)
+	}
+
+	if resp := webchatLogin(t, server, "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad login: status %d", resp.StatusCode)
+	}
+}
+
+func TestWebChat_Conversation(t *testing.T) {
+	ch, msgBus, server := newTestWebChat(t)
+
+	resp := webchatLogin(t, server, "secret")
+	if resp.StatusCode != http.StatusNoContent || len(resp.Cookies()) != 1 {
+		t.Fatalf("login: status %d, cookies %v", resp.StatusCode, resp.Cookies())
+	}
+	cookie := resp.Cookies()[0]
+
+	header := http.Header{"Cookie": {cookie.String()}, "Origin": {server.URL}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/chat/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	readEvent := func() webchatEvent {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var ev webchatEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	conn.WriteJSON(map[string]interface{}{
+		"type":    "message",
+		"content": "what is in this file?",
+		"files":   []map[string]string{{"name": "notes.txt", "data": base64.StdEncoding.EncodeToString([]byte("hello"))}},
+	})
+
+	user := readEvent()
+	if user.Type != "user" || user.Session == "" || len(user.Files) != 1 || user.Files[0].Name != "notes.txt" {
+		t.Fatalf("user event = %+v", user)
+	}
+	if typing := readEvent(); typing.Type != "typing" {
+		t.Errorf("expected typing, got %+v", typing)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok {
+		t.Fatal("no inbound message")
+	}
+	if msg.ChatID != user.Session || msg.Content != "what is in this file?\n[file: notes.txt]" || len(msg.Media) != 1 {
+		t.Fatalf("inbound = %+v", msg)
+	}
+	defer os.Remove(msg.Media[0])
+	if data, _ := os.ReadFile(msg.Media[0]); string(data) != "hello" {
+		t.Errorf("upload = %q", data)
+	}
+
+	ch.ToolStarted(msg.ChatID, "read_file", map[string]interface{}{"path": "notes.txt"})
+	if ev := readEvent(); ev.Type != "tool_start" || ev.Tool != "read_file" || ev.Args["path"] != "notes.txt" {
+		t.Errorf("tool_start = %+v", ev)
+	}
+	ch.ToolFinished(msg.ChatID, "read_file", "hello", false)
+	if ev := readEvent(); ev.Type != "tool_result" || ev.Content != "hello" {
+		t.Errorf("tool_result = %+v", ev)
+	}
+	ch.Send(ctx, bus.OutboundMessage{Channel: "webchat", ChatID: msg.ChatID, Content: "It says hello."})
+	if ev := readEvent(); ev.Type != "assistant" || ev.Content != "It says hello." {
+		t.Errorf("assistant = %+v", ev)
+	}
+
+	// The session list and transcript survive a page reload
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/chat/api/sessions/"+msg.ChatID, nil)
+	req.AddCookie(cookie)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var transcript struct {
+		Events []webchatEvent `json:"events"`
+	}
+	json.NewDecoder(resp.Body).Decode(&transcript)
+	if len(transcript.Events) != 4 {
+		t.Errorf("transcript has %d events, want 4", len(transcript.Events))
+	}
+}
+
+func TestWebChat_LogoutRevokesLogin(t *testing.T) {
+	_, _, server := newTestWebChat(t)
+
+	sessionsStatus := func(cookie *http.Cookie) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/chat/api/sessions", nil)
+		req.AddCookie(cookie)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	first := webchatLogin(t, server, "secret").Cookies()[0]
+	second := webchatLogin(t, server, "secret").Cookies()[0]
+	if first.Value == second.Value {
+		t.Fatal("each login should get its own cookie")
+	}
+
+	header := http.Header{"Cookie": {first.String()}, "Origin": {server.URL}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/chat/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/chat/api/logout", nil)
+	req.AddCookie(first)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if status := sessionsStatus(first); status != http.StatusUnauthorized {
+		t.Errorf("revoked cookie: status %d, want 401", status)
+	}
+	if status := sessionsStatus(second); status != http.StatusOK {
+		t.Errorf("other login: status %d, want 200", status)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := conn.ReadMessage(); err == nil || os.IsTimeout(err) {
+		t.Errorf("socket of the revoked login should be closed, read error = %v", err)
+	}
+}
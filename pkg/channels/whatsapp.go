@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
+// whatsappMessageLimit stays under WhatsApp's 65536 character limit.
+const whatsappMessageLimit = 65000
+
 type WhatsAppChannel struct {
 	*BaseChannel
 	conn      *websocket.Conn
@@ -26,6 +30,7 @@ type WhatsAppChannel struct {
 
 func NewWhatsAppChannel(cfg config.WhatsAppConfig, bus *bus.MessageBus) (*WhatsAppChannel, error) {
 	base := NewBaseChannel("whatsapp", cfg, bus, cfg.AllowFrom)
+	base.SetGroupPolicy(cfg.Groups)
 
 	return &WhatsAppChannel{
 		BaseChannel: base,
@@ -38,27 +43,57 @@ func NewWhatsAppChannel(cfg config.WhatsAppConfig, bus *bus.MessageBus) (*WhatsA
 func (c *WhatsAppChannel) Start(ctx context.Context) error {
 	log.Printf("Starting WhatsApp channel connecting to %s...", c.url)
 
+	if err := c.connect(); err != nil {
+		return fmt.Errorf("failed to connect to WhatsApp bridge: %w", err)
+	}
+
+	c.setRunning(true)
+	log.Println("WhatsApp channel connected")
+
+	go c.listen(ctx)
+
+	return nil
+}
+
+func (c *WhatsAppChannel) connect() error {
 	dialer := websocket.DefaultDialer
 	dialer.HandshakeTimeout = 10 * time.Second
 
 	conn, _, err := dialer.Dial(c.url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to connect to WhatsApp bridge: %w", err)
+		return err
 	}
 
 	c.mu.Lock()
 	c.conn = conn
 	c.connected = true
 	c.mu.Unlock()
-
-	c.setRunning(true)
-	log.Println("WhatsApp channel connected")
-
-	go c.listen(ctx)
-
 	return nil
 }
 
+// reconnect dials the bridge until it succeeds, backing off up to a
+// minute between attempts. It returns false once the channel stops.
+func (c *WhatsAppChannel) reconnect(ctx context.Context) bool {
+	delay := 2 * time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+		if !c.IsRunning() {
+			return false
+		}
+		if err := c.connect(); err != nil {
+			log.Printf("WhatsApp bridge reconnect failed: %v", err)
+			delay = min(delay*2, time.Minute)
+			continue
+		}
+		log.Println("WhatsApp bridge reconnected")
+		return true
+	}
+}
+
 func (c *WhatsAppChannel) Stop(ctx context.Context) error {
 	log.Println("Stopping WhatsApp channel...")
 
@@ -78,6 +113,16 @@ func (c *WhatsAppChannel) Stop(ctx context.Context) error {
 	return nil
 }
 
+// Health reports whether the bridge connection is up.
+func (c *WhatsAppChannel) Health() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return fmt.Errorf("not connected to bridge %s", c.url)
+	}
+	return nil
+}
+
 func (c *WhatsAppChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -86,19 +131,21 @@ func (c *WhatsAppChannel) Send(ctx context.Context, msg bus.OutboundMessage) err
 		return fmt.Errorf("whatsapp connection not established")
 	}
 
-	payload := map[string]interface{}{
-		"type":    "message",
-		"to":      msg.ChatID,
-		"content": msg.Content,
-	}
+	for _, chunk := range RenderChunks(msg.Content, FormatWhatsApp, whatsappMessageLimit) {
+		payload := map[string]interface{}{
+			"type":    "message",
+			"to":      msg.ChatID,
+			"content": chunk,
+		}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
-	}
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		return fmt.Errorf("failed to send message: %w", err)
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return fmt.Errorf("failed to send message: %w", err)
+		}
 	}
 
 	return nil
@@ -121,8 +168,20 @@ func (c *WhatsAppChannel) listen(ctx context.Context) {
 
 			_, message, err := conn.ReadMessage()
 			if err != nil {
+				if !c.IsRunning() {
+					return
+				}
 				log.Printf("WhatsApp read error: %v", err)
-				time.Sleep(2 * time.Second)
+				c.mu.Lock()
+				if c.conn == conn {
+					conn.Close()
+					c.conn = nil
+					c.connected = false
+				}
+				c.mu.Unlock()
+				if !c.reconnect(ctx) {
+					return
+				}
 				continue
 			}
 
@@ -177,6 +236,9 @@ func (c *WhatsAppChannel) handleIncomingMessage(msg map[string]interface{}) {
 	if userName, ok := msg["from_name"].(string); ok {
 		metadata["user_name"] = userName
 	}
+	// Group JIDs end in @g.us; the bridge does not report mentions, so
+	// groups in "mention" mode answer trigger prefixes only
+	metadata["is_group"] = fmt.Sprintf("%t", strings.HasSuffix(chatID, "@g.us"))
 
 	log.Printf("WhatsApp message from %s: %s...", senderID, utils.Truncate(content, 50))
 
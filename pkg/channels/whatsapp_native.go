@@ -0,0 +1,604 @@
+//go:build !mips && !mipsle && !mips64 && !ppc64 && !(freebsd && riscv64)
+
+package channels
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mdp/qrterminal/v3"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+	_ "modernc.org/sqlite"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/utils"
+	"github.com/sipeed/picoclaw/pkg/voice"
+)
+
+const (
+	whatsappTypingInterval = 10 * time.Second
+	whatsappTypingMaxTotal = 5 * time.Minute
+)
+
+// WhatsAppNativeChannel connects to WhatsApp Web directly as a linked
+// device, without a bridge process. The device session is kept in a
+// SQLite database at StorePath and is created by "picoclaw whatsapp login".
+type WhatsAppNativeChannel struct {
+	*BaseChannel
+	config      config.WhatsAppConfig
+	client      *whatsmeow.Client
+	container   *sqlstore.Container
+	transcriber *voice.GroqTranscriber
+	ctx         context.Context
+	cancel      context.CancelFunc
+	stopTyping  sync.Map // chatID -> *thinkingCancel
+}
+
+// NewWhatsAppNativeChannel creates the native WhatsApp channel. Allowlist
+// entries are phone numbers, with or without a leading "+".
+func NewWhatsAppNativeChannel(cfg config.WhatsAppConfig, messageBus *bus.MessageBus) (*WhatsAppNativeChannel, error) {
+	if cfg.StoreFile() == "" {
+		return nil, fmt.Errorf("whatsapp store_path is required in native mode")
+	}
+
+	allowFrom := make([]string, len(cfg.AllowFrom))
+	for i, entry := range cfg.AllowFrom {
+		allowFrom[i] = strings.TrimPrefix(entry, "+")
+	}
+	base := NewBaseChannel("whatsapp", cfg, messageBus, allowFrom)
+	base.SetGroupPolicy(withRequireMention(cfg.Groups, cfg.RequireMention))
+
+	return &WhatsAppNativeChannel{
+		BaseChannel: base,
+		config:      cfg,
+	}, nil
+}
+
+func (c *WhatsAppNativeChannel) SetTranscriber(transcriber *voice.GroqTranscriber) {
+	c.transcriber = transcriber
+}
+
+func (c *WhatsAppNativeChannel) Start(ctx context.Context) error {
+	logger.InfoC("whatsapp", "Starting native WhatsApp channel")
+
+	container, client, err := openWhatsAppClient(ctx, c.config)
+	if err != nil {
+		return err
+	}
+	if client.Store.ID == nil {
+		container.Close()
+		return fmt.Errorf("whatsapp is not linked yet, run: picoclaw whatsapp login")
+	}
+
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	c.container = container
+	c.client = client
+	// Keep retrying when the network is down at startup; whatsmeow already
+	// reconnects after the connection drops.
+	client.InitialAutoReconnect = true
+	client.AddEventHandler(c.handleEvent)
+
+	if err := client.Connect(); err != nil {
+		c.cancel()
+		container.Close()
+		return fmt.Errorf("failed to connect to WhatsApp: %w", err)
+	}
+
+	c.setRunning(true)
+	logger.InfoCF("whatsapp", "Native WhatsApp channel started", map[string]interface{}{
+		"jid": client.Store.ID.String(),
+	})
+	return nil
+}
+
+func (c *WhatsAppNativeChannel) Stop(ctx context.Context) error {
+	logger.InfoC("whatsapp", "Stopping native WhatsApp channel")
+	c.setRunning(false)
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.client != nil {
+		c.client.Disconnect()
+	}
+	if c.container != nil {
+		c.container.Close()
+	}
+	return nil
+}
+
+// Health reports whether the WhatsApp connection is up and still linked.
+func (c *WhatsAppNativeChannel) Health() error {
+	if c.client == nil || !c.client.IsConnected() {
+		return fmt.Errorf("not connected to WhatsApp")
+	}
+	if !c.client.IsLoggedIn() {
+		return fmt.Errorf("device is logged out, run: picoclaw whatsapp login")
+	}
+	return nil
+}
+
+func (c *WhatsAppNativeChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("whatsapp channel not running")
+	}
+
+	jid, err := types.ParseJID(msg.ChatID)
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid whatsapp chat ID %q: %w", msg.ChatID, err))
+	}
+
+	c.stopTypingFor(msg.ChatID)
+	c.client.SendChatPresence(ctx, jid, types.ChatPresencePaused, types.ChatPresenceMediaText)
+
+	for _, path := range msg.Media {
+		media, err := c.uploadMedia(ctx, path)
+		if err != nil {
+			return err
+		}
+		if _, err := c.client.SendMessage(ctx, jid, media); err != nil {
+			return fmt.Errorf("failed to send whatsapp media: %w", err)
+		}
+	}
+
+	for _, chunk := range RenderChunks(msg.Content, FormatWhatsApp, whatsappMessageLimit) {
+		if _, err := c.client.SendMessage(ctx, jid, &waE2E.Message{Conversation: proto.String(chunk)}); err != nil {
+			return fmt.Errorf("failed to send whatsapp message: %w", err)
+		}
+	}
+	return nil
+}
+
+// uploadMedia uploads a local file and wraps it in the message type that
+// matches its content.
+func (c *WhatsAppNativeChannel) uploadMedia(ctx context.Context, path string) (*waE2E.Message, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	mimeType := http.DetectContentType(data)
+
+	mediaType := whatsmeow.MediaDocument
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		mediaType = whatsmeow.MediaImage
+	case strings.HasPrefix(mimeType, "video/"):
+		mediaType = whatsmeow.MediaVideo
+	case strings.HasPrefix(mimeType, "audio/") || mimeType == "application/ogg":
+		mediaType = whatsmeow.MediaAudio
+	}
+
+	uploaded, err := c.client.Upload(ctx, data, mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload %s: %w", filepath.Base(path), err)
+	}
+
+	switch mediaType {
+	case whatsmeow.MediaImage:
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			URL: &uploaded.URL, DirectPath: &uploaded.DirectPath, MediaKey: uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256, FileSHA256: uploaded.FileSHA256,
+			FileLength: &uploaded.FileLength, Mimetype: proto.String(mimeType),
+		}}, nil
+	case whatsmeow.MediaVideo:
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{
+			URL: &uploaded.URL, DirectPath: &uploaded.DirectPath, MediaKey: uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256, FileSHA256: uploaded.FileSHA256,
+			FileLength: &uploaded.FileLength, Mimetype: proto.String(mimeType),
+		}}, nil
+	case whatsmeow.MediaAudio:
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{
+			URL: &uploaded.URL, DirectPath: &uploaded.DirectPath, MediaKey: uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256, FileSHA256: uploaded.FileSHA256,
+			FileLength: &uploaded.FileLength, Mimetype: proto.String(mimeType),
+		}}, nil
+	default:
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+			URL: &uploaded.URL, DirectPath: &uploaded.DirectPath, MediaKey: uploaded.MediaKey,
+			FileEncSHA256: uploaded.FileEncSHA256, FileSHA256: uploaded.FileSHA256,
+			FileLength: &uploaded.FileLength, Mimetype: proto.String(mimeType),
+			FileName: proto.String(filepath.Base(path)),
+		}}, nil
+	}
+}
+
+func (c *WhatsAppNativeChannel) handleEvent(evt interface{}) {
+	switch e := evt.(type) {
+	case *events.Message:
+		c.handleMessage(e)
+	case *events.Connected:
+		logger.InfoC("whatsapp", "Connected to WhatsApp")
+	case *events.Disconnected:
+		logger.WarnC("whatsapp", "Disconnected from WhatsApp, reconnecting")
+	case *events.LoggedOut:
+		logger.ErrorCF("whatsapp", "WhatsApp session logged out, run: picoclaw whatsapp login", map[string]interface{}{
+			"reason": e.Reason.String(),
+		})
+	case *events.StreamReplaced:
+		logger.ErrorC("whatsapp", "WhatsApp session was opened elsewhere with the same device")
+	}
+}
+
+func (c *WhatsAppNativeChannel) handleMessage(evt *events.Message) {
+	info := evt.Info
+	if info.IsFromMe || evt.Message == nil || info.Chat.Server == types.BroadcastServer {
+		return
+	}
+
+	senderID := whatsappSenderID(info)
+	if !c.IsAllowed(senderID) {
+		logger.DebugCF("whatsapp", "Message rejected by allowlist", map[string]interface{}{
+			"sender": senderID,
+		})
+		c.requestPairing(senderID, info.Chat.String(), info.IsGroup)
+		return
+	}
+
+	chatID := info.Chat.String()
+	content := whatsappMessageText(evt.Message)
+	var mediaPaths []string
+	if path, tag := c.downloadMedia(evt.Message); path != "" {
+		mediaPaths = append(mediaPaths, path)
+		if content != "" {
+			content += "\n"
+		}
+		content += tag
+	}
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+
+	logger.DebugCF("whatsapp", "Received message", map[string]interface{}{
+		"sender_id": senderID,
+		"chat_id":   chatID,
+		"is_group":  info.IsGroup,
+		"preview":   utils.Truncate(content, 50),
+	})
+
+	metadata := map[string]string{
+		"message_id": info.ID,
+		"user_name":  info.PushName,
+		"is_group":   fmt.Sprintf("%t", info.IsGroup),
+	}
+	if info.IsGroup && whatsappMentions(evt.Message, c.ownJIDs()) {
+		metadata[MetaIsMentioned] = "true"
+	}
+	if ctxInfo := whatsappContextInfo(evt.Message); ctxInfo.GetQuotedMessage() != nil {
+		mediaPaths = append(mediaPaths, c.addQuotedMessage(ctxInfo, metadata)...)
+	}
+
+	if c.ShouldReply(senderID, chatID, content, metadata) {
+		c.client.MarkRead(c.ctx, []types.MessageID{info.ID}, info.Timestamp, info.Chat, info.Sender)
+		c.startTyping(info.Chat)
+	}
+	c.HandleMessage(senderID, chatID, content, mediaPaths, metadata)
+}
+
+// ownJIDs returns the addresses other users may mention us by.
+func (c *WhatsAppNativeChannel) ownJIDs() []types.JID {
+	var own []types.JID
+	if c.client.Store.ID != nil {
+		own = append(own, c.client.Store.ID.ToNonAD())
+	}
+	if !c.client.Store.LID.IsEmpty() {
+		own = append(own, c.client.Store.LID.ToNonAD())
+	}
+	return own
+}
+
+// downloadMedia saves an image, voice note, audio, video or document to
+// the media directory and returns its path and a content tag.
+func (c *WhatsAppNativeChannel) downloadMedia(msg *waE2E.Message) (string, string) {
+	var (
+		media    whatsmeow.DownloadableMessage
+		filename string
+		tag      string
+	)
+	switch {
+	case msg.GetImageMessage() != nil:
+		media, filename, tag = msg.GetImageMessage(), "image.jpg", "[image]"
+	case msg.GetAudioMessage() != nil:
+		media, filename, tag = msg.GetAudioMessage(), "voice.ogg", "[voice]"
+	case msg.GetVideoMessage() != nil:
+		media, filename, tag = msg.GetVideoMessage(), "video.mp4", "[video]"
+	case msg.GetDocumentMessage() != nil:
+		doc := msg.GetDocumentMessage()
+		media, filename, tag = doc, doc.GetFileName(), "[file]"
+		if filename == "" {
+			filename = "document"
+		}
+	default:
+		return "", ""
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, time.Minute)
+	defer cancel()
+	data, err := c.client.Download(ctx, media)
+	if err != nil {
+		logger.ErrorCF("whatsapp", "Failed to download media", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return "", ""
+	}
+
+	mediaDir := filepath.Join(os.TempDir(), "picoclaw_media")
+	if err := os.MkdirAll(mediaDir, 0700); err != nil {
+		logger.ErrorCF("whatsapp", "Failed to create media directory", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return "", ""
+	}
+	localPath := filepath.Join(mediaDir, uuid.New().String()[:8]+"_"+utils.SanitizeFilename(filename))
+	if err := os.WriteFile(localPath, data, 0600); err != nil {
+		logger.ErrorCF("whatsapp", "Failed to save media", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return "", ""
+	}
+
+	if msg.GetAudioMessage() != nil {
+		tag = c.transcribe(localPath)
+	}
+	return localPath, tag
+}
+
+func (c *WhatsAppNativeChannel) transcribe(path string) string {
+	if c.transcriber == nil || !c.transcriber.IsAvailable() {
+		return "[voice]"
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := c.transcriber.Transcribe(ctx, path)
+	if err != nil {
+		logger.ErrorCF("whatsapp", "Voice transcription failed", map[string]interface{}{
+			"error": err.Error(),
+			"path":  path,
+		})
+		return "[voice (transcription failed)]"
+	}
+	return fmt.Sprintf("[voice transcription: %s]", result.Text)
+}
+
+// startTyping shows "typing…" until the reply is sent. WhatsApp clears the
+// state after a while, so it is refreshed.
+func (c *WhatsAppNativeChannel) startTyping(chat types.JID) {
+	chatID := chat.String()
+	c.stopTypingFor(chatID)
+
+	ctx, cancel := context.WithTimeout(c.ctx, whatsappTypingMaxTotal)
+	c.stopTyping.Store(chatID, &thinkingCancel{fn: cancel})
+
+	go func() {
+		ticker := time.NewTicker(whatsappTypingInterval)
+		defer ticker.Stop()
+		for {
+			if err := c.client.SendChatPresence(ctx, chat, types.ChatPresenceComposing, types.ChatPresenceMediaText); err != nil && ctx.Err() == nil {
+				logger.DebugCF("whatsapp", "Failed to send typing state", map[string]interface{}{
+					"error": err.Error(),
+				})
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *WhatsAppNativeChannel) stopTypingFor(chatID string) {
+	if stop, ok := c.stopTyping.LoadAndDelete(chatID); ok {
+		if cf, ok := stop.(*thinkingCancel); ok && cf != nil {
+			cf.Cancel()
+		}
+	}
+}
+
+// whatsappSenderID returns the sender's phone number, or the LID user when
+// WhatsApp hides the number.
+func whatsappSenderID(info types.MessageInfo) string {
+	if info.Sender.Server == types.HiddenUserServer && info.SenderAlt.Server == types.DefaultUserServer {
+		return info.SenderAlt.User
+	}
+	return info.Sender.User
+}
+
+// whatsappMessageText returns the text or media caption of a message.
+func whatsappMessageText(msg *waE2E.Message) string {
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
+
+// addQuotedMessage records the message a user replied to, downloading its
+// media.
+func (c *WhatsAppNativeChannel) addQuotedMessage(ctxInfo *waE2E.ContextInfo, metadata map[string]string) []string {
+	quoted := ctxInfo.GetQuotedMessage()
+	text := whatsappMessageText(quoted)
+	var media []string
+	if path, tag := c.downloadMedia(quoted); path != "" {
+		media = append(media, path)
+		text = appendContent(text, tag)
+	}
+
+	var author string
+	if jid, err := types.ParseJID(ctxInfo.GetParticipant()); err == nil {
+		if whatsappIsOwn(jid, c.ownJIDs()) {
+			metadata[MetaIsReplyToBot] = "true"
+		} else {
+			author = jid.User
+		}
+	}
+	setReplyContext(metadata, ctxInfo.GetStanzaID(), author, text)
+	return media
+}
+
+// whatsappContextInfo returns the reply and mention details of a message.
+func whatsappContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	}
+	return nil
+}
+
+// whatsappMentions reports whether a group message mentions or replies to
+// one of own.
+func whatsappMentions(msg *waE2E.Message, own []types.JID) bool {
+	ctxInfo := whatsappContextInfo(msg)
+	if ctxInfo == nil {
+		return false
+	}
+
+	candidates := append([]string{ctxInfo.GetParticipant()}, ctxInfo.GetMentionedJID()...)
+	for _, candidate := range candidates {
+		jid, err := types.ParseJID(candidate)
+		if err == nil && whatsappIsOwn(jid, own) {
+			return true
+		}
+	}
+	return false
+}
+
+func whatsappIsOwn(jid types.JID, own []types.JID) bool {
+	for _, me := range own {
+		if jid.User == me.User && jid.Server == me.Server {
+			return true
+		}
+	}
+	return false
+}
+
+func openWhatsAppClient(ctx context.Context, cfg config.WhatsAppConfig) (*sqlstore.Container, *whatsmeow.Client, error) {
+	storeFile := cfg.StoreFile()
+	if err := os.MkdirAll(filepath.Dir(storeFile), 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create whatsapp store directory: %w", err)
+	}
+
+	dsn := "file:" + storeFile + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	container, err := sqlstore.New(ctx, "sqlite", dsn, whatsappLogger{module: "store"})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open whatsapp store: %w", err)
+	}
+	device, err := container.GetFirstDevice(ctx)
+	if err != nil {
+		container.Close()
+		return nil, nil, fmt.Errorf("failed to load whatsapp device: %w", err)
+	}
+	return container, whatsmeow.NewClient(device, whatsappLogger{module: "client"}), nil
+}
+
+// WhatsAppLogin links picoclaw as a WhatsApp device. Without a phone number
+// it prints QR codes to scan from WhatsApp > Linked devices; with one it
+// prints a pairing code to enter on that phone instead.
+func WhatsAppLogin(ctx context.Context, cfg config.WhatsAppConfig, phone string, out io.Writer) error {
+	container, client, err := openWhatsAppClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer container.Close()
+
+	if client.Store.ID != nil {
+		fmt.Fprintf(out, "Already linked as %s. Unlink it in WhatsApp or delete %s to link again.\n",
+			client.Store.ID.User, cfg.StoreFile())
+		return nil
+	}
+
+	qrChan, err := client.GetQRChannel(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start login: %w", err)
+	}
+	if err := client.Connect(); err != nil {
+		return fmt.Errorf("failed to connect to WhatsApp: %w", err)
+	}
+	defer client.Disconnect()
+
+	pairingRequested := false
+	for item := range qrChan {
+		switch item.Event {
+		case whatsmeow.QRChannelEventCode:
+			if phone == "" {
+				fmt.Fprintln(out, "Scan this code in WhatsApp > Settings > Linked devices > Link a device:")
+				qrterminal.GenerateHalfBlock(item.Code, qrterminal.L, out)
+				continue
+			}
+			if pairingRequested {
+				continue
+			}
+			pairingRequested = true
+			code, err := client.PairPhone(ctx, strings.TrimPrefix(phone, "+"), true, whatsmeow.PairClientChrome, "Chrome (Linux)")
+			if err != nil {
+				return fmt.Errorf("failed to request pairing code: %w", err)
+			}
+			fmt.Fprintf(out, "Enter this code in WhatsApp > Linked devices > Link with phone number: %s\n", code)
+		case whatsmeow.QRChannelEventError:
+			return fmt.Errorf("login failed: %w", item.Error)
+		default:
+			if item == whatsmeow.QRChannelSuccess {
+				fmt.Fprintf(out, "✓ Linked as %s\n", client.Store.ID.User)
+				return nil
+			}
+			return fmt.Errorf("login ended: %s", item.Event)
+		}
+	}
+	return fmt.Errorf("login ended before linking")
+}
+
+// whatsappLogger routes whatsmeow's logs to the picoclaw logger.
+type whatsappLogger struct {
+	module string
+}
+
+func (l whatsappLogger) Errorf(msg string, args ...interface{}) {
+	logger.ErrorCF("whatsapp", fmt.Sprintf(msg, args...), map[string]interface{}{"module": l.module})
+}
+
+func (l whatsappLogger) Warnf(msg string, args ...interface{}) {
+	logger.WarnCF("whatsapp", fmt.Sprintf(msg, args...), map[string]interface{}{"module": l.module})
+}
+
+func (l whatsappLogger) Infof(msg string, args ...interface{}) {
+	logger.DebugCF("whatsapp", fmt.Sprintf(msg, args...), map[string]interface{}{"module": l.module})
+}
+
+func (l whatsappLogger) Debugf(msg string, args ...interface{}) {}
+
+func (l whatsappLogger) Sub(module string) waLog.Logger {
+	return whatsappLogger{module: l.module + "/" + module}
+}
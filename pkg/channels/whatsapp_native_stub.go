@@ -0,0 +1,45 @@
+//go:build mips || mipsle || mips64 || ppc64 || (freebsd && riscv64)
+
+package channels
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/voice"
+)
+
+var errWhatsAppNativeUnsupported = errors.New("native whatsapp mode is not supported on this architecture, its SQLite store is unavailable. Please use bridge mode")
+
+// WhatsAppNativeChannel is a stub for architectures without the pure-Go
+// SQLite driver the native mode stores its session in.
+type WhatsAppNativeChannel struct {
+	*BaseChannel
+}
+
+// NewWhatsAppNativeChannel returns an error on unsupported architectures.
+func NewWhatsAppNativeChannel(cfg config.WhatsAppConfig, bus *bus.MessageBus) (*WhatsAppNativeChannel, error) {
+	return nil, errWhatsAppNativeUnsupported
+}
+
+func (c *WhatsAppNativeChannel) SetTranscriber(transcriber *voice.GroqTranscriber) {}
+
+func (c *WhatsAppNativeChannel) Start(ctx context.Context) error {
+	return errWhatsAppNativeUnsupported
+}
+
+func (c *WhatsAppNativeChannel) Stop(ctx context.Context) error {
+	return nil
+}
+
+func (c *WhatsAppNativeChannel) Send(ctx context.Context, msg bus.OutboundMessage) error {
+	return errWhatsAppNativeUnsupported
+}
+
+// WhatsAppLogin returns an error on unsupported architectures.
+func WhatsAppLogin(ctx context.Context, cfg config.WhatsAppConfig, phone string, out io.Writer) error {
+	return errWhatsAppNativeUnsupported
+}
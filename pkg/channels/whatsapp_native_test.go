@@ -0,0 +1,63 @@
+//go:build !mips && !mipsle && !mips64 && !ppc64 && !(freebsd && riscv64)
+
+package channels
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestWhatsAppMentions(t *testing.T) {
+	own := []types.JID{types.NewJID("15550000", types.DefaultUserServer), types.NewJID("9876", types.HiddenUserServer)}
+
+	tests := []struct {
+		name string
+		msg  *waE2E.Message
+		want bool
+	}{
+		{"plain text", &waE2E.Message{Conversation: proto.String("hello all")}, false},
+		{"mentioned by number", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			Text:        proto.String("@15550000 hi"),
+			ContextInfo: &waE2E.ContextInfo{MentionedJID: []string{"15550000@s.whatsapp.net"}},
+		}}, true},
+		{"mentioned by LID", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			ContextInfo: &waE2E.ContextInfo{MentionedJID: []string{"9876@lid"}},
+		}}, true},
+		{"reply to us", &waE2E.Message{ImageMessage: &waE2E.ImageMessage{
+			ContextInfo: &waE2E.ContextInfo{Participant: proto.String("15550000@s.whatsapp.net")},
+		}}, true},
+		{"someone else mentioned", &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{
+			ContextInfo: &waE2E.ContextInfo{MentionedJID: []string{"15559999@s.whatsapp.net"}},
+		}}, false},
+	}
+	for _, tt := range tests {
+		if got := whatsappMentions(tt.msg, own); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWhatsAppSenderID(t *testing.T) {
+	info := types.MessageInfo{MessageSource: types.MessageSource{
+		Sender:    types.NewJID("9876", types.HiddenUserServer),
+		SenderAlt: types.NewJID("15550001", types.DefaultUserServer),
+	}}
+	if got := whatsappSenderID(info); got != "15550001" {
+		t.Errorf("LID sender = %q, want phone number", got)
+	}
+
+	info.SenderAlt = types.EmptyJID
+	if got := whatsappSenderID(info); got != "9876" {
+		t.Errorf("LID-only sender = %q", got)
+	}
+}
+
+func TestWhatsAppMessageText(t *testing.T) {
+	msg := &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: proto.String("look")}}
+	if got := whatsappMessageText(msg); got != "look" {
+		t.Errorf("caption = %q", got)
+	}
+}
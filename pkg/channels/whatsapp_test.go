@@ -0,0 +1,58 @@
+package channels
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestWhatsAppChannel_ReconnectsToBridge(t *testing.T) {
+	conns := make(chan *websocket.Conn, 4)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- conn
+	}))
+	defer server.Close()
+
+	msgBus := bus.NewMessageBus()
+	ch, err := NewWhatsAppChannel(config.WhatsAppConfig{
+		BridgeURL: "ws" + strings.TrimPrefix(server.URL, "http"),
+	}, msgBus)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer ch.Stop(context.Background())
+
+	// The bridge goes away; the channel dials it again
+	(<-conns).Close()
+	var conn *websocket.Conn
+	select {
+	case conn = <-conns:
+	case <-ctx.Done():
+		t.Fatal("channel did not reconnect")
+	}
+
+	conn.WriteJSON(map[string]string{"type": "message", "from": "15550001", "chat": "15550001@s.whatsapp.net", "content": "back again"})
+	msg, ok := msgBus.ConsumeInbound(ctx)
+	if !ok || msg.Content != "back again" || msg.ChatID != "15550001@s.whatsapp.net" {
+		t.Errorf("after reconnect got %+v", msg)
+	}
+}
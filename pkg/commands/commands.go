@@ -0,0 +1,376 @@
+// PicoClaw - Ultra-lightweight personal AI agent
+// License: MIT
+//
+// Copyright (c) 2026 PicoClaw contributors
+
+// Package commands is the registry of chat commands such as /help. The
+// agent loop dispatches commands from every channel through one Registry,
+// and channels with a native command UI advertise its contents.
+package commands
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Level is the permission a sender needs to run a command.
+type Level int
+
+const (
+	LevelUser Level = iota
+	LevelAdmin
+)
+
+// ArgType is the type an argument is parsed into.
+type ArgType int
+
+const (
+	ArgString ArgType = iota
+	ArgInt
+	ArgBool
+)
+
+// Arg describes one positional argument of a command.
+type Arg struct {
+	Name        string
+	Description string
+	Type        ArgType
+	// Choices restricts a string argument to these values.
+	Choices  []string
+	Required bool
+	// Rest makes the argument take the remainder of the line. Only the last
+	// argument can be Rest.
+	Rest bool
+}
+
+// Handler runs a command.
+type Handler func(ctx context.Context, req *Request) (Result, error)
+
+// Command is a chat command, invoked as /Name followed by its arguments.
+type Command struct {
+	// Name is 1-32 lowercase letters, digits or underscores, which every
+	// platform with native commands accepts.
+	Name        string
+	Description string
+	Args        []Arg
+	Level       Level
+	Handler     Handler
+	// Source tells where the command came from, "builtin" or "skill:<name>".
+	Source string
+}
+
+// Request is one invocation of a command.
+type Request struct {
+	Command    *Command
+	Channel    string
+	ChatID     string
+	SenderID   string
+	SessionKey string
+	// Admin is true when the sender may run LevelAdmin commands.
+	Admin bool
+	// Permitted, when set, limits the commands the sender may run, for
+	// senders whose role restricts commands.
+	Permitted func(name string) bool
+	Args      Args
+}
+
+// allows reports whether the sender may run cmd.
+func (req *Request) allows(cmd *Command) bool {
+	if cmd.Level == LevelAdmin && !req.Admin {
+		return false
+	}
+	return req.Permitted == nil || req.Permitted(cmd.Name)
+}
+
+// Args holds parsed argument values by name. Missing optional arguments
+// are absent.
+type Args map[string]interface{}
+
+func (a Args) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+func (a Args) Int(name string) int {
+	n, _ := a[name].(int)
+	return n
+}
+
+func (a Args) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+// Has reports whether an argument was given.
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// Result is what a command produces. Reply is sent back to the chat. A
+// non-empty Prompt is handed to the agent as if the user had typed it,
+// for commands that are shortcuts for a request.
+type Result struct {
+	Reply  string
+	Prompt string
+}
+
+// Reply returns a Result that answers the chat with a formatted message.
+func Reply(format string, args ...interface{}) Result {
+	return Result{Reply: fmt.Sprintf(format, args...)}
+}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// Usage returns the command's syntax, such as "/show <target>".
+func (c *Command) Usage() string {
+	var sb strings.Builder
+	sb.WriteString("/" + c.Name)
+	for _, arg := range c.Args {
+		label := arg.Name
+		if len(arg.Choices) > 0 {
+			label = strings.Join(arg.Choices, "|")
+		}
+		if arg.Rest {
+			label += "..."
+		}
+		if arg.Required {
+			sb.WriteString(" <" + label + ">")
+		} else {
+			sb.WriteString(" [" + label + "]")
+		}
+	}
+	return sb.String()
+}
+
+func (c *Command) validate() error {
+	if !namePattern.MatchString(c.Name) {
+		return fmt.Errorf("command name %q must be 1-32 lowercase letters, digits or underscores", c.Name)
+	}
+	if c.Handler == nil {
+		return fmt.Errorf("command /%s has no handler", c.Name)
+	}
+	optional := false
+	for i, arg := range c.Args {
+		if !namePattern.MatchString(arg.Name) {
+			return fmt.Errorf("/%s: argument name %q must be lowercase letters, digits or underscores", c.Name, arg.Name)
+		}
+		if arg.Rest && i != len(c.Args)-1 {
+			return fmt.Errorf("/%s: only the last argument can take the rest of the line", c.Name)
+		}
+		if arg.Required && optional {
+			return fmt.Errorf("/%s: required argument %q follows an optional one", c.Name, arg.Name)
+		}
+		optional = optional || !arg.Required
+	}
+	return nil
+}
+
+// parseArgs converts the text after the command name into Args.
+func (c *Command) parseArgs(text string) (Args, error) {
+	args := make(Args)
+	rest := strings.TrimSpace(text)
+	for _, arg := range c.Args {
+		var word string
+		if arg.Rest {
+			word, rest = rest, ""
+		} else {
+			word, rest, _ = strings.Cut(rest, " ")
+			rest = strings.TrimSpace(rest)
+		}
+		if word == "" {
+			if arg.Required {
+				return nil, fmt.Errorf("missing %s", arg.Name)
+			}
+			continue
+		}
+
+		switch arg.Type {
+		case ArgInt:
+			n, err := strconv.Atoi(word)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a number", arg.Name)
+			}
+			args[arg.Name] = n
+		case ArgBool:
+			switch strings.ToLower(word) {
+			case "on", "true", "yes", "1":
+				args[arg.Name] = true
+			case "off", "false", "no", "0":
+				args[arg.Name] = false
+			default:
+				return nil, fmt.Errorf("%s must be on or off", arg.Name)
+			}
+		default:
+			if len(arg.Choices) > 0 {
+				choice := ""
+				for _, c := range arg.Choices {
+					if strings.EqualFold(c, word) {
+						choice = c
+					}
+				}
+				if choice == "" {
+					return nil, fmt.Errorf("unknown %s: %s", arg.Name, word)
+				}
+				word = choice
+			}
+			args[arg.Name] = word
+		}
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("too many arguments")
+	}
+	return args, nil
+}
+
+// Parse splits "/name args" into the lowercased command name and the rest
+// of the line. A "@botname" suffix on the name, as Telegram adds in groups,
+// is dropped.
+func Parse(content string) (name, args string, ok bool) {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "/") {
+		return "", "", false
+	}
+	head, args, _ := strings.Cut(content[1:], " ")
+	head, _, _ = strings.Cut(head, "\n")
+	head, _, _ = strings.Cut(head, "@")
+	if head == "" {
+		return "", "", false
+	}
+	return strings.ToLower(head), strings.TrimSpace(args), true
+}
+
+// Registry holds the available commands. It is safe for concurrent use.
+type Registry struct {
+	mu        sync.RWMutex
+	commands  map[string]*Command
+	listeners []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]*Command)}
+}
+
+// Register adds a command. Names must be unique.
+func (r *Registry) Register(cmd Command) error {
+	if err := cmd.validate(); err != nil {
+		return err
+	}
+	if cmd.Source == "" {
+		cmd.Source = "builtin"
+	}
+
+	r.mu.Lock()
+	if existing, ok := r.commands[cmd.Name]; ok {
+		r.mu.Unlock()
+		return fmt.Errorf("command /%s is already registered by %s", cmd.Name, existing.Source)
+	}
+	r.commands[cmd.Name] = &cmd
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn()
+	}
+	return nil
+}
+
+// Unregister removes a command if it exists.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	_, ok := r.commands[name]
+	delete(r.commands, name)
+	listeners := r.listeners
+	r.mu.Unlock()
+
+	if ok {
+		for _, fn := range listeners {
+			fn()
+		}
+	}
+}
+
+func (r *Registry) Get(name string) (*Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.commands[name]
+	return cmd, ok
+}
+
+// List returns all commands sorted by name.
+func (r *Registry) List() []*Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cmds := make([]*Command, 0, len(r.commands))
+	for _, cmd := range r.commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+// OnChange calls fn whenever a command is added or removed, so channels
+// can update the commands they advertise.
+func (r *Registry) OnChange(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Help lists the commands the sender of req may run.
+func (r *Registry) Help(req *Request) string {
+	var lines []string
+	for _, cmd := range r.List() {
+		if !req.allows(cmd) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s - %s", cmd.Usage(), cmd.Description))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Execute runs the command in content. It returns false when content is
+// not a registered command, so the message can go to the agent instead.
+func (r *Registry) Execute(ctx context.Context, req Request, content string) (Result, bool) {
+	name, text, ok := Parse(content)
+	if !ok {
+		return Result{}, false
+	}
+	cmd, ok := r.Get(name)
+	if !ok {
+		return Result{}, false
+	}
+
+	if cmd.Level == LevelAdmin && !req.Admin {
+		return Reply("/%s is only available to admins", cmd.Name), true
+	}
+	if !req.allows(cmd) {
+		return Reply("/%s is not available to you", cmd.Name), true
+	}
+
+	args, err := cmd.parseArgs(text)
+	if err != nil {
+		return Reply("%s\nUsage: %s", capitalize(err.Error()), cmd.Usage()), true
+	}
+
+	req.Command = cmd
+	req.Args = args
+	result, err := cmd.Handler(ctx, &req)
+	if err != nil {
+		return Reply("Error: %v", err), true
+	}
+	return result, true
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func echoCommand() Command {
+	return Command{
+		Name:        "echo",
+		Description: "Repeat a message",
+		Args: []Arg{
+			{Name: "times", Type: ArgInt, Required: true},
+			{Name: "loud", Type: ArgBool},
+			{Name: "text", Rest: true},
+		},
+		Handler: func(ctx context.Context, req *Request) (Result, error) {
+			text := strings.Repeat(req.Args.String("text")+" ", req.Args.Int("times"))
+			if req.Args.Bool("loud") {
+				text = strings.ToUpper(text)
+			}
+			return Reply("%s", strings.TrimSpace(text)), nil
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		content, name, args string
+		ok                  bool
+	}{
+		{"/help", "help", "", true},
+		{"  /Show model ", "show", "model", true},
+		{"/show@picoclaw_bot channel", "show", "channel", true},
+		{"hello /help", "", "", false},
+		{"/", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := Parse(tt.content)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v", tt.content, name, args, ok)
+		}
+	}
+}
+
+func TestRegistry_ExecuteParsesTypedArgs(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register(echoCommand()); err != nil {
+		t.Fatal(err)
+	}
+
+	result, ok := r.Execute(context.Background(), Request{}, "/echo 2 on hi there")
+	if !ok || result.Reply != "HI THERE HI THERE" {
+		t.Errorf("reply = %q, handled %v", result.Reply, ok)
+	}
+
+	result, _ = r.Execute(context.Background(), Request{}, "/echo two")
+	if !strings.Contains(result.Reply, "Times must be a number") || !strings.Contains(result.Reply, "Usage: /echo <times> [loud] [text...]") {
+		t.Errorf("bad arg reply = %q", result.Reply)
+	}
+
+	if _, ok := r.Execute(context.Background(), Request{}, "/unknown"); ok {
+		t.Error("unknown command was handled")
+	}
+}
+
+func TestRegistry_Choices(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Command{
+		Name: "show",
+		Args: []Arg{{Name: "target", Choices: []string{"model", "channel"}, Required: true}},
+		Handler: func(ctx context.Context, req *Request) (Result, error) {
+			return Reply("%s", req.Args.String("target")), nil
+		},
+	})
+
+	if result, _ := r.Execute(context.Background(), Request{}, "/show MODEL"); result.Reply != "model" {
+		t.Errorf("reply = %q", result.Reply)
+	}
+	if result, _ := r.Execute(context.Background(), Request{}, "/show weather"); !strings.HasPrefix(result.Reply, "Unknown target: weather") {
+		t.Errorf("reply = %q", result.Reply)
+	}
+	if result, _ := r.Execute(context.Background(), Request{}, "/show model extra"); !strings.HasPrefix(result.Reply, "Too many arguments") {
+		t.Errorf("reply = %q", result.Reply)
+	}
+}
+
+func TestRegistry_AdminCommands(t *testing.T) {
+	r := NewRegistry()
+	cmd := echoCommand()
+	cmd.Level = LevelAdmin
+	r.Register(cmd)
+
+	if result, _ := r.Execute(context.Background(), Request{}, "/echo 1 off hi"); result.Reply != "/echo is only available to admins" {
+		t.Errorf("non-admin reply = %q", result.Reply)
+	}
+	if result, _ := r.Execute(context.Background(), Request{Admin: true}, "/echo 1 off hi"); result.Reply != "hi" {
+		t.Errorf("admin reply = %q", result.Reply)
+	}
+	if help := r.Help(&Request{}); strings.Contains(help, "/echo") {
+		t.Errorf("admin command listed for users: %q", help)
+	}
+}
+
+func TestRegistry_RegisterValidates(t *testing.T) {
+	r := NewRegistry()
+	noop := func(ctx context.Context, req *Request) (Result, error) { return Result{}, nil }
+
+	bad := []Command{
+		{Name: "Bad-Name", Handler: noop},
+		{Name: "nohandler"},
+		{Name: "rest", Handler: noop, Args: []Arg{{Name: "a", Rest: true}, {Name: "b"}}},
+		{Name: "order", Handler: noop, Args: []Arg{{Name: "a"}, {Name: "b", Required: true}}},
+	}
+	for _, cmd := range bad {
+		if err := r.Register(cmd); err == nil {
+			t.Errorf("Register(%q) succeeded", cmd.Name)
+		}
+	}
+
+	changes := 0
+	r.OnChange(func() { changes++ })
+	if err := r.Register(Command{Name: "ok", Handler: noop}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Register(Command{Name: "ok", Handler: noop, Source: "skill:other"}); err == nil {
+		t.Error("duplicate command registered")
+	}
+	r.Unregister("ok")
+	if changes != 2 {
+		t.Errorf("OnChange called %d times, want 2", changes)
+	}
+}
@@ -47,15 +47,24 @@ type Config struct {
 	Agents    AgentsConfig    `json:"agents"`
 	Channels  ChannelsConfig  `json:"channels"`
 	Providers ProvidersConfig `json:"providers"`
-	Gateway   GatewayConfig   `json:"gateway"`
-	Tools     ToolsConfig     `json:"tools"`
-	Heartbeat HeartbeatConfig `json:"heartbeat"`
-	Devices   DevicesConfig   `json:"devices"`
-	mu        sync.RWMutex
+	// ProviderList declares named provider entries; see ProviderEntries.
+	ProviderList []ProviderEntry   `json:"provider_list,omitempty"`
+	Gateway      GatewayConfig     `json:"gateway"`
+	Tools        ToolsConfig       `json:"tools"`
+	Heartbeat    HeartbeatConfig   `json:"heartbeat"`
+	Devices      DevicesConfig     `json:"devices"`
+	Outbox       OutboxConfig      `json:"outbox"`
+	Commands     CommandsConfig    `json:"commands"`
+	Identity     IdentityConfig    `json:"identity"`
+	Pairing      PairingConfig     `json:"pairing"`
+	Permissions  PermissionsConfig `json:"permissions"`
+	mu           sync.RWMutex
 }
 
 type AgentsConfig struct {
 	Defaults AgentDefaults `json:"defaults"`
+	// Subagent configures agents started by the spawn and subagent tools
+	Subagent AgentConfig `json:"subagent"`
 }
 
 type AgentDefaults struct {
@@ -66,6 +75,29 @@ type AgentDefaults struct {
 	MaxTokens           int     `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
 	Temperature         float64 `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
 	MaxToolIterations   int     `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	// ReasoningEffort is "low", "medium" or "high"; empty leaves the
+	// provider default. ThinkingBudget sets an explicit token budget for
+	// providers that take one (Anthropic, Gemini) and overrides the effort.
+	ReasoningEffort string `json:"reasoning_effort,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_REASONING_EFFORT"`
+	ThinkingBudget  int    `json:"thinking_budget,omitempty" env:"PICOCLAW_AGENTS_DEFAULTS_THINKING_BUDGET"`
+}
+
+// AgentConfig holds settings for one agent. Unset fields fall back to
+// agents.defaults.
+type AgentConfig struct {
+	ReasoningEffort string `json:"reasoning_effort,omitempty" env:"PICOCLAW_AGENTS_SUBAGENT_REASONING_EFFORT"`
+	ThinkingBudget  int    `json:"thinking_budget,omitempty" env:"PICOCLAW_AGENTS_SUBAGENT_THINKING_BUDGET"`
+}
+
+// Merge returns the defaults with the agent's own settings applied.
+func (d AgentDefaults) Merge(agent AgentConfig) AgentDefaults {
+	if agent.ReasoningEffort != "" {
+		d.ReasoningEffort = agent.ReasoningEffort
+	}
+	if agent.ThinkingBudget > 0 {
+		d.ThinkingBudget = agent.ThinkingBudget
+	}
+	return d
 }
 
 type ChannelsConfig struct {
@@ -79,19 +111,44 @@ type ChannelsConfig struct {
 	Slack    SlackConfig    `json:"slack"`
 	LINE     LINEConfig     `json:"line"`
 	OneBot   OneBotConfig   `json:"onebot"`
+	Email    EmailConfig    `json:"email"`
+	Matrix   MatrixConfig   `json:"matrix"`
+	Signal   SignalConfig   `json:"signal"`
+	HTTP     HTTPConfig     `json:"http"`
+	WebChat  WebChatConfig  `json:"webchat"`
 }
 
+// WhatsAppConfig selects between an external bridge process ("bridge", the
+// default) and a built-in WhatsApp Web client ("native").
 type WhatsAppConfig struct {
-	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_WHATSAPP_ENABLED"`
-	BridgeURL string              `json:"bridge_url" env:"PICOCLAW_CHANNELS_WHATSAPP_BRIDGE_URL"`
-	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WHATSAPP_ALLOW_FROM"`
+	Enabled   bool   `json:"enabled" env:"PICOCLAW_CHANNELS_WHATSAPP_ENABLED"`
+	Mode      string `json:"mode" env:"PICOCLAW_CHANNELS_WHATSAPP_MODE"`
+	BridgeURL string `json:"bridge_url" env:"PICOCLAW_CHANNELS_WHATSAPP_BRIDGE_URL"`
+	// StorePath is the native mode's session database
+	StorePath      string              `json:"store_path" env:"PICOCLAW_CHANNELS_WHATSAPP_STORE_PATH"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_WHATSAPP_REQUIRE_MENTION"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_WHATSAPP_ALLOW_FROM"`
+	Groups         GroupsConfig        `json:"groups"`
+}
+
+// StoreFile returns StorePath with a leading ~ expanded.
+func (c WhatsAppConfig) StoreFile() string {
+	return expandHome(c.StorePath)
 }
 
 type TelegramConfig struct {
-	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_TELEGRAM_ENABLED"`
-	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_TELEGRAM_TOKEN"`
-	Proxy     string              `json:"proxy" env:"PICOCLAW_CHANNELS_TELEGRAM_PROXY"`
-	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_TELEGRAM_ALLOW_FROM"`
+	Enabled bool   `json:"enabled" env:"PICOCLAW_CHANNELS_TELEGRAM_ENABLED"`
+	Token   string `json:"token" env:"PICOCLAW_CHANNELS_TELEGRAM_TOKEN"`
+	Proxy   string `json:"proxy" env:"PICOCLAW_CHANNELS_TELEGRAM_PROXY"`
+	// WebhookURL is the public HTTPS address Telegram posts updates to.
+	// The gateway serves the webhook on the URL's path. Empty uses long
+	// polling.
+	WebhookURL string `json:"webhook_url" env:"PICOCLAW_CHANNELS_TELEGRAM_WEBHOOK_URL"`
+	// WebhookSecret is checked on every webhook request. A random one is
+	// used when empty.
+	WebhookSecret string              `json:"webhook_secret" env:"PICOCLAW_CHANNELS_TELEGRAM_WEBHOOK_SECRET"`
+	AllowFrom     FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_TELEGRAM_ALLOW_FROM"`
+	Groups        GroupsConfig        `json:"groups"`
 }
 
 type FeishuConfig struct {
@@ -101,12 +158,31 @@ type FeishuConfig struct {
 	EncryptKey        string              `json:"encrypt_key" env:"PICOCLAW_CHANNELS_FEISHU_ENCRYPT_KEY"`
 	VerificationToken string              `json:"verification_token" env:"PICOCLAW_CHANNELS_FEISHU_VERIFICATION_TOKEN"`
 	AllowFrom         FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_FEISHU_ALLOW_FROM"`
+	Groups            GroupsConfig        `json:"groups"`
 }
 
 type DiscordConfig struct {
 	Enabled   bool                `json:"enabled" env:"PICOCLAW_CHANNELS_DISCORD_ENABLED"`
 	Token     string              `json:"token" env:"PICOCLAW_CHANNELS_DISCORD_TOKEN"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_DISCORD_ALLOW_FROM"`
+	Groups    GroupsConfig        `json:"groups"`
+	// ThreadReplies answers a server message in a new thread started from
+	// it, so each conversation has its own thread and session.
+	ThreadReplies bool `json:"thread_replies" env:"PICOCLAW_CHANNELS_DISCORD_THREAD_REPLIES"`
+	// Guilds limits the bot to these servers, keyed by guild ID. Empty
+	// allows every server the bot is in.
+	Guilds map[string]DiscordGuildConfig `json:"guilds"`
+}
+
+// DiscordGuildConfig sets where in a server the bot answers and who may
+// use it there.
+type DiscordGuildConfig struct {
+	// Channels limits the bot to these channel IDs; threads count as their
+	// parent channel. Empty allows every channel.
+	Channels FlexibleStringSlice `json:"channels"`
+	// Roles lets members with any of these role IDs or names use the bot,
+	// along with the senders in allow_from.
+	Roles FlexibleStringSlice `json:"roles"`
 }
 
 type MaixCamConfig struct {
@@ -135,6 +211,7 @@ type SlackConfig struct {
 	BotToken  string              `json:"bot_token" env:"PICOCLAW_CHANNELS_SLACK_BOT_TOKEN"`
 	AppToken  string              `json:"app_token" env:"PICOCLAW_CHANNELS_SLACK_APP_TOKEN"`
 	AllowFrom FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_SLACK_ALLOW_FROM"`
+	Groups    GroupsConfig        `json:"groups"`
 }
 
 type LINEConfig struct {
@@ -145,15 +222,108 @@ type LINEConfig struct {
 	WebhookPort        int                 `json:"webhook_port" env:"PICOCLAW_CHANNELS_LINE_WEBHOOK_PORT"`
 	WebhookPath        string              `json:"webhook_path" env:"PICOCLAW_CHANNELS_LINE_WEBHOOK_PATH"`
 	AllowFrom          FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_LINE_ALLOW_FROM"`
+	Groups             GroupsConfig        `json:"groups"`
 }
 
 type OneBotConfig struct {
-	Enabled            bool                `json:"enabled" env:"PICOCLAW_CHANNELS_ONEBOT_ENABLED"`
-	WSUrl              string              `json:"ws_url" env:"PICOCLAW_CHANNELS_ONEBOT_WS_URL"`
+	Enabled bool `json:"enabled" env:"PICOCLAW_CHANNELS_ONEBOT_ENABLED"`
+	// Mode is how events arrive: "ws" dials ws_url (the default),
+	// "reverse_ws" accepts WebSocket connections from the implementation on
+	// the gateway at path, and "http" receives events it posts to path.
+	Mode  string `json:"mode" env:"PICOCLAW_CHANNELS_ONEBOT_MODE"`
+	WSUrl string `json:"ws_url" env:"PICOCLAW_CHANNELS_ONEBOT_WS_URL"`
+	// Path is where the gateway serves reverse WebSocket or HTTP POST mode.
+	Path string `json:"path" env:"PICOCLAW_CHANNELS_ONEBOT_PATH"`
+	// HTTPURL is the implementation's HTTP API. When set, messages are sent
+	// through it; HTTP POST mode needs it.
+	HTTPURL string `json:"http_url" env:"PICOCLAW_CHANNELS_ONEBOT_HTTP_URL"`
+	// Secret checks the X-Signature of events posted in HTTP POST mode.
+	Secret             string              `json:"secret" env:"PICOCLAW_CHANNELS_ONEBOT_SECRET"`
 	AccessToken        string              `json:"access_token" env:"PICOCLAW_CHANNELS_ONEBOT_ACCESS_TOKEN"`
 	ReconnectInterval  int                 `json:"reconnect_interval" env:"PICOCLAW_CHANNELS_ONEBOT_RECONNECT_INTERVAL"`
 	GroupTriggerPrefix []string            `json:"group_trigger_prefix" env:"PICOCLAW_CHANNELS_ONEBOT_GROUP_TRIGGER_PREFIX"`
 	AllowFrom          FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_ONEBOT_ALLOW_FROM"`
+	Groups             GroupsConfig        `json:"groups"`
+}
+
+type EmailConfig struct {
+	Enabled      bool                `json:"enabled" env:"PICOCLAW_CHANNELS_EMAIL_ENABLED"`
+	IMAPHost     string              `json:"imap_host" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_HOST"`
+	IMAPPort     int                 `json:"imap_port" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_PORT"`
+	IMAPTLS      bool                `json:"imap_tls" env:"PICOCLAW_CHANNELS_EMAIL_IMAP_TLS"`
+	SMTPHost     string              `json:"smtp_host" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_HOST"`
+	SMTPPort     int                 `json:"smtp_port" env:"PICOCLAW_CHANNELS_EMAIL_SMTP_PORT"`
+	Username     string              `json:"username" env:"PICOCLAW_CHANNELS_EMAIL_USERNAME"`
+	Password     string              `json:"password" env:"PICOCLAW_CHANNELS_EMAIL_PASSWORD"`
+	FromAddress  string              `json:"from_address" env:"PICOCLAW_CHANNELS_EMAIL_FROM_ADDRESS"`
+	Mailbox      string              `json:"mailbox" env:"PICOCLAW_CHANNELS_EMAIL_MAILBOX"`
+	PollInterval int                 `json:"poll_interval" env:"PICOCLAW_CHANNELS_EMAIL_POLL_INTERVAL"`
+	AllowFrom    FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_EMAIL_ALLOW_FROM"`
+}
+
+type MatrixConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_MATRIX_ENABLED"`
+	Homeserver     string              `json:"homeserver" env:"PICOCLAW_CHANNELS_MATRIX_HOMESERVER"`
+	UserID         string              `json:"user_id" env:"PICOCLAW_CHANNELS_MATRIX_USER_ID"`
+	AccessToken    string              `json:"access_token" env:"PICOCLAW_CHANNELS_MATRIX_ACCESS_TOKEN"`
+	Password       string              `json:"password" env:"PICOCLAW_CHANNELS_MATRIX_PASSWORD"`
+	RequireMention bool                `json:"require_mention" env:"PICOCLAW_CHANNELS_MATRIX_REQUIRE_MENTION"`
+	AutoJoin       bool                `json:"auto_join" env:"PICOCLAW_CHANNELS_MATRIX_AUTO_JOIN"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_MATRIX_ALLOW_FROM"`
+	Groups         GroupsConfig        `json:"groups"`
+}
+
+type SignalConfig struct {
+	Enabled           bool                `json:"enabled" env:"PICOCLAW_CHANNELS_SIGNAL_ENABLED"`
+	Account           string              `json:"account" env:"PICOCLAW_CHANNELS_SIGNAL_ACCOUNT"`
+	RPCAddress        string              `json:"rpc_address" env:"PICOCLAW_CHANNELS_SIGNAL_RPC_ADDRESS"`
+	AttachmentsDir    string              `json:"attachments_dir" env:"PICOCLAW_CHANNELS_SIGNAL_ATTACHMENTS_DIR"`
+	ReconnectInterval int                 `json:"reconnect_interval" env:"PICOCLAW_CHANNELS_SIGNAL_RECONNECT_INTERVAL"`
+	AllowFrom         FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_SIGNAL_ALLOW_FROM"`
+	Groups            GroupsConfig        `json:"groups"`
+}
+
+// HTTPConfig configures the HTTP API channel, which is served on the
+// gateway port.
+type HTTPConfig struct {
+	Enabled        bool                `json:"enabled" env:"PICOCLAW_CHANNELS_HTTP_ENABLED"`
+	Token          string              `json:"token" env:"PICOCLAW_CHANNELS_HTTP_TOKEN"`
+	RequestTimeout int                 `json:"request_timeout" env:"PICOCLAW_CHANNELS_HTTP_REQUEST_TIMEOUT"`
+	AllowFrom      FlexibleStringSlice `json:"allow_from" env:"PICOCLAW_CHANNELS_HTTP_ALLOW_FROM"`
+}
+
+// WebChatConfig configures the built-in browser chat, which is served on
+// the gateway port under /chat/.
+type WebChatConfig struct {
+	Enabled     bool   `json:"enabled" env:"PICOCLAW_CHANNELS_WEBCHAT_ENABLED"`
+	Token       string `json:"token" env:"PICOCLAW_CHANNELS_WEBCHAT_TOKEN"`
+	MaxUploadMB int    `json:"max_upload_mb" env:"PICOCLAW_CHANNELS_WEBCHAT_MAX_UPLOAD_MB"`
+}
+
+// GroupPolicyConfig decides which group chat messages the agent answers.
+type GroupPolicyConfig struct {
+	// Mode is "all" to answer every message, "mention" to answer only when
+	// mentioned, replied to or addressed with a trigger prefix, or "listen"
+	// to answer like "mention" while keeping the other messages as context.
+	// Empty means "all".
+	Mode            string              `json:"mode,omitempty"`
+	TriggerPrefixes FlexibleStringSlice `json:"trigger_prefixes,omitempty"`
+	// QuietHours is a local time range such as "23:00-07:00" in which the
+	// agent does not answer.
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// AllowFrom limits who may address the agent in the group, on top of
+	// the channel's allow_from.
+	AllowFrom FlexibleStringSlice `json:"allow_from,omitempty"`
+}
+
+// GroupsConfig is a channel's group chat policy. The embedded policy
+// applies to every group; Overrides replaces its fields for single groups,
+// keyed by chat ID.
+type GroupsConfig struct {
+	GroupPolicyConfig
+	// Allow lists the groups the bot works in. Empty means all.
+	Allow     FlexibleStringSlice          `json:"allow,omitempty"`
+	Overrides map[string]GroupPolicyConfig `json:"overrides,omitempty"`
 }
 
 type HeartbeatConfig struct {
@@ -161,6 +331,66 @@ type HeartbeatConfig struct {
 	Interval int  `json:"interval" env:"PICOCLAW_HEARTBEAT_INTERVAL"` // minutes, min 5
 }
 
+// CommandsConfig controls chat commands.
+type CommandsConfig struct {
+	// Admins may run admin commands such as /switch. Entries are sender IDs
+	// as in allow_from, optionally prefixed with the channel
+	// ("telegram:123456"). Empty means everyone.
+	Admins FlexibleStringSlice `json:"admins" env:"PICOCLAW_COMMANDS_ADMINS"`
+}
+
+// PermissionsConfig assigns senders to roles that limit the tools and
+// commands they may use. Without roles every allowed sender may use all
+// of them.
+type PermissionsConfig struct {
+	// DefaultRole applies to senders who are not a member of any role.
+	// Empty leaves them unrestricted.
+	DefaultRole string                `json:"default_role" env:"PICOCLAW_PERMISSIONS_DEFAULT_ROLE"`
+	Roles       map[string]RoleConfig `json:"roles,omitempty"`
+}
+
+// RoleConfig is what members of a role may do. Tools and Commands list
+// names, with "*" allowing all.
+type RoleConfig struct {
+	// Members are sender IDs as in commands.admins, optionally prefixed
+	// with the channel ("telegram:123456").
+	Members  FlexibleStringSlice `json:"members"`
+	Tools    FlexibleStringSlice `json:"tools"`
+	Commands FlexibleStringSlice `json:"commands"`
+	// MaxToolIterations caps the tool calls per message. Zero uses
+	// agents.defaults.max_tool_iterations.
+	MaxToolIterations int `json:"max_tool_iterations,omitempty"`
+}
+
+// IdentityConfig controls accounts linked across channels with /link.
+type IdentityConfig struct {
+	// SharedSessions gives a linked person one conversation across the
+	// direct chats of all their accounts.
+	SharedSessions bool `json:"shared_sessions" env:"PICOCLAW_IDENTITY_SHARED_SESSIONS"`
+}
+
+// PairingConfig controls pairing, which lets unknown senders ask for
+// access with a code the owner approves.
+type PairingConfig struct {
+	Enabled bool `json:"enabled" env:"PICOCLAW_PAIRING_ENABLED"`
+}
+
+// OutboxConfig controls delivery of outbound messages to channels.
+type OutboxConfig struct {
+	MaxAttempts int `json:"max_attempts" env:"PICOCLAW_OUTBOX_MAX_ATTEMPTS"`
+	MaxAge      int `json:"max_age" env:"PICOCLAW_OUTBOX_MAX_AGE"` // minutes before an undelivered message is given up
+	// RateLimits overrides the built-in send rate of a channel, keyed by
+	// channel name.
+	RateLimits map[string]RateLimitConfig `json:"rate_limits,omitempty"`
+}
+
+// RateLimitConfig limits sends per second, across a channel and per chat.
+// Zero means unlimited.
+type RateLimitConfig struct {
+	PerSecond        float64 `json:"per_second"`
+	PerChatPerSecond float64 `json:"per_chat_per_second"`
+}
+
 type DevicesConfig struct {
 	Enabled    bool `json:"enabled" env:"PICOCLAW_DEVICES_ENABLED"`
 	MonitorUSB bool `json:"monitor_usb" env:"PICOCLAW_DEVICES_MONITOR_USB"`
@@ -188,6 +418,8 @@ type ProviderConfig struct {
 	Proxy       string `json:"proxy,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_PROXY"`
 	AuthMethod  string `json:"auth_method,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_AUTH_METHOD"`
 	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+	// SafetySettings maps harm categories to block thresholds, only for Gemini
+	SafetySettings map[string]string `json:"safety_settings,omitempty"`
 }
 
 type GatewayConfig struct {
@@ -242,9 +474,12 @@ func DefaultConfig() *Config {
 		},
 		Channels: ChannelsConfig{
 			WhatsApp: WhatsAppConfig{
-				Enabled:   false,
-				BridgeURL: "ws://localhost:3001",
-				AllowFrom: FlexibleStringSlice{},
+				Enabled:        false,
+				Mode:           "bridge",
+				BridgeURL:      "ws://localhost:3001",
+				StorePath:      "~/.picoclaw/whatsapp/session.db",
+				RequireMention: true,
+				AllowFrom:      FlexibleStringSlice{},
 			},
 			Telegram: TelegramConfig{
 				Enabled:   false,
@@ -296,14 +531,49 @@ func DefaultConfig() *Config {
 				WebhookPort:        18791,
 				WebhookPath:        "/webhook/line",
 				AllowFrom:          FlexibleStringSlice{},
+				Groups:             GroupsConfig{GroupPolicyConfig: GroupPolicyConfig{Mode: "mention"}},
 			},
 			OneBot: OneBotConfig{
 				Enabled:            false,
+				Mode:               "ws",
 				WSUrl:              "ws://127.0.0.1:3001",
+				Path:               "/onebot",
 				AccessToken:        "",
 				ReconnectInterval:  5,
 				GroupTriggerPrefix: []string{},
 				AllowFrom:          FlexibleStringSlice{},
+				Groups:             GroupsConfig{GroupPolicyConfig: GroupPolicyConfig{Mode: "mention"}},
+			},
+			Email: EmailConfig{
+				Enabled:      false,
+				IMAPPort:     993,
+				IMAPTLS:      true,
+				SMTPPort:     587,
+				Mailbox:      "INBOX",
+				PollInterval: 60,
+				AllowFrom:    FlexibleStringSlice{},
+			},
+			Matrix: MatrixConfig{
+				Enabled:        false,
+				Homeserver:     "https://matrix.org",
+				RequireMention: true,
+				AutoJoin:       true,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			Signal: SignalConfig{
+				Enabled:           false,
+				RPCAddress:        "127.0.0.1:7583",
+				ReconnectInterval: 5,
+				AllowFrom:         FlexibleStringSlice{},
+			},
+			HTTP: HTTPConfig{
+				Enabled:        false,
+				RequestTimeout: 300,
+				AllowFrom:      FlexibleStringSlice{},
+			},
+			WebChat: WebChatConfig{
+				Enabled:     false,
+				MaxUploadMB: 20,
 			},
 		},
 		Providers: ProvidersConfig{
@@ -347,6 +617,10 @@ func DefaultConfig() *Config {
 			Enabled:  true,
 			Interval: 30, // default 30 minutes
 		},
+		Outbox: OutboxConfig{
+			MaxAttempts: 8,
+			MaxAge:      60,
+		},
 		Devices: DevicesConfig{
 			Enabled:    false,
 			MonitorUSB: true,
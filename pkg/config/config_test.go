@@ -204,3 +204,43 @@ func TestConfig_Complete(t *testing.T) {
 		t.Error("Heartbeat should be enabled by default")
 	}
 }
+
+// TestProviderEntries_MigratesLegacyFields verifies the fixed provider fields become named entries
+func TestProviderEntries_MigratesLegacyFields(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Providers.Groq.APIKey = "gsk_test"
+	cfg.Providers.OpenAI.AuthMethod = "oauth"
+	cfg.ProviderList = []ProviderEntry{
+		{Name: "groq", Protocol: ProtocolOpenAIChat, APIBase: "https://proxy.example/v1", APIKey: "override"},
+	}
+
+	byName := make(map[string]ProviderEntry)
+	for _, e := range cfg.ProviderEntries() {
+		byName[e.Name] = e
+	}
+
+	if byName["groq"].APIKey != "override" {
+		t.Errorf("explicit entry should shadow legacy groq field, got %+v", byName["groq"])
+	}
+	if byName["openai"].Protocol != ProtocolOpenAIResponses {
+		t.Errorf("openai with auth_method should use %q, got %q", ProtocolOpenAIResponses, byName["openai"].Protocol)
+	}
+	if _, ok := byName["anthropic"]; ok {
+		t.Error("unconfigured legacy providers should not produce entries")
+	}
+	if byName["claude-cli"].Protocol != ProtocolCLI {
+		t.Error("claude-cli entry should always be available")
+	}
+}
+
+func TestAgentDefaults_Merge(t *testing.T) {
+	defaults := AgentDefaults{Model: "m", ReasoningEffort: "high", ThinkingBudget: 16000}
+
+	got := defaults.Merge(AgentConfig{ReasoningEffort: "low"})
+	if got.Model != "m" || got.ReasoningEffort != "low" || got.ThinkingBudget != 16000 {
+		t.Errorf("Merge = %+v", got)
+	}
+	if got := defaults.Merge(AgentConfig{}); got != defaults {
+		t.Errorf("empty agent config changed the defaults: %+v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+// Wire protocols a provider entry can speak. The providers package maps
+// each protocol to a client implementation.
+const (
+	ProtocolOpenAIChat        = "openai-chat"
+	ProtocolOpenAIResponses   = "openai-responses"
+	ProtocolAnthropicMessages = "anthropic-messages"
+	ProtocolOllama            = "ollama"
+	ProtocolGemini            = "gemini"
+	ProtocolCLI               = "cli"
+	ProtocolGitHubCopilot     = "github-copilot"
+)
+
+// ProviderEntry is a named LLM endpoint. Models are addressed as
+// "<name>/<model>", or by a bare model name listed in Models or Aliases.
+type ProviderEntry struct {
+	Name        string            `json:"name"`
+	Protocol    string            `json:"protocol"`
+	APIBase     string            `json:"api_base,omitempty"`
+	APIKey      string            `json:"api_key,omitempty"`
+	AuthMethod  string            `json:"auth_method,omitempty"` // "", "oauth", "token" or "codex-cli"
+	AuthHeader  string            `json:"auth_header,omitempty"` // send the key raw in this header instead of "Authorization: Bearer"
+	Proxy       string            `json:"proxy,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	Models      []string          `json:"models,omitempty"`
+	Aliases     map[string]string `json:"aliases,omitempty"` // alias -> upstream model id
+	Command     string            `json:"command,omitempty"` // cli protocol only: "claude" or "codex"
+	ConnectMode string            `json:"connect_mode,omitempty"`
+	// SafetySettings maps Gemini harm categories to block thresholds.
+	SafetySettings map[string]string `json:"safety_settings,omitempty"`
+	// Legacy is set on entries migrated from the fixed "providers" fields,
+	// which keep routing models the way those fields always did.
+	Legacy bool `json:"-"`
+}
+
+// ResolveModel maps a model requested from this entry to the upstream model
+// id by applying aliases. The "<name>/" prefix is removed beforehand by the
+// caller that chose the entry by it.
+func (e ProviderEntry) ResolveModel(model string) string {
+	if target, ok := e.Aliases[model]; ok && target != "" {
+		return target
+	}
+	return model
+}
+
+// ServesModel reports whether the entry declares model in Models or Aliases.
+func (e ProviderEntry) ServesModel(model string) bool {
+	if _, ok := e.Aliases[model]; ok {
+		return true
+	}
+	for _, m := range e.Models {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+type legacyProvider struct {
+	name     string
+	protocol string
+	apiBase  string
+	cfg      func(p *ProvidersConfig) ProviderConfig
+}
+
+// legacyProviders lists the fixed ProvidersConfig fields in the order the
+// old model-name matching used to consult them.
+var legacyProviders = []legacyProvider{
+	{"moonshot", ProtocolOpenAIChat, "https://api.moonshot.cn/v1", func(p *ProvidersConfig) ProviderConfig { return p.Moonshot }},
+	{"openrouter", ProtocolOpenAIChat, "https://openrouter.ai/api/v1", func(p *ProvidersConfig) ProviderConfig { return p.OpenRouter }},
+	{"anthropic", ProtocolAnthropicMessages, "", func(p *ProvidersConfig) ProviderConfig { return p.Anthropic }},
+	{"openai", ProtocolOpenAIChat, "https://api.openai.com/v1", func(p *ProvidersConfig) ProviderConfig { return p.OpenAI }},
+	{"gemini", ProtocolGemini, "https://generativelanguage.googleapis.com/v1beta", func(p *ProvidersConfig) ProviderConfig { return p.Gemini }},
+	{"zhipu", ProtocolOpenAIChat, "https://open.bigmodel.cn/api/paas/v4", func(p *ProvidersConfig) ProviderConfig { return p.Zhipu }},
+	{"groq", ProtocolOpenAIChat, "https://api.groq.com/openai/v1", func(p *ProvidersConfig) ProviderConfig { return p.Groq }},
+	{"nvidia", ProtocolOpenAIChat, "https://integrate.api.nvidia.com/v1", func(p *ProvidersConfig) ProviderConfig { return p.Nvidia }},
+	{"ollama", ProtocolOllama, "http://localhost:11434/v1", func(p *ProvidersConfig) ProviderConfig { return p.Ollama }},
+	{"vllm", ProtocolOpenAIChat, "", func(p *ProvidersConfig) ProviderConfig { return p.VLLM }},
+	{"shengsuanyun", ProtocolOpenAIChat, "https://router.shengsuanyun.com/api/v1", func(p *ProvidersConfig) ProviderConfig { return p.ShengSuanYun }},
+	{"deepseek", ProtocolOpenAIChat, "https://api.deepseek.com/v1", func(p *ProvidersConfig) ProviderConfig { return p.DeepSeek }},
+}
+
+// ProviderEntries returns the configured provider_list followed by entries
+// migrated from the legacy per-vendor fields under "providers". An explicit
+// entry shadows a legacy one with the same name.
+func (c *Config) ProviderEntries() []ProviderEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entries := make([]ProviderEntry, 0, len(c.ProviderList)+len(legacyProviders)+3)
+	seen := make(map[string]bool)
+	for _, e := range c.ProviderList {
+		if e.Name == "" || seen[e.Name] {
+			continue
+		}
+		seen[e.Name] = true
+		entries = append(entries, e)
+	}
+
+	add := func(e ProviderEntry) {
+		if !seen[e.Name] {
+			seen[e.Name] = true
+			entries = append(entries, e)
+		}
+	}
+
+	for _, lp := range legacyProviders {
+		pc := lp.cfg(&c.Providers)
+		if pc.APIKey == "" && pc.APIBase == "" && pc.AuthMethod == "" {
+			continue
+		}
+		e := ProviderEntry{
+			Name:           lp.name,
+			Protocol:       lp.protocol,
+			APIBase:        pc.APIBase,
+			APIKey:         pc.APIKey,
+			AuthMethod:     pc.AuthMethod,
+			Proxy:          pc.Proxy,
+			SafetySettings: pc.SafetySettings,
+			Legacy:         true,
+		}
+		if e.APIBase == "" {
+			e.APIBase = lp.apiBase
+		}
+		if lp.name == "openai" && pc.AuthMethod != "" {
+			e.Protocol = ProtocolOpenAIResponses
+		}
+		add(e)
+	}
+
+	add(ProviderEntry{Name: "claude-cli", Protocol: ProtocolCLI, Command: "claude"})
+	add(ProviderEntry{Name: "codex-cli", Protocol: ProtocolCLI, Command: "codex"})
+
+	copilot := c.Providers.GitHubCopilot
+	if copilot.APIBase == "" {
+		copilot.APIBase = "localhost:4321"
+	}
+	add(ProviderEntry{
+		Name:        "github_copilot",
+		Protocol:    ProtocolGitHubCopilot,
+		APIBase:     copilot.APIBase,
+		ConnectMode: copilot.ConnectMode,
+	})
+
+	return entries
+}
@@ -11,9 +11,10 @@ import (
 
 type Server struct {
 	server    *http.Server
+	mux       *http.ServeMux
 	mu        sync.RWMutex
 	ready     bool
-	checks    map[string]Check
+	checks    map[string]func() (bool, string)
 	startTime time.Time
 }
 
@@ -33,8 +34,9 @@ type StatusResponse struct {
 func NewServer(host string, port int) *Server {
 	mux := http.NewServeMux()
 	s := &Server{
+		mux:       mux,
 		ready:     false,
-		checks:    make(map[string]Check),
+		checks:    make(map[string]func() (bool, string)),
 		startTime: time.Now(),
 	}
 
@@ -52,6 +54,12 @@ func NewServer(host string, port int) *Server {
 	return s
 }
 
+// Handle mounts an additional handler on the gateway port. Handlers that
+// hold connections open must extend their own write deadline.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *Server) Start() error {
 	s.mu.Lock()
 	s.ready = true
@@ -90,17 +98,34 @@ func (s *Server) SetReady(ready bool) {
 	s.mu.Unlock()
 }
 
+// RegisterCheck adds a readiness check. checkFn is called on every /ready
+// request and must be quick; /ready fails while any check fails.
 func (s *Server) RegisterCheck(name string, checkFn func() (bool, string)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.checks[name] = checkFn
+}
 
-	status, msg := checkFn()
-	s.checks[name] = Check{
-		Name:      name,
-		Status:    statusString(status),
-		Message:   msg,
-		Timestamp: time.Now(),
+// runChecks evaluates every registered check.
+func (s *Server) runChecks() map[string]Check {
+	s.mu.RLock()
+	fns := make(map[string]func() (bool, string), len(s.checks))
+	for name, fn := range s.checks {
+		fns[name] = fn
 	}
+	s.mu.RUnlock()
+
+	checks := make(map[string]Check, len(fns))
+	for name, fn := range fns {
+		status, msg := fn()
+		checks[name] = Check{
+			Name:      name,
+			Status:    statusString(status),
+			Message:   msg,
+			Timestamp: time.Now(),
+		}
+	}
+	return checks
 }
 
 func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -121,11 +146,8 @@ func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
 
 	s.mu.RLock()
 	ready := s.ready
-	checks := make(map[string]Check)
-	for k, v := range s.checks {
-		checks[k] = v
-	}
 	s.mu.RUnlock()
+	checks := s.runChecks()
 
 	if !ready {
 		w.WriteHeader(http.StatusServiceUnavailable)
@@ -0,0 +1,49 @@
+package heartbeat
+
+import (
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// Decision is the structured outcome of a heartbeat run.
+type Decision struct {
+	Notify  bool   `json:"notify"`
+	Message string `json:"message"`
+}
+
+// DecisionSchema constrains the final reply of a heartbeat run.
+var DecisionSchema = &providers.JSONSchema{
+	Name:        "heartbeat_decision",
+	Description: "Report whether the heartbeat found anything the user should be told.",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"notify": map[string]interface{}{
+				"type":        "boolean",
+				"description": "true if the user needs to see message",
+			},
+			"message": map[string]interface{}{
+				"type":        "string",
+				"description": "What to tell the user; empty when notify is false",
+			},
+		},
+		"required": []interface{}{"notify", "message"},
+	},
+}
+
+// ParseDecision reads the final reply of a heartbeat run. The legacy
+// HEARTBEAT_OK reply and unparseable replies mean there is nothing to send.
+func ParseDecision(response string) (Decision, error) {
+	if strings.TrimSpace(response) == "HEARTBEAT_OK" {
+		return Decision{}, nil
+	}
+	var d Decision
+	if err := DecisionSchema.Parse(response, &d); err != nil {
+		return Decision{}, err
+	}
+	if strings.TrimSpace(d.Message) == "" {
+		d.Notify = false
+	}
+	return d, nil
+}
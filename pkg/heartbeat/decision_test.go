@@ -0,0 +1,27 @@
+package heartbeat
+
+import "testing"
+
+func TestParseDecision(t *testing.T) {
+	tests := []struct {
+		response string
+		want     Decision
+		wantErr  bool
+	}{
+		{`HEARTBEAT_OK`, Decision{}, false},
+		{`{"notify": false, "message": ""}`, Decision{}, false},
+		{`{"notify": true, "message": "Battery low"}`, Decision{Notify: true, Message: "Battery low"}, false},
+		{`{"notify": true, "message": "  "}`, Decision{Message: "  "}, false},
+		{`All good!`, Decision{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDecision(tt.response)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDecision(%q) error = %v, wantErr %v", tt.response, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDecision(%q) = %+v, want %+v", tt.response, got, tt.want)
+		}
+	}
+}
@@ -241,7 +241,8 @@ Current time: %s
 
 You are a proactive AI assistant. This is a scheduled heartbeat check.
 Review the following tasks and execute any necessary actions using available skills.
-If there is nothing that requires attention, respond ONLY with: HEARTBEAT_OK
+When done, respond ONLY with a JSON object: {"notify": false, "message": ""} if nothing
+requires the user's attention, otherwise {"notify": true, "message": "<what to tell the user>"}.
 
 %s
 `, now, content)
@@ -268,7 +269,7 @@ This file contains tasks for the heartbeat service to check periodically.
 - For complex tasks that may take time, use the spawn tool to create a subagent.
 - The spawn tool is async - subagent results will be sent to the user automatically.
 - After spawning a subagent, CONTINUE to process remaining tasks.
-- Only respond with HEARTBEAT_OK when ALL tasks are done AND nothing needs attention.
+- Only answer with "notify": false when ALL tasks are done AND nothing needs attention.
 
 ---
 
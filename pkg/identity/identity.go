@@ -0,0 +1,318 @@
+// Package identity links the accounts one person uses on different
+// channels, so the agent can treat them as the same user.
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// CodeTTL is how long a link code stays valid.
+const CodeTTL = 10 * time.Minute
+
+// MaxCodeAttempts is how many wrong codes a sender may enter before their
+// pending link is dropped and has to be started again.
+const MaxCodeAttempts = 5
+
+var (
+	ErrNoDirectChat = errors.New("that account has not messaged the bot directly yet")
+	ErrSameAccount  = errors.New("cannot link an account to itself")
+	ErrInvalidCode  = errors.New("invalid or expired code")
+	ErrTooManyTries = errors.New("too many wrong codes, start the link again")
+	ErrNotLinked    = errors.New("this account is not linked")
+)
+
+// Person is one user and the accounts they are known by, each written as
+// "channel:senderID".
+type Person struct {
+	ID       string    `json:"id"`
+	Accounts []string  `json:"accounts"`
+	Created  time.Time `json:"created"`
+}
+
+type pendingLink struct {
+	from     string
+	to       string
+	expires  time.Time
+	attempts int
+}
+
+type registryFile struct {
+	People      []*Person         `json:"people"`
+	DirectChats map[string]string `json:"direct_chats"`
+}
+
+// Registry stores linked identities in <dir>/identities.json.
+type Registry struct {
+	path string
+
+	mu          sync.Mutex
+	people      map[string]*Person
+	accounts    map[string]string // account -> person ID
+	directChats map[string]string // account -> DM chat ID
+	pending     map[string]pendingLink
+	now         func() time.Time
+}
+
+// NewRegistry loads the registry in dir, starting empty if there is none.
+func NewRegistry(dir string) *Registry {
+	r := &Registry{
+		path:        filepath.Join(dir, "identities.json"),
+		people:      make(map[string]*Person),
+		accounts:    make(map[string]string),
+		directChats: make(map[string]string),
+		pending:     make(map[string]pendingLink),
+		now:         time.Now,
+	}
+
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return r
+	}
+	var file registryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		logger.ErrorCF("identity", "Identity file unreadable, starting empty", map[string]interface{}{
+			"path":  r.path,
+			"error": err.Error(),
+		})
+		return r
+	}
+	for _, p := range file.People {
+		r.people[p.ID] = p
+		for _, account := range p.Accounts {
+			r.accounts[account] = p.ID
+		}
+	}
+	for account, chatID := range file.DirectChats {
+		r.directChats[account] = chatID
+	}
+	return r
+}
+
+// Account returns the registry key for a sender on a channel. Compound
+// sender IDs such as "123|alice" are keyed by their ID part.
+func Account(channel, senderID string) string {
+	id, _, _ := strings.Cut(senderID, "|")
+	return channel + ":" + id
+}
+
+// SeenDirect records the chat in which a sender talks to the bot directly,
+// which is where link codes for that account are sent.
+func (r *Registry) SeenDirect(channel, senderID, chatID string) {
+	account := Account(channel, senderID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.directChats[account] == chatID {
+		return
+	}
+	r.directChats[account] = chatID
+	r.saveLocked()
+}
+
+// Lookup returns the person a sender is linked to.
+func (r *Registry) Lookup(channel, senderID string) (Person, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.people[r.accounts[Account(channel, senderID)]]
+	if !ok {
+		return Person{}, false
+	}
+	return Person{ID: p.ID, Accounts: append([]string(nil), p.Accounts...), Created: p.Created}, true
+}
+
+// StartLink begins linking the sender's account to target, an account
+// on another channel. It returns a one-time code and the chat on target's
+// channel to send it to; the sender proves they own target by entering
+// the code with CompleteLink.
+func (r *Registry) StartLink(channel, senderID, targetChannel, targetSenderID string) (code, chatID string, err error) {
+	from := Account(channel, senderID)
+	to := Account(targetChannel, targetSenderID)
+	if from == to {
+		return "", "", ErrSameAccount
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	chatID, ok := r.directChats[to]
+	if !ok {
+		return "", "", ErrNoDirectChat
+	}
+
+	r.expireLocked()
+	for code, link := range r.pending {
+		if link.from == from {
+			delete(r.pending, code)
+		}
+	}
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", "", err
+		}
+		code = fmt.Sprintf("%06d", n.Int64())
+		if _, taken := r.pending[code]; !taken {
+			break
+		}
+	}
+	r.pending[code] = pendingLink{from: from, to: to, expires: r.now().Add(CodeTTL)}
+	return code, chatID, nil
+}
+
+// CompleteLink links the accounts of a pending link if code was issued to
+// the sender. Existing links on either side are merged into one person.
+// Wrong codes count against the sender's pending link, which is dropped
+// after MaxCodeAttempts of them.
+func (r *Registry) CompleteLink(channel, senderID, code string) (Person, error) {
+	from := Account(channel, senderID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expireLocked()
+	code = strings.TrimSpace(code)
+	link, ok := r.pending[code]
+	if !ok || link.from != from {
+		return Person{}, r.failAttemptLocked(from)
+	}
+	delete(r.pending, code)
+
+	person := r.people[r.accounts[from]]
+	other := r.people[r.accounts[link.to]]
+	switch {
+	case person == nil && other == nil:
+		person = &Person{ID: newID(), Created: r.now()}
+		r.people[person.ID] = person
+	case person == nil:
+		person = other
+	case other != nil && other != person:
+		for _, account := range other.Accounts {
+			person.Accounts = append(person.Accounts, account)
+			r.accounts[account] = person.ID
+		}
+		delete(r.people, other.ID)
+	}
+	for _, account := range []string{from, link.to} {
+		if r.accounts[account] != person.ID || !contains(person.Accounts, account) {
+			person.Accounts = append(person.Accounts, account)
+			r.accounts[account] = person.ID
+		}
+	}
+	sort.Strings(person.Accounts)
+
+	if err := r.saveLocked(); err != nil {
+		return Person{}, err
+	}
+	return Person{ID: person.ID, Accounts: append([]string(nil), person.Accounts...), Created: person.Created}, nil
+}
+
+// failAttemptLocked counts a wrong code against the sender's pending link
+// and drops the link once the sender has used up their attempts.
+func (r *Registry) failAttemptLocked(from string) error {
+	for code, link := range r.pending {
+		if link.from != from {
+			continue
+		}
+		link.attempts++
+		if link.attempts >= MaxCodeAttempts {
+			delete(r.pending, code)
+			logger.WarnCF("identity", "Dropped link after too many wrong codes", map[string]interface{}{
+				"from": from,
+				"to":   link.to,
+			})
+			return ErrTooManyTries
+		}
+		r.pending[code] = link
+	}
+	return ErrInvalidCode
+}
+
+// Unlink removes the sender's account from the person it is linked to.
+func (r *Registry) Unlink(channel, senderID string) error {
+	account := Account(channel, senderID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	person, ok := r.people[r.accounts[account]]
+	if !ok {
+		return ErrNotLinked
+	}
+	delete(r.accounts, account)
+	kept := person.Accounts[:0]
+	for _, a := range person.Accounts {
+		if a != account {
+			kept = append(kept, a)
+		}
+	}
+	person.Accounts = kept
+	if len(person.Accounts) < 2 {
+		// A person with one account is just that account again
+		for _, a := range person.Accounts {
+			delete(r.accounts, a)
+		}
+		delete(r.people, person.ID)
+	}
+	return r.saveLocked()
+}
+
+func (r *Registry) expireLocked() {
+	now := r.now()
+	for code, link := range r.pending {
+		if now.After(link.expires) {
+			delete(r.pending, code)
+		}
+	}
+}
+
+func (r *Registry) saveLocked() error {
+	file := registryFile{DirectChats: r.directChats}
+	for _, p := range r.people {
+		file.People = append(file.People, p)
+	}
+	sort.Slice(file.People, func(i, j int) bool { return file.People[i].ID < file.People[j].ID })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		logger.ErrorCF("identity", "Failed to save identities", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
+	}
+	return nil
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
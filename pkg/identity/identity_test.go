@@ -0,0 +1,119 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLinkAccounts(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRegistry(dir)
+
+	if _, _, err := r.StartLink("telegram", "42", "discord", "777"); !errors.Is(err, ErrNoDirectChat) {
+		t.Fatalf("StartLink before a DM: %v", err)
+	}
+
+	r.SeenDirect("discord", "777", "dm-777")
+	code, chatID, err := r.StartLink("telegram", "42|alice", "discord", "777")
+	if err != nil || chatID != "dm-777" {
+		t.Fatalf("StartLink = %q, %q, %v", code, chatID, err)
+	}
+
+	if _, err := r.CompleteLink("discord", "777", code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("code accepted from the wrong account: %v", err)
+	}
+	person, err := r.CompleteLink("telegram", "42", code)
+	if err != nil {
+		t.Fatalf("CompleteLink: %v", err)
+	}
+	if len(person.Accounts) != 2 || person.Accounts[0] != "discord:777" || person.Accounts[1] != "telegram:42" {
+		t.Errorf("accounts = %v", person.Accounts)
+	}
+	if _, err := r.CompleteLink("telegram", "42", code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("code reused: %v", err)
+	}
+
+	reloaded := NewRegistry(dir)
+	got, ok := reloaded.Lookup("discord", "777")
+	if !ok || got.ID != person.ID {
+		t.Errorf("after reload Lookup = %+v, %v", got, ok)
+	}
+
+	if err := reloaded.Unlink("telegram", "42"); err != nil {
+		t.Fatalf("Unlink: %v", err)
+	}
+	if _, ok := reloaded.Lookup("discord", "777"); ok {
+		t.Error("single remaining account still linked")
+	}
+}
+
+func TestLinkMergesPeople(t *testing.T) {
+	r := NewRegistry(t.TempDir())
+	link := func(ch, sender, toCh, toSender string) Person {
+		r.SeenDirect(toCh, toSender, toSender)
+		code, _, err := r.StartLink(ch, sender, toCh, toSender)
+		if err != nil {
+			t.Fatalf("StartLink: %v", err)
+		}
+		p, err := r.CompleteLink(ch, sender, code)
+		if err != nil {
+			t.Fatalf("CompleteLink: %v", err)
+		}
+		return p
+	}
+
+	link("telegram", "1", "discord", "2")
+	link("slack", "3", "line", "4")
+	merged := link("telegram", "1", "slack", "3")
+	if len(merged.Accounts) != 4 {
+		t.Errorf("accounts = %v", merged.Accounts)
+	}
+	if p, _ := r.Lookup("line", "4"); p.ID != merged.ID {
+		t.Errorf("line:4 belongs to %q, want %q", p.ID, merged.ID)
+	}
+}
+
+func TestLinkCodeExpires(t *testing.T) {
+	r := NewRegistry(t.TempDir())
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	r.SeenDirect("discord", "777", "dm-777")
+	code, _, err := r.StartLink("telegram", "42", "discord", "777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(CodeTTL + time.Second)
+	if _, err := r.CompleteLink("telegram", "42", code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("expired code accepted: %v", err)
+	}
+}
+
+func TestLinkDroppedAfterWrongCodes(t *testing.T) {
+	r := NewRegistry(t.TempDir())
+	r.SeenDirect("discord", "777", "dm-777")
+	code, _, err := r.StartLink("telegram", "42", "discord", "777")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "000001"
+	}
+
+	for i := 1; i < MaxCodeAttempts; i++ {
+		if _, err := r.CompleteLink("telegram", "42", wrong); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	if _, err := r.CompleteLink("telegram", "42", wrong); !errors.Is(err, ErrTooManyTries) {
+		t.Fatalf("last attempt: %v, want ErrTooManyTries", err)
+	}
+	if _, err := r.CompleteLink("telegram", "42", code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("right code accepted after the link was dropped: %v", err)
+	}
+	if _, ok := r.Lookup("discord", "777"); ok {
+		t.Error("accounts linked after too many wrong codes")
+	}
+}
@@ -25,6 +25,16 @@ func NewClaudeCliProvider(workspace string) *ClaudeCliProvider {
 
 // Chat implements LLMProvider.Chat by executing the claude CLI.
 func (p *ClaudeCliProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if len(tools) == 0 {
+		return p.chatOnce(ctx, messages, tools, model)
+	}
+	return chatWithToolCallRepair(messages, func(msgs []Message) (*LLMResponse, error) {
+		return p.chatOnce(ctx, msgs, tools, model)
+	})
+}
+
+// chatOnce runs the CLI a single time.
+func (p *ClaudeCliProvider) chatOnce(ctx context.Context, messages []Message, tools []ToolDefinition, model string) (*LLMResponse, error) {
 	systemPrompt := p.buildSystemPrompt(messages, tools)
 	prompt := p.messagesToPrompt(messages)
 
@@ -182,12 +192,29 @@ func (p *ClaudeCliProvider) stripToolCallsJSON(text string) string {
 }
 
 // findMatchingBrace finds the index after the closing brace matching the opening brace at pos.
+// Braces inside JSON strings are ignored.
 func findMatchingBrace(text string, pos int) int {
 	depth := 0
+	inString, escaped := false, false
 	for i := pos; i < len(text); i++ {
-		if text[i] == '{' {
+		c := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
 			depth++
-		} else if text[i] == '}' {
+		case '}':
 			depth--
 			if depth == 0 {
 				return i + 1
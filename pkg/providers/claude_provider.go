@@ -49,6 +49,9 @@ func (p *ClaudeProvider) Chat(ctx context.Context, messages []Message, tools []T
 		return nil, fmt.Errorf("claude API call: %w", err)
 	}
 
+	if schema := responseSchema(options); schema != nil {
+		return structuredFromToolCall(parseClaudeResponse(resp), schema), nil
+	}
 	return parseClaudeResponse(resp), nil
 }
 
@@ -59,17 +62,32 @@ func (p *ClaudeProvider) GetDefaultModel() string {
 func buildClaudeParams(messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (anthropic.MessageNewParams, error) {
 	var system []anthropic.TextBlockParam
 	var anthropicMessages []anthropic.MessageParam
+	turnStart := -1 // index of the latest user turn
+	budget := thinkingBudget(options)
+	schema := responseSchema(options)
+	if schema != nil {
+		// Structured output is forced tool use, which thinking does not allow
+		budget = 0
+	}
 
 	for _, msg := range messages {
 		switch msg.Role {
 		case "system":
-			system = append(system, anthropic.TextBlockParam{Text: msg.Content})
+			if n := msg.CachePrefixLen; n > 0 && n < len(msg.Content) {
+				system = append(system,
+					anthropic.TextBlockParam{Text: msg.Content[:n], CacheControl: anthropic.NewCacheControlEphemeralParam()},
+					anthropic.TextBlockParam{Text: msg.Content[n:]},
+				)
+			} else {
+				system = append(system, anthropic.TextBlockParam{Text: msg.Content})
+			}
 		case "user":
 			if msg.ToolCallID != "" {
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false)),
 				)
 			} else {
+				turnStart = len(anthropicMessages)
 				anthropicMessages = append(anthropicMessages,
 					anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)),
 				)
@@ -77,6 +95,11 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 		case "assistant":
 			if len(msg.ToolCalls) > 0 {
 				var blocks []anthropic.ContentBlockParamUnion
+				// With thinking enabled, the API requires the signed
+				// thinking blocks to lead the assistant tool_use turn.
+				if budget > 0 {
+					blocks = append(blocks, claudeThinkingBlocks(msg.ThinkingBlocks)...)
+				}
 				if msg.Content != "" {
 					blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
 				}
@@ -108,20 +131,94 @@ func buildClaudeParams(messages []Message, tools []ToolDefinition, model string,
 	}
 
 	if len(system) > 0 {
+		if !hasCacheControl(system) {
+			system[len(system)-1].CacheControl = anthropic.NewCacheControlEphemeralParam()
+		}
 		params.System = system
 	}
 
-	if temp, ok := options["temperature"].(float64); ok {
+	if budget > 0 {
+		// max_tokens must leave room beyond the thinking budget, and
+		// temperature cannot be changed while thinking is enabled.
+		if maxTokens <= int64(budget) {
+			params.MaxTokens = int64(budget) + maxTokens
+		}
+		params.Thinking = anthropic.ThinkingConfigParamOfEnabled(int64(budget))
+	} else if temp, ok := options["temperature"].(float64); ok {
 		params.Temperature = anthropic.Float(temp)
 	}
 
+	if schema != nil {
+		// Without other tools the schema tool is forced; alongside them the
+		// model must call some tool, and answers through the schema tool.
+		if len(tools) == 0 {
+			params.ToolChoice = anthropic.ToolChoiceParamOfTool(schema.Name)
+		} else {
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfAny: &anthropic.ToolChoiceAnyParam{}}
+		}
+		tools = append(append([]ToolDefinition(nil), tools...), schema.asToolDefinition())
+	}
+
 	if len(tools) > 0 {
 		params.Tools = translateToolsForClaude(tools)
+		if last := params.Tools[len(params.Tools)-1].OfTool; last != nil {
+			last.CacheControl = anthropic.NewCacheControlEphemeralParam()
+		}
 	}
 
+	markClaudeHistoryCache(anthropicMessages, turnStart)
+
 	return params, nil
 }
 
+func claudeThinkingBlocks(thinking []ThinkingBlock) []anthropic.ContentBlockParamUnion {
+	var blocks []anthropic.ContentBlockParamUnion
+	for _, tb := range thinking {
+		switch tb.Type {
+		case "thinking":
+			blocks = append(blocks, anthropic.NewThinkingBlock(tb.Signature, tb.Thinking))
+		case "redacted_thinking":
+			blocks = append(blocks, anthropic.NewRedactedThinkingBlock(tb.Data))
+		}
+	}
+	return blocks
+}
+
+func hasCacheControl(blocks []anthropic.TextBlockParam) bool {
+	for _, b := range blocks {
+		if b.CacheControl.Type != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// markClaudeHistoryCache places cache breakpoints on the history preceding
+// the current user turn and on the newest message, so the conversation so
+// far is read from cache and each tool-loop iteration extends it. Together
+// with the system prompt and tools this stays within the API's limit of
+// four breakpoints.
+func markClaudeHistoryCache(anthropicMessages []anthropic.MessageParam, turnStart int) {
+	if len(anthropicMessages) == 0 {
+		return
+	}
+
+	mark := func(i int) {
+		blocks := anthropicMessages[i].Content
+		if len(blocks) == 0 {
+			return
+		}
+		if cc := blocks[len(blocks)-1].GetCacheControl(); cc != nil {
+			*cc = anthropic.NewCacheControlEphemeralParam()
+		}
+	}
+
+	if turnStart > 0 {
+		mark(turnStart - 1)
+	}
+	mark(len(anthropicMessages) - 1)
+}
+
 func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam {
 	result := make([]anthropic.ToolUnionParam, 0, len(tools))
 	for _, t := range tools {
@@ -149,14 +246,21 @@ func translateToolsForClaude(tools []ToolDefinition) []anthropic.ToolUnionParam
 }
 
 func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
-	var content string
+	var content, reasoning string
 	var toolCalls []ToolCall
+	var thinking []ThinkingBlock
 
 	for _, block := range resp.Content {
 		switch block.Type {
 		case "text":
 			tb := block.AsText()
 			content += tb.Text
+		case "thinking":
+			tb := block.AsThinking()
+			reasoning += tb.Thinking
+			thinking = append(thinking, ThinkingBlock{Type: "thinking", Thinking: tb.Thinking, Signature: tb.Signature})
+		case "redacted_thinking":
+			thinking = append(thinking, ThinkingBlock{Type: "redacted_thinking", Data: block.AsRedactedThinking().Data})
 		case "tool_use":
 			tu := block.AsToolUse()
 			var args map[string]interface{}
@@ -182,14 +286,25 @@ func parseClaudeResponse(resp *anthropic.Message) *LLMResponse {
 	}
 
 	return &LLMResponse{
-		Content:      content,
-		ToolCalls:    toolCalls,
-		FinishReason: finishReason,
-		Usage: &UsageInfo{
-			PromptTokens:     int(resp.Usage.InputTokens),
-			CompletionTokens: int(resp.Usage.OutputTokens),
-			TotalTokens:      int(resp.Usage.InputTokens + resp.Usage.OutputTokens),
-		},
+		Content:        content,
+		ToolCalls:      toolCalls,
+		FinishReason:   finishReason,
+		Usage:          claudeUsage(resp.Usage),
+		Reasoning:      reasoning,
+		ThinkingBlocks: thinking,
+	}
+}
+
+// claudeUsage reports prompt tokens including cached ones, since Anthropic
+// counts cache reads and writes separately from input_tokens.
+func claudeUsage(u anthropic.Usage) *UsageInfo {
+	prompt := int(u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens)
+	return &UsageInfo{
+		PromptTokens:     prompt,
+		CompletionTokens: int(u.OutputTokens),
+		TotalTokens:      prompt + int(u.OutputTokens),
+		CachedTokens:     int(u.CacheReadInputTokens),
+		CacheWriteTokens: int(u.CacheCreationInputTokens),
 	}
 }
 
@@ -102,6 +102,158 @@ func TestBuildClaudeParams_WithTools(t *testing.T) {
 	}
 }
 
+func TestBuildClaudeParams_CacheBreakpoints(t *testing.T) {
+	stable := "You are helpful"
+	messages := []Message{
+		{Role: "system", Content: stable + "\n\nCurrent time: now", CachePrefixLen: len(stable)},
+		{Role: "user", Content: "Earlier question"},
+		{Role: "assistant", Content: "Earlier answer"},
+		{Role: "user", Content: "New question"},
+	}
+	tools := []ToolDefinition{
+		{Type: "function", Function: ToolFunctionDefinition{Name: "a", Parameters: map[string]interface{}{"type": "object"}}},
+		{Type: "function", Function: ToolFunctionDefinition{Name: "b", Parameters: map[string]interface{}{"type": "object"}}},
+	}
+
+	params, err := buildClaudeParams(messages, tools, "claude-sonnet-4-5-20250929", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+
+	if len(params.System) != 2 {
+		t.Fatalf("len(System) = %d, want 2 (stable + volatile)", len(params.System))
+	}
+	if params.System[0].Text != stable || params.System[0].CacheControl.Type == "" {
+		t.Errorf("stable system block = %+v, want cached %q", params.System[0], stable)
+	}
+	if params.System[1].CacheControl.Type != "" {
+		t.Error("volatile system block should not be cached")
+	}
+
+	if params.Tools[0].OfTool.CacheControl.Type != "" || params.Tools[1].OfTool.CacheControl.Type == "" {
+		t.Error("only the last tool definition should carry a cache breakpoint")
+	}
+
+	cached := func(i int) bool {
+		blocks := params.Messages[i].Content
+		return blocks[len(blocks)-1].GetCacheControl().Type != ""
+	}
+	if cached(0) {
+		t.Error("oldest history message should not be a breakpoint")
+	}
+	if !cached(1) {
+		t.Error("history before the current turn should be a breakpoint")
+	}
+	if !cached(2) {
+		t.Error("newest message should be a breakpoint")
+	}
+}
+
+func TestParseClaudeResponse_CacheUsage(t *testing.T) {
+	resp := &anthropic.Message{
+		Usage: anthropic.Usage{
+			InputTokens:              10,
+			CacheReadInputTokens:     1000,
+			CacheCreationInputTokens: 200,
+			OutputTokens:             5,
+		},
+	}
+	result := parseClaudeResponse(resp)
+	if result.Usage.PromptTokens != 1210 {
+		t.Errorf("PromptTokens = %d, want 1210", result.Usage.PromptTokens)
+	}
+	if result.Usage.CachedTokens != 1000 || result.Usage.CacheWriteTokens != 200 {
+		t.Errorf("cache usage = %+v", result.Usage)
+	}
+	if result.Usage.TotalTokens != 1215 {
+		t.Errorf("TotalTokens = %d, want 1215", result.Usage.TotalTokens)
+	}
+}
+
+func TestBuildClaudeParams_Thinking(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "What's the weather?"},
+		{
+			Role:           "assistant",
+			ThinkingBlocks: []ThinkingBlock{{Type: "thinking", Thinking: "need a tool", Signature: "sig"}},
+			ToolCalls:      []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: map[string]interface{}{"city": "SF"}}},
+		},
+		{Role: "tool", Content: `{"temp": 72}`, ToolCallID: "call_1"},
+	}
+	params, err := buildClaudeParams(messages, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"max_tokens":      4096,
+		"temperature":     0.7,
+		"thinking_budget": 8000,
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.Thinking.OfEnabled == nil || params.Thinking.OfEnabled.BudgetTokens != 8000 {
+		t.Fatalf("Thinking = %+v, want enabled with budget 8000", params.Thinking)
+	}
+	if params.MaxTokens <= 8000 {
+		t.Errorf("MaxTokens = %d, want more than the thinking budget", params.MaxTokens)
+	}
+	if params.Temperature.Valid() {
+		t.Error("Temperature should not be set while thinking is enabled")
+	}
+	blocks := params.Messages[1].Content
+	if len(blocks) != 2 || blocks[0].OfThinking == nil || blocks[0].OfThinking.Signature != "sig" {
+		t.Errorf("assistant blocks = %+v, want thinking block before tool_use", blocks)
+	}
+}
+
+func TestBuildClaudeParams_ResponseSchema(t *testing.T) {
+	schema := &JSONSchema{Name: "answer", Schema: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"value": map[string]interface{}{"type": "string"}},
+	}}
+	params, err := buildClaudeParams([]Message{{Role: "user", Content: "hi"}}, nil, "claude-sonnet-4-5-20250929", map[string]interface{}{
+		"response_schema": schema,
+		"thinking_budget": 4000,
+	})
+	if err != nil {
+		t.Fatalf("buildClaudeParams() error: %v", err)
+	}
+	if params.ToolChoice.OfTool == nil || params.ToolChoice.OfTool.Name != "answer" {
+		t.Errorf("ToolChoice = %+v, want forced answer tool", params.ToolChoice)
+	}
+	if len(params.Tools) != 1 || params.Tools[0].OfTool.Name != "answer" {
+		t.Errorf("Tools = %+v", params.Tools)
+	}
+	if params.Thinking.OfEnabled != nil {
+		t.Error("thinking must be off with forced tool use")
+	}
+
+	resp := structuredFromToolCall(&LLMResponse{
+		ToolCalls:    []ToolCall{{ID: "t1", Name: "answer", Arguments: map[string]interface{}{"value": "ok"}}},
+		FinishReason: "tool_calls",
+	}, schema)
+	if resp.Content != `{"value":"ok"}` || len(resp.ToolCalls) != 0 || resp.FinishReason != "stop" {
+		t.Errorf("structuredFromToolCall() = %+v", resp)
+	}
+}
+
+func TestParseClaudeResponse_Thinking(t *testing.T) {
+	var resp anthropic.Message
+	if err := json.Unmarshal([]byte(`{"content":[
+		{"type":"thinking","thinking":"let me think","signature":"sig"},
+		{"type":"redacted_thinking","data":"opaque"},
+		{"type":"text","text":"Answer"}],"stop_reason":"end_turn"}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	result := parseClaudeResponse(&resp)
+	if result.Content != "Answer" {
+		t.Errorf("Content = %q, want %q", result.Content, "Answer")
+	}
+	if result.Reasoning != "let me think" {
+		t.Errorf("Reasoning = %q, want %q", result.Reasoning, "let me think")
+	}
+	if len(result.ThinkingBlocks) != 2 || result.ThinkingBlocks[1].Data != "opaque" {
+		t.Errorf("ThinkingBlocks = %+v", result.ThinkingBlocks)
+	}
+}
+
 func TestParseClaudeResponse_TextOnly(t *testing.T) {
 	resp := &anthropic.Message{
 		Content: []anthropic.ContentBlockUnion{},
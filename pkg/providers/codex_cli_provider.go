@@ -26,6 +26,16 @@ func NewCodexCliProvider(workspace string) *CodexCliProvider {
 
 // Chat implements LLMProvider.Chat by executing the codex CLI in non-interactive mode.
 func (p *CodexCliProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if len(tools) == 0 {
+		return p.chatOnce(ctx, messages, tools, model)
+	}
+	return chatWithToolCallRepair(messages, func(msgs []Message) (*LLMResponse, error) {
+		return p.chatOnce(ctx, msgs, tools, model)
+	})
+}
+
+// chatOnce runs the CLI a single time.
+func (p *CodexCliProvider) chatOnce(ctx context.Context, messages []Message, tools []ToolDefinition, model string) (*LLMResponse, error) {
 	if p.command == "" {
 		return nil, fmt.Errorf("codex command not configured")
 	}
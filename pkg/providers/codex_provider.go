@@ -10,6 +10,7 @@ import (
 	"github.com/openai/openai-go/v3"
 	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/responses"
+	"github.com/openai/openai-go/v3/shared"
 	"github.com/sipeed/picoclaw/pkg/auth"
 	"github.com/sipeed/picoclaw/pkg/logger"
 )
@@ -21,6 +22,9 @@ type CodexProvider struct {
 	client      *openai.Client
 	accountID   string
 	tokenSource func() (string, string, error)
+	// passthroughModel is set for plain Responses API endpoints, which accept
+	// any model id the account has access to.
+	passthroughModel bool
 }
 
 const defaultCodexInstructions = "You are Codex, a coding assistant."
@@ -42,6 +46,23 @@ func NewCodexProvider(token, accountID string) *CodexProvider {
 	}
 }
 
+// NewOpenAIResponsesProvider talks to a standard OpenAI Responses API
+// endpoint with an API key, rather than the ChatGPT Codex backend.
+func NewOpenAIResponsesProvider(apiKey, apiBase string, headers map[string]string) *CodexProvider {
+	opts := []option.RequestOption{
+		option.WithBaseURL(apiBase),
+		option.WithAPIKey(apiKey),
+	}
+	for k, v := range headers {
+		opts = append(opts, option.WithHeader(k, v))
+	}
+	client := openai.NewClient(opts...)
+	return &CodexProvider{
+		client:           &client,
+		passthroughModel: true,
+	}
+}
+
 func NewCodexProviderWithTokenSource(token, accountID string, tokenSource func() (string, string, error)) *CodexProvider {
 	p := NewCodexProvider(token, accountID)
 	p.tokenSource = tokenSource
@@ -52,6 +73,9 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 	var opts []option.RequestOption
 	accountID := p.accountID
 	resolvedModel, fallbackReason := resolveCodexModel(model)
+	if p.passthroughModel {
+		resolvedModel, fallbackReason = model, ""
+	}
 	if fallbackReason != "" {
 		logger.WarnCF("provider.codex", "Requested model is not compatible with Codex backend, using fallback", map[string]interface{}{
 			"requested_model": model,
@@ -71,7 +95,7 @@ func (p *CodexProvider) Chat(ctx context.Context, messages []Message, tools []To
 	}
 	if accountID != "" {
 		opts = append(opts, option.WithHeader("Chatgpt-Account-Id", accountID))
-	} else {
+	} else if !p.passthroughModel {
 		logger.WarnCF("provider.codex", "No account id found for Codex request; backend may reject with 400", map[string]interface{}{
 			"requested_model": model,
 			"resolved_model":  resolvedModel,
@@ -270,6 +294,27 @@ func buildCodexParams(messages []Message, tools []ToolDefinition, model string,
 		params.Tools = translateToolsForCodex(tools)
 	}
 
+	if schema := responseSchema(options); schema != nil {
+		format := &responses.ResponseFormatTextJSONSchemaConfigParam{
+			Name:   schema.Name,
+			Schema: schema.Schema,
+			Strict: openai.Opt(false),
+		}
+		if schema.Description != "" {
+			format.Description = openai.Opt(schema.Description)
+		}
+		params.Text = responses.ResponseTextConfigParam{
+			Format: responses.ResponseFormatTextConfigUnionParam{OfJSONSchema: format},
+		}
+	}
+
+	if effort := reasoningEffort(options); effort != "" {
+		params.Reasoning = shared.ReasoningParam{
+			Effort:  shared.ReasoningEffort(effort),
+			Summary: shared.ReasoningSummaryAuto,
+		}
+	}
+
 	return params
 }
 
@@ -314,7 +359,7 @@ func translateToolsForCodex(tools []ToolDefinition) []responses.ToolUnionParam {
 }
 
 func parseCodexResponse(resp *responses.Response) *LLMResponse {
-	var content strings.Builder
+	var content, reasoning strings.Builder
 	var toolCalls []ToolCall
 
 	for _, item := range resp.Output {
@@ -325,6 +370,13 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 					content.WriteString(c.Text)
 				}
 			}
+		case "reasoning":
+			for _, s := range item.Summary {
+				if reasoning.Len() > 0 {
+					reasoning.WriteString("\n\n")
+				}
+				reasoning.WriteString(s.Text)
+			}
 		case "function_call":
 			var args map[string]interface{}
 			if err := json.Unmarshal([]byte(item.Arguments), &args); err != nil {
@@ -352,6 +404,7 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 			PromptTokens:     int(resp.Usage.InputTokens),
 			CompletionTokens: int(resp.Usage.OutputTokens),
 			TotalTokens:      int(resp.Usage.TotalTokens),
+			CachedTokens:     int(resp.Usage.InputTokensDetails.CachedTokens),
 		}
 	}
 
@@ -360,6 +413,7 @@ func parseCodexResponse(resp *responses.Response) *LLMResponse {
 		ToolCalls:    toolCalls,
 		FinishReason: finishReason,
 		Usage:        usage,
+		Reasoning:    reasoning.String(),
 	}
 }
 
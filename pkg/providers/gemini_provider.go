@@ -0,0 +1,572 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+const geminiDefaultModel = "gemini-2.5-flash"
+
+// geminiSkipThoughtSignature is the documented placeholder for function call
+// parts whose original thought signature is missing, such as calls recorded
+// before signatures were kept in history.
+const geminiSkipThoughtSignature = "skip_thought_signature_validator"
+
+// GeminiProvider speaks the native generateContent API of Google Gemini.
+type GeminiProvider struct {
+	apiKey         string
+	apiBase        string
+	headers        map[string]string
+	safetySettings map[string]string
+	httpClient     *http.Client
+}
+
+func NewGeminiProvider(apiKey, apiBase, proxy string) *GeminiProvider {
+	client := &http.Client{
+		Timeout: 120 * time.Second,
+	}
+
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err == nil {
+			client.Transport = &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			}
+		}
+	}
+
+	if apiBase == "" {
+		apiBase = "https://generativelanguage.googleapis.com/v1beta"
+	}
+	// Configs written for the OpenAI-compatible endpoint point at .../openai.
+	apiBase = strings.TrimSuffix(strings.TrimRight(apiBase, "/"), "/openai")
+
+	return &GeminiProvider{
+		apiKey:     apiKey,
+		apiBase:    apiBase,
+		httpClient: client,
+	}
+}
+
+// SetSafetySettings sets the harm category -> block threshold pairs sent
+// with every request, e.g. "HARM_CATEGORY_HARASSMENT": "BLOCK_NONE".
+func (p *GeminiProvider) SetSafetySettings(settings map[string]string) {
+	p.safetySettings = settings
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	ThoughtSignature string                  `json:"thoughtSignature,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent        `json:"contents"`
+	Tools             []geminiTool           `json:"tools,omitempty"`
+	ToolConfig        map[string]interface{} `json:"toolConfig,omitempty"`
+	SafetySettings    []geminiSafetySetting  `json:"safetySettings,omitempty"`
+	GenerationConfig  map[string]interface{} `json:"generationConfig,omitempty"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (p *GeminiProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	if model == "" {
+		model = geminiDefaultModel
+	}
+	model = strings.TrimPrefix(model, "models/")
+
+	reqBody := buildGeminiRequest(messages, tools, options)
+	for category, threshold := range p.safetySettings {
+		reqBody.SafetySettings = append(reqBody.SafetySettings, geminiSafetySetting{
+			Category:  category,
+			Threshold: threshold,
+		})
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/models/%s:generateContent", p.apiBase, url.PathEscape(model))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	if p.apiKey != "" {
+		req.Header.Set("x-goog-api-key", p.apiKey)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed:\n  Status: %d\n  Body:   %s", resp.StatusCode, string(body))
+	}
+
+	return parseGeminiResponse(body)
+}
+
+func (p *GeminiProvider) GetDefaultModel() string {
+	return geminiDefaultModel
+}
+
+func buildGeminiRequest(messages []Message, tools []ToolDefinition, options map[string]interface{}) geminiRequest {
+	var req geminiRequest
+	var systemParts []geminiPart
+
+	// Gemini pairs function responses by name, while history only carries
+	// the call id on tool results.
+	toolNames := make(map[string]string)
+
+	appendContent := func(role string, parts ...geminiPart) {
+		if len(parts) == 0 {
+			return
+		}
+		// Consecutive turns of the same role are merged so parallel function
+		// responses arrive together, as Gemini expects.
+		if n := len(req.Contents); n > 0 && req.Contents[n-1].Role == role {
+			req.Contents[n-1].Parts = append(req.Contents[n-1].Parts, parts...)
+			return
+		}
+		req.Contents = append(req.Contents, geminiContent{Role: role, Parts: parts})
+	}
+
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			if msg.Content != "" {
+				systemParts = append(systemParts, geminiPart{Text: msg.Content})
+			}
+		case "user":
+			if msg.ToolCallID != "" {
+				appendContent("user", geminiToolResultPart(msg, toolNames))
+				continue
+			}
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			parts = append(parts, geminiMediaParts(msg.Media)...)
+			appendContent("user", parts...)
+		case "assistant":
+			var parts []geminiPart
+			if msg.Content != "" {
+				parts = append(parts, geminiPart{Text: msg.Content})
+			}
+			for i, tc := range msg.ToolCalls {
+				name, args := geminiToolCallArgs(tc)
+				if name == "" {
+					continue
+				}
+				toolNames[tc.ID] = name
+				part := geminiPart{
+					FunctionCall: &geminiFunctionCall{ID: tc.ID, Name: name, Args: args},
+				}
+				if tc.ThoughtSignature != "" {
+					part.ThoughtSignature = tc.ThoughtSignature
+				} else if i == 0 {
+					part.ThoughtSignature = geminiSkipThoughtSignature
+				}
+				parts = append(parts, part)
+			}
+			appendContent("model", parts...)
+		case "tool":
+			appendContent("user", geminiToolResultPart(msg, toolNames))
+		}
+	}
+
+	if len(systemParts) > 0 {
+		req.SystemInstruction = &geminiContent{Parts: systemParts}
+	}
+
+	if len(tools) > 0 {
+		decls := make([]geminiFunctionDeclaration, 0, len(tools))
+		for _, t := range tools {
+			decl := geminiFunctionDeclaration{
+				Name:        t.Function.Name,
+				Description: t.Function.Description,
+			}
+			if params := sanitizeGeminiSchema(t.Function.Parameters); params != nil {
+				decl.Parameters = params
+			}
+			decls = append(decls, decl)
+		}
+		req.Tools = []geminiTool{{FunctionDeclarations: decls}}
+		req.ToolConfig = map[string]interface{}{
+			"functionCallingConfig": map[string]interface{}{"mode": "AUTO"},
+		}
+	}
+
+	genCfg := make(map[string]interface{})
+	if maxTokens, ok := options["max_tokens"].(int); ok {
+		genCfg["maxOutputTokens"] = maxTokens
+	}
+	if temperature, ok := options["temperature"].(float64); ok {
+		genCfg["temperature"] = temperature
+	}
+	// Gemini cannot combine a response schema with function calling, so
+	// with tools the reply is steered by the prompt alone.
+	if schema := responseSchema(options); schema != nil {
+		if len(tools) > 0 {
+			logger.DebugCF("provider.gemini", "Dropping response schema because tools are present", map[string]interface{}{
+				"schema": schema.Name,
+				"tools":  len(tools),
+			})
+		} else {
+			genCfg["responseMimeType"] = "application/json"
+			if s := sanitizeGeminiSchema(schema.Schema); s != nil {
+				genCfg["responseSchema"] = s
+			}
+		}
+	}
+	if budget := thinkingBudget(options); budget > 0 {
+		genCfg["thinkingConfig"] = map[string]interface{}{
+			"thinkingBudget":  budget,
+			"includeThoughts": true,
+		}
+	}
+	if len(genCfg) > 0 {
+		req.GenerationConfig = genCfg
+	}
+
+	return req
+}
+
+func geminiToolCallArgs(tc ToolCall) (string, map[string]interface{}) {
+	name := tc.Name
+	if name == "" && tc.Function != nil {
+		name = tc.Function.Name
+	}
+	args := tc.Arguments
+	if len(args) == 0 && tc.Function != nil && tc.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+			args = map[string]interface{}{"raw": tc.Function.Arguments}
+		}
+	}
+	return name, args
+}
+
+func geminiToolResultPart(msg Message, toolNames map[string]string) geminiPart {
+	name := toolNames[msg.ToolCallID]
+	if name == "" {
+		name = "tool"
+	}
+	return geminiPart{
+		FunctionResponse: &geminiFunctionResponse{
+			ID:       msg.ToolCallID,
+			Name:     name,
+			Response: map[string]interface{}{"content": msg.Content},
+		},
+	}
+}
+
+// geminiMediaParts inlines local image files. Other media types and
+// unreadable files are skipped.
+func geminiMediaParts(paths []string) []geminiPart {
+	var parts []geminiPart
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.WarnCF("provider.gemini", "Skipping unreadable media", map[string]interface{}{
+				"path":  path,
+				"error": err.Error(),
+			})
+			continue
+		}
+		mimeType := http.DetectContentType(data)
+		if !strings.HasPrefix(mimeType, "image/") {
+			continue
+		}
+		parts = append(parts, geminiPart{
+			InlineData: &geminiInlineData{
+				MimeType: mimeType,
+				Data:     base64.StdEncoding.EncodeToString(data),
+			},
+		})
+	}
+	return parts
+}
+
+// geminiSchemaKeys are the OpenAPI schema fields Gemini accepts in function
+// declarations; anything else (additionalProperties, $schema, default, ...)
+// makes the whole request fail.
+var geminiSchemaKeys = map[string]bool{
+	"type":        true,
+	"format":      true,
+	"description": true,
+	"nullable":    true,
+	"enum":        true,
+	"properties":  true,
+	"required":    true,
+	"items":       true,
+	"minItems":    true,
+	"maxItems":    true,
+	"minimum":     true,
+	"maximum":     true,
+}
+
+// sanitizeGeminiSchema returns a copy of a JSON schema reduced to what
+// Gemini accepts. It returns nil for an object schema without properties,
+// which Gemini rejects and which is better expressed by omitting parameters.
+func sanitizeGeminiSchema(schema map[string]interface{}) map[string]interface{} {
+	if schema == nil {
+		return nil
+	}
+
+	out := make(map[string]interface{})
+	for k, v := range schema {
+		if !geminiSchemaKeys[k] {
+			continue
+		}
+		switch k {
+		case "type":
+			// JSON schema allows ["string", "null"]; Gemini wants one type.
+			if types, ok := v.([]interface{}); ok {
+				for _, t := range types {
+					s, _ := t.(string)
+					if s == "null" {
+						out["nullable"] = true
+					} else if s != "" && out["type"] == nil {
+						out["type"] = s
+					}
+				}
+				continue
+			}
+			out[k] = v
+		case "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			cleaned := make(map[string]interface{}, len(props))
+			for name, prop := range props {
+				if propSchema, ok := prop.(map[string]interface{}); ok {
+					if sanitized := sanitizeGeminiSchema(propSchema); sanitized != nil {
+						cleaned[name] = sanitized
+						continue
+					}
+					// Free-form objects become strings the model fills with JSON.
+					fallback := map[string]interface{}{"type": "string"}
+					if desc, ok := propSchema["description"].(string); ok {
+						fallback["description"] = desc
+					}
+					cleaned[name] = fallback
+				}
+			}
+			if len(cleaned) > 0 {
+				out[k] = cleaned
+			}
+		case "items":
+			if itemSchema, ok := v.(map[string]interface{}); ok {
+				if sanitized := sanitizeGeminiSchema(itemSchema); sanitized != nil {
+					out[k] = sanitized
+				} else {
+					out[k] = map[string]interface{}{"type": "string"}
+				}
+			}
+		case "enum":
+			// Gemini only supports string enums.
+			values, ok := v.([]interface{})
+			if !ok {
+				if ss, ok := v.([]string); ok {
+					out[k] = ss
+				}
+				continue
+			}
+			enum := make([]string, 0, len(values))
+			for _, e := range values {
+				enum = append(enum, fmt.Sprintf("%v", e))
+			}
+			out[k] = enum
+			out["type"] = "string"
+		default:
+			out[k] = v
+		}
+	}
+
+	if t, _ := out["type"].(string); t == "object" {
+		props, _ := out["properties"].(map[string]interface{})
+		if len(props) == 0 {
+			return nil
+		}
+		out["required"] = filterRequired(out["required"], props)
+		if req, ok := out["required"].([]string); ok && len(req) == 0 {
+			delete(out, "required")
+		}
+	}
+	return out
+}
+
+// filterRequired drops required entries that do not name a property.
+func filterRequired(required interface{}, props map[string]interface{}) []string {
+	var names []string
+	switch r := required.(type) {
+	case []string:
+		names = r
+	case []interface{}:
+		for _, v := range r {
+			if s, ok := v.(string); ok {
+				names = append(names, s)
+			}
+		}
+	}
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := props[name]; ok {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func parseGeminiResponse(body []byte) (*LLMResponse, error) {
+	var apiResponse geminiResponse
+	if err := json.Unmarshal(body, &apiResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var usage *UsageInfo
+	if um := apiResponse.UsageMetadata; um != nil {
+		usage = &UsageInfo{
+			PromptTokens:     um.PromptTokenCount,
+			CompletionTokens: um.CandidatesTokenCount,
+			TotalTokens:      um.TotalTokenCount,
+			CachedTokens:     um.CachedContentTokenCount,
+		}
+	}
+
+	if len(apiResponse.Candidates) == 0 {
+		if pf := apiResponse.PromptFeedback; pf != nil && pf.BlockReason != "" {
+			return nil, fmt.Errorf("gemini blocked the prompt: %s", pf.BlockReason)
+		}
+		return &LLMResponse{FinishReason: "stop", Usage: usage}, nil
+	}
+
+	candidate := apiResponse.Candidates[0]
+	var content, reasoning strings.Builder
+	var toolCalls []ToolCall
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			reasoning.WriteString(part.Text)
+		} else if part.Text != "" {
+			content.WriteString(part.Text)
+		}
+		if fc := part.FunctionCall; fc != nil {
+			id := fc.ID
+			if id == "" {
+				id = "call_" + uuid.New().String()[:8]
+			}
+			args := fc.Args
+			if args == nil {
+				args = map[string]interface{}{}
+			}
+			toolCalls = append(toolCalls, ToolCall{
+				ID:               id,
+				Name:             fc.Name,
+				Arguments:        args,
+				ThoughtSignature: part.ThoughtSignature,
+			})
+		}
+	}
+
+	finishReason := "stop"
+	switch candidate.FinishReason {
+	case "MAX_TOKENS":
+		finishReason = "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		finishReason = "content_filter"
+	}
+	if len(toolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+
+	return &LLMResponse{
+		Content:      content.String(),
+		ToolCalls:    toolCalls,
+		FinishReason: finishReason,
+		Usage:        usage,
+		Reasoning:    reasoning.String(),
+	}, nil
+}
@@ -0,0 +1,262 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildGeminiRequest_SystemAndToolTurns(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "You are helpful"},
+		{Role: "user", Content: "Weather?"},
+		{
+			Role: "assistant",
+			ToolCalls: []ToolCall{
+				{ID: "call_1", Type: "function", Function: &FunctionCall{Name: "get_weather", Arguments: `{"city":"SF"}`}},
+				{ID: "call_2", Type: "function", Function: &FunctionCall{Name: "get_time", Arguments: `{}`}},
+			},
+		},
+		{Role: "tool", Content: "Sunny", ToolCallID: "call_1"},
+		{Role: "tool", Content: "Noon", ToolCallID: "call_2"},
+	}
+
+	req := buildGeminiRequest(messages, nil, map[string]interface{}{"max_tokens": 512, "temperature": 0.3})
+
+	if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "You are helpful" {
+		t.Fatalf("SystemInstruction = %+v", req.SystemInstruction)
+	}
+	if len(req.Contents) != 3 {
+		t.Fatalf("len(Contents) = %d, want 3 (user, model, merged function responses)", len(req.Contents))
+	}
+
+	model := req.Contents[1]
+	if model.Role != "model" || len(model.Parts) != 2 {
+		t.Fatalf("model turn = %+v", model)
+	}
+	if fc := model.Parts[0].FunctionCall; fc == nil || fc.Name != "get_weather" || fc.Args["city"] != "SF" {
+		t.Errorf("first function call = %+v", model.Parts[0].FunctionCall)
+	}
+	if model.Parts[0].ThoughtSignature == "" {
+		t.Error("first function call part should carry a thought signature")
+	}
+
+	responses := req.Contents[2]
+	if responses.Role != "user" || len(responses.Parts) != 2 {
+		t.Fatalf("function response turn = %+v", responses)
+	}
+	if fr := responses.Parts[1].FunctionResponse; fr == nil || fr.Name != "get_time" || fr.Response["content"] != "Noon" {
+		t.Errorf("second function response = %+v", responses.Parts[1].FunctionResponse)
+	}
+
+	if req.GenerationConfig["maxOutputTokens"] != 512 {
+		t.Errorf("maxOutputTokens = %v, want 512", req.GenerationConfig["maxOutputTokens"])
+	}
+}
+
+func TestGeminiThoughtSignature_ReplayedWithCall(t *testing.T) {
+	resp, err := parseGeminiResponse([]byte(`{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"functionCall": {"id": "c1", "name": "get_weather", "args": {"city": "SF"}}, "thoughtSignature": "sig-abc"},
+				{"functionCall": {"id": "c2", "name": "get_time", "args": {}}}
+			]}
+		}]
+	}`))
+	if err != nil {
+		t.Fatalf("parseGeminiResponse() error = %v", err)
+	}
+	if len(resp.ToolCalls) != 2 || resp.ToolCalls[0].ThoughtSignature != "sig-abc" {
+		t.Fatalf("ToolCalls = %+v, want signature on the first call", resp.ToolCalls)
+	}
+
+	// History keeps the calls in the OpenAI shape, as the agent loop stores them.
+	var calls []ToolCall
+	for _, tc := range resp.ToolCalls {
+		args, _ := json.Marshal(tc.Arguments)
+		calls = append(calls, ToolCall{
+			ID:               tc.ID,
+			Type:             "function",
+			Function:         &FunctionCall{Name: tc.Name, Arguments: string(args)},
+			ThoughtSignature: tc.ThoughtSignature,
+		})
+	}
+	req := buildGeminiRequest([]Message{
+		{Role: "user", Content: "Weather?"},
+		{Role: "assistant", ToolCalls: calls},
+	}, nil, nil)
+
+	parts := req.Contents[1].Parts
+	if parts[0].ThoughtSignature != "sig-abc" {
+		t.Errorf("first call signature = %q, want the one Gemini returned", parts[0].ThoughtSignature)
+	}
+	if parts[1].ThoughtSignature != "" {
+		t.Errorf("second call signature = %q, want none", parts[1].ThoughtSignature)
+	}
+}
+
+func TestBuildGeminiRequest_SchemaDroppedWithTools(t *testing.T) {
+	schema := &JSONSchema{Name: "decision", Schema: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"notify": map[string]interface{}{"type": "boolean"}},
+	}}
+	tools := []ToolDefinition{{Type: "function", Function: ToolFunctionDefinition{Name: "read_file"}}}
+	msgs := []Message{{Role: "user", Content: "hi"}}
+
+	req := buildGeminiRequest(msgs, tools, map[string]interface{}{"response_schema": schema})
+	if _, ok := req.GenerationConfig["responseSchema"]; ok {
+		t.Error("responseSchema should be dropped when tools are present")
+	}
+	req = buildGeminiRequest(msgs, nil, map[string]interface{}{"response_schema": schema})
+	if _, ok := req.GenerationConfig["responseSchema"]; !ok {
+		t.Error("responseSchema should be sent without tools")
+	}
+}
+
+func TestBuildGeminiRequest_InlineImage(t *testing.T) {
+	dir := t.TempDir()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	imgPath := filepath.Join(dir, "photo.png")
+	if err := os.WriteFile(imgPath, png, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req := buildGeminiRequest([]Message{
+		{Role: "user", Content: "What is this?", Media: []string{imgPath, filepath.Join(dir, "missing.jpg")}},
+	}, nil, nil)
+
+	parts := req.Contents[0].Parts
+	if len(parts) != 2 {
+		t.Fatalf("len(parts) = %d, want 2", len(parts))
+	}
+	if parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/png" {
+		t.Errorf("inline data = %+v, want image/png", parts[1].InlineData)
+	}
+}
+
+func TestSanitizeGeminiSchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "File path",
+				"default":     ".",
+			},
+			"mode": map[string]interface{}{
+				"type": []interface{}{"string", "null"},
+				"enum": []interface{}{"r", "w"},
+			},
+			"options": map[string]interface{}{
+				"type":        "object",
+				"description": "Free-form options",
+			},
+		},
+		"required": []interface{}{"path", "ghost"},
+	}
+
+	got := sanitizeGeminiSchema(schema)
+
+	for _, key := range []string{"$schema", "additionalProperties"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("%s should be removed", key)
+		}
+	}
+	props := got["properties"].(map[string]interface{})
+	if _, ok := props["path"].(map[string]interface{})["default"]; ok {
+		t.Error("nested default should be removed")
+	}
+	mode := props["mode"].(map[string]interface{})
+	if mode["type"] != "string" || mode["nullable"] != true {
+		t.Errorf("mode = %+v, want string + nullable", mode)
+	}
+	if opts := props["options"].(map[string]interface{}); opts["type"] != "string" {
+		t.Errorf("free-form object should become string, got %+v", opts)
+	}
+	if req := got["required"].([]string); len(req) != 1 || req[0] != "path" {
+		t.Errorf("required = %v, want [path]", req)
+	}
+
+	if sanitizeGeminiSchema(map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}) != nil {
+		t.Error("object without properties should sanitize to nil")
+	}
+}
+
+func TestGeminiProvider_ChatRoundTrip(t *testing.T) {
+	var reqBody map[string]interface{}
+	var gotKey, gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-goog-api-key")
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"candidates": [{
+				"content": {"role": "model", "parts": [
+					{"text": "Checking."},
+					{"functionCall": {"name": "read_file", "args": {"path": "a.txt"}}}
+				]},
+				"finishReason": "STOP"
+			}],
+			"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 5, "totalTokenCount": 17}
+		}`))
+	}))
+	defer server.Close()
+
+	p := NewGeminiProvider("g-key", server.URL+"/openai", "")
+	p.SetSafetySettings(map[string]string{"HARM_CATEGORY_HARASSMENT": "BLOCK_NONE"})
+
+	tools := []ToolDefinition{{
+		Type: "function",
+		Function: ToolFunctionDefinition{
+			Name:        "read_file",
+			Description: "Read a file",
+			Parameters: map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"path": map[string]interface{}{"type": "string"}},
+				"required":   []interface{}{"path"},
+			},
+		},
+	}}
+
+	resp, err := p.Chat(context.Background(), []Message{{Role: "user", Content: "read a.txt"}}, tools, "gemini-2.5-pro", nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+
+	if gotKey != "g-key" {
+		t.Errorf("api key header = %q", gotKey)
+	}
+	if gotPath != "/models/gemini-2.5-pro:generateContent" {
+		t.Errorf("path = %q", gotPath)
+	}
+	if safety, _ := reqBody["safetySettings"].([]interface{}); len(safety) != 1 {
+		t.Errorf("safetySettings = %v", reqBody["safetySettings"])
+	}
+
+	if resp.Content != "Checking." {
+		t.Errorf("Content = %q", resp.Content)
+	}
+	if resp.FinishReason != "tool_calls" {
+		t.Errorf("FinishReason = %q, want tool_calls", resp.FinishReason)
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "read_file" || resp.ToolCalls[0].ID == "" {
+		t.Fatalf("ToolCalls = %+v", resp.ToolCalls)
+	}
+	if resp.Usage == nil || resp.Usage.PromptTokens != 12 || resp.Usage.CompletionTokens != 5 || resp.Usage.TotalTokens != 17 {
+		t.Errorf("Usage = %+v", resp.Usage)
+	}
+}
+
+func TestParseGeminiResponse_BlockedPrompt(t *testing.T) {
+	_, err := parseGeminiResponse([]byte(`{"promptFeedback": {"blockReason": "SAFETY"}}`))
+	if err == nil {
+		t.Fatal("expected error for blocked prompt")
+	}
+}
@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPProvider_ParseCachedUsage(t *testing.T) {
+	p := NewHTTPProvider("k", "https://example.invalid/v1", "")
+
+	resp, err := p.parseResponse([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}],
+		"usage":{"prompt_tokens":100,"completion_tokens":5,"total_tokens":105,"prompt_tokens_details":{"cached_tokens":64}}}`))
+	if err != nil {
+		t.Fatalf("parseResponse() error = %v", err)
+	}
+	if resp.Usage.PromptTokens != 100 || resp.Usage.CachedTokens != 64 {
+		t.Errorf("Usage = %+v, want 100 prompt / 64 cached", resp.Usage)
+	}
+
+	resp, err = p.parseResponse([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}],
+		"usage":{"prompt_tokens":100,"completion_tokens":5,"total_tokens":105,"prompt_cache_hit_tokens":32}}`))
+	if err != nil {
+		t.Fatalf("parseResponse() error = %v", err)
+	}
+	if resp.Usage.CachedTokens != 32 {
+		t.Errorf("CachedTokens = %d, want 32 (DeepSeek field)", resp.Usage.CachedTokens)
+	}
+}
+
+func TestHTTPProvider_ParseReasoning(t *testing.T) {
+	p := NewHTTPProvider("k", "https://example.invalid/v1", "")
+
+	resp, err := p.parseResponse([]byte(`{"choices":[{"message":{"content":"42","reasoning_content":"6*7"},"finish_reason":"stop"}]}`))
+	if err != nil {
+		t.Fatalf("parseResponse() error = %v", err)
+	}
+	if resp.Content != "42" || resp.Reasoning != "6*7" {
+		t.Errorf("Content = %q, Reasoning = %q", resp.Content, resp.Reasoning)
+	}
+
+	resp, err = p.parseResponse([]byte(`{"choices":[{"message":{"content":"<think>\nhmm\n</think>\n\n42"},"finish_reason":"stop"}]}`))
+	if err != nil {
+		t.Fatalf("parseResponse() error = %v", err)
+	}
+	if resp.Content != "42" || resp.Reasoning != "hmm" {
+		t.Errorf("Content = %q, Reasoning = %q, want <think> section split out", resp.Content, resp.Reasoning)
+	}
+}
+
+func TestOpenAIChatMessages_ReasoningOnlyInCurrentTurn(t *testing.T) {
+	call := []ToolCall{{ID: "c1", Type: "function", Function: &FunctionCall{Name: "f", Arguments: "{}"}}}
+	wire := openAIChatMessages([]Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", ToolCalls: call, ReasoningContent: "old"},
+		{Role: "tool", Content: "r", ToolCallID: "c1"},
+		{Role: "assistant", Content: "done", ReasoningContent: "old answer"},
+		{Role: "user", Content: "second"},
+		{Role: "assistant", ToolCalls: call, ReasoningContent: "new"},
+	})
+	if wire[1].ReasoningContent != "" || wire[3].ReasoningContent != "" {
+		t.Error("reasoning_content from earlier turns should not be sent")
+	}
+	if wire[5].ReasoningContent != "new" {
+		t.Errorf("ReasoningContent = %q, want %q", wire[5].ReasoningContent, "new")
+	}
+}
+
+func TestAcceptsReasoningEffort(t *testing.T) {
+	for model, want := range map[string]bool{
+		"o3-mini":                   true,
+		"o1":                        true,
+		"gpt-5.1":                   true,
+		"openai/gpt-5-mini":         true,
+		"openai/gpt-oss-120b":       true,
+		"qwen/qwen3-32b":            true,
+		"grok-3-mini":               true,
+		"gemini-2.5-flash":          true,
+		"gpt-4o":                    false,
+		"deepseek-reasoner":         false,
+		"glm-4.7":                   false,
+		"moonshotai/kimi-k2":        false,
+		"meta-llama/llama-3.3-70b":  false,
+		"anthropic/claude-sonnet-4": false,
+	} {
+		if got := acceptsReasoningEffort(model); got != want {
+			t.Errorf("acceptsReasoningEffort(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestHTTPProvider_ReasoningEffortOnlyForSupportedModels(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"choices":[{"message":{"content":"ok"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	p := NewHTTPProvider("k", server.URL, "")
+	options := map[string]interface{}{"reasoning_effort": "high"}
+
+	if _, err := p.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "o3-mini", options); err != nil {
+		t.Fatal(err)
+	}
+	if body["reasoning_effort"] != "high" {
+		t.Errorf("o3-mini request reasoning_effort = %v, want high", body["reasoning_effort"])
+	}
+
+	if _, err := p.Chat(context.Background(), []Message{{Role: "user", Content: "hi"}}, nil, "deepseek-chat", options); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["reasoning_effort"]; ok {
+		t.Error("reasoning_effort sent to a model that does not accept it")
+	}
+}
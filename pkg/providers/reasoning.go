@@ -0,0 +1,67 @@
+package providers
+
+import (
+	"strings"
+)
+
+// effortBudgets maps a reasoning effort to a thinking token budget for
+// providers that only accept a budget.
+var effortBudgets = map[string]int{
+	"low":    2048,
+	"medium": 8192,
+	"high":   16384,
+}
+
+// reasoningEffort returns the "reasoning_effort" chat option.
+func reasoningEffort(options map[string]interface{}) string {
+	effort, _ := options["reasoning_effort"].(string)
+	return strings.ToLower(effort)
+}
+
+// reasoningEffortModels are the model families that accept a
+// "reasoning_effort" field over OpenAI-compatible chat completions: OpenAI
+// o-series and GPT-5, gpt-oss and Qwen3 (Groq), Grok 3 Mini (xAI) and
+// Gemini 2.5 and later. Other vendors reject or ignore the field.
+var reasoningEffortModels = []string{
+	"o1", "o3", "o4", "gpt-5", "gpt-oss", "qwen3", "grok-3-mini", "gemini-2.5", "gemini-3",
+}
+
+// acceptsReasoningEffort reports whether model takes "reasoning_effort".
+// A vendor prefix such as "openai/" is ignored.
+func acceptsReasoningEffort(model string) bool {
+	name := strings.ToLower(model)
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+	}
+	for _, family := range reasoningEffortModels {
+		if name == family || strings.HasPrefix(name, family+"-") || strings.HasPrefix(name, family+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// thinkingBudget returns the "thinking_budget" chat option, falling back to
+// the budget implied by "reasoning_effort". Zero means thinking stays off.
+func thinkingBudget(options map[string]interface{}) int {
+	if budget, ok := options["thinking_budget"].(int); ok && budget > 0 {
+		return budget
+	}
+	return effortBudgets[reasoningEffort(options)]
+}
+
+// splitThinkTags moves a leading <think>...</think> section, as emitted by
+// DeepSeek-R1 style models served over plain chat completions, out of
+// content and into the returned reasoning.
+func splitThinkTags(content string) (string, string) {
+	trimmed := strings.TrimLeft(content, " \t\r\n")
+	if !strings.HasPrefix(trimmed, "<think>") {
+		return content, ""
+	}
+	end := strings.Index(trimmed, "</think>")
+	if end == -1 {
+		return content, ""
+	}
+	reasoning := strings.TrimSpace(trimmed[len("<think>"):end])
+	return strings.TrimLeft(trimmed[end+len("</think>"):], " \t\r\n"), reasoning
+}
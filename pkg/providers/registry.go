@@ -0,0 +1,308 @@
+package providers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropicoption "github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// ProtocolFactory builds a client for a provider entry.
+type ProtocolFactory func(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error)
+
+var protocolFactories = map[string]ProtocolFactory{}
+
+// RegisterProtocol makes a wire protocol available to provider entries.
+// Registering an existing protocol replaces its factory.
+func RegisterProtocol(protocol string, factory ProtocolFactory) {
+	protocolFactories[protocol] = factory
+}
+
+func init() {
+	RegisterProtocol(config.ProtocolOpenAIChat, newOpenAIChatProvider)
+	RegisterProtocol(config.ProtocolOllama, newOpenAIChatProvider)
+	RegisterProtocol(config.ProtocolGemini, newGeminiProvider)
+	RegisterProtocol(config.ProtocolOpenAIResponses, newOpenAIResponsesProvider)
+	RegisterProtocol(config.ProtocolAnthropicMessages, newAnthropicMessagesProvider)
+	RegisterProtocol(config.ProtocolCLI, newCLIProvider)
+	RegisterProtocol(config.ProtocolGitHubCopilot, newGitHubCopilotProvider)
+}
+
+// providerNameAliases maps historical spellings of agents.defaults.provider
+// to entry names.
+var providerNameAliases = map[string]string{
+	"gpt":         "openai",
+	"claude":      "anthropic",
+	"glm":         "zhipu",
+	"google":      "gemini",
+	"claudecode":  "claude-cli",
+	"claude-code": "claude-cli",
+	"codex-code":  "codex-cli",
+	"copilot":     "github_copilot",
+}
+
+// legacyModelHints reproduces the model-name matching of the old fixed
+// provider switch. It is consulted only after explicit addressing fails, so
+// existing configs keep selecting the same vendor.
+var legacyModelHints = []struct {
+	entry    string
+	keywords []string
+	prefixes []string
+}{
+	{"moonshot", []string{"kimi", "moonshot"}, nil},
+	{"openrouter", nil, []string{"openrouter/", "anthropic/", "openai/", "meta-llama/", "deepseek/", "google/"}},
+	{"anthropic", []string{"claude"}, nil},
+	{"openai", []string{"gpt"}, nil},
+	{"gemini", []string{"gemini"}, nil},
+	{"zhipu", []string{"glm", "zhipu", "zai"}, nil},
+	{"groq", []string{"groq"}, nil},
+	{"nvidia", []string{"nvidia"}, nil},
+	{"ollama", []string{"ollama"}, nil},
+}
+
+// legacyStrippedPrefixes are the legacy entries whose "<name>/" prefix the
+// old client removed. Other prefixes, such as "anthropic/", were OpenRouter
+// model ids and still go to OpenRouter as they are.
+var legacyStrippedPrefixes = map[string]bool{
+	"moonshot": true,
+	"nvidia":   true,
+	"groq":     true,
+	"ollama":   true,
+}
+
+// prefixAddressable reports whether "<entry>/<model>" selects entry and is
+// sent upstream without the prefix. OpenRouter's own ids look like
+// "openrouter/auto", so its prefix is never taken as addressing.
+func prefixAddressable(e config.ProviderEntry) bool {
+	switch {
+	case e.Name == "openrouter", e.Protocol == config.ProtocolCLI, e.Protocol == config.ProtocolGitHubCopilot:
+		return false
+	case e.Legacy:
+		return legacyStrippedPrefixes[e.Name]
+	}
+	return true
+}
+
+// ResolveProviderModel selects the provider entry serving model, as
+// ResolveProviderEntry does, and returns the model id to send it.
+func ResolveProviderModel(cfg *config.Config, providerName, model string) (config.ProviderEntry, string, error) {
+	entry, err := ResolveProviderEntry(cfg, providerName, model)
+	if err != nil {
+		return entry, model, err
+	}
+
+	upstream := model
+	if prefixAddressable(entry) {
+		upstream = strings.TrimPrefix(upstream, entry.Name+"/")
+	}
+	upstream = entry.ResolveModel(upstream)
+
+	// The legacy DeepSeek field only ever served these two models
+	if entry.Legacy && entry.Name == "deepseek" && upstream != "deepseek-chat" && upstream != "deepseek-reasoner" {
+		upstream = "deepseek-chat"
+	}
+	return entry, upstream, nil
+}
+
+// ResolveProviderEntry selects the provider entry serving model. An explicit
+// providerName wins when it is configured; otherwise "<entry>/<model>"
+// addressing, then entries declaring the model, then the legacy model-name
+// hints are tried in order.
+func ResolveProviderEntry(cfg *config.Config, providerName, model string) (config.ProviderEntry, error) {
+	entries := cfg.ProviderEntries()
+	byName := make(map[string]config.ProviderEntry, len(entries))
+	for _, e := range entries {
+		byName[e.Name] = e
+	}
+
+	if providerName != "" {
+		name := strings.ToLower(providerName)
+		if alias, ok := providerNameAliases[name]; ok {
+			name = alias
+		}
+		if e, ok := byName[name]; ok {
+			return e, nil
+		}
+		// As before provider entries, fall back to detecting it from the model
+		logger.WarnCF("providers", "Configured provider has no credentials, choosing by model", map[string]interface{}{
+			"provider": providerName,
+			"model":    model,
+		})
+	}
+
+	if idx := strings.Index(model, "/"); idx != -1 {
+		if e, ok := byName[model[:idx]]; ok && prefixAddressable(e) {
+			return e, nil
+		}
+	}
+
+	for _, e := range entries {
+		if e.ServesModel(model) {
+			return e, nil
+		}
+	}
+
+	lowerModel := strings.ToLower(model)
+	for _, hint := range legacyModelHints {
+		e, ok := byName[hint.entry]
+		if !ok {
+			continue
+		}
+		for _, kw := range hint.keywords {
+			if strings.Contains(lowerModel, kw) {
+				return e, nil
+			}
+		}
+		for _, prefix := range hint.prefixes {
+			if strings.HasPrefix(model, prefix) {
+				return e, nil
+			}
+		}
+	}
+
+	if e, ok := byName["vllm"]; ok {
+		return e, nil
+	}
+	if e, ok := byName["openrouter"]; ok {
+		return e, nil
+	}
+	for _, e := range cfg.ProviderList {
+		if e.Name != "" {
+			return byName[e.Name], nil
+		}
+	}
+
+	return config.ProviderEntry{}, fmt.Errorf("no API key configured for model: %s", model)
+}
+
+// ResolveModel returns the upstream model id for model, as served by the
+// provider entry it resolves to. Unresolvable models are returned unchanged.
+func ResolveModel(cfg *config.Config, model string) string {
+	_, upstream, _ := ResolveProviderModel(cfg, cfg.Agents.Defaults.Provider, model)
+	return upstream
+}
+
+// CreateProviderFromEntry builds the client for entry using the factory
+// registered for its protocol.
+func CreateProviderFromEntry(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	protocol := entry.Protocol
+	if protocol == "" {
+		protocol = config.ProtocolOpenAIChat
+	}
+	factory, ok := protocolFactories[protocol]
+	if !ok {
+		return nil, fmt.Errorf("provider %q: unknown protocol %q", entry.Name, entry.Protocol)
+	}
+	return factory(entry, cfg)
+}
+
+func CreateProvider(cfg *config.Config) (LLMProvider, error) {
+	entry, err := ResolveProviderEntry(cfg, cfg.Agents.Defaults.Provider, cfg.Agents.Defaults.Model)
+	if err != nil {
+		return nil, err
+	}
+	return CreateProviderFromEntry(entry, cfg)
+}
+
+func newOpenAIChatProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	if entry.APIBase == "" {
+		return nil, fmt.Errorf("no API base configured for provider %q", entry.Name)
+	}
+	// Bedrock models go through a gateway holding the AWS credentials
+	keyless := strings.HasPrefix(cfg.Agents.Defaults.Model, "bedrock/")
+	if entry.APIKey == "" && !keyless && entry.Protocol != config.ProtocolOllama && !isLocalAPIBase(entry.APIBase) {
+		return nil, fmt.Errorf("no API key configured for provider %q", entry.Name)
+	}
+	p := NewHTTPProvider(entry.APIKey, entry.APIBase, entry.Proxy)
+	p.headers = entry.Headers
+	p.authHeader = entry.AuthHeader
+	return p, nil
+}
+
+func newGeminiProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	if entry.APIKey == "" {
+		return nil, fmt.Errorf("no API key configured for provider %q", entry.Name)
+	}
+	p := NewGeminiProvider(entry.APIKey, entry.APIBase, entry.Proxy)
+	p.headers = entry.Headers
+	p.SetSafetySettings(entry.SafetySettings)
+	return p, nil
+}
+
+func newOpenAIResponsesProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	switch entry.AuthMethod {
+	case "codex-cli":
+		return NewCodexProviderWithTokenSource("", "", CreateCodexCliTokenSource()), nil
+	case "oauth", "token":
+		return createCodexAuthProvider()
+	}
+	if entry.APIKey == "" {
+		return nil, fmt.Errorf("no API key configured for provider %q", entry.Name)
+	}
+	apiBase := entry.APIBase
+	if apiBase == "" {
+		apiBase = "https://api.openai.com/v1"
+	}
+	return NewOpenAIResponsesProvider(entry.APIKey, apiBase, entry.Headers), nil
+}
+
+func newAnthropicMessagesProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	if entry.AuthMethod == "oauth" || entry.AuthMethod == "token" {
+		return createClaudeAuthProvider()
+	}
+	if entry.APIKey == "" {
+		return nil, fmt.Errorf("no API key configured for provider %q", entry.Name)
+	}
+
+	// The SDK appends /v1/messages itself; accept bases written either way.
+	apiBase := strings.TrimSuffix(strings.TrimRight(entry.APIBase, "/"), "/v1")
+	if apiBase == "" {
+		apiBase = "https://api.anthropic.com"
+	}
+	opts := []anthropicoption.RequestOption{
+		anthropicoption.WithAPIKey(entry.APIKey),
+		anthropicoption.WithBaseURL(apiBase),
+	}
+	for k, v := range entry.Headers {
+		opts = append(opts, anthropicoption.WithHeader(k, v))
+	}
+	if entry.Proxy != "" {
+		proxyURL, err := url.Parse(entry.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: invalid proxy: %w", entry.Name, err)
+		}
+		opts = append(opts, anthropicoption.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		}))
+	}
+	client := anthropic.NewClient(opts...)
+	return &ClaudeProvider{client: &client}, nil
+}
+
+func newCLIProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	workspace := cfg.WorkspacePath()
+	if workspace == "" {
+		workspace = "."
+	}
+	switch entry.Command {
+	case "", "claude":
+		return NewClaudeCliProvider(workspace), nil
+	case "codex":
+		return NewCodexCliProvider(workspace), nil
+	default:
+		return nil, fmt.Errorf("provider %q: unsupported cli command %q", entry.Name, entry.Command)
+	}
+}
+
+func newGitHubCopilotProvider(entry config.ProviderEntry, cfg *config.Config) (LLMProvider, error) {
+	return NewGitHubCopilotProvider(entry.APIBase, entry.ConnectMode, cfg.Agents.Defaults.Model)
+}
+
+func isLocalAPIBase(apiBase string) bool {
+	return strings.Contains(apiBase, "://localhost") || strings.Contains(apiBase, "://127.0.0.1")
+}
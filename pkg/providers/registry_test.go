@@ -0,0 +1,379 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func TestResolveProviderEntry_ExplicitPrefix(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Zhipu.APIKey = "zhipu-key"
+	cfg.ProviderList = []config.ProviderEntry{
+		{Name: "acme", Protocol: config.ProtocolOpenAIChat, APIBase: "https://acme.example/v1", APIKey: "k"},
+	}
+
+	// "glm" in the model name used to select Zhipu; the explicit prefix must win.
+	entry, err := ResolveProviderEntry(cfg, "", "acme/glm-4-air")
+	if err != nil {
+		t.Fatalf("ResolveProviderEntry() error = %v", err)
+	}
+	if entry.Name != "acme" {
+		t.Errorf("entry = %q, want %q", entry.Name, "acme")
+	}
+	if got := ResolveModel(cfg, "acme/glm-4-air"); got != "glm-4-air" {
+		t.Errorf("ResolveModel() = %q, want %q", got, "glm-4-air")
+	}
+}
+
+func TestResolveProviderEntry_Alias(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProviderList = []config.ProviderEntry{
+		{
+			Name:     "acme",
+			Protocol: config.ProtocolOpenAIChat,
+			APIBase:  "https://acme.example/v1",
+			APIKey:   "k",
+			Aliases:  map[string]string{"fast": "acme-turbo-2"},
+		},
+	}
+	cfg.Agents.Defaults.Model = "fast"
+
+	if got := ResolveModel(cfg, "fast"); got != "acme-turbo-2" {
+		t.Errorf("ResolveModel() = %q, want %q", got, "acme-turbo-2")
+	}
+}
+
+func TestResolveProviderEntry_LegacyModelHint(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Zhipu.APIKey = "zhipu-key"
+	cfg.Providers.OpenRouter.APIKey = "or-key"
+
+	entry, err := ResolveProviderEntry(cfg, "", "glm-4.7")
+	if err != nil {
+		t.Fatalf("ResolveProviderEntry() error = %v", err)
+	}
+	if entry.Name != "zhipu" || entry.APIBase != "https://open.bigmodel.cn/api/paas/v4" {
+		t.Errorf("entry = %+v, want migrated zhipu entry", entry)
+	}
+
+	entry, err = ResolveProviderEntry(cfg, "", "meta-llama/llama-3-70b")
+	if err != nil {
+		t.Fatalf("ResolveProviderEntry() error = %v", err)
+	}
+	if entry.Name != "openrouter" {
+		t.Errorf("entry = %q, want %q", entry.Name, "openrouter")
+	}
+}
+
+func TestResolveProviderEntry_ProviderNameAlias(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Zhipu.APIKey = "zhipu-key"
+
+	entry, err := ResolveProviderEntry(cfg, "GLM", "whatever")
+	if err != nil {
+		t.Fatalf("ResolveProviderEntry() error = %v", err)
+	}
+	if entry.Name != "zhipu" {
+		t.Errorf("entry = %q, want %q", entry.Name, "zhipu")
+	}
+
+	if _, err := ResolveProviderEntry(cfg, "groq", "whatever"); err == nil {
+		t.Error("expected error for unconfigured provider")
+	}
+}
+
+func TestResolveProviderEntry_NothingConfigured(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if _, err := CreateProvider(cfg); err == nil {
+		t.Error("CreateProvider() expected error with no providers configured")
+	}
+}
+
+func TestCreateProvider_UnknownProtocol(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProviderList = []config.ProviderEntry{{Name: "x", Protocol: "carrier-pigeon"}}
+	cfg.Agents.Defaults.Model = "x/m"
+
+	if _, err := CreateProvider(cfg); err == nil {
+		t.Error("CreateProvider() expected error for unknown protocol")
+	}
+}
+
+func TestCreateProvider_OpenAIChatHeaders(t *testing.T) {
+	var gotAuth, gotTitle, gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("X-Api-Key")
+		gotTitle = r.Header.Get("X-Title")
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel, _ = body["model"].(string)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"content":"hi"},"finish_reason":"stop"}]}`))
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.ProviderList = []config.ProviderEntry{
+		{
+			Name:       "acme",
+			Protocol:   config.ProtocolOpenAIChat,
+			APIBase:    server.URL,
+			APIKey:     "secret",
+			AuthHeader: "X-Api-Key",
+			Headers:    map[string]string{"X-Title": "picoclaw"},
+		},
+	}
+	cfg.Agents.Defaults.Model = "acme/model-a"
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error = %v", err)
+	}
+	if _, ok := provider.(*HTTPProvider); !ok {
+		t.Fatalf("CreateProvider() returned %T, want *HTTPProvider", provider)
+	}
+
+	resp, err := provider.Chat(context.Background(), []Message{{Role: "user", Content: "hello"}}, nil, ResolveModel(cfg, cfg.Agents.Defaults.Model), nil)
+	if err != nil {
+		t.Fatalf("Chat() error = %v", err)
+	}
+	if resp.Content != "hi" {
+		t.Errorf("Content = %q, want %q", resp.Content, "hi")
+	}
+	if gotAuth != "secret" {
+		t.Errorf("X-Api-Key = %q, want %q", gotAuth, "secret")
+	}
+	if gotTitle != "picoclaw" {
+		t.Errorf("X-Title = %q, want %q", gotTitle, "picoclaw")
+	}
+	if gotModel != "model-a" {
+		t.Errorf("model = %q, want %q", gotModel, "model-a")
+	}
+}
+
+func TestCreateProvider_AnthropicMessages(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Anthropic.APIKey = "sk-ant"
+	cfg.Agents.Defaults.Model = "claude-sonnet-4-5-20250929"
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error = %v", err)
+	}
+	if _, ok := provider.(*ClaudeProvider); !ok {
+		t.Fatalf("CreateProvider() returned %T, want *ClaudeProvider", provider)
+	}
+}
+
+// TestResolveProviderModel_LegacyRouting pins configs using the fixed
+// "providers" fields to the vendor and model id they were always sent to.
+func TestResolveProviderModel_LegacyRouting(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers func(p *config.ProvidersConfig)
+		provider  string
+		model     string
+		wantEntry string
+		wantModel string
+	}{
+		{
+			name:      "anthropic prefix goes to openrouter",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or"; p.Anthropic.APIKey = "ant" },
+			model:     "anthropic/claude-sonnet-4",
+			wantEntry: "openrouter",
+			wantModel: "anthropic/claude-sonnet-4",
+		},
+		{
+			name:      "openai prefix goes to openrouter",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or"; p.OpenAI.APIKey = "oa" },
+			model:     "openai/gpt-4o",
+			wantEntry: "openrouter",
+			wantModel: "openai/gpt-4o",
+		},
+		{
+			name:      "deepseek prefix goes to openrouter",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or"; p.DeepSeek.APIKey = "ds" },
+			model:     "deepseek/deepseek-chat",
+			wantEntry: "openrouter",
+			wantModel: "deepseek/deepseek-chat",
+		},
+		{
+			name:      "google prefix goes to openrouter",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or"; p.Gemini.APIKey = "gm" },
+			model:     "google/gemini-2.0-flash",
+			wantEntry: "openrouter",
+			wantModel: "google/gemini-2.0-flash",
+		},
+		{
+			name:      "openrouter ids are kept",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or" },
+			model:     "openrouter/auto",
+			wantEntry: "openrouter",
+			wantModel: "openrouter/auto",
+		},
+		{
+			name:      "explicit openrouter keeps the id",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or" },
+			provider:  "openrouter",
+			model:     "openrouter/auto",
+			wantEntry: "openrouter",
+			wantModel: "openrouter/auto",
+		},
+		{
+			name:      "unknown vendor goes to openrouter",
+			providers: func(p *config.ProvidersConfig) { p.OpenRouter.APIKey = "or" },
+			model:     "meta-llama/llama-3-70b",
+			wantEntry: "openrouter",
+			wantModel: "meta-llama/llama-3-70b",
+		},
+		{
+			name:      "moonshot prefix is stripped",
+			providers: func(p *config.ProvidersConfig) { p.Moonshot.APIKey = "ms" },
+			model:     "moonshot/kimi-k2.5",
+			wantEntry: "moonshot",
+			wantModel: "kimi-k2.5",
+		},
+		{
+			name:      "groq prefix is stripped once",
+			providers: func(p *config.ProvidersConfig) { p.Groq.APIKey = "gq" },
+			model:     "groq/openai/gpt-oss-120b",
+			wantEntry: "groq",
+			wantModel: "openai/gpt-oss-120b",
+		},
+		{
+			name:      "ollama prefix is stripped",
+			providers: func(p *config.ProvidersConfig) { p.Ollama.APIKey = "ol" },
+			model:     "ollama/qwen2.5:14b",
+			wantEntry: "ollama",
+			wantModel: "qwen2.5:14b",
+		},
+		{
+			name:      "deepseek coerces other models",
+			providers: func(p *config.ProvidersConfig) { p.DeepSeek.APIKey = "ds" },
+			provider:  "deepseek",
+			model:     "gpt-4o",
+			wantEntry: "deepseek",
+			wantModel: "deepseek-chat",
+		},
+		{
+			name:      "deepseek keeps the reasoner",
+			providers: func(p *config.ProvidersConfig) { p.DeepSeek.APIKey = "ds" },
+			provider:  "deepseek",
+			model:     "deepseek-reasoner",
+			wantEntry: "deepseek",
+			wantModel: "deepseek-reasoner",
+		},
+		{
+			name:      "claude goes to anthropic",
+			providers: func(p *config.ProvidersConfig) { p.Anthropic.APIKey = "ant"; p.OpenRouter.APIKey = "or" },
+			model:     "claude-sonnet-4-5-20250929",
+			wantEntry: "anthropic",
+			wantModel: "claude-sonnet-4-5-20250929",
+		},
+		{
+			name:      "glm goes to zhipu",
+			providers: func(p *config.ProvidersConfig) { p.Zhipu.APIKey = "zp" },
+			model:     "glm-4.7",
+			wantEntry: "zhipu",
+			wantModel: "glm-4.7",
+		},
+		{
+			name: "vllm takes unmatched models",
+			providers: func(p *config.ProvidersConfig) {
+				p.VLLM.APIBase = "http://localhost:8000/v1"
+				p.OpenRouter.APIKey = "or"
+			},
+			model:     "my-model",
+			wantEntry: "vllm",
+			wantModel: "my-model",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			tt.providers(&cfg.Providers)
+			entry, model, err := ResolveProviderModel(cfg, tt.provider, tt.model)
+			if err != nil {
+				t.Fatalf("ResolveProviderModel() error = %v", err)
+			}
+			if entry.Name != tt.wantEntry || model != tt.wantModel {
+				t.Errorf("ResolveProviderModel() = %q, %q, want %q, %q", entry.Name, model, tt.wantEntry, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestResolveProviderModel_ProviderListOpenRouter(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ProviderList = []config.ProviderEntry{
+		{Name: "openrouter", Protocol: config.ProtocolOpenAIChat, APIBase: "https://openrouter.ai/api/v1", APIKey: "k"},
+	}
+
+	entry, model, err := ResolveProviderModel(cfg, "", "openrouter/auto")
+	if err != nil {
+		t.Fatalf("ResolveProviderModel() error = %v", err)
+	}
+	if entry.Name != "openrouter" || model != "openrouter/auto" {
+		t.Errorf("ResolveProviderModel() = %q, %q", entry.Name, model)
+	}
+}
+
+func TestCreateProvider_AnthropicProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"type":"error","error":{"type":"invalid_request_error","message":"stop"}}`))
+	}))
+	defer proxy.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Providers.Anthropic.APIKey = "sk-ant"
+	cfg.Providers.Anthropic.APIBase = "http://anthropic.invalid"
+	cfg.Providers.Anthropic.Proxy = proxy.URL
+	cfg.Agents.Defaults.Model = "claude-sonnet-4-5-20250929"
+
+	provider, err := CreateProvider(cfg)
+	if err != nil {
+		t.Fatalf("CreateProvider() error = %v", err)
+	}
+	provider.Chat(context.Background(), []Message{{Role: "user", Content: "hello"}}, nil, cfg.Agents.Defaults.Model, nil)
+	if proxied != "http://anthropic.invalid/v1/messages" {
+		t.Errorf("proxied request = %q", proxied)
+	}
+}
+
+func TestResolveProviderEntry_UnconfiguredProviderFallsBackToModel(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.Anthropic.APIKey = "sk-ant"
+
+	entry, err := ResolveProviderEntry(cfg, "groq", "claude-sonnet-4-5-20250929")
+	if err != nil {
+		t.Fatalf("ResolveProviderEntry() error = %v", err)
+	}
+	if entry.Name != "anthropic" {
+		t.Errorf("entry = %q, want the provider detected from the model", entry.Name)
+	}
+}
+
+func TestCreateProvider_BedrockNeedsNoKey(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Providers.VLLM.APIBase = "https://bedrock-gateway.example.com/v1"
+
+	cfg.Agents.Defaults.Model = "bedrock/anthropic.claude-3-5-sonnet"
+	if _, err := CreateProvider(cfg); err != nil {
+		t.Errorf("CreateProvider() for a bedrock model error = %v", err)
+	}
+
+	cfg.Agents.Defaults.Model = "my-model"
+	if _, err := CreateProvider(cfg); err == nil {
+		t.Error("remote provider without a key accepted for a non-bedrock model")
+	}
+}
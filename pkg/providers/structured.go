@@ -0,0 +1,359 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/openai/openai-go/v3"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// JSONSchema describes the JSON object a reply must be. Pass it as the
+// "response_schema" chat option: providers with native structured output
+// (OpenAI response_format, Anthropic forced tool use, Gemini responseSchema)
+// enforce it, the others are steered by Instructions and checked by Parse.
+type JSONSchema struct {
+	Name        string
+	Description string
+	Schema      map[string]interface{}
+}
+
+// maxStructuredRepairs bounds how often an invalid reply is sent back for
+// correction.
+const maxStructuredRepairs = 2
+
+func responseSchema(options map[string]interface{}) *JSONSchema {
+	schema, _ := options["response_schema"].(*JSONSchema)
+	return schema
+}
+
+// Instructions returns prompt text asking for a reply matching the schema.
+func (s *JSONSchema) Instructions() string {
+	schemaJSON, _ := json.Marshal(s.Schema)
+	return fmt.Sprintf("Respond with ONLY a JSON object, without prose or code fences, matching this JSON schema:\n%s", schemaJSON)
+}
+
+// RepairPrompt asks the model to correct a reply that failed Parse.
+func (s *JSONSchema) RepairPrompt(err error) string {
+	return fmt.Sprintf("Your previous reply did not match the required JSON schema: %v\nReply again with ONLY the corrected JSON object.", err)
+}
+
+// Parse extracts the JSON object from text, validates it against the schema
+// and, if out is non-nil, decodes it into out.
+func (s *JSONSchema) Parse(text string, out interface{}) error {
+	raw := ExtractJSON(text)
+	if raw == "" {
+		return fmt.Errorf("no JSON object found in reply")
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := validateJSONSchema(value, s.Schema, "$"); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(raw), out)
+}
+
+// asToolDefinition presents the schema as a tool, for providers that
+// implement structured output through forced tool use.
+func (s *JSONSchema) asToolDefinition() ToolDefinition {
+	desc := s.Description
+	if desc == "" {
+		desc = "Return the final answer as structured data."
+	}
+	return ToolDefinition{
+		Type: "function",
+		Function: ToolFunctionDefinition{
+			Name:        s.Name,
+			Description: desc,
+			Parameters:  s.Schema,
+		},
+	}
+}
+
+// structuredFromToolCall turns a call of the schema tool back into plain
+// JSON content, leaving any other tool calls in place.
+func structuredFromToolCall(resp *LLMResponse, schema *JSONSchema) *LLMResponse {
+	kept := resp.ToolCalls[:0]
+	for _, tc := range resp.ToolCalls {
+		if tc.Name != schema.Name {
+			kept = append(kept, tc)
+			continue
+		}
+		data, err := json.Marshal(tc.Arguments)
+		if err != nil {
+			continue
+		}
+		resp.Content = string(data)
+	}
+	resp.ToolCalls = kept
+	if len(kept) == 0 && resp.FinishReason == "tool_calls" {
+		resp.FinishReason = "stop"
+	}
+	return resp
+}
+
+// schemaRejectionHints are phrases in a 4xx error body that point at a
+// request parameter the vendor does not accept.
+var schemaRejectionHints = []string{
+	"response_format", "json_schema", "response_schema", "responseschema", "responsemimetype", "tool_choice",
+	"unsupported", "not supported", "unknown parameter", "unrecognized", "extra inputs", "invalid parameter", "invalid_parameter",
+}
+
+var errorStatusPattern = regexp.MustCompile(`Status: (\d{3})`)
+
+// errorStatus returns the HTTP status of a failed provider call, or 0 when
+// it is not known.
+func errorStatus(err error) int {
+	var anthropicErr *anthropic.Error
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode
+	}
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
+	}
+	if m := errorStatusPattern.FindStringSubmatch(err.Error()); m != nil {
+		status, _ := strconv.Atoi(m[1])
+		return status
+	}
+	return 0
+}
+
+// SchemaRejected reports whether err is a vendor refusing the native
+// structured output option: a client error about an unsupported or invalid
+// parameter. Timeouts, rate limits and server errors are not, so a passing
+// outage does not degrade structured output to the prompt alone.
+func SchemaRejected(err error) bool {
+	status := errorStatus(err)
+	if status < 400 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, hint := range schemaRejectionHints {
+		if strings.Contains(msg, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatJSON asks provider for a reply matching schema and decodes it into
+// out. Replies that fail validation are sent back with the error for
+// repair. If the provider rejects the native schema option, the request is
+// retried with the prompt instructions alone.
+func ChatJSON(ctx context.Context, provider LLMProvider, messages []Message, model string, options map[string]interface{}, schema *JSONSchema, out interface{}) error {
+	if len(messages) == 0 {
+		return fmt.Errorf("structured chat needs at least one message")
+	}
+
+	opts := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		opts[k] = v
+	}
+	opts["response_schema"] = schema
+
+	msgs := append([]Message(nil), messages...)
+	last := &msgs[len(msgs)-1]
+	last.Content += "\n\n" + schema.Instructions()
+
+	var lastErr error
+	for repairs := 0; repairs <= maxStructuredRepairs; {
+		resp, err := provider.Chat(ctx, msgs, nil, model, opts)
+		if err != nil {
+			if _, native := opts["response_schema"]; native && SchemaRejected(err) {
+				logger.WarnCF("provider", "Structured output rejected, retrying with prompt only",
+					map[string]interface{}{"schema": schema.Name, "error": err.Error()})
+				delete(opts, "response_schema")
+				continue
+			}
+			return err
+		}
+
+		if lastErr = schema.Parse(resp.Content, out); lastErr == nil {
+			return nil
+		}
+		repairs++
+		msgs = append(msgs,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: schema.RepairPrompt(lastErr)},
+		)
+	}
+	return fmt.Errorf("structured reply %q: %w", schema.Name, lastErr)
+}
+
+// ExtractJSON returns the first JSON object embedded in text, tolerating
+// surrounding prose and markdown code fences. It returns "" if none is found.
+func ExtractJSON(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if json.Valid([]byte(trimmed)) && strings.HasPrefix(trimmed, "{") {
+		return trimmed
+	}
+	for start := strings.IndexByte(text, '{'); start != -1; {
+		end := findMatchingBrace(text, start)
+		if end != start && json.Valid([]byte(text[start:end])) {
+			return text[start:end]
+		}
+		next := strings.IndexByte(text[start+1:], '{')
+		if next == -1 {
+			break
+		}
+		start += next + 1
+	}
+	return ""
+}
+
+// validateJSONSchema checks value against the subset of JSON Schema used by
+// picoclaw's internal schemas: type, enum, properties, required and items.
+func validateJSONSchema(value interface{}, schema map[string]interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch tv := t.(type) {
+		case string:
+			types = []string{tv}
+		case []string:
+			types = tv
+		case []interface{}:
+			for _, v := range tv {
+				if s, ok := v.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+		if len(types) > 0 && !matchesJSONType(value, types) {
+			return fmt.Errorf("%s: expected %s", path, strings.Join(types, " or "))
+		}
+	}
+
+	if enum := schemaEnum(schema["enum"]); enum != nil {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propValue, ok := v[name]
+			if !ok {
+				continue
+			}
+			propSchema, _ := props[name].(map[string]interface{})
+			if err := validateJSONSchema(propValue, propSchema, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items, _ := schema["items"].(map[string]interface{})
+		for i, item := range v {
+			if err := validateJSONSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func matchesJSONType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if f, ok := value.(float64); ok && f == float64(int64(f)) {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// schemaEnum returns the allowed values of an enum, written either as
+// []interface{} (decoded JSON) or as a typed slice such as []string.
+func schemaEnum(v interface{}) []interface{} {
+	switch tv := v.(type) {
+	case []interface{}:
+		return tv
+	case []string:
+		out := make([]interface{}, len(tv))
+		for i, s := range tv {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}
+
+func schemaStrings(v interface{}) []string {
+	switch tv := v.(type) {
+	case []string:
+		return tv
+	case []interface{}:
+		out := make([]string, 0, len(tv))
+		for _, s := range tv {
+			if str, ok := s.(string); ok {
+				out = append(out, str)
+			}
+		}
+		return out
+	}
+	return nil
+}
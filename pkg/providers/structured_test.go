@@ -0,0 +1,175 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testSchema = &JSONSchema{
+	Name: "answer",
+	Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"value": map[string]interface{}{"type": "integer"},
+			"unit":  map[string]interface{}{"type": "string", "enum": []interface{}{"m", "km"}},
+		},
+		"required": []interface{}{"value"},
+	},
+}
+
+type scriptedProvider struct {
+	replies []string
+	errs    []error
+	calls   []map[string]interface{}
+	last    []Message
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	i := len(p.calls)
+	p.calls = append(p.calls, options)
+	p.last = messages
+	if i < len(p.errs) && p.errs[i] != nil {
+		return nil, p.errs[i]
+	}
+	return &LLMResponse{Content: p.replies[i], FinishReason: "stop"}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string { return "" }
+
+func TestJSONSchema_Parse(t *testing.T) {
+	var out struct {
+		Value int    `json:"value"`
+		Unit  string `json:"unit"`
+	}
+	if err := testSchema.Parse("Sure!\n```json\n{\"value\": 3, \"unit\": \"km\"}\n```", &out); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if out.Value != 3 || out.Unit != "km" {
+		t.Errorf("out = %+v", out)
+	}
+
+	for _, bad := range []string{`no json here`, `{"unit":"m"}`, `{"value":"3"}`, `{"value":3,"unit":"mi"}`, `{"value":1.5}`} {
+		if err := testSchema.Parse(bad, nil); err == nil {
+			t.Errorf("Parse(%q) expected error", bad)
+		}
+	}
+}
+
+func TestExtractJSON_BracesInStrings(t *testing.T) {
+	got := ExtractJSON(`Result: {"text":"a } b"} trailing`)
+	if got != `{"text":"a } b"}` {
+		t.Errorf("ExtractJSON() = %q", got)
+	}
+}
+
+func TestChatJSON_RepairsInvalidReply(t *testing.T) {
+	p := &scriptedProvider{replies: []string{`{"value":"three"}`, `{"value":3}`}}
+	var out struct {
+		Value int `json:"value"`
+	}
+	err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "how far?"}}, "m", nil, testSchema, &out)
+	if err != nil {
+		t.Fatalf("ChatJSON() error = %v", err)
+	}
+	if out.Value != 3 {
+		t.Errorf("Value = %d, want 3", out.Value)
+	}
+	if len(p.calls) != 2 {
+		t.Fatalf("calls = %d, want 2", len(p.calls))
+	}
+	if n := len(p.last); n != 3 || !strings.Contains(p.last[2].Content, "did not match") {
+		t.Errorf("repair messages = %+v", p.last)
+	}
+	if p.calls[0]["response_schema"] != testSchema {
+		t.Error("response_schema option not passed to provider")
+	}
+}
+
+func TestChatJSON_FallsBackWhenSchemaRejected(t *testing.T) {
+	p := &scriptedProvider{
+		replies: []string{"", `{"value":1}`},
+		errs:    []error{errors.New("API request failed:\n  Status: 400\n  Body:   {\"error\":{\"message\":\"response_format json_schema is not supported\"}}")},
+	}
+	if err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "q"}}, "m", nil, testSchema, nil); err != nil {
+		t.Fatalf("ChatJSON() error = %v", err)
+	}
+	if _, ok := p.calls[1]["response_schema"]; ok {
+		t.Error("retry should not send response_schema")
+	}
+}
+
+func TestChatJSON_KeepsSchemaOnTransientError(t *testing.T) {
+	p := &scriptedProvider{
+		replies: []string{"", `{"value":1}`},
+		errs:    []error{errors.New("API request failed:\n  Status: 503\n  Body:   upstream unavailable")},
+	}
+	if err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "q"}}, "m", nil, testSchema, nil); err == nil {
+		t.Fatal("ChatJSON() should return a transient error instead of dropping the schema")
+	}
+	if len(p.calls) != 1 {
+		t.Errorf("calls = %d, want 1", len(p.calls))
+	}
+}
+
+func TestSchemaRejected(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"API request failed:\n  Status: 400\n  Body:   unknown parameter: response_format": true,
+		"API request failed:\n  Status: 422\n  Body:   json_schema is not supported":       true,
+		"API request failed:\n  Status: 400\n  Body:   prompt is too long":                 false,
+		"API request failed:\n  Status: 429\n  Body:   response_format rate limited":       false,
+		"API request failed:\n  Status: 500\n  Body:   unsupported":                        false,
+		"context deadline exceeded": false,
+	} {
+		if got := SchemaRejected(errors.New(msg)); got != want {
+			t.Errorf("SchemaRejected(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestJSONSchema_TypedEnum(t *testing.T) {
+	schema := &JSONSchema{Name: "pick", Schema: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"color": map[string]interface{}{"type": "string", "enum": []string{"red", "blue"}}},
+	}}
+	if err := schema.Parse(`{"color":"red"}`, nil); err != nil {
+		t.Errorf("Parse() error = %v", err)
+	}
+	if err := schema.Parse(`{"color":"green"}`, nil); err == nil {
+		t.Error("value outside a []string enum accepted")
+	}
+}
+
+func TestChatJSON_GivesUp(t *testing.T) {
+	p := &scriptedProvider{replies: []string{"nope", "nope", "nope"}}
+	if err := ChatJSON(context.Background(), p, []Message{{Role: "user", Content: "q"}}, "m", nil, testSchema, nil); err == nil {
+		t.Fatal("ChatJSON() expected error")
+	}
+	if len(p.calls) != maxStructuredRepairs+1 {
+		t.Errorf("calls = %d, want %d", len(p.calls), maxStructuredRepairs+1)
+	}
+}
+
+func TestChatWithToolCallRepair(t *testing.T) {
+	replies := []string{
+		`{"tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":"{not json"}}]}`,
+		`{"tool_calls":[{"id":"c1","type":"function","function":{"name":"f","arguments":{"x":1}}}]}`,
+	}
+	calls := 0
+	resp, err := chatWithToolCallRepair([]Message{{Role: "user", Content: "go"}}, func(msgs []Message) (*LLMResponse, error) {
+		text := replies[calls]
+		calls++
+		toolCalls := extractToolCallsFromText(text)
+		if len(toolCalls) > 0 {
+			return &LLMResponse{ToolCalls: toolCalls, FinishReason: "tool_calls"}, nil
+		}
+		return &LLMResponse{Content: text, FinishReason: "stop"}, nil
+	})
+	if err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	if calls != 2 || len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Arguments["x"] != float64(1) {
+		t.Errorf("calls = %d, resp = %+v", calls, resp)
+	}
+}
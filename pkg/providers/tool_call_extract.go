@@ -2,44 +2,94 @@ package providers
 
 import (
 	"encoding/json"
+	"fmt"
 	"strings"
 )
 
+// toolCallsSchema is the reply format CLI providers are told to use for
+// tool calls. Arguments may arrive as a JSON string or, from models that
+// ignore the instruction, as an object.
+var toolCallsSchema = &JSONSchema{
+	Name: "tool_calls",
+	Schema: map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"tool_calls"},
+		"properties": map[string]interface{}{
+			"tool_calls": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"function"},
+					"properties": map[string]interface{}{
+						"id":   map[string]interface{}{"type": "string"},
+						"type": map[string]interface{}{"type": "string"},
+						"function": map[string]interface{}{
+							"type":     "object",
+							"required": []interface{}{"name"},
+							"properties": map[string]interface{}{
+								"name":      map[string]interface{}{"type": "string"},
+								"arguments": map[string]interface{}{"type": []interface{}{"string", "object"}},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
 // extractToolCallsFromText parses tool call JSON from response text.
 // Both ClaudeCliProvider and CodexCliProvider use this to extract
 // tool calls that the model outputs in its response text.
 func extractToolCallsFromText(text string) []ToolCall {
+	toolCalls, _ := parseToolCallsFromText(text)
+	return toolCalls
+}
+
+// parseToolCallsFromText is extractToolCallsFromText with an error for
+// replies that attempt a tool call but do not match toolCallsSchema.
+func parseToolCallsFromText(text string) ([]ToolCall, error) {
 	start := strings.Index(text, `{"tool_calls"`)
 	if start == -1 {
-		return nil
+		return nil, nil
 	}
 
 	end := findMatchingBrace(text, start)
 	if end == start {
-		return nil
+		return nil, fmt.Errorf("unterminated tool_calls object")
 	}
 
-	jsonStr := text[start:end]
-
 	var wrapper struct {
 		ToolCalls []struct {
 			ID       string `json:"id"`
 			Type     string `json:"type"`
 			Function struct {
-				Name      string `json:"name"`
-				Arguments string `json:"arguments"`
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
 			} `json:"function"`
 		} `json:"tool_calls"`
 	}
 
-	if err := json.Unmarshal([]byte(jsonStr), &wrapper); err != nil {
-		return nil
+	jsonStr := text[start:end]
+	if err := toolCallsSchema.Parse(jsonStr, &wrapper); err != nil {
+		return nil, err
 	}
 
 	var result []ToolCall
 	for _, tc := range wrapper.ToolCalls {
+		arguments := string(tc.Function.Arguments)
+		var s string
+		if err := json.Unmarshal(tc.Function.Arguments, &s); err == nil {
+			arguments = s
+		}
+		if arguments == "" {
+			arguments = "{}"
+		}
+
 		var args map[string]interface{}
-		json.Unmarshal([]byte(tc.Function.Arguments), &args)
+		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+			return nil, fmt.Errorf("arguments of %s are not a JSON object: %w", tc.Function.Name, err)
+		}
 
 		result = append(result, ToolCall{
 			ID:        tc.ID,
@@ -48,12 +98,31 @@ func extractToolCallsFromText(text string) []ToolCall {
 			Arguments: args,
 			Function: &FunctionCall{
 				Name:      tc.Function.Name,
-				Arguments: tc.Function.Arguments,
+				Arguments: arguments,
 			},
 		})
 	}
 
-	return result
+	return result, nil
+}
+
+// chatWithToolCallRepair runs chat and, when the reply attempts a tool call
+// that cannot be parsed, sends the error back so the model can correct it.
+func chatWithToolCallRepair(messages []Message, chat func([]Message) (*LLMResponse, error)) (*LLMResponse, error) {
+	for repairs := 0; ; repairs++ {
+		resp, err := chat(messages)
+		if err != nil || len(resp.ToolCalls) > 0 || repairs == maxStructuredRepairs {
+			return resp, err
+		}
+		_, parseErr := parseToolCallsFromText(resp.Content)
+		if parseErr == nil {
+			return resp, nil
+		}
+		messages = append(messages,
+			Message{Role: "assistant", Content: resp.Content},
+			Message{Role: "user", Content: toolCallsSchema.RepairPrompt(parseErr)},
+		)
+	}
 }
 
 // stripToolCallsFromText removes tool call JSON from response text.
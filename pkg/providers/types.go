@@ -8,6 +8,9 @@ type ToolCall struct {
 	Function  *FunctionCall          `json:"function,omitempty"`
 	Name      string                 `json:"name,omitempty"`
 	Arguments map[string]interface{} `json:"arguments,omitempty"`
+	// ThoughtSignature is Gemini's opaque signature for the call; it must be
+	// replayed unchanged with the call in later turns.
+	ThoughtSignature string `json:"thought_signature,omitempty"`
 }
 
 type FunctionCall struct {
@@ -20,12 +23,30 @@ type LLMResponse struct {
 	ToolCalls    []ToolCall `json:"tool_calls,omitempty"`
 	FinishReason string     `json:"finish_reason"`
 	Usage        *UsageInfo `json:"usage,omitempty"`
+	// Reasoning is the model's thinking text, kept apart from Content so it
+	// never reaches the user unless asked for.
+	Reasoning      string          `json:"reasoning,omitempty"`
+	ThinkingBlocks []ThinkingBlock `json:"thinking_blocks,omitempty"`
+}
+
+// ThinkingBlock is a signed reasoning block that has to be sent back
+// unchanged while a tool-use turn continues (Anthropic extended thinking).
+type ThinkingBlock struct {
+	Type      string `json:"type"` // "thinking" or "redacted_thinking"
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Data      string `json:"data,omitempty"`
 }
 
 type UsageInfo struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// CachedTokens is the part of PromptTokens read from the prompt cache.
+	CachedTokens int `json:"cached_tokens,omitempty"`
+	// CacheWriteTokens is the part of PromptTokens written to the prompt
+	// cache (Anthropic only).
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
 }
 
 type Message struct {
@@ -33,6 +54,16 @@ type Message struct {
 	Content    string     `json:"content"`
 	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
 	ToolCallID string     `json:"tool_call_id,omitempty"`
+	// ReasoningContent and ThinkingBlocks carry an assistant message's
+	// reasoning so it can be replayed within a tool-use turn.
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	ThinkingBlocks   []ThinkingBlock `json:"thinking_blocks,omitempty"`
+	// Media holds local file paths attached to a user message. Only
+	// providers with native multimodal input read it; it is not persisted.
+	Media []string `json:"-"`
+	// CachePrefixLen marks Content[:CachePrefixLen] as identical across
+	// turns, so providers with explicit prompt caching can cache just that.
+	CachePrefixLen int `json:"-"`
 }
 
 type LLMProvider interface {
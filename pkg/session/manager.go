@@ -15,8 +15,10 @@ type Session struct {
 	Key      string              `json:"key"`
 	Messages []providers.Message `json:"messages"`
 	Summary  string              `json:"summary,omitempty"`
-	Created  time.Time           `json:"created"`
-	Updated  time.Time           `json:"updated"`
+	// ShowThinking is set by /think on to show reasoning before replies
+	ShowThinking bool      `json:"show_thinking,omitempty"`
+	Created      time.Time `json:"created"`
+	Updated      time.Time `json:"updated"`
 }
 
 type SessionManager struct {
@@ -122,6 +124,33 @@ func (sm *SessionManager) SetSummary(key string, summary string) {
 	}
 }
 
+func (sm *SessionManager) GetShowThinking(key string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	session, ok := sm.sessions[key]
+	return ok && session.ShowThinking
+}
+
+// SetShowThinking records the /think toggle, creating the session when the
+// command is its first message.
+func (sm *SessionManager) SetShowThinking(key string, show bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, ok := sm.sessions[key]
+	if !ok {
+		session = &Session{
+			Key:      key,
+			Messages: []providers.Message{},
+			Created:  time.Now(),
+		}
+		sm.sessions[key] = session
+	}
+	session.ShowThinking = show
+	session.Updated = time.Now()
+}
+
 func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
@@ -148,12 +177,15 @@ func (sm *SessionManager) TruncateHistory(key string, keepLast int) {
 // sanitizeFilename converts a session key into a cross-platform safe filename.
 // Session keys use "channel:chatID" (e.g. "telegram:123456") but ':' is the
 // volume separator on Windows, so filepath.Base would misinterpret the key.
-// We replace it with '_'. The original key is preserved inside the JSON file,
-// so loadSessions still maps back to the right in-memory key.
+// Thread chat IDs add a '/' (e.g. "telegram:-100123/42"). We replace all of
+// them, and '\', with '_'. The original key is preserved inside the JSON
+// file, so loadSessions still maps back to the right in-memory key.
 func sanitizeFilename(key string) string {
-	return strings.ReplaceAll(key, ":", "_")
+	return filenameReplacer.Replace(key)
 }
 
+var filenameReplacer = strings.NewReplacer(":", "_", "/", "_", `\`, "_")
+
 func (sm *SessionManager) Save(key string) error {
 	if sm.storage == "" {
 		return nil
@@ -178,10 +210,11 @@ func (sm *SessionManager) Save(key string) error {
 	}
 
 	snapshot := Session{
-		Key:     stored.Key,
-		Summary: stored.Summary,
-		Created: stored.Created,
-		Updated: stored.Updated,
+		Key:          stored.Key,
+		Summary:      stored.Summary,
+		ShowThinking: stored.ShowThinking,
+		Created:      stored.Created,
+		Updated:      stored.Updated,
 	}
 	if len(stored.Messages) > 0 {
 		snapshot.Messages = make([]providers.Message, len(stored.Messages))
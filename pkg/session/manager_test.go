@@ -17,6 +17,8 @@ func TestSanitizeFilename(t *testing.T) {
 		{"slack:C01234", "slack_C01234"},
 		{"no-colons-here", "no-colons-here"},
 		{"multiple:colons:here", "multiple_colons_here"},
+		{"telegram:-100123/42", "telegram_-100123_42"},
+		{`back\slash`, "back_slash"},
 	}
 
 	for _, tt := range tests {
@@ -64,11 +66,62 @@ func TestSave_RejectsPathTraversal(t *testing.T) {
 	tmpDir := t.TempDir()
 	sm := NewSessionManager(tmpDir)
 
-	badKeys := []string{"", ".", "..", "foo/bar", "foo\\bar"}
+	badKeys := []string{"", ".", ".."}
 	for _, key := range badKeys {
 		sm.GetOrCreate(key)
 		if err := sm.Save(key); err == nil {
 			t.Errorf("Save(%q) should have failed but didn't", key)
 		}
 	}
+
+	// Separators are replaced, so these stay inside the storage directory
+	for _, key := range []string{"../escape", "foo\\..\\bar"} {
+		sm.GetOrCreate(key)
+		if err := sm.Save(key); err != nil {
+			t.Errorf("Save(%q) failed: %v", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(tmpDir), "escape.json")); err == nil {
+		t.Error("session written outside the storage directory")
+	}
+}
+
+func TestSave_ThreadSessionSurvivesRestart(t *testing.T) {
+	tmpDir := t.TempDir()
+	sm := NewSessionManager(tmpDir)
+
+	// Telegram forum topics put "/" in the chat ID
+	for _, key := range []string{"telegram:-100123/42"} {
+		sm.AddMessage(key, "user", "in a thread")
+		sm.SetShowThinking(key, true)
+		if err := sm.Save(key); err != nil {
+			t.Fatalf("Save(%q) failed: %v", key, err)
+		}
+	}
+
+	reloaded := NewSessionManager(tmpDir)
+	for _, key := range []string{"telegram:-100123/42"} {
+		if history := reloaded.GetHistory(key); len(history) != 1 || history[0].Content != "in a thread" {
+			t.Errorf("%s history after reload = %+v", key, history)
+		}
+		if !reloaded.GetShowThinking(key) {
+			t.Errorf("%s lost /think on reload", key)
+		}
+	}
+}
+
+func TestShowThinking_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	sm := NewSessionManager(dir)
+	sm.SetShowThinking("telegram:1", true)
+	if err := sm.Save("telegram:1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !NewSessionManager(dir).GetShowThinking("telegram:1") {
+		t.Error("/think on was lost on reload")
+	}
+	if sm.GetShowThinking("telegram:2") {
+		t.Error("unknown session shows thinking")
+	}
 }
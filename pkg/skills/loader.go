@@ -21,6 +21,7 @@ const (
 type SkillMetadata struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	Command     string `json:"command,omitempty"`
 }
 
 type SkillInfo struct {
@@ -28,6 +29,9 @@ type SkillInfo struct {
 	Path        string `json:"path"`
 	Source      string `json:"source"`
 	Description string `json:"description"`
+	// Command is the chat command that invokes the skill, without the
+	// leading slash. Empty when the skill declares none.
+	Command string `json:"command,omitempty"`
 }
 
 func (info SkillInfo) validate() error {
@@ -85,6 +89,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Command = metadata.Command
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from workspace", "name", info.Name, "error", err)
@@ -125,6 +130,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Command = metadata.Command
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from global", "name", info.Name, "error", err)
@@ -164,6 +170,7 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 						if metadata != nil {
 							info.Description = metadata.Description
 							info.Name = metadata.Name
+							info.Command = metadata.Command
 						}
 						if err := info.validate(); err != nil {
 							slog.Warn("invalid skill from builtin", "name", info.Name, "error", err)
@@ -265,11 +272,13 @@ func (sl *SkillsLoader) getSkillMetadata(skillPath string) *SkillMetadata {
 	var jsonMeta struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
+		Command     string `json:"command"`
 	}
 	if err := json.Unmarshal([]byte(frontmatter), &jsonMeta); err == nil {
 		return &SkillMetadata{
 			Name:        jsonMeta.Name,
 			Description: jsonMeta.Description,
+			Command:     strings.TrimPrefix(jsonMeta.Command, "/"),
 		}
 	}
 
@@ -278,6 +287,7 @@ func (sl *SkillsLoader) getSkillMetadata(skillPath string) *SkillMetadata {
 	return &SkillMetadata{
 		Name:        yamlMeta["name"],
 		Description: yamlMeta["description"],
+		Command:     strings.TrimPrefix(yamlMeta["command"], "/"),
 	}
 }
 
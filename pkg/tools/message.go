@@ -3,12 +3,33 @@ package tools
 import (
 	"context"
 	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
 )
 
 type SendCallback func(channel, chatID, content string) error
 
+// SendMediaCallback sends content together with local files.
+type SendMediaCallback func(channel, chatID, content string, media []string) error
+
+// SendButtonsCallback sends content with choices for the user to press.
+type SendButtonsCallback func(channel, chatID, content string, buttons []bus.Button) error
+
+// Limits that keep buttons within what every platform accepts; Telegram
+// allows 64 bytes of callback data.
+const (
+	maxButtons          = 10
+	maxButtonValueBytes = 64
+)
+
 type MessageTool struct {
 	sendCallback   SendCallback
+	mediaCallback  SendMediaCallback
+	buttonCallback SendButtonsCallback
+	workspace      string
+	restrict       bool
 	defaultChannel string
 	defaultChatID  string
 	sentInRound    bool // Tracks whether a message was sent in the current processing round
@@ -42,6 +63,23 @@ func (t *MessageTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional: target chat/user ID",
 			},
+			"media": map[string]interface{}{
+				"type":        "array",
+				"items":       map[string]interface{}{"type": "string"},
+				"description": "Optional: paths of local files to attach (on channels that support attachments)",
+			},
+			"buttons": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"label": map[string]interface{}{"type": "string", "description": "Text on the button"},
+						"value": map[string]interface{}{"type": "string", "description": "Reply sent back when pressed (defaults to the label)"},
+					},
+					"required": []string{"label"},
+				},
+				"description": "Optional: choices shown as buttons, to ask a multiple-choice question. The pressed button's value comes back as the user's next message",
+			},
 		},
 		"required": []string{"content"},
 	}
@@ -62,6 +100,19 @@ func (t *MessageTool) SetSendCallback(callback SendCallback) {
 	t.sendCallback = callback
 }
 
+// SetMediaCallback enables the media parameter. Paths are resolved against
+// workspace and, if restrict is set, must stay inside it.
+func (t *MessageTool) SetMediaCallback(callback SendMediaCallback, workspace string, restrict bool) {
+	t.mediaCallback = callback
+	t.workspace = workspace
+	t.restrict = restrict
+}
+
+// SetButtonsCallback enables the buttons parameter.
+func (t *MessageTool) SetButtonsCallback(callback SendButtonsCallback) {
+	t.buttonCallback = callback
+}
+
 func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
 	content, ok := args["content"].(string)
 	if !ok {
@@ -82,11 +133,36 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		return &ToolResult{ForLLM: "No target channel/chat specified", IsError: true}
 	}
 
-	if t.sendCallback == nil {
-		return &ToolResult{ForLLM: "Message sending not configured", IsError: true}
+	media, err := t.resolveMedia(args["media"])
+	if err != nil {
+		return &ToolResult{ForLLM: err.Error(), IsError: true, Err: err}
+	}
+
+	buttons, err := parseButtons(args["buttons"])
+	if err != nil {
+		return &ToolResult{ForLLM: err.Error(), IsError: true, Err: err}
 	}
 
-	if err := t.sendCallback(channel, chatID, content); err != nil {
+	switch {
+	case len(buttons) > 0 && len(media) > 0:
+		return &ToolResult{ForLLM: "buttons cannot be sent together with media; send the files first", IsError: true}
+	case len(buttons) > 0:
+		if t.buttonCallback == nil {
+			return &ToolResult{ForLLM: "Sending buttons not configured", IsError: true}
+		}
+		err = t.buttonCallback(channel, chatID, content, buttons)
+	case len(media) > 0:
+		if t.mediaCallback == nil {
+			return &ToolResult{ForLLM: "Sending files not configured", IsError: true}
+		}
+		err = t.mediaCallback(channel, chatID, content, media)
+	default:
+		if t.sendCallback == nil {
+			return &ToolResult{ForLLM: "Message sending not configured", IsError: true}
+		}
+		err = t.sendCallback(channel, chatID, content)
+	}
+	if err != nil {
 		return &ToolResult{
 			ForLLM:  fmt.Sprintf("sending message: %v", err),
 			IsError: true,
@@ -101,3 +177,60 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 		Silent: true,
 	}
 }
+
+// parseButtons reads the buttons argument, defaulting each value to its
+// label.
+func parseButtons(arg interface{}) ([]bus.Button, error) {
+	items, _ := arg.([]interface{})
+	if len(items) > maxButtons {
+		return nil, fmt.Errorf("at most %d buttons are allowed", maxButtons)
+	}
+	var buttons []bus.Button
+	for _, item := range items {
+		var button bus.Button
+		switch v := item.(type) {
+		case string:
+			button.Label = v
+		case map[string]interface{}:
+			button.Label, _ = v["label"].(string)
+			button.Value, _ = v["value"].(string)
+		}
+		button.Label = strings.TrimSpace(button.Label)
+		if button.Label == "" {
+			return nil, fmt.Errorf("every button needs a label")
+		}
+		if button.Value == "" {
+			button.Value = button.Label
+		}
+		if len(button.Value) > maxButtonValueBytes {
+			return nil, fmt.Errorf("button value %q is longer than %d bytes", button.Value, maxButtonValueBytes)
+		}
+		buttons = append(buttons, button)
+	}
+	return buttons, nil
+}
+
+// resolveMedia turns the media argument into absolute paths of existing files.
+func (t *MessageTool) resolveMedia(arg interface{}) ([]string, error) {
+	items, _ := arg.([]interface{})
+	var media []string
+	for _, item := range items {
+		path, ok := item.(string)
+		if !ok || path == "" {
+			continue
+		}
+		resolved, err := validatePath(path, t.workspace, t.restrict)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return nil, fmt.Errorf("media file: %w", err)
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("media file %s is a directory", path)
+		}
+		media = append(media, resolved)
+	}
+	return media, nil
+}
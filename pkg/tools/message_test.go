@@ -3,7 +3,11 @@ package tools
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
 )
 
 func TestMessageTool_Execute_Success(t *testing.T) {
@@ -257,3 +261,70 @@ func TestMessageTool_Parameters(t *testing.T) {
 		t.Error("Expected chat_id type to be 'string'")
 	}
 }
+
+func TestMessageTool_Execute_WithMedia(t *testing.T) {
+	workspace := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workspace, "report.pdf"), []byte("pdf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := NewMessageTool()
+	tool.SetContext("signal", "+15550001")
+	var sentMedia []string
+	tool.SetMediaCallback(func(channel, chatID, content string, media []string) error {
+		sentMedia = media
+		return nil
+	}, workspace, true)
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"content": "Here it is",
+		"media":   []interface{}{"report.pdf"},
+	})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	if len(sentMedia) != 1 || sentMedia[0] != filepath.Join(workspace, "report.pdf") {
+		t.Errorf("media = %v", sentMedia)
+	}
+
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"content": "secrets",
+		"media":   []interface{}{"/etc/passwd"},
+	})
+	if !result.IsError {
+		t.Error("expected files outside the workspace to be rejected")
+	}
+}
+
+func TestMessageTool_Execute_WithButtons(t *testing.T) {
+	tool := NewMessageTool()
+	tool.SetContext("telegram", "123")
+	var sentButtons []bus.Button
+	tool.SetButtonsCallback(func(channel, chatID, content string, buttons []bus.Button) error {
+		sentButtons = buttons
+		return nil
+	})
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"content": "Delete the file?",
+		"buttons": []interface{}{
+			map[string]interface{}{"label": "Yes, delete", "value": "yes"},
+			map[string]interface{}{"label": "No"},
+		},
+	})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	want := []bus.Button{{Label: "Yes, delete", Value: "yes"}, {Label: "No", Value: "No"}}
+	if len(sentButtons) != len(want) || sentButtons[0] != want[0] || sentButtons[1] != want[1] {
+		t.Errorf("buttons = %v, want %v", sentButtons, want)
+	}
+
+	result = tool.Execute(context.Background(), map[string]interface{}{
+		"content": "Pick one",
+		"buttons": []interface{}{map[string]interface{}{"label": ""}},
+	})
+	if !result.IsError {
+		t.Error("expected a button without a label to be rejected")
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -10,6 +11,20 @@ import (
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
+type toolFilterKey struct{}
+
+// WithToolFilter limits the tools offered to the model and run under ctx
+// to those allow accepts, for senders whose role restricts tools.
+func WithToolFilter(ctx context.Context, allow func(name string) bool) context.Context {
+	return context.WithValue(ctx, toolFilterKey{}, allow)
+}
+
+// toolAllowed reports whether ctx's filter, if any, accepts the tool.
+func toolAllowed(ctx context.Context, name string) bool {
+	allow, ok := ctx.Value(toolFilterKey{}).(func(string) bool)
+	return !ok || allow(name)
+}
+
 type ToolRegistry struct {
 	tools map[string]Tool
 	mu    sync.RWMutex
@@ -57,6 +72,14 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
 	}
 
+	if !toolAllowed(ctx, name) {
+		logger.WarnCF("tool", "Tool denied by role",
+			map[string]interface{}{
+				"tool": name,
+			})
+		return ErrorResult(fmt.Sprintf("tool %q is not available to this user", name)).WithError(fmt.Errorf("tool not permitted"))
+	}
+
 	// If tool implements ContextualTool, set context
 	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
 		contextualTool.SetContext(channel, chatID)
@@ -106,8 +129,8 @@ func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 	defer r.mu.RUnlock()
 
 	definitions := make([]map[string]interface{}, 0, len(r.tools))
-	for _, tool := range r.tools {
-		definitions = append(definitions, ToolToSchema(tool))
+	for _, name := range r.sortedNames() {
+		definitions = append(definitions, ToolToSchema(r.tools[name]))
 	}
 	return definitions
 }
@@ -115,12 +138,21 @@ func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 // ToProviderDefs converts tool definitions to provider-compatible format.
 // This is the format expected by LLM provider APIs.
 func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
+	return r.ToProviderDefsFor(context.Background())
+}
+
+// ToProviderDefsFor is ToProviderDefs limited to the tools ctx's filter
+// allows, see WithToolFilter.
+func (r *ToolRegistry) ToProviderDefsFor(ctx context.Context) []providers.ToolDefinition {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]providers.ToolDefinition, 0, len(r.tools))
-	for _, tool := range r.tools {
-		schema := ToolToSchema(tool)
+	for _, toolName := range r.sortedNames() {
+		if !toolAllowed(ctx, toolName) {
+			continue
+		}
+		schema := ToolToSchema(r.tools[toolName])
 
 		// Safely extract nested values with type checks
 		fn, ok := schema["function"].(map[string]interface{})
@@ -149,10 +181,18 @@ func (r *ToolRegistry) List() []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
+	return r.sortedNames()
+}
+
+// sortedNames returns tool names in a stable order, so tool definitions and
+// summaries are byte-identical between calls and prompt caches can hit.
+// Callers must hold r.mu.
+func (r *ToolRegistry) sortedNames() []string {
 	names := make([]string, 0, len(r.tools))
 	for name := range r.tools {
 		names = append(names, name)
 	}
+	sort.Strings(names)
 	return names
 }
 
@@ -170,7 +210,8 @@ func (r *ToolRegistry) GetSummaries() []string {
 	defer r.mu.RUnlock()
 
 	summaries := make([]string, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for _, name := range r.sortedNames() {
+		tool := r.tools[name]
 		summaries = append(summaries, fmt.Sprintf("- `%s` - %s", tool.Name(), tool.Description()))
 	}
 	return summaries
@@ -5,6 +5,30 @@ import (
 	"fmt"
 )
 
+type backgroundKey struct{}
+
+// WithBackground sets the context that work outliving the current turn,
+// such as spawned subagents, is cancelled with.
+func WithBackground(ctx, background context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, background)
+}
+
+// detach returns a context for work that outlives the tool call. It keeps
+// ctx's values but not its cancellation, so ending the turn leaves the
+// work running; it still ends with the context set by WithBackground.
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	background, ok := ctx.Value(backgroundKey{}).(context.Context)
+	if !ok {
+		return detached, cancel
+	}
+	stop := context.AfterFunc(background, cancel)
+	return detached, func() {
+		stop()
+		cancel()
+	}
+}
+
 type SpawnTool struct {
 	manager       *SubagentManager
 	originChannel string
@@ -31,6 +31,9 @@ type SubagentManager struct {
 	tools         *ToolRegistry
 	maxIterations int
 	nextID        int
+	// Reasoning controls for subagent LLM calls
+	reasoningEffort string
+	thinkingBudget  int
 }
 
 func NewSubagentManager(provider providers.LLMProvider, defaultModel, workspace string, bus *bus.MessageBus) *SubagentManager {
@@ -54,6 +57,31 @@ func (sm *SubagentManager) SetTools(tools *ToolRegistry) {
 	sm.tools = tools
 }
 
+// SetReasoning sets the reasoning effort and thinking budget subagents
+// request from the provider.
+func (sm *SubagentManager) SetReasoning(effort string, budget int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.reasoningEffort = effort
+	sm.thinkingBudget = budget
+}
+
+// llmOptions returns the chat options for a subagent run. The caller holds
+// sm.mu.
+func (sm *SubagentManager) llmOptions() map[string]any {
+	options := map[string]any{
+		"max_tokens":  4096,
+		"temperature": 0.7,
+	}
+	if sm.reasoningEffort != "" {
+		options["reasoning_effort"] = sm.reasoningEffort
+	}
+	if sm.thinkingBudget > 0 {
+		options["thinking_budget"] = sm.thinkingBudget
+	}
+	return options
+}
+
 // RegisterTool registers a tool for subagent execution.
 func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.mu.Lock()
@@ -79,8 +107,12 @@ func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel
 	}
 	sm.tasks[taskID] = subagentTask
 
-	// Start task in background with context cancellation support
-	go sm.runTask(ctx, subagentTask, callback)
+	// Start task in background, outliving the turn that spawned it
+	taskCtx, cancel := detach(ctx)
+	go func() {
+		defer cancel()
+		sm.runTask(taskCtx, subagentTask, callback)
+	}()
 
 	if label != "" {
 		return fmt.Sprintf("Spawned subagent '%s' for task: %s", label, task), nil
@@ -123,6 +155,7 @@ After completing the task, provide a clear summary of what was done.`
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	options := sm.llmOptions()
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
@@ -130,10 +163,7 @@ After completing the task, provide a clear summary of what was done.`
 		Model:         sm.defaultModel,
 		Tools:         tools,
 		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
+		LLMOptions:    options,
 	}, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
@@ -281,6 +311,7 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 	sm.mu.RLock()
 	tools := sm.tools
 	maxIter := sm.maxIterations
+	options := sm.llmOptions()
 	sm.mu.RUnlock()
 
 	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
@@ -288,10 +319,7 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 		Model:         sm.defaultModel,
 		Tools:         tools,
 		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
+		LLMOptions:    options,
 	}, messages, t.originChannel, t.originChatID)
 
 	if err != nil {
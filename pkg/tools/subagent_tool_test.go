@@ -313,3 +313,53 @@ func TestSubagentTool_ForUserTruncation(t *testing.T) {
 		t.Error("ForLLM should contain reference to original task")
 	}
 }
+
+// blockingProvider answers once released, or fails when ctx ends.
+type blockingProvider struct {
+	MockLLMProvider
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	close(p.started)
+	select {
+	case <-p.release:
+		return &providers.LLMResponse{Content: "done"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// TestSpawnOutlivesTurn verifies a spawned subagent keeps running after the
+// turn that spawned it ends, and stops with the background context.
+func TestSpawnOutlivesTurn(t *testing.T) {
+	spawn := func(background context.Context) (*blockingProvider, chan *ToolResult) {
+		provider := &blockingProvider{started: make(chan struct{}), release: make(chan struct{})}
+		manager := NewSubagentManager(provider, "test-model", "/tmp/test", nil)
+		tool := NewSpawnTool(manager)
+		results := make(chan *ToolResult, 1)
+		tool.SetCallback(func(ctx context.Context, result *ToolResult) { results <- result })
+
+		turn, endTurn := context.WithCancel(WithBackground(context.Background(), background))
+		if result := tool.Execute(turn, map[string]interface{}{"task": "work"}); result.IsError {
+			t.Fatalf("spawn failed: %s", result.ForLLM)
+		}
+		endTurn()
+		return provider, results
+	}
+
+	provider, results := spawn(context.Background())
+	close(provider.release)
+	if result := <-results; result.IsError {
+		t.Errorf("subagent stopped with its turn: %s", result.ForLLM)
+	}
+
+	background, shutdown := context.WithCancel(context.Background())
+	provider, results = spawn(background)
+	<-provider.started
+	shutdown()
+	if result := <-results; !result.IsError {
+		t.Error("subagent kept running after shutdown")
+	}
+}